@@ -1,6 +1,7 @@
 package main
 
 import (
+	"diagramgen/pkg/grid"
 	"diagramgen/pkg/parser"
 	"diagramgen/pkg/renderer" // This package now contains Render (text) and RenderToPNG
 	"flag"
@@ -8,18 +9,112 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// defineFlags collects repeated "-D name=value" flags into variable overrides.
+type defineFlags map[string]string
+
+func (d defineFlags) String() string { return fmt.Sprint(map[string]string(d)) }
+
+func (d defineFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected name=value, got '%s'", value)
+	}
+	d[strings.TrimSpace(parts[0])] = parts[1]
+	return nil
+}
+
+// runQuery implements "diagramgen query [-D name=value] file.txt [table!]B3": it prints the cell an
+// address resolves to, with formulas computed, and returns the exit status.
+func runQuery(args []string) int {
+	queryFlags := flag.NewFlagSet("query", flag.ExitOnError)
+	defines := defineFlags{}
+	queryFlags.Var(defines, "D", "Set a variable, overriding the document's 'let' of the same name: -D name=value. May be repeated.")
+	queryFlags.Usage = func() {
+		fmt.Fprintln(queryFlags.Output(), "Usage: diagramgen query [-D name=value] file.txt address")
+		fmt.Fprintln(queryFlags.Output(), "The address is a cell like 'B3' or '#id', in the main table or after 'table!'.")
+		queryFlags.PrintDefaults()
+	}
+	queryFlags.Parse(args)
+	if queryFlags.NArg() != 2 {
+		queryFlags.Usage()
+		return 2
+	}
+	inputFile, address := queryFlags.Arg(0), queryFlags.Arg(1)
+
+	content, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		log.Printf("Error reading input file '%s': %v", inputFile, err)
+		return 1
+	}
+	allTablesData, err := parser.ParseAllTextWithOptions(string(content), parser.Options{Variables: defines, BaseDir: filepath.Dir(inputFile)})
+	if err != nil {
+		log.Printf("Error parsing input from file '%s': %v", inputFile, err)
+		return 1
+	}
+	printWarnings(allTablesData.Warnings)
+	resolved, err := grid.ResolveCell(allTablesData.Tables, allTablesData.MainTableID, address)
+	if err != nil {
+		log.Printf("Error resolving '%s' in '%s': %v", address, inputFile, err)
+		return 1
+	}
+
+	cell := resolved.Cell
+	escape := func(s string) string { return strings.ReplaceAll(s, "\n", `\n`) }
+	fmt.Println(resolved.Name())
+	if cell.Colspan > 1 || cell.Rowspan > 1 {
+		fmt.Printf("spans:   %s:%s\n", grid.CellName(resolved.Row, resolved.Col), grid.CellName(resolved.Row+cell.Rowspan-1, resolved.Col+cell.Colspan-1))
+	}
+	if cell.ID != "" {
+		fmt.Printf("id:      #%s\n", cell.ID)
+	}
+	if cell.Title != "" {
+		fmt.Printf("title:   %s\n", escape(cell.Title))
+	}
+	if cell.Formula != "" {
+		fmt.Printf("formula: %s\n", cell.Formula)
+	}
+	fmt.Printf("content: %s\n", escape(cell.Content))
+	for _, block := range cell.Blocks {
+		switch {
+		case block.TableRefID != "":
+			fmt.Printf("table:   %s\n", block.TableRefID)
+		case block.Image != "" || block.Icon != "":
+			fmt.Printf("image:   %s\n", block.Image+block.Icon)
+		default:
+			fmt.Printf("text:    %s\n", escape(block.Text))
+		}
+	}
+	if cell.IsTableRef && !cell.HasBlocks() {
+		fmt.Printf("table:   %s\n", cell.TableRefID)
+	}
+	if len(cell.Links) > 0 {
+		fmt.Printf("links:   %s\n", strings.Join(cell.Links, ", "))
+	}
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		os.Exit(runQuery(os.Args[2:]))
+	}
+
 	// Define command-line flags
 	inputFile := flag.String("inputFile", "example.txt", "Path to the input text file.")
 	outputFile := flag.String("outputFile", "output.png", "Path to save the output PNG file.")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging.")
+	maxWidth := flag.Float64("maxWidth", 0, "Maximum width of the output image in pixels; columns shrink and wrap their text to fit. 0 means no limit.")
+	defines := defineFlags{}
+	flag.Var(defines, "D", "Set a variable, overriding the document's 'let' of the same name: -D name=value. May be repeated.")
 
 	// Shorthand flags
 	flag.StringVar(inputFile, "i", "example.txt", "Path to the input text file (shorthand).")
 	flag.StringVar(outputFile, "o", "output.png", "Path to save the output PNG file (shorthand).")
 	flag.BoolVar(verbose, "v", false, "Enable verbose logging (shorthand).")
+	flag.Float64Var(maxWidth, "w", 0, "Maximum width of the output image in pixels (shorthand).")
 
 	flag.Parse()
 
@@ -41,11 +136,12 @@ func main() {
 	}
 
 	// Parse the content
-	allTablesData, err := parser.ParseAllText(string(content))
+	allTablesData, err := parser.ParseAllTextWithOptions(string(content), parser.Options{Variables: defines, BaseDir: filepath.Dir(*inputFile)})
 	if err != nil {
 		log.Printf("Error parsing input from file '%s': %v", *inputFile, err)
 		os.Exit(1)
 	}
+	printWarnings(allTablesData.Warnings)
 
 	if *verbose {
 		log.Printf("Successfully parsed content from file: %s", *inputFile)
@@ -60,6 +156,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Image paths in cells are relative to the input file.
+	renderer.ResolveImagePaths(allTablesData.Tables, filepath.Dir(*inputFile))
+
+	// Links are drawn between cells of the same table.
+	if err := grid.CheckLinks(allTablesData.Tables); err != nil {
+		log.Printf("Error in the cell links of '%s': %v", *inputFile, err)
+		os.Exit(1)
+	}
+
 	mainTable, ok := allTablesData.Tables[allTablesData.MainTableID]
 	if !ok {
 		log.Printf("Error: Main table with ID '%s' not found in parsed tables.", allTablesData.MainTableID)
@@ -70,8 +175,13 @@ func main() {
 		log.Printf("Main table to render: %s", allTablesData.MainTableID)
 	}
 
+	if *maxWidth > 0 {
+		renderer.LimitCanvasWidth(&mainTable, *maxWidth)
+	}
+
 	// Render the main table to PNG
-	err = renderer.RenderToPNG(&mainTable, allTablesData.Tables, *outputFile) // Pass address of mainTable and all parsed tables
+	warnings, err := renderer.RenderToPNG(&mainTable, allTablesData.Tables, *outputFile) // Pass address of mainTable and all parsed tables
+	printWarnings(warnings)
 	if err != nil {
 		log.Printf("Error rendering main table to PNG '%s': %v", *outputFile, err)
 		os.Exit(1)
@@ -86,3 +196,11 @@ func main() {
 		fmt.Println(textOutput)                  // Use fmt.Println to send to stdout directly, respecting verbose for logs
 	}
 }
+
+// printWarnings writes the lint messages of parsing or rendering a document to stderr, away from the
+// output of the query command.
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Lint: %s.\n", warning)
+	}
+}
@@ -2,9 +2,8 @@ module diagramgen
 
 go 1.22.2
 
-require github.com/fogleman/gg v1.3.0
-
 require (
-	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	golang.org/x/image v0.17.0 // indirect
+	github.com/fogleman/gg v1.3.0
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
+	golang.org/x/image v0.17.0
 )
@@ -0,0 +1,319 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// cellAddressRegex matches the address of a cell: its position on the logical grid ("B3") or its
+	// ID ("#db-primary"), either optionally after the ID of its table ("main_overview!B3").
+	cellAddressRegex = regexp.MustCompile(`^(?:([\w\-]+)!)?(?:#([\w\-]+)|([A-Z]+)(\d+))$`)
+	// cellRangeRegex matches a range of the logical grid: "B2:C5", a whole column "C:C" or a whole row "2:2".
+	cellRangeRegex = regexp.MustCompile(`^(?:([A-Z]+)(\d+):([A-Z]+)(\d+)|([A-Z]+):([A-Z]+)|(\d+):(\d+))$`)
+)
+
+// maxColumnLetters and maxRowNumber bound the addresses of cells, far beyond the size of any diagram,
+// so that reading them cannot overflow: columns go up to "ZZZ" and rows up to 1,000,000.
+const (
+	maxColumnLetters = 3
+	maxRowNumber     = 1000000
+)
+
+// rowIndex returns the zero-based index of a row given by its number.
+func rowIndex(digits string) (int, error) {
+	row, err := strconv.Atoi(digits)
+	if err != nil || row < 1 || row > maxRowNumber {
+		return 0, fmt.Errorf("row %s is not between 1 and %d", digits, maxRowNumber)
+	}
+	return row - 1, nil
+}
+
+// CellAddress is a parsed cell address, see ParseCellAddress.
+type CellAddress struct {
+	TableID  string // Empty for the table the address is written in.
+	ID       string // The cell ID of a "#id" address.
+	Row, Col int    // The zero-based grid position of a "B3" address.
+}
+
+// ParseCellAddress parses the address of a cell: "B3" names the cell covering that slot of the logical
+// grid of Place, "#db-primary" the cell given that ID with ::id=...::, and either can be prefixed by
+// the ID of another table, "main_overview!B3".
+func ParseCellAddress(s string) (CellAddress, error) {
+	matches := cellAddressRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return CellAddress{}, fmt.Errorf("invalid cell address '%s': expected a cell like 'B3' or '#id', optionally after 'table!'", s)
+	}
+	addr := CellAddress{TableID: matches[1], ID: matches[2]}
+	if addr.ID == "" {
+		var err error
+		if addr.Row, err = rowIndex(matches[4]); err != nil {
+			return CellAddress{}, fmt.Errorf("invalid cell address '%s': %w", s, err)
+		}
+		if addr.Col, err = columnIndex(matches[3]); err != nil {
+			return CellAddress{}, fmt.Errorf("invalid cell address '%s': %w", s, err)
+		}
+	}
+	return addr, nil
+}
+
+// String returns the address as written, e.g. "main_overview!#db-primary".
+func (a CellAddress) String() string {
+	name := "#" + a.ID
+	if a.ID == "" {
+		name = CellName(a.Row, a.Col)
+	}
+	if a.TableID != "" {
+		return a.TableID + "!" + name
+	}
+	return name
+}
+
+// ResolvedCell is the cell an address refers to.
+type ResolvedCell struct {
+	TableID  string
+	Cell     *table.Cell
+	Row, Col int // The top-left slot of the cell, which names it.
+}
+
+// Name returns the qualified name of the cell, e.g. "main_overview!B3".
+func (rc ResolvedCell) Name() string {
+	return rc.TableID + "!" + CellName(rc.Row, rc.Col)
+}
+
+// ResolveCell returns the cell an address refers to. Addresses without a table are in fromTable; a
+// "#id" without a table is looked for in fromTable first, then in the other tables, where it must be
+// unique. A spanning cell can be addressed by any slot it covers.
+func ResolveCell(allTables map[string]table.Table, fromTable, address string) (ResolvedCell, error) {
+	addr, err := ParseCellAddress(address)
+	if err != nil {
+		return ResolvedCell{}, err
+	}
+	grid, cell, err := newCellIndex(allTables).resolve(fromTable, addr)
+	if err != nil {
+		return ResolvedCell{}, err
+	}
+	if cell == nil {
+		return ResolvedCell{}, fmt.Errorf("no cell covers %s", grid.id+"!"+CellName(addr.Row, addr.Col))
+	}
+	p := grid.position[cell]
+	return ResolvedCell{TableID: grid.id, Cell: cell, Row: p[0], Col: p[1]}, nil
+}
+
+// SelectCells returns the cells of a table covered by a subject: an address without a table ("B3",
+// "#db-primary") or a range ("B2:C5", "C:C", "2:2"). Parts of the subject beyond the grid, and IDs the
+// table does not have, select nothing, so one subject can apply to tables of different sizes.
+func SelectCells(t *table.Table, subject string) ([]*table.Cell, error) {
+	grid := newTableGrid(t.ID, *t)
+	if matches := cellRangeRegex.FindStringSubmatch(subject); matches != nil {
+		r1, c1, r2, c2, _, err := rangeBounds(matches, grid.rows, grid.cols)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range '%s': %w", subject, err)
+		}
+		var cells []*table.Cell
+		seen := make(map[*table.Cell]bool)
+		for r := r1; r <= r2 && r < grid.rows; r++ {
+			for c := c1; c <= c2 && c < grid.cols; c++ {
+				if cell := grid.at(r, c); cell != nil && !seen[cell] {
+					seen[cell] = true
+					cells = append(cells, cell)
+				}
+			}
+		}
+		return cells, nil
+	}
+	addr, err := ParseCellAddress(subject)
+	if err != nil {
+		return nil, err
+	}
+	if addr.TableID != "" {
+		return nil, fmt.Errorf("'%s' is in another table: a subject selects cells of its own table", subject)
+	}
+	if addr.ID != "" {
+		if cell := grid.byID[addr.ID]; cell != nil {
+			return []*table.Cell{cell}, nil
+		}
+		return nil, nil
+	}
+	if cell := grid.at(addr.Row, addr.Col); cell != nil {
+		return []*table.Cell{cell}, nil
+	}
+	return nil, nil
+}
+
+// rangeBounds returns the first and last row and column of a range matched by cellRangeRegex, on a
+// grid of rows x cols for whole columns and rows, and whether it is a whole column or row. Rows
+// numbered 0 and columns with too many letters are errors.
+func rangeBounds(matches []string, rows, cols int) (r1, c1, r2, c2 int, whole bool, err error) {
+	r1, c1, r2, c2, whole = 0, 0, rows-1, cols-1, true
+	indexes := []struct {
+		index *int
+		read  func(string) (int, error)
+		text  string
+	}{{&r1, rowIndex, matches[2]}, {&r2, rowIndex, matches[4]}, {&c1, columnIndex, matches[1]}, {&c2, columnIndex, matches[3]},
+		{&c1, columnIndex, matches[5]}, {&c2, columnIndex, matches[6]}, {&r1, rowIndex, matches[7]}, {&r2, rowIndex, matches[8]}}
+	for _, ix := range indexes {
+		if ix.text == "" {
+			continue // Not part of this kind of range.
+		}
+		if *ix.index, err = ix.read(ix.text); err != nil {
+			return 0, 0, 0, 0, false, err
+		}
+	}
+	whole = matches[1] == ""
+	if r1 > r2 {
+		r1, r2 = r2, r1
+	}
+	if c1 > c2 {
+		c1, c2 = c2, c1
+	}
+	return r1, c1, r2, c2, whole, nil
+}
+
+// tableGrid is a table placed on its logical grid, with its cells by position and by ID.
+type tableGrid struct {
+	id         string
+	table      table.Table
+	slots      [][]*table.Cell // See Place.
+	rows, cols int
+	position   map[*table.Cell][2]int // Top-left slot of each cell.
+	cells      []*table.Cell          // In grid order.
+	byID       map[string]*table.Cell
+}
+
+// newTableGrid places a table. The table shares its rows with the one it is copied from, so the cells
+// are those of the document.
+func newTableGrid(id string, t table.Table) *tableGrid {
+	g := &tableGrid{id: id, table: t, slots: Place(&t), position: make(map[*table.Cell][2]int), byID: make(map[string]*table.Cell)}
+	if g.rows = len(g.slots); g.rows > 0 {
+		g.cols = len(g.slots[0])
+	}
+	for r := 0; r < g.rows; r++ {
+		for c := 0; c < g.cols; c++ {
+			cell := g.slots[r][c]
+			if _, seen := g.position[cell]; cell == nil || seen {
+				continue
+			}
+			g.position[cell] = [2]int{r, c}
+			g.cells = append(g.cells, cell)
+			if cell.ID != "" && g.byID[cell.ID] == nil {
+				g.byID[cell.ID] = cell
+			}
+		}
+	}
+	return g
+}
+
+// name returns the name of a cell's top-left slot.
+func (g *tableGrid) name(cell *table.Cell) string {
+	p := g.position[cell]
+	return CellName(p[0], p[1])
+}
+
+// inside reports whether a slot is on the grid.
+func (g *tableGrid) inside(row, col int) bool {
+	return row >= 0 && col >= 0 && row < g.rows && col < g.cols
+}
+
+// at returns the cell covering a slot, nil for a slot outside the grid or that no cell covers (at the
+// end of a short row).
+func (g *tableGrid) at(row, col int) *table.Cell {
+	if !g.inside(row, col) {
+		return nil
+	}
+	return g.slots[row][col]
+}
+
+// outsideError is the error for a slot outside a table's grid. The table is named unless it is the
+// one the address was written in.
+func (g *tableGrid) outsideError(addr CellAddress) error {
+	last := CellName(g.rows-1, g.cols-1)
+	if g.rows == 0 || g.cols == 0 {
+		return fmt.Errorf("cell %s is outside table '%s', which is empty", addr, g.id)
+	}
+	if addr.TableID == "" {
+		return fmt.Errorf("cell %s is outside the table, which ends at %s", addr, last)
+	}
+	return fmt.Errorf("cell %s is outside table '%s', which ends at %s", addr, g.id, last)
+}
+
+// cellIndex resolves cell addresses over the tables of a document, placing each table once.
+type cellIndex struct {
+	allTables map[string]table.Table
+	grids     map[string]*tableGrid
+	owner     map[*table.Cell]*tableGrid
+}
+
+func newCellIndex(allTables map[string]table.Table) *cellIndex {
+	return &cellIndex{allTables: allTables, grids: make(map[string]*tableGrid), owner: make(map[*table.Cell]*tableGrid)}
+}
+
+// grid returns the placed table of the given ID.
+func (ix *cellIndex) grid(id string) (*tableGrid, error) {
+	if g, ok := ix.grids[id]; ok {
+		return g, nil
+	}
+	t, ok := ix.allTables[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown table '%s'", id)
+	}
+	g := newTableGrid(id, t)
+	ix.grids[id] = g
+	for _, cell := range g.cells {
+		ix.owner[cell] = g
+	}
+	return g, nil
+}
+
+// resolve returns the table and the cell an address refers to, see ResolveCell. The cell is nil for a
+// slot of the grid that no cell covers.
+func (ix *cellIndex) resolve(fromTable string, addr CellAddress) (*tableGrid, *table.Cell, error) {
+	id := addr.TableID
+	if id == "" {
+		id = fromTable
+	}
+	g, err := ix.grid(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if addr.ID == "" {
+		if !g.inside(addr.Row, addr.Col) {
+			return nil, nil, g.outsideError(addr)
+		}
+		return g, g.at(addr.Row, addr.Col), nil
+	}
+	if cell := g.byID[addr.ID]; cell != nil {
+		return g, cell, nil
+	}
+	if addr.TableID != "" {
+		return nil, nil, fmt.Errorf("table '%s' has no cell with ID '%s'", id, addr.ID)
+	}
+	others := make([]string, 0, len(ix.allTables))
+	for other := range ix.allTables {
+		if other != id {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+	var found []*tableGrid
+	for _, other := range others {
+		og, err := ix.grid(other)
+		if err != nil {
+			return nil, nil, err
+		}
+		if og.byID[addr.ID] != nil {
+			found = append(found, og)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil, fmt.Errorf("no cell has ID '%s'", addr.ID)
+	case 1:
+		return found[0], found[0].byID[addr.ID], nil
+	}
+	return nil, nil, fmt.Errorf("cell ID '%s' is used in tables '%s' and '%s': write 'table!#%s'", addr.ID, found[0].id, found[1].id, addr.ID)
+}
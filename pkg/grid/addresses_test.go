@@ -0,0 +1,112 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"strings"
+	"testing"
+)
+
+func TestParseCellAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    CellAddress
+	}{
+		{"B3", CellAddress{Row: 2, Col: 1}},
+		{"AA10", CellAddress{Row: 9, Col: 26}},
+		{"#db-primary", CellAddress{ID: "db-primary"}},
+		{"main_overview!B3", CellAddress{TableID: "main_overview", Row: 2, Col: 1}},
+		{"main_overview!#db-primary", CellAddress{TableID: "main_overview", ID: "db-primary"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCellAddress(tt.address)
+		if err != nil || got != tt.want || got.String() != tt.address {
+			t.Errorf("ParseCellAddress(%q) = %+v (%q), %v, want %+v", tt.address, got, got.String(), err, tt.want)
+		}
+	}
+	for _, invalid := range []string{"", "b3", "B0", "B", "#", "B2:B3", "t!", "AAAA1", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAA1", "B1000001"} {
+		if _, err := ParseCellAddress(invalid); err == nil {
+			t.Errorf("ParseCellAddress(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestResolveCell(t *testing.T) {
+	main := newFormulaTestTable([]string{"Service", "Status"}, []string{"API", "up"}, []string{"DB", "down"})
+	main.Rows[0].Cells[0].Colspan = 2
+	main.Rows[0].Cells = main.Rows[0].Cells[:1]
+	main.Rows[2].Cells[0].ID = "db-primary"
+	other := newFormulaTestTable([]string{"Replica", "lag"})
+	other.Rows[0].Cells[0].ID = "replica"
+	twin := newFormulaTestTable([]string{"x"})
+	twin.Rows[0].Cells[0].ID = "replica"
+	allTables := map[string]table.Table{"main": main, "other": other, "twin": twin}
+
+	tests := []struct {
+		address, want, content string
+	}{
+		{"B1", "main!A1", "Service"}, // A spanning cell is named by its top-left slot.
+		{"B3", "main!B3", "down"},
+		{"#db-primary", "main!A3", "DB"},
+		{"other!B1", "other!B1", "lag"},
+		{"other!#replica", "other!A1", "Replica"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveCell(allTables, "main", tt.address)
+		if err != nil || got.Name() != tt.want || got.Cell.Content != tt.content {
+			t.Errorf("ResolveCell(%q) = %s %+v, %v, want %s", tt.address, got.Name(), got.Cell, err, tt.want)
+		}
+	}
+	// An ID of another table can be used without it if no other table has it.
+	if got, err := ResolveCell(allTables, "other", "#db-primary"); err != nil || got.Name() != "main!A3" {
+		t.Errorf("ResolveCell(#db-primary) from another table = %s, %v", got.Name(), err)
+	}
+
+	errorTests := []struct {
+		address, want string
+	}{
+		{"C1", "outside the table, which ends at B3"},
+		{"other!A2", "outside table 'other', which ends at B1"},
+		{"nope!A1", "unknown table 'nope'"},
+		{"#nope", "no cell has ID 'nope'"},
+		{"other!#db-primary", "table 'other' has no cell with ID 'db-primary'"},
+		{"#replica", "used in tables 'other' and 'twin'"},
+	}
+	for _, tt := range errorTests {
+		if _, err := ResolveCell(allTables, "main", tt.address); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ResolveCell(%q) error = %v, want it to mention %q", tt.address, err, tt.want)
+		}
+	}
+}
+
+func TestSelectCells(t *testing.T) {
+	tbl := newFormulaTestTable([]string{"a", "b", "c"}, []string{"d", "e"}, []string{"g", "h", "i"})
+	tbl.Rows[1].Cells[1].Colspan = 2
+	tbl.Rows[2].Cells[2].ID = "last"
+	tests := []struct {
+		subject, want string
+	}{
+		{"B2", "e"},
+		{"C2", "e"},
+		{"B:C", "b c e h i"},
+		{"2:2", "d e"},
+		{"A2:Z9", "d e g h i"}, // Beyond the grid selects nothing.
+		{"#last", "i"},
+		{"#missing", ""},
+		{"D1", ""},
+	}
+	for _, tt := range tests {
+		cells, err := SelectCells(&tbl, tt.subject)
+		var got []string
+		for _, cell := range cells {
+			got = append(got, cell.Content)
+		}
+		if err != nil || strings.Join(got, " ") != tt.want {
+			t.Errorf("SelectCells(%q) = %q, %v, want %q", tt.subject, got, err, tt.want)
+		}
+	}
+	for _, invalid := range []string{"other!B2", "0:0", "0:2", "A0:B2", "B2:A0", "A:AAAAAAAAAAAAAAAAAAAAAAAAAA", "AAAAAAAAAAAAAAAAAAAAAAAAAAAA1"} {
+		if _, err := SelectCells(&tbl, invalid); err == nil {
+			t.Errorf("SelectCells(%q) succeeded", invalid)
+		}
+	}
+}
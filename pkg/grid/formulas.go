@@ -0,0 +1,538 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CellName returns the spreadsheet-style name of a logical grid position, e.g. "B3" for row 2,
+// column 1 (both zero-based).
+func CellName(row, col int) string {
+	return columnName(col) + strconv.Itoa(row+1)
+}
+
+// columnName returns the letters of a zero-based column index: A..Z, AA..AZ, ...
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
+
+// columnIndex returns the zero-based index of a column given by its letters, at most
+// maxColumnLetters of them.
+func columnIndex(letters string) (int, error) {
+	if len(letters) > maxColumnLetters {
+		return 0, fmt.Errorf("column %s has more than %d letters", letters, maxColumnLetters)
+	}
+	index := 0
+	for _, r := range letters {
+		index = index*26 + int(r-'A') + 1
+	}
+	return index - 1, nil
+}
+
+// EvaluateFormulas computes the formula cells of all tables (see table.Cell.Formula), replacing their
+// content by the formatted result, and applies number formats to numeric cells that have one. Text
+// that starts with "=" but not with an expression, like "== Overview ==", is not a formula and stays
+// text; errors in formulas, such as a division by zero, fail the table.
+// Formulas refer to cells by their address (see ParseCellAddress): their position on the logical grid
+// of Place, so a spanning cell can be named by any slot it covers, or their ID, in their own table
+// or, with a "table!" prefix, in another one.
+func EvaluateFormulas(allTables map[string]table.Table) error {
+	ids := make([]string, 0, len(allTables))
+	for id := range allTables {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids) // Report errors deterministically.
+	fs := &formulaState{index: newCellIndex(allTables), values: make(map[*table.Cell]float64)}
+	for _, id := range ids {
+		if err := fs.evaluateTable(id); err != nil {
+			return fmt.Errorf("table '%s': %w", id, err)
+		}
+	}
+	// Formats are applied once all formulas are computed, which read the unformatted values.
+	for _, id := range ids {
+		if err := fs.formatTable(id); err != nil {
+			return fmt.Errorf("table '%s': %w", id, err)
+		}
+	}
+	return nil
+}
+
+// formulaState is the evaluation state of the formula cells of a document.
+type formulaState struct {
+	index    *cellIndex
+	current  *tableGrid // The table being evaluated; cells of other tables are named with theirs.
+	values   map[*table.Cell]float64
+	visiting []*table.Cell // Formula cells being evaluated, outermost first, to report cycles.
+}
+
+func (fs *formulaState) evaluateTable(id string) (err error) {
+	if fs.current, err = fs.index.grid(id); err != nil {
+		return err
+	}
+	for _, cell := range fs.current.cells {
+		if cell.Formula != "" {
+			// A cell that turns out not to be a formula has lost its Formula, and its text is no number.
+			if _, err := fs.value(cell); err != nil && cell.Formula != "" {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *formulaState) formatTable(id string) (err error) {
+	if fs.current, err = fs.index.grid(id); err != nil {
+		return err
+	}
+	for _, cell := range fs.current.cells {
+		format := fs.numberFormat(cell)
+		switch {
+		case cell.Formula != "":
+			cell.Content, err = formatNumber(fs.values[cell], format)
+		case format != "":
+			if number, parseErr := strconv.ParseFloat(strings.TrimSpace(cell.Content), 64); parseErr == nil {
+				cell.Content, err = formatNumber(number, format)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("cell %s: %w", fs.name(cell), err)
+		}
+	}
+	return nil
+}
+
+// name returns the name of a cell's top-left slot, after the ID of its table if it is not the one
+// being evaluated.
+func (fs *formulaState) name(cell *table.Cell) string {
+	g := fs.index.owner[cell]
+	if g != fs.current {
+		return g.id + "!" + g.name(cell)
+	}
+	return g.name(cell)
+}
+
+// numberFormat returns the number format of a cell: its own, else that of its column.
+func (fs *formulaState) numberFormat(cell *table.Cell) string {
+	if cell.NumberFormat != "" {
+		return cell.NumberFormat
+	}
+	g := fs.index.owner[cell]
+	if col := g.position[cell][1]; col < len(g.table.Columns) {
+		return g.table.Columns[col].NumberFormat
+	}
+	return ""
+}
+
+// value returns the numeric value of a cell: the result of its formula, or its content read as a
+// number. Text is an error; empty cells are 0. A cell whose formula does not start with an
+// expression is text after all: its Formula is cleared.
+func (fs *formulaState) value(cell *table.Cell) (value float64, err error) {
+	if cell.Formula == "" {
+		if strings.TrimSpace(cell.Content) == "" {
+			return 0, nil
+		}
+		number, ok := cellNumber(cell.Content)
+		if !ok {
+			return 0, fmt.Errorf("cell %s ('%s') is not a number", fs.name(cell), cell.Content)
+		}
+		return number, nil
+	}
+	if v, done := fs.values[cell]; done {
+		return v, nil
+	}
+	for i, visiting := range fs.visiting {
+		if visiting == cell {
+			var path []string
+			for _, c := range fs.visiting[i:] {
+				path = append(path, fs.name(c))
+			}
+			return 0, fmt.Errorf("circular reference: %s -> %s", strings.Join(path, " -> "), fs.name(cell))
+		}
+	}
+	fs.visiting = append(fs.visiting, cell)
+	defer func() { fs.visiting = fs.visiting[:len(fs.visiting)-1] }()
+	p := &formulaParser{fs: fs, grid: fs.index.owner[cell], self: cell, src: strings.TrimPrefix(cell.Formula, "=")}
+	v, err := p.parse()
+	if err != nil {
+		if _, text := err.(notFormulaError); text {
+			cell.Formula = ""
+			return fs.value(cell)
+		}
+		if _, located := err.(*formulaError); located {
+			return 0, err
+		}
+		return 0, &formulaError{cell: fs.name(cell), err: err}
+	}
+	fs.values[cell] = v
+	return v, nil
+}
+
+// formulaError is an error in the formula of a cell, located at the innermost formula it occurs in.
+type formulaError struct {
+	cell string
+	err  error
+}
+
+func (e *formulaError) Error() string { return "cell " + e.cell + ": " + e.err.Error() }
+
+// notFormulaError reports that the text after "=" does not start with an expression, so the cell
+// holds text rather than a formula (see value).
+type notFormulaError struct{ src string }
+
+func (e notFormulaError) Error() string { return "'=" + e.src + "' is not a formula" }
+
+// cellNumber reads a cell's content as a number. Surrounding spaces, thousands separators and a
+// trailing percent sign are ignored, as in conditional formatting rules.
+func cellNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	number, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	return number, err == nil
+}
+
+var (
+	formulaNumberRegex = regexp.MustCompile(`^\d+(\.\d*)?([eE][+-]?\d+)?|^\.\d+`)
+	formulaNameRegex   = regexp.MustCompile(`^[A-Za-z_]+\d*`)
+	// formulaAddressRegex and formulaRangeRegex match a cell address (see ParseCellAddress) and a range
+	// (see cellRangeRegex), optionally in another table, at the start of the rest of a formula.
+	formulaAddressRegex = regexp.MustCompile(`^(?:[\w\-]+!)?(?:#[\w\-]+|[A-Z]+\d+\b)`)
+	formulaRangeRegex   = regexp.MustCompile(`^(?:([\w\-]+)!)?([A-Z]+\d+:[A-Z]+\d+|[A-Z]+:[A-Z]+|\d+:\d+)`)
+)
+
+// formulaFunctions are the functions a formula can call. Their arguments are numbers or ranges.
+var formulaFunctions = map[string]func(args []float64) (float64, error){
+	"SUM": func(args []float64) (float64, error) {
+		sum := 0.0
+		for _, v := range args {
+			sum += v
+		}
+		return sum, nil
+	},
+	"AVG":     average,
+	"AVERAGE": average,
+	"MIN": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+		min := args[0]
+		for _, v := range args[1:] {
+			min = math.Min(min, v)
+		}
+		return min, nil
+	},
+	"MAX": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+		max := args[0]
+		for _, v := range args[1:] {
+			max = math.Max(max, v)
+		}
+		return max, nil
+	},
+	"COUNT": func(args []float64) (float64, error) { return float64(len(args)), nil },
+	"ABS": func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("ABS takes one number")
+		}
+		return math.Abs(args[0]), nil
+	},
+	"ROUND": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("ROUND takes a number and a number of decimals")
+		}
+		scale := math.Pow(10, math.Round(args[1]))
+		return math.Round(args[0]*scale) / scale, nil
+	},
+}
+
+func average(args []float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("average of no numbers")
+	}
+	sum := 0.0
+	for _, v := range args {
+		sum += v
+	}
+	return sum / float64(len(args)), nil
+}
+
+// formulaParser evaluates a formula as it parses it:
+//
+//	expr    = term {("+" | "-") term}
+//	term    = unary {("*" | "/") unary}
+//	unary   = ("-" | "+") unary | primary
+//	primary = number | cell | name "(" [arg {"," arg}] ")" | "(" expr ")"
+//	arg     = range | expr
+//
+// Cells are named like "B3" or "#db-primary"; ranges like "B2:B5", "C:C" (a whole column) or "2:2" (a
+// whole row). Both can be in another table: "other!B3", "other!B2:B5".
+type formulaParser struct {
+	fs   *formulaState
+	grid *tableGrid // The table of the formula.
+	self *table.Cell
+	src  string
+	pos  int
+}
+
+func (p *formulaParser) parse() (float64, error) {
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.skipSpaces(); p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected '%s' in formula '=%s'", p.src[p.pos:], p.src)
+	}
+	return v, nil
+}
+
+func (p *formulaParser) skipSpaces() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// accept consumes the next non-space byte if it is one of chars.
+func (p *formulaParser) accept(chars string) (byte, bool) {
+	p.skipSpaces()
+	if p.pos < len(p.src) && strings.IndexByte(chars, p.src[p.pos]) >= 0 {
+		p.pos++
+		return p.src[p.pos-1], true
+	}
+	return 0, false
+}
+
+func (p *formulaParser) expr() (float64, error) {
+	v, err := p.term()
+	for err == nil {
+		op, ok := p.accept("+-")
+		if !ok {
+			break
+		}
+		var rhs float64
+		if rhs, err = p.term(); op == '+' {
+			v += rhs
+		} else {
+			v -= rhs
+		}
+	}
+	return v, err
+}
+
+func (p *formulaParser) term() (float64, error) {
+	v, err := p.unary()
+	for err == nil {
+		op, ok := p.accept("*/")
+		if !ok {
+			break
+		}
+		var rhs float64
+		if rhs, err = p.unary(); err != nil {
+			break
+		}
+		if op == '*' {
+			v *= rhs
+		} else if rhs == 0 {
+			err = fmt.Errorf("division by zero")
+		} else {
+			v /= rhs
+		}
+	}
+	return v, err
+}
+
+func (p *formulaParser) unary() (float64, error) {
+	if op, ok := p.accept("+-"); ok {
+		v, err := p.unary()
+		if op == '-' {
+			v = -v
+		}
+		return v, err
+	}
+	return p.primary()
+}
+
+func (p *formulaParser) primary() (float64, error) {
+	p.skipSpaces()
+	rest := p.src[p.pos:]
+	if _, ok := p.accept("("); ok {
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return 0, fmt.Errorf("missing ')' in formula '=%s'", p.src)
+		}
+		return v, nil
+	}
+	if number := formulaNumberRegex.FindString(rest); number != "" {
+		p.pos += len(number)
+		return strconv.ParseFloat(number, 64)
+	}
+	if address := formulaAddressRegex.FindString(rest); address != "" {
+		p.pos += len(address)
+		addr, err := ParseCellAddress(address)
+		if err != nil {
+			return 0, err
+		}
+		_, cell, err := p.fs.index.resolve(p.grid.id, addr)
+		if err != nil {
+			return 0, fmt.Errorf("%w, in formula '=%s'", err, p.src)
+		}
+		if cell == nil {
+			return 0, nil // A slot without a cell is empty.
+		}
+		return p.fs.value(cell)
+	}
+	// Text that does not even start like an expression, such as "== Overview ==" or "=Overview", is
+	// not a formula; errors after the start of one are.
+	atStart := strings.TrimSpace(p.src[:p.pos]) == ""
+	name := formulaNameRegex.FindString(rest)
+	if name == "" {
+		if atStart && rest != "" {
+			return 0, notFormulaError{p.src}
+		}
+		if rest == "" {
+			return 0, fmt.Errorf("formula '=%s' ends unexpectedly", p.src)
+		}
+		return 0, fmt.Errorf("unexpected '%s' in formula '=%s'", rest, p.src)
+	}
+	p.pos += len(name)
+	if _, ok := p.accept("("); ok {
+		return p.call(strings.ToUpper(name))
+	}
+	if atStart {
+		return 0, notFormulaError{p.src}
+	}
+	return 0, fmt.Errorf("unknown name '%s' in formula '=%s'", name, p.src)
+}
+
+// call evaluates the arguments of a function, after its opening parenthesis, and calls it. Ranges
+// contribute the numbers they hold; text and empty cells in them are left out.
+func (p *formulaParser) call(name string) (float64, error) {
+	fn, ok := formulaFunctions[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown function '%s' in formula '=%s'", name, p.src)
+	}
+	var args []float64
+	if _, ok := p.accept(")"); !ok {
+		for {
+			p.skipSpaces()
+			if matches := formulaRangeRegex.FindStringSubmatch(p.src[p.pos:]); matches != nil {
+				p.pos += len(matches[0])
+				values, err := p.rangeValues(matches)
+				if err != nil {
+					return 0, err
+				}
+				args = append(args, values...)
+			} else {
+				v, err := p.expr()
+				if err != nil {
+					return 0, err
+				}
+				args = append(args, v)
+			}
+			if _, ok := p.accept(","); ok {
+				continue
+			}
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			return 0, fmt.Errorf("missing ')' after the arguments of %s in formula '=%s'", name, p.src)
+		}
+	}
+	v, err := fn(args)
+	if err != nil {
+		return 0, fmt.Errorf("%s in formula '=%s'", err, p.src)
+	}
+	return v, nil
+}
+
+// rangeValues returns the numbers in a range matched by formulaRangeRegex. A whole column or row
+// leaves out the formula's own cell, so a total can sum the column it is in.
+func (p *formulaParser) rangeValues(matches []string) ([]float64, error) {
+	g := p.grid
+	if matches[1] != "" {
+		var err error
+		if g, err = p.fs.index.grid(matches[1]); err != nil {
+			return nil, fmt.Errorf("%w, in formula '=%s'", err, p.src)
+		}
+	}
+	r1, c1, r2, c2, whole, err := rangeBounds(cellRangeRegex.FindStringSubmatch(matches[2]), g.rows, g.cols)
+	if err != nil {
+		return nil, fmt.Errorf("%w, in formula '=%s'", err, p.src)
+	}
+	var values []float64
+	seen := make(map[*table.Cell]bool)
+	for r := r1; r <= r2; r++ {
+		for c := c1; c <= c2; c++ {
+			if !g.inside(r, c) {
+				return nil, fmt.Errorf("%w, in formula '=%s'", g.outsideError(CellAddress{TableID: matches[1], Row: r, Col: c}), p.src)
+			}
+			cell := g.at(r, c)
+			if cell == nil || seen[cell] || (whole && cell == p.self) {
+				continue
+			}
+			seen[cell] = true
+			if cell.Formula == "" {
+				if _, ok := cellNumber(cell.Content); !ok {
+					continue
+				}
+			}
+			v, err := p.fs.value(cell)
+			if err != nil {
+				if cell.Formula == "" {
+					continue // Text starting with "=", like the text it is among.
+				}
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// numberFormatRegex splits a number format into a prefix, the integer digits (with "," for
+// thousands grouping), the decimal digits, a percent sign and a suffix, e.g. "$#,##0.00" or "0.0 %".
+var numberFormatRegex = regexp.MustCompile(`^([^#0]*?)([#,]*0)(?:\.(0+))?(\s*%)?([^#0%]*)$`)
+
+// formatNumber formats a number with a spreadsheet-like pattern: "0" rounds to an integer, "0.00" to
+// two decimals, "#,##0" groups thousands, a "%" shows the number as a percentage, and other text
+// before and after is kept. An empty pattern shows up to four decimals, without trailing zeros.
+func formatNumber(v float64, pattern string) (string, error) {
+	if pattern == "" {
+		return strconv.FormatFloat(math.Round(v*1e4)/1e4, 'f', -1, 64), nil
+	}
+	matches := numberFormatRegex.FindStringSubmatch(pattern)
+	if matches == nil {
+		return "", fmt.Errorf("invalid number format '%s': expected a pattern like '0', '0.00', '#,##0' or '0.0%%'", pattern)
+	}
+	if matches[4] != "" {
+		v *= 100
+	}
+	scale := math.Pow(10, float64(len(matches[3])))
+	digits := strconv.FormatFloat(math.Round(math.Abs(v)*scale)/scale, 'f', len(matches[3]), 64) // Halves round up.
+	if strings.Contains(matches[2], ",") {
+		integer, fraction := digits, ""
+		if dot := strings.IndexByte(digits, '.'); dot >= 0 {
+			integer, fraction = digits[:dot], digits[dot:]
+		}
+		for i := len(integer) - 3; i > 0; i -= 3 {
+			integer = integer[:i] + "," + integer[i:]
+		}
+		digits = integer + fraction
+	}
+	sign := ""
+	if v < 0 && strings.Trim(digits, "0.,") != "" {
+		sign = "-"
+	}
+	return sign + matches[1] + digits + matches[4] + matches[5], nil
+}
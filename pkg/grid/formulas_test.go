@@ -0,0 +1,178 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"strings"
+	"testing"
+)
+
+// newFormulaTestTable builds a table from rows of cell contents; contents starting with "=" are
+// formulas, as the parser sets them.
+func newFormulaTestTable(rows ...[]string) table.Table {
+	t := table.Table{ID: "t", Settings: table.DefaultGlobalSettings()}
+	for _, contents := range rows {
+		var row table.Row
+		for _, content := range contents {
+			cell := table.NewCell("", content)
+			if strings.HasPrefix(content, "=") {
+				cell.Formula = content
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+func TestEvaluateFormulas(t *testing.T) {
+	tbl := newFormulaTestTable(
+		[]string{"Host", "RAM", "CPU", "Cost"},
+		[]string{"db1", "1,024", "71.5", "=B2*C2/100"},
+		[]string{"db2", "2048", "93.25 %", "=B3 * (C3 - 3.25) / 100"},
+		[]string{"web", "", "n/a", "=-D2 + 2 * ROUND(1.25, 1)"},
+		[]string{"Total", "=SUM(B2:B4)", "=AVG(C2:C4)", "=SUM(D:D)"},
+		[]string{"Stats", "=MAX(B2:B3) - MIN(B2, B3)", "=COUNT(A1:C4)", "=B5/B2"},
+		[]string{"Span", "=B5+1", "=B7"},
+	)
+	// A spanning cell is named by any slot it covers, and counted once in a range.
+	tbl.Rows[6].Cells[1].Colspan = 2
+	tbl.Rows = append(tbl.Rows, newFormulaTestTable([]string{"Row", "=C7", "=SUM(7:7)"}).Rows...)
+	if err := EvaluateFormulas(map[string]table.Table{"t": tbl}); err != nil {
+		t.Fatalf("EvaluateFormulas() error = %v", err)
+	}
+	want := [][]string{
+		{"Host", "RAM", "CPU", "Cost"},
+		{"db1", "1,024", "71.5", "732.16"},
+		{"db2", "2048", "93.25 %", "1843.2"},
+		{"web", "", "n/a", "-729.56"},
+		{"Total", "3072", "82.375", "4921.8"},
+		{"Stats", "1024", "4", "3"},
+		{"Span", "3073", "3073"},
+		{"Row", "3073", "6146"},
+	}
+	for r, row := range tbl.Rows {
+		for c, cell := range row.Cells {
+			if cell.Content != want[r][c] {
+				t.Errorf("row %d, cell %d = %q, want %q", r+1, c+1, cell.Content, want[r][c])
+			}
+		}
+	}
+}
+
+func TestEvaluateFormulas_References(t *testing.T) {
+	main := newFormulaTestTable([]string{"Load", "42", "=#peak * 2"}, []string{"Sum", "=SUM(stats!A1:B1) + stats!#base", "=stats!C1"})
+	stats := newFormulaTestTable([]string{"1", "2", "=main!B1 + 1"}, []string{"10"})
+	stats.Rows[0].Cells[2].ID = "peak"
+	stats.Rows[1].Cells[0].ID = "base"
+	if err := EvaluateFormulas(map[string]table.Table{"main": main, "stats": stats}); err != nil {
+		t.Fatalf("EvaluateFormulas() error = %v", err)
+	}
+	got := []string{main.Rows[0].Cells[2].Content, main.Rows[1].Cells[1].Content, main.Rows[1].Cells[2].Content, stats.Rows[0].Cells[2].Content}
+	if want := []string{"86", "13", "43", "43"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("contents = %q, want %q", got, want)
+	}
+
+	// A cycle across tables names the cells of the other table with it.
+	a, b := newFormulaTestTable([]string{"=b!A1"}), newFormulaTestTable([]string{"=a!A1"})
+	err := EvaluateFormulas(map[string]table.Table{"a": a, "b": b})
+	if want := "table 'a': cell b!A1: circular reference: A1 -> b!A1 -> A1"; err == nil || err.Error() != want {
+		t.Errorf("EvaluateFormulas() error = %v, want %q", err, want)
+	}
+}
+
+func TestEvaluateFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]string
+		want string
+	}{
+		{"circular", [][]string{{"=B1", "=C1+1", "=A1"}}, "cell C1: circular reference: A1 -> B1 -> C1 -> A1"},
+		{"self", [][]string{{"1", "=SUM(A1:B1)"}}, "cell B1: circular reference: B1 -> B1"},
+		{"text", [][]string{{"abc", "=A1*2"}}, "cell B1: cell A1 ('abc') is not a number"},
+		{"outside", [][]string{{"1", "=C1"}}, "cell C1 is outside the table, which ends at B1"},
+		{"division by zero", [][]string{{"0", "=1/A1"}}, "cell B1: division by zero"},
+		{"unknown function", [][]string{{"=MEDIAN(1)"}}, "unknown function 'MEDIAN'"},
+		{"syntax", [][]string{{"=1 +"}}, "ends unexpectedly"},
+		{"trailing", [][]string{{"=1 2"}}, "unexpected '2'"},
+		{"range outside a function", [][]string{{"1", "=A1:A1"}}, "unexpected ':A1'"},
+		{"average of nothing", [][]string{{"x", "=AVG(A1:A1)"}}, "average of no numbers"},
+		{"nested error", [][]string{{"=1/0", "=A1"}}, "cell A1: division by zero"},
+		{"sum over an error", [][]string{{"10", "0"}, {"=A1/B1", "5"}, {"=SUM(A1:A2)", "x"}}, "cell A2: division by zero"},
+		{"trailing operator", [][]string{{"1", "=A1+"}}, "ends unexpectedly"},
+		{"reference to text starting with '='", [][]string{{"== Overview ==", "=A1"}}, "cell B1: cell A1 ('== Overview ==') is not a number"},
+		{"unknown ID", [][]string{{"=#nope"}}, "no cell has ID 'nope', in formula '=#nope'"},
+		{"row 0 range", [][]string{{"=SUM(A0:B1)"}}, "row 0 is not between 1 and 1000000, in formula '=SUM(A0:B1)'"},
+		{"long column range", [][]string{{"=SUM(A:AAAAAAAAAAAAAAAAAAAAAAAAAA)"}}, "has more than 3 letters"},
+		{"unknown table", [][]string{{"=SUM(nope!A:A)"}}, "unknown table 'nope'"},
+		{"outside another table", [][]string{{"=t!B1"}}, "cell t!B1 is outside table 't', which ends at A1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EvaluateFormulas(map[string]table.Table{"t": newFormulaTestTable(tt.rows...)})
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "table 't': ") {
+				t.Errorf("EvaluateFormulas() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestEvaluateFormulas_TextStartingWithEquals checks that text which starts with "=" but not with an
+// expression keeps its content rather than failing the document.
+func TestEvaluateFormulas_TextStartingWithEquals(t *testing.T) {
+	texts := []string{"== Overview ==", "=> next", "=Overview", "= ?"}
+	tbl := newFormulaTestTable(texts, []string{"1", "=SUM(A1:D1) + A2"})
+	if err := EvaluateFormulas(map[string]table.Table{"t": tbl}); err != nil {
+		t.Fatalf("EvaluateFormulas() error = %v", err)
+	}
+	for i, want := range texts {
+		if cell := tbl.Rows[0].Cells[i]; cell.Content != want || cell.Formula != "" {
+			t.Errorf("cell %d = %+v, want the text %q", i, cell, want)
+		}
+	}
+	// Ranges skip such text like any other.
+	if got := tbl.Rows[1].Cells[1].Content; got != "1" {
+		t.Errorf("SUM over text = %q, want \"1\"", got)
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		v       float64
+		pattern string
+		want    string
+	}{
+		{1 / 3.0, "", "0.3333"},
+		{2, "", "2"},
+		{1234.5, "0", "1235"},
+		{93.25, "0.0", "93.3"},
+		{1234567.891, "#,##0.00", "1,234,567.89"},
+		{-1234, "$#,##0", "-$1,234"},
+		{0.325, "0.0%", "32.5%"},
+		{0.5, "0 %", "50 %"},
+		{64, "0.0 GB", "64.0 GB"},
+		{-0.001, "0.00", "0.00"},
+	}
+	for _, tt := range tests {
+		if got, err := formatNumber(tt.v, tt.pattern); err != nil || got != tt.want {
+			t.Errorf("formatNumber(%v, %q) = %q, %v, want %q", tt.v, tt.pattern, got, err, tt.want)
+		}
+	}
+	if _, err := formatNumber(1, "abc"); err == nil {
+		t.Error("formatNumber() with an invalid pattern succeeded")
+	}
+}
+
+func TestEvaluateFormulas_NumberFormats(t *testing.T) {
+	tbl := newFormulaTestTable([]string{"Size", "1234.5", "=B1*2"}, []string{"Share", "0.25", "=B2"})
+	tbl.Columns = []table.ColumnSpec{{}, {NumberFormat: "#,##0"}}
+	tbl.Rows[1].Cells[2].NumberFormat = "0%"
+	if err := EvaluateFormulas(map[string]table.Table{"t": tbl}); err != nil {
+		t.Fatalf("EvaluateFormulas() error = %v", err)
+	}
+	// Plain numbers take the column's format; formulas compute with the unformatted values.
+	got := []string{tbl.Rows[0].Cells[0].Content, tbl.Rows[0].Cells[1].Content, tbl.Rows[0].Cells[2].Content, tbl.Rows[1].Cells[1].Content, tbl.Rows[1].Cells[2].Content}
+	want := []string{"Size", "1,235", "2469", "0", "25%"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("formatted contents = %q, want %q", got, want)
+	}
+}
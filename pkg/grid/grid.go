@@ -0,0 +1,72 @@
+// Package grid treats tables like spreadsheets: it places their cells on a logical grid, resolves
+// cell addresses such as "B3", "#db-primary" or "main_overview!B3" on it, and computes formulas.
+// It only depends on the table model, so both the parser and the renderer can use it.
+package grid
+
+import "diagramgen/pkg/table"
+
+// Place places the cells of a table on a grid of logical rows and columns and returns, for each slot,
+// the cell covering it (nil for none). Each cell covers Rowspan x Colspan slots. All rows of the grid
+// have the same length.
+//
+// Placement follows the HTML table model: rows are filled left to right, and a cell that would start
+// on (or span over) a slot already covered by a rowspan or colspan from an earlier row moves right to
+// the first column where all of its slots are free. Cells are never dropped; the grid grows wider when
+// the shifted cells need more columns than the widest row declares, and taller when a rowspan reaches
+// past the last row.
+func Place(t *table.Table) [][]*table.Cell {
+	if t == nil {
+		return nil
+	}
+	slots := make([][]*table.Cell, len(t.Rows))
+	cols := 0
+	for _, row := range t.Rows {
+		declared := 0
+		for _, cell := range row.Cells {
+			declared += cell.Colspan
+		}
+		if declared > cols {
+			cols = declared
+		}
+	}
+	free := func(r, c, rowspan, colspan int) bool {
+		for rr := r; rr < r+rowspan && rr < len(slots); rr++ {
+			for cc := c; cc < c+colspan && cc < len(slots[rr]); cc++ {
+				if slots[rr][cc] != nil {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for r := range t.Rows {
+		col := 0
+		for i := range t.Rows[r].Cells {
+			cell := &t.Rows[r].Cells[i]
+			for !free(r, col, cell.Rowspan, cell.Colspan) {
+				col++
+			}
+			for rr := r; rr < r+cell.Rowspan; rr++ {
+				for len(slots) <= rr {
+					slots = append(slots, nil)
+				}
+				for cc := col; cc < col+cell.Colspan; cc++ {
+					for len(slots[rr]) <= cc {
+						slots[rr] = append(slots[rr], nil)
+					}
+					slots[rr][cc] = cell
+				}
+			}
+			col += cell.Colspan
+			if col > cols {
+				cols = col
+			}
+		}
+	}
+	for r := range slots {
+		for len(slots[r]) < cols {
+			slots[r] = append(slots[r], nil)
+		}
+	}
+	return slots
+}
@@ -0,0 +1,32 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"testing"
+)
+
+func TestPlace(t *testing.T) {
+	// a spans two rows, so c moves right past it; e moves past d's two rows and widens the grid.
+	tbl := newFormulaTestTable([]string{"a", "b"}, []string{"c", "d"}, []string{"e"})
+	tbl.Rows[0].Cells[0].Rowspan = 2
+	tbl.Rows[1].Cells[1].Rowspan = 2
+	slots := Place(&tbl)
+	want := [][]string{{"a", "b", ""}, {"a", "c", "d"}, {"e", "", "d"}}
+	if len(slots) != len(want) {
+		t.Fatalf("Place() gave %d rows, want %d", len(slots), len(want))
+	}
+	for r := range want {
+		for c := range want[r] {
+			got := ""
+			if c < len(slots[r]) && slots[r][c] != nil {
+				got = slots[r][c].Content
+			}
+			if got != want[r][c] {
+				t.Errorf("slot %s = %q, want %q", CellName(r, c), got, want[r][c])
+			}
+		}
+	}
+	if slots := Place(&table.Table{}); len(slots) != 0 {
+		t.Error("Place() of an empty table is not empty")
+	}
+}
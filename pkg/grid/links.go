@@ -0,0 +1,55 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"fmt"
+	"sort"
+)
+
+// CheckLinks checks that the links of all cells (see table.Cell.Links) point to a cell of the
+// same table, where they are drawn as arrows.
+func CheckLinks(allTables map[string]table.Table) error {
+	ids := make([]string, 0, len(allTables))
+	for id := range allTables {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids) // Report errors deterministically.
+	index := newCellIndex(allTables)
+	for _, id := range ids {
+		g, err := index.grid(id)
+		if err != nil {
+			return err
+		}
+		for _, cell := range g.cells {
+			for _, link := range cell.Links {
+				if err := checkCellLink(index, g, cell, link); err != nil {
+					return fmt.Errorf("table '%s': cell %s: link to '%s': %w", id, g.name(cell), link, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkCellLink(index *cellIndex, g *tableGrid, from *table.Cell, link string) error {
+	addr, err := ParseCellAddress(link)
+	if err != nil {
+		return err
+	}
+	if addr.TableID != "" && addr.TableID != g.id {
+		return fmt.Errorf("links join cells of the same table")
+	}
+	if addr.ID != "" && g.byID[addr.ID] == nil {
+		return fmt.Errorf("the table has no cell with ID '%s'", addr.ID)
+	}
+	_, cell, err := index.resolve(g.id, addr)
+	switch {
+	case err != nil:
+		return err
+	case cell == nil:
+		return fmt.Errorf("no cell covers %s", CellName(addr.Row, addr.Col))
+	case cell == from:
+		return fmt.Errorf("a cell cannot link to itself")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package grid
+
+import (
+	"diagramgen/pkg/table"
+	"strings"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		links []string
+		want  string
+	}{
+		{"by position and ID", []string{"B1", "#db", "t!#db"}, ""},
+		{"another table", []string{"u!A1"}, "links join cells of the same table"},
+		{"ID of another table", []string{"#elsewhere"}, "no cell with ID 'elsewhere'"},
+		{"outside", []string{"C1"}, "outside the table"},
+		{"itself", []string{"A1"}, "cannot link to itself"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := newFormulaTestTable([]string{"API", "DB"})
+			tbl.Rows[0].Cells[0].Links = tt.links
+			tbl.Rows[0].Cells[1].ID = "db"
+			other := newFormulaTestTable([]string{"x"})
+			other.Rows[0].Cells[0].ID = "elsewhere"
+			err := CheckLinks(map[string]table.Table{"t": tbl, "u": other})
+			if tt.want == "" && err != nil {
+				t.Errorf("CheckLinks() error = %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "table 't': cell A1: ")) {
+				t.Errorf("CheckLinks() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
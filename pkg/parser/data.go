@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+var (
+	rowsFromRegex = regexp.MustCompile(`^rows_from:\s*(\S+)(?:\s+path=(\S+))?\s*$`)
+	// jsonPathStepRegex matches one step of a JSON path after the leading "$": ".name", ".*",
+	// "[2]", "[*]" or "['name']".
+	jsonPathStepRegex = regexp.MustCompile(`^(?:\.([\w\-]+|\*)|\[(\d+|\*)\]|\['([^']*)'\])`)
+
+	// dataEscaper replaces the characters that separate cells or start directives, settings, titles,
+	// formulas and row markers in the values a row template prints by private use characters, so that data is
+	// always cell text; dataUnescaper puts them back once the cell is parsed. Line breaks in values
+	// become line breaks in the cell.
+	dataEscaper   = strings.NewReplacer(escapedDataPairs(false)...)
+	dataUnescaper = strings.NewReplacer(escapedDataPairs(true)...)
+)
+
+// escapedDataChars are the characters of data values that dataEscaper hides from the table syntax.
+const escapedDataChars = `|:{}[]=@\`
+
+// escapedDataPairs returns the replacements of dataEscaper, or of dataUnescaper if reverse is set.
+func escapedDataPairs(reverse bool) []string {
+	var pairs []string
+	for _, c := range escapedDataChars {
+		from, to := string(c), string(0xE000+c)
+		if reverse {
+			from, to = to, from
+		}
+		pairs = append(pairs, from, to)
+	}
+	if !reverse {
+		pairs = append(pairs, "\r\n", `\n`, "\n", `\n`)
+	}
+	return pairs
+}
+
+// escapeDataValue formats a value printed by a row template as cell text (see dataEscaper).
+func escapeDataValue(value interface{}) string {
+	return dataEscaper.Replace(fmt.Sprint(value))
+}
+
+// unescapeData restores the characters of data values hidden by dataEscaper.
+func unescapeData(s string) string {
+	return dataUnescaper.Replace(s)
+}
+
+// escapeTemplateOutput makes every action of a template that prints a value pass it through
+// escapeData, as if it were written "{{.field | escapeData}}". Text written in the template itself
+// keeps its meaning.
+func escapeTemplateOutput(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeTemplateOutput(tree, child)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) == 0 { // Assignments print nothing.
+			escape := parse.NewIdentifier("escapeData").SetTree(tree).SetPos(n.Pos)
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos, Args: []parse.Node{escape}})
+		}
+	case *parse.IfNode:
+		escapeTemplateOutput(tree, n.List)
+		escapeTemplateOutput(tree, n.ElseList)
+	case *parse.RangeNode:
+		escapeTemplateOutput(tree, n.List)
+		escapeTemplateOutput(tree, n.ElseList)
+	case *parse.WithNode:
+		escapeTemplateOutput(tree, n.List)
+		escapeTemplateOutput(tree, n.ElseList)
+	}
+}
+
+// expandDataRows replaces each `rows_from: file path=$.json.path` line of a table definition, and the
+// row template on the next non-empty line, by one row per item of the data. The template is a Go
+// text/template executed with the item, so `{{.field}}` inserts a field. JSON files are searched
+// with path (the whole document, or each of its elements if it is an array, by default); CSV files
+// give one item per record, keyed by the header record. Relative paths are resolved against baseDir.
+// The values the template prints are escaped (see dataEscaper): "a|b" in the data is one cell.
+func expandDataRows(lines []string, baseDir string) ([]string, error) {
+	var expanded []string
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(trimmed, "rows_from:") {
+			expanded = append(expanded, lines[i])
+			continue
+		}
+		matches := rowsFromRegex.FindStringSubmatch(trimmed)
+		if len(matches) != 3 {
+			return nil, fmt.Errorf("invalid rows_from line '%s': expected 'rows_from: file.json [path=$.items[*]]' or 'rows_from: file.csv'", trimmed)
+		}
+		j := i + 1
+		for j < len(lines) && strings.TrimSpace(lines[j]) == "" {
+			j++
+		}
+		if j == len(lines) {
+			return nil, fmt.Errorf("rows_from line '%s' must be followed by a row template", trimmed)
+		}
+		rowTemplate, err := template.New("row").Funcs(template.FuncMap{"escapeData": escapeDataValue}).Option("missingkey=error").Parse(strings.TrimSpace(lines[j]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid row template '%s': %w", strings.TrimSpace(lines[j]), err)
+		}
+		for _, tmpl := range rowTemplate.Templates() {
+			escapeTemplateOutput(tmpl.Tree, tmpl.Tree.Root)
+		}
+		items, err := loadDataItems(matches[1], matches[2], baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("rows_from '%s': %w", matches[1], err)
+		}
+		for k, item := range items {
+			var row bytes.Buffer
+			if err := rowTemplate.Execute(&row, item); err != nil {
+				return nil, fmt.Errorf("rows_from '%s', item %d: %w", matches[1], k, err)
+			}
+			expanded = append(expanded, row.String())
+		}
+		i = j
+	}
+	return expanded, nil
+}
+
+// loadDataItems reads the items of a rows_from data file.
+func loadDataItems(file, path, baseDir string) ([]interface{}, error) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(baseDir, file)
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext != ".json" && ext != ".csv" {
+		return nil, fmt.Errorf("unsupported data file type '%s' (expected .json or .csv)", filepath.Ext(file))
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ext == ".csv" {
+		if path != "" {
+			return nil, fmt.Errorf("path= only applies to JSON files")
+		}
+		records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		var items []interface{}
+		for r := 1; r < len(records); r++ {
+			item := make(map[string]interface{}, len(records[0]))
+			for c, name := range records[0] {
+				item[strings.TrimSpace(name)] = records[r][c]
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber() // Numbers are written as they appear in the file.
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if path == "" {
+		if list, ok := doc.([]interface{}); ok {
+			return list, nil
+		}
+		return []interface{}{doc}, nil
+	}
+	return selectJSONPath(doc, path)
+}
+
+// selectJSONPath returns the values a JSON path selects from doc. Paths start at the root "$" and
+// step into fields (".name" or "['name']"), array elements ("[2]") or all elements or fields ("[*]"
+// or ".*", fields sorted by name). A field or element that does not exist selects nothing.
+func selectJSONPath(doc interface{}, path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSON path '%s' must start with '$'", path)
+	}
+	current := []interface{}{doc}
+	for rest := path[1:]; rest != ""; {
+		matches := jsonPathStepRegex.FindStringSubmatch(rest)
+		if matches == nil {
+			return nil, fmt.Errorf("unsupported JSON path '%s' at '%s'", path, rest)
+		}
+		rest = rest[len(matches[0]):]
+		var next []interface{}
+		for _, value := range current {
+			switch {
+			case matches[1] == "*" || matches[2] == "*":
+				switch v := value.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case map[string]interface{}:
+					names := make([]string, 0, len(v))
+					for name := range v {
+						names = append(names, name)
+					}
+					sort.Strings(names) // Fields in a stable order.
+					for _, name := range names {
+						next = append(next, v[name])
+					}
+				}
+			case matches[2] != "":
+				index, _ := strconv.Atoi(matches[2])
+				if list, ok := value.([]interface{}); ok && index < len(list) {
+					next = append(next, list[index])
+				}
+			default:
+				if object, ok := value.(map[string]interface{}); ok {
+					if field, ok := object[matches[1]+matches[3]]; ok {
+						next = append(next, field)
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current, nil
+}
@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDataRows(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	jsonPath := writeFile("services.json", `{"services": [{"name": "auth", "replicas": 3, "up": true}, {"name": "billing", "replicas": 1.50, "up": false}]}`)
+	writeFile("owners.csv", "service, owner\nauth,Team A\nbilling,\"Team B, Berlin\"\n")
+
+	input := strings.Join([]string{
+		"table: [t] {header_rows:1}",
+		"Name | Replicas",
+		"rows_from: " + jsonPath + " path=$.services[*]",
+		"",
+		`{{.name}} | {{.replicas}} {{if not .up}}{bg:#FDE7E9}{{end}}`,
+		"Total | 4.5",
+	}, "\n")
+	tbl, err := parseSingleTableDefinition(input)
+	if err != nil {
+		t.Fatalf("parseSingleTableDefinition() error = %v", err)
+	}
+	var got [][]string
+	for _, row := range tbl.Rows {
+		var cells []string
+		for _, cell := range row.Cells {
+			cells = append(cells, cell.Content+cell.BackgroundColor)
+		}
+		got = append(got, cells)
+	}
+	want := [][]string{{"Name", "Replicas"}, {"auth", "3"}, {"billing", "1.50#FDE7E9"}, {"Total", "4.5"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON rows = %q, want %q", got, want)
+	}
+
+	// CSV records are keyed by the header record; relative paths are resolved against baseDir.
+	sheet, _ := newStyleSheet("light")
+	tbl, err = parseTableDefinition("table: [t]\nrows_from: owners.csv\n{{.service}} | {{.owner}}", sheet, dir, "")
+	if err != nil {
+		t.Fatalf("parseTableDefinition() error = %v", err)
+	}
+	if len(tbl.Rows) != 2 || tbl.Rows[1].Cells[1].Content != "Team B, Berlin" {
+		t.Errorf("CSV rows = %+v", tbl.Rows)
+	}
+}
+
+func TestDataRowsEscaping(t *testing.T) {
+	dir := t.TempDir()
+	values := []string{"a | b", "x ::bg=#FF0000::", "{bg:red}", "[Title] text", "=1+1", `C:\temp`, "@row {bg:red}", "two\nlines"}
+	var csvRecords []string
+	for _, value := range values {
+		csvRecords = append(csvRecords, `"`+value+`"`)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "values.csv"), []byte("value\n"+strings.Join(csvRecords, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sheet, _ := newStyleSheet("light")
+	// The template's own directive and settings keep their meaning; the values are plain text.
+	tbl, err := parseTableDefinition("table: [t]\nrows_from: values.csv\n{{.value}} ::align=right:: | {{if .value}}{{.value}}{{end}} {fg:#333333}", sheet, dir, "")
+	if err != nil {
+		t.Fatalf("parseTableDefinition() error = %v", err)
+	}
+	if len(tbl.Rows) != len(values) {
+		t.Fatalf("got %d rows, want %d: %+v", len(tbl.Rows), len(values), tbl.Rows)
+	}
+	for i, row := range tbl.Rows {
+		want := strings.TrimSpace(values[i])
+		if len(row.Cells) != 2 || row.BackgroundColor != "" {
+			t.Errorf("value %q: got row %+v, want 2 cells", values[i], row)
+			continue
+		}
+		for _, cell := range row.Cells {
+			if cell.Content != want || cell.Title != "" || cell.Formula != "" || cell.BackgroundColor != "" {
+				t.Errorf("value %q: got cell %+v, want it as plain text", values[i], cell)
+			}
+		}
+		if row.Cells[0].Align != "right" || row.Cells[1].TextColor != "#333333" {
+			t.Errorf("value %q: template styling lost: %+v", values[i], row.Cells)
+		}
+	}
+}
+
+func TestSelectJSONPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"a": []interface{}{
+			map[string]interface{}{"b": "x", "c d": "y"},
+			map[string]interface{}{"b": "z"},
+		},
+		"n": map[string]interface{}{"k2": 2.0, "k1": 1.0},
+	}
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"$", []interface{}{doc}},
+		{"$.a[*].b", []interface{}{"x", "z"}},
+		{"$.a[1].b", []interface{}{"z"}},
+		{"$['a'][0]['c d']", []interface{}{"y"}},
+		{"$.n.*", []interface{}{1.0, 2.0}},
+		{"$.a[5]", nil},
+		{"$.missing.b", nil},
+	}
+	for _, tt := range tests {
+		got, err := selectJSONPath(doc, tt.path)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectJSONPath(%q) = %v, %v, want %v", tt.path, got, err, tt.want)
+		}
+	}
+	for _, path := range []string{"a.b", "$..b", "$.a[-1]"} {
+		if _, err := selectJSONPath(doc, path); err == nil {
+			t.Errorf("selectJSONPath(%q) succeeded, want an error", path)
+		}
+	}
+}
+
+func TestDataRowErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "items.json"), []byte(`[{"name": "a"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing file", "rows_from: nope.json\n{{.name}}", "nope.json"},
+		{"missing template", "rows_from: items.json", "must be followed by a row template"},
+		{"bad template", "rows_from: items.json\n{{.name", "invalid row template"},
+		{"missing field", "rows_from: items.json\n{{.name}} | {{.owner}}", "item 0"},
+		{"unsupported type", "rows_from: items.xml\n{{.name}}", "unsupported data file type"},
+		{"malformed line", "rows_from:\n{{.name}}", "invalid rows_from line"},
+	}
+	sheet, _ := newStyleSheet("light")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTableDefinition("table: [t]\n"+tt.input, sheet, dir, "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseTableDefinition() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
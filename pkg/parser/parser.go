@@ -1,16 +1,34 @@
 package parser
 
 import (
+	"diagramgen/pkg/grid"
 	"diagramgen/pkg/table"
 	"fmt"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // ParseAllText takes a string input that may contain multiple table definitions
 // and parses them into an AllTables struct.
 func ParseAllText(fullInput string) (table.AllTables, error) {
+	return ParseAllTextWithOptions(fullInput, Options{})
+}
+
+// Options holds the settings of a document that come from outside of it.
+type Options struct {
+	// Variables are set from outside the document (e.g. the command line) and take precedence over
+	// the document's `let` lines of the same name.
+	Variables map[string]string
+	// BaseDir is the directory relative `rows_from:` data files are read from, usually that of the
+	// input file. Empty means the working directory.
+	BaseDir string
+}
+
+// ParseAllTextWithOptions is ParseAllText with options set from outside the document.
+func ParseAllTextWithOptions(fullInput string, opts Options) (table.AllTables, error) {
 	allTables := table.AllTables{Tables: make(map[string]table.Table)}
 	trimmedFullInput := strings.TrimSpace(fullInput)
 	if trimmedFullInput == "" {
@@ -18,6 +36,10 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 	}
 
 	initialLines := strings.Split(trimmedFullInput, "\n")
+	// Variables are substituted before anything else; `let` lines are left empty.
+	if err := collectVariables(initialLines, opts.Variables); err != nil {
+		return table.AllTables{}, err
+	}
 	var contentLines []string
 	var explicitMainTableID string
 
@@ -32,6 +54,9 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 			}
 			continue // Skip all empty lines
 		}
+		if styleLineRegex.MatchString(trimmedLine) || styleRuleRegex.MatchString(trimmedLine) || themeLineRegex.MatchString(trimmedLine) {
+			continue // Document-level style lines are collected below; they may precede main_table.
+		}
 		if explicitMainTableID == "" && len(allTables.Tables) == 0 { // Check for directive only if not already found and no tables parsed
 			matches := mainTableRegex.FindStringSubmatch(trimmedLine)
 			if len(matches) == 2 {
@@ -55,6 +80,18 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 		contentLines = []string{} // No content lines left if directive was last or only empty lines
 	}
 
+	// Collect the theme and style blocks first: tables may use classes defined further down.
+	// Collected lines are blanked so they neither end up in a table nor shift line numbers.
+	sheet, err := collectStyleSheet(initialLines, contentLines)
+	if err != nil {
+		return table.AllTables{}, err
+	}
+	// Templates are blanked the same way; they become tables only where cells instantiate them.
+	templates, err := collectTemplates(contentLines)
+	if err != nil {
+		return table.AllTables{}, err
+	}
+
 	var currentTableLines []string
 	var tableStartLineNumber int // Relative to contentLines
 
@@ -63,7 +100,7 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 			// If currentTableLines has content, then a previous table definition has ended.
 			if len(currentTableLines) > 0 {
 				tableDef := strings.Join(currentTableLines, "\n")
-				parsedTable, err := parseSingleTableDefinition(tableDef)
+				parsedTable, err := parseTableDefinition(tableDef, sheet, opts.BaseDir, "")
 				if err != nil {
 					return table.AllTables{}, fmt.Errorf("error parsing table starting at line %d: %w", tableStartLineNumber, err)
 				}
@@ -90,7 +127,7 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 	// Process the last table definition if any
 	if len(currentTableLines) > 0 {
 		tableDef := strings.Join(currentTableLines, "\n")
-		parsedTable, err := parseSingleTableDefinition(tableDef)
+		parsedTable, err := parseTableDefinition(tableDef, sheet, opts.BaseDir, "")
 		if err != nil {
 			return table.AllTables{}, fmt.Errorf("error parsing table starting at line %d: %w", tableStartLineNumber, err)
 		}
@@ -108,6 +145,18 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 		}
 	}
 
+	if err := expandTemplates(&allTables, templates, sheet, opts.BaseDir); err != nil {
+		return table.AllTables{}, err
+	}
+	// Formulas are computed once every table is known, as they can refer to cells of other tables.
+	if err := grid.EvaluateFormulas(allTables.Tables); err != nil {
+		return table.AllTables{}, fmt.Errorf("error evaluating formulas: %w", err)
+	}
+	if err := sheet.styleFormulaCells(); err != nil {
+		return table.AllTables{}, err
+	}
+	allTables.Warnings = append(allTables.Warnings, sheet.unmatchedRules()...)
+
 	// After processing all tables, handle explicitMainTableID
 	if explicitMainTableID != "" {
 		if _, exists := allTables.Tables[explicitMainTableID]; !exists {
@@ -125,9 +174,25 @@ func ParseAllText(fullInput string) (table.AllTables, error) {
 // It also parses global table settings from the title line and
 // rowspan and background color from individual cells.
 func parseSingleTableDefinition(tableInput string) (table.Table, error) {
-	// Initialize table with default settings. These can be overridden by parsed settings.
+	sheet, err := newStyleSheet("light")
+	if err != nil {
+		return table.Table{}, err
+	}
+	return parseTableDefinition(tableInput, sheet, "", "")
+}
+
+// parseTableDefinition parses a single table definition, resolving style classes against sheet and
+// reading `rows_from:` data files relative to baseDir. template names the template the definition is
+// an instance of, empty for a table; the rules of all instances of a template count as one.
+func parseTableDefinition(tableInput string, sheet *styleSheet, baseDir, template string) (table.Table, error) {
+	// Initialize table with the document defaults (built-in theme and document `default` style).
+	// These can be overridden by the table's classes and parsed settings.
+	defaults, err := sheet.tableDefaults()
+	if err != nil {
+		return table.Table{}, err
+	}
 	t := table.Table{
-		Settings: table.DefaultGlobalSettings(),
+		Settings: defaults,
 		Rows:     []table.Row{}, // Ensure Rows is initialized
 	}
 
@@ -149,6 +214,13 @@ func parseSingleTableDefinition(tableInput string) (table.Table, error) {
 	titleAndSettingsLine := strings.TrimPrefix(lines[0], "table:")
 	firstLineIdx := 1 // Start processing rows from the next line
 
+	// Rows generated from data files are expanded into ordinary row lines first.
+	rowLines, err := expandDataRows(lines[firstLineIdx:], baseDir)
+	if err != nil {
+		return table.Table{}, err
+	}
+	lines = append(lines[:firstLineIdx], rowLines...)
+
 		// Regex to capture optional table ID: e.g., "[my-id] Title {settings}"
 		idRegex := regexp.MustCompile(`^\s*\[([\w\-]+)\](.*)`)
 		idMatches := idRegex.FindStringSubmatch(titleAndSettingsLine)
@@ -163,9 +235,13 @@ func parseSingleTableDefinition(tableInput string) (table.Table, error) {
 		settingsRegex := regexp.MustCompile(`^(.*?)\s*\{(.*)\}\s*$`)
 		settingsMatch := settingsRegex.FindStringSubmatch(strings.TrimSpace(titleAndSettingsLine))
 
+		settingsStr := ""
 		if len(settingsMatch) == 3 { // 0: full match, 1: title part, 2: settings string
 			t.Title = strings.TrimSpace(settingsMatch[1])
-			settingsStr := settingsMatch[2]
+			settingsStr = settingsMatch[2]
+			if err := sheet.applyTableClasses(settingsStr, &t.Settings); err != nil {
+				return table.Table{}, fmt.Errorf("failed to apply table classes '%s': %w", settingsStr, err)
+			}
 			if err := parseGlobalSettings(settingsStr, &t.Settings); err != nil {
 				return table.Table{}, fmt.Errorf("failed to parse global settings '%s': %w", settingsStr, err)
 			}
@@ -173,12 +249,17 @@ func parseSingleTableDefinition(tableInput string) (table.Table, error) {
 			// No settings block found, the whole string is the title
 			t.Title = strings.TrimSpace(titleAndSettingsLine)
 		}
+		t.Title = unescapeData(t.Title) // Template arguments are escaped like data values.
 
 		if len(lines) <= 1 { // Only title/settings line, no rows
 			return t, nil
 		}
 	// THE ERRONEOUS '}' WAS HERE (after the if block, before "Process actual table rows")
 
+	// Conditional formatting rules: those inherited through the document's styles, then the table's own.
+	rules := sheet.tableRules(settingsStr)
+	tableRuleCount := 0
+
 	// Process actual table rows
 	for i := firstLineIdx; i < len(lines); i++ {
 		line := lines[i]
@@ -188,7 +269,76 @@ func parseSingleTableDefinition(tableInput string) (table.Table, error) {
 			continue // Skip empty lines
 		}
 
+		if strings.HasPrefix(trimmedLine, "rule:") {
+			styleName, rule, err := parseRule(trimmedLine)
+			if err != nil {
+				return table.Table{}, err
+			}
+			if styleName != "" {
+				return table.Table{}, fmt.Errorf("rule '%s' names style '%s': style rules are written outside of tables", rule.text, styleName)
+			}
+			rule.origin = fmt.Sprintf("table '%s'", t.ID)
+			if template != "" {
+				rule.origin = fmt.Sprintf("template '%s'", template)
+			}
+			rule.definition = fmt.Sprintf("%s, rule %d", rule.origin, tableRuleCount)
+			tableRuleCount++
+			rules = append(rules, rule)
+			sheet.allRules = append(sheet.allRules, rule)
+			continue
+		}
+
+		if strings.HasPrefix(trimmedLine, "columns:") {
+			if t.Columns != nil {
+				return table.Table{}, fmt.Errorf("duplicate columns line '%s'", trimmedLine)
+			}
+			columns, err := parseColumnsLine(strings.TrimPrefix(trimmedLine, "columns:"))
+			if err != nil {
+				return table.Table{}, fmt.Errorf("invalid columns line '%s': %w", trimmedLine, err)
+			}
+			t.Columns = columns
+			continue
+		}
+
+		// A Markdown-style delimiter row ("|---|:---:|") marks all rows above it as header rows.
+		// Colons set the column alignment unless the columns line already does.
+		if isHeaderSeparator(trimmedLine) {
+			t.Settings.HeaderRows = len(t.Rows)
+			for i, delimiter := range strings.Split(strings.Trim(trimmedLine, "| "), "|") {
+				delimiter = strings.TrimSpace(delimiter)
+				align := ""
+				switch {
+				case strings.HasPrefix(delimiter, ":") && strings.HasSuffix(delimiter, ":"):
+					align = "center"
+				case strings.HasSuffix(delimiter, ":"):
+					align = "right"
+				case strings.HasPrefix(delimiter, ":"):
+					align = "left"
+				}
+				if align == "" {
+					continue
+				}
+				for len(t.Columns) <= i {
+					t.Columns = append(t.Columns, table.ColumnSpec{})
+				}
+				if t.Columns[i].Align == "" {
+					t.Columns[i].Align = align
+				}
+			}
+			continue
+		}
+
 		var currentRow table.Row
+		// An optional leading "@row {key:value, ...}" marker styles the whole row.
+		if matches := rowDirectiveRegex.FindStringSubmatch(trimmedLine); len(matches) == 3 {
+			if err := parseRowDirective(matches[1], &currentRow); err != nil {
+				return table.Table{}, fmt.Errorf("invalid row directive in line '%s': %w", trimmedLine, err)
+			}
+			if err := sheet.applyToRow(&currentRow); err != nil {
+				return table.Table{}, fmt.Errorf("invalid row directive in line '%s': %w", trimmedLine, err)
+			}
+			trimmedLine = strings.TrimSpace(matches[2])
+		}
 		cellStrings := strings.Split(trimmedLine, "|")
 
 		startIdx := 0
@@ -228,41 +378,420 @@ func parseSingleTableDefinition(tableInput string) (table.Table, error) {
 		}
 	}
 
+	// Cell IDs are unique within a table, so "#id" names one cell.
+	cellIDs := make(map[string]bool)
+	for _, row := range t.Rows {
+		for _, cell := range row.Cells {
+			if cell.ID != "" && cellIDs[cell.ID] {
+				return table.Table{}, fmt.Errorf("duplicate cell id '%s' in table '%s'", cell.ID, t.ID)
+			}
+			cellIDs[cell.ID] = true
+		}
+	}
+
+	// Rules, then classes, fill in the style properties cells do not set themselves. Rules are matched
+	// against the content of the cells below the header rows; formula cells wait for their results.
+	subjects, err := ruleSubjects(&t, rules)
+	if err != nil {
+		return table.Table{}, err
+	}
+	for r := range t.Rows {
+		for c := range t.Rows[r].Cells {
+			if t.Rows[r].Cells[c].Formula != "" {
+				sheet.formulaCells = append(sheet.formulaCells, pendingCell{cell: &t.Rows[r].Cells[c], header: r < t.Settings.HeaderRows, rules: rules, subjects: subjects})
+				continue
+			}
+			if r >= t.Settings.HeaderRows {
+				applyRules(&t.Rows[r].Cells[c], rules, subjects)
+			}
+			if err := sheet.applyToCell(&t.Rows[r].Cells[c]); err != nil {
+				return table.Table{}, err
+			}
+		}
+	}
+
 	return t, nil
 }
 
 // parseGlobalSettings parses key-value pairs for global table settings.
 func parseGlobalSettings(settingsStr string, settings *table.GlobalSettings) error {
-	pairs := strings.Split(settingsStr, ",")
+	// Split on top-level commas only, so values like "linear(#fff,#ddd,90)" stay intact.
+	pairs := splitOutsideParens(settingsStr, func(r rune) bool { return r == ',' })
 	for _, pair := range pairs {
 		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
 		if len(parts) != 2 {
 			continue
 		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
+		if err := applyGlobalSetting(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyGlobalSetting applies a single table setting. Unknown keys are ignored.
+func applyGlobalSetting(key, value string, settings *table.GlobalSettings) error {
+	switch key {
+	case "bg_table":
+		settings.TableBackgroundColor = value
+	case "bg_cell":
+		settings.DefaultCellBackgroundColor = value
+	case "edge_color":
+		settings.EdgeColor = value
+	case "text_color":
+		settings.TextColor = value
+	case "header_rows", "header_cols":
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s value '%s': %w", key, value, err)
+		}
+		if count < 0 {
+			return fmt.Errorf("%s must be non-negative, got %d", key, count)
+		}
+		if key == "header_rows" {
+			settings.HeaderRows = count
+		} else {
+			settings.HeaderCols = count
+		}
+	case "width", "max_width":
+		width, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s value '%s': %w", key, value, err)
+		}
+		if width <= 0 {
+			return fmt.Errorf("%s must be positive, got %g", key, width)
+		}
+		if key == "width" {
+			settings.Width = width
+		} else {
+			settings.MaxWidth = width
+		}
+	case "header_bg":
+		settings.HeaderBackgroundColor = value
+	case "header_fg":
+		settings.HeaderTextColor = value
+	case "hyphens":
+		if value != "none" && value != "manual" && value != "auto" {
+			return fmt.Errorf("invalid hyphens '%s': expected none, manual or auto", value)
+		}
+		settings.Hyphens = value
+	case "hyphen_words":
+		for _, word := range strings.Fields(value) {
+			if !hyphenWordRegex.MatchString(word) {
+				return fmt.Errorf("invalid hyphen_words entry '%s': expected letters with '-' at the break points", word)
+			}
+		}
+		settings.HyphenWords = value
+	case "direction":
+		if value != "ltr" && value != "rtl" {
+			return fmt.Errorf("invalid direction '%s': expected ltr or rtl", value)
+		}
+		settings.Direction = value
+	case "overflow":
+		if !isValidOverflow(value) {
+			return fmt.Errorf("invalid overflow '%s': expected clip, ellipsis, shrink or expand", value)
+		}
+		settings.Overflow = value
+	case "class":
+		// Applied beforehand by styleSheet.applyTableClasses so the table's own settings win.
+	case "edge_thickness":
+		thickness, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid edge_thickness value '%s': %w", value, err)
+		}
+		if thickness < 0 {
+			return fmt.Errorf("edge_thickness must be non-negative, got %d", thickness)
+		}
+		settings.EdgeThickness = thickness
+	case "corner_radius", "margin":
+		parsedVal, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s value '%s': %w", key, value, err)
+		}
+		if parsedVal < 0 {
+			return fmt.Errorf("%s must be non-negative, got %g", key, parsedVal)
+		}
+		if key == "corner_radius" {
+			settings.CornerRadius = &parsedVal
+		} else {
+			settings.Margin = &parsedVal
+		}
+	case "border_mode":
+		if value != "collapse" && value != "separate" {
+			return fmt.Errorf("invalid border_mode value '%s': expected 'collapse' or 'separate'", value)
+		}
+		settings.BorderMode = value
+	case "cell_spacing":
+		spacing, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cell_spacing value '%s': %w", value, err)
+		}
+		if spacing < 0 {
+			return fmt.Errorf("cell_spacing must be non-negative, got %g", spacing)
+		}
+		settings.CellSpacing = spacing
+	case "padding":
+		padding, err := parsePaddingSpec(value)
+		if err != nil {
+			return fmt.Errorf("invalid padding value '%s': %w", value, err)
+		}
+		settings.Padding = padding.Over(settings.Padding)
+	case "padding_top", "padding_right", "padding_bottom", "padding_left":
+		parsedVal, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s value '%s': %w", key, value, err)
+		}
+		if parsedVal < 0 {
+			return fmt.Errorf("%s must be non-negative, got %g", key, parsedVal)
+		}
+		setPaddingSide(&settings.Padding, strings.TrimPrefix(key, "padding_"), parsedVal)
+	}
+	return nil
+}
 
+// headerSeparatorRegex matches a Markdown table delimiter row such as "|---|:--:|--:|". To avoid mistaking
+// rows of single dashes for a delimiter row, callers also require at least one "---" (see isHeaderSeparator).
+var headerSeparatorRegex = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+
+// isHeaderSeparator reports whether line is a Markdown table delimiter row.
+func isHeaderSeparator(line string) bool {
+	return strings.Contains(line, "---") && headerSeparatorRegex.MatchString(line)
+}
+
+// cellIDRegex matches the ID of a cell, as given with ::id=...::.
+var cellIDRegex = regexp.MustCompile(`^[\w\-]+$`)
+
+// rowDirectiveRegex matches a row line starting with an "@row {...}" marker; group 2 is the rest of the row.
+var rowDirectiveRegex = regexp.MustCompile(`^@row\s*\{([^{}]*)\}(.*)$`)
+
+// isValidAlign reports whether value is a supported horizontal alignment.
+func isValidAlign(value string) bool {
+	return value == "left" || value == "center" || value == "right"
+}
+
+// hyphenWordRegex matches a hyphen_words entry such as "da-ta-base".
+var hyphenWordRegex = regexp.MustCompile(`^\pL+(-\pL+)+$`)
+
+// parseRotation parses a rotation in degrees, a multiple of 90, and normalizes it to 0, 90, 180 or 270.
+func parseRotation(value string) (int, error) {
+	degrees, err := strconv.Atoi(value)
+	if err != nil || degrees%90 != 0 {
+		return 0, fmt.Errorf("expected a multiple of 90 degrees")
+	}
+	return (degrees%360 + 360) % 360, nil
+}
+
+func isValidOverflow(value string) bool {
+	return value == "clip" || value == "ellipsis" || value == "shrink" || value == "expand"
+}
+
+// parseRowDirective parses the body of an "@row {...}" marker onto row.
+// Supported keys: bg, fg, align, height and class.
+func parseRowDirective(body string, row *table.Row) error {
+	for _, pair := range splitOutsideParens(body, func(r rune) bool { return r == ',' }) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected 'key:value', got '%s'", pair)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
 		switch key {
-		case "bg_table":
-			settings.TableBackgroundColor = value
-		case "bg_cell":
-			settings.DefaultCellBackgroundColor = value
-		case "edge_color":
-			settings.EdgeColor = value
-		case "edge_thickness":
-			thickness, err := strconv.Atoi(value)
-			if err != nil {
-				return fmt.Errorf("invalid edge_thickness value '%s': %w", value, err)
+		case "bg":
+			row.BackgroundColor = value
+		case "fg":
+			row.TextColor = value
+		case "align":
+			if !isValidAlign(value) {
+				return fmt.Errorf("invalid align value '%s': expected left, center or right", value)
 			}
-			if thickness < 0 {
-				return fmt.Errorf("edge_thickness must be non-negative, got %d", thickness)
+			row.Align = value
+		case "height":
+			height, err := strconv.ParseFloat(value, 64)
+			if err != nil || height <= 0 {
+				return fmt.Errorf("invalid height value '%s': expected a positive number", value)
 			}
-			settings.EdgeThickness = thickness
+			row.Height = height
+		case "class":
+			row.Class = strings.Join(strings.Fields(value), " ")
+		default:
+			return fmt.Errorf("unknown row setting '%s'", key)
 		}
 	}
 	return nil
 }
 
+// parseLength parses a positive length in pixels ("120") or percent of the table width ("30%").
+func parseLength(value string) (table.Length, error) {
+	length := table.Length{Percent: strings.HasSuffix(value, "%")}
+	parsedVal, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+	if err != nil || parsedVal <= 0 {
+		return table.Length{}, fmt.Errorf("'%s' is not a positive number of pixels or a percentage", value)
+	}
+	if length.Percent && parsedVal > 100 {
+		return table.Length{}, fmt.Errorf("'%s' exceeds 100%%", value)
+	}
+	length.Value = parsedVal
+	return length, nil
+}
+
+// parseColumnsLine parses the part after "columns:", one "{key:value, ...}" block (or nothing)
+// per column, separated by "|". Supported keys: width, min_width, max_width (pixels or percent of the
+// table width), flex, align, bg and fg.
+func parseColumnsLine(line string) ([]table.ColumnSpec, error) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	var columns []table.ColumnSpec
+	for i, entry := range strings.Split(line, "|") {
+		var column table.ColumnSpec
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			if !strings.HasPrefix(entry, "{") || !strings.HasSuffix(entry, "}") {
+				return nil, fmt.Errorf("column %d: expected '{key:value, ...}', got '%s'", i+1, entry)
+			}
+			for _, pair := range splitOutsideParens(entry[1:len(entry)-1], func(r rune) bool { return r == ',' }) {
+				parts := strings.SplitN(pair, ":", 2)
+				if len(parts) != 2 {
+					return nil, fmt.Errorf("column %d: expected 'key:value', got '%s'", i+1, pair)
+				}
+				key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+				switch key {
+				case "width":
+					width, err := parseLength(value)
+					if err != nil {
+						return nil, fmt.Errorf("column %d: invalid width: %w", i+1, err)
+					}
+					if width.Percent {
+						column.WidthPercent = width.Value
+					} else {
+						column.Width = width.Value
+					}
+				case "min_width", "max_width":
+					length, err := parseLength(value)
+					if err != nil {
+						return nil, fmt.Errorf("column %d: invalid %s: %w", i+1, key, err)
+					}
+					if key == "min_width" {
+						column.MinWidth = length
+					} else {
+						column.MaxWidth = length
+					}
+				case "flex":
+					flex, err := strconv.ParseFloat(value, 64)
+					if err != nil || flex < 0 {
+						return nil, fmt.Errorf("column %d: invalid flex '%s': expected a non-negative number", i+1, value)
+					}
+					column.Flex = flex
+				case "align":
+					if !isValidAlign(value) {
+						return nil, fmt.Errorf("column %d: invalid align value '%s': expected left, center or right", i+1, value)
+					}
+					column.Align = value
+				case "bg":
+					column.BackgroundColor = value
+				case "fg":
+					column.TextColor = value
+				case "format":
+					column.NumberFormat = strings.Trim(value, `"`) // Quoted when it holds a comma.
+				default:
+					return nil, fmt.Errorf("column %d: unknown column setting '%s'", i+1, key)
+				}
+			}
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// parsePaddingSpec parses CSS-style padding shorthand: "N" (all sides),
+// "V H" (vertical, horizontal) or "T R B L".
+func parsePaddingSpec(value string) (table.PaddingSpec, error) {
+	fields := strings.Fields(value)
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		parsedVal, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return table.PaddingSpec{}, err
+		}
+		if parsedVal < 0 {
+			return table.PaddingSpec{}, fmt.Errorf("padding must be non-negative, got %g", parsedVal)
+		}
+		values[i] = parsedVal
+	}
+	var top, right, bottom, left float64
+	switch len(values) {
+	case 1:
+		top, right, bottom, left = values[0], values[0], values[0], values[0]
+	case 2:
+		top, right, bottom, left = values[0], values[1], values[0], values[1]
+	case 4:
+		top, right, bottom, left = values[0], values[1], values[2], values[3]
+	default:
+		return table.PaddingSpec{}, fmt.Errorf("expected 1, 2 or 4 values, got %d", len(values))
+	}
+	return table.PaddingSpec{Top: &top, Right: &right, Bottom: &bottom, Left: &left}, nil
+}
+
+// setPaddingSide sets one side ("top", "right", "bottom" or "left") of a padding spec.
+func setPaddingSide(padding *table.PaddingSpec, side string, value float64) {
+	switch side {
+	case "top":
+		padding.Top = &value
+	case "right":
+		padding.Right = &value
+	case "bottom":
+		padding.Bottom = &value
+	case "left":
+		padding.Left = &value
+	}
+}
+
+// blockMarkerRegex matches the markers parseCell leaves in place of the ::table::, ::image:: and
+// ::icon:: directives of a cell with a nested table, to keep them in order with the text around them.
+var blockMarkerRegex = regexp.MustCompile("\x1e(table|image|icon)\x1e")
+
+// blockMarker returns the marker of a directive of the given kind (see blockMarkerRegex).
+func blockMarker(kind string) string { return "\x1e" + kind + "\x1e" }
+
+// blockMarkerIf returns the marker of a directive of the given kind if the cell has blocks, else "".
+func blockMarkerIf(hasBlocks bool, kind string) string {
+	if !hasBlocks {
+		return ""
+	}
+	return blockMarker(kind)
+}
+
+// setCellBlocks splits the content of a cell with nested tables at its markers, the tables taking the
+// blocks of tables in order. A table alone stays a plain table reference; text, an image or other
+// tables next to it turn the cell's content into Blocks.
+func setCellBlocks(cell *table.Cell, tables []table.CellBlock) {
+	var blocks []table.CellBlock
+	addText := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			blocks = append(blocks, table.CellBlock{Text: text})
+		}
+	}
+	prev := 0
+	for _, loc := range blockMarkerRegex.FindAllStringSubmatchIndex(cell.Content, -1) {
+		addText(cell.Content[prev:loc[0]])
+		switch cell.Content[loc[2]:loc[3]] {
+		case "table":
+			blocks = append(blocks, tables[0])
+			tables = tables[1:]
+		case "image":
+			blocks = append(blocks, table.CellBlock{Image: cell.Image})
+		case "icon":
+			blocks = append(blocks, table.CellBlock{Icon: cell.Icon})
+		}
+		prev = loc[1]
+	}
+	addText(cell.Content[prev:])
+	cell.Content = ""
+	if len(blocks) == 1 && blocks[0].TableRefID != "" {
+		return
+	}
+	cell.Blocks = blocks
+	cell.IsTableRef, cell.TableRefID, cell.Image, cell.Icon = false, "", "", ""
+}
+
 // parseCell refines the parsing of individual cell strings to more flexibly extract
 // title, rowspan, colspan, background color, and content.
 // Directives can be mixed with content.
@@ -288,6 +817,28 @@ func parseCell(cellInput string) (table.Cell, error) {
 	// tempStr will be modified as directives are extracted.
 	tempStr := remainingStr
 
+	// 1b. Extract Table References (e.g., "::table=ref-id::") before any other directive, so that
+	// directives written inside the quoted arguments of a template reference stay arguments.
+	// Each directive is replaced by a marker, so text written around it is kept in order with the tables:
+	// such a cell gets a list of blocks once all directives are removed (see setCellBlocks).
+	// A template is referenced with its arguments, e.g. "::table=card(name="Alice")::" (see expandTemplates).
+	// Regex: `(.*?)::table=([\w\-]+(?:\((?:"[^"]*"|[^")])*\))?)::(.*)`
+	tableRefRegex := regexp.MustCompile(`(.*?)::table=([\w\-]+(?:\((?:"[^"]*"|[^")])*\))?)::(.*)`)
+	var tableBlocks []table.CellBlock
+	for {
+		matches := tableRefRegex.FindStringSubmatch(tempStr)
+		if len(matches) != 4 {
+			break
+		}
+		tableBlocks = append(tableBlocks, table.CellBlock{TableRefID: strings.TrimSpace(matches[2])})
+		tempStr = strings.TrimSpace(matches[1] + " " + blockMarker("table") + " " + matches[3])
+	}
+	isTableRef := len(tableBlocks) > 0
+	tableRefID := ""
+	if isTableRef {
+		tableRefID = tableBlocks[0].TableRefID
+	}
+
 	// 2. Extract Rowspan (e.g., "Some Content ::rowspan=2:: More Content")
 	// Regex: `(.*?)::rowspan=(\d+)::(.*)`
 	// (.*?) non-greedy before directive, (\d+) for number, (.*) for after.
@@ -312,28 +863,19 @@ func parseCell(cellInput string) (table.Cell, error) {
 	}
 
 	// 4. Extract Background Color (e.g., "Some Content {bg:#RRGGBB} More Content")
-	// Regex: `(.*?)\{bg:([#\w\d]+)\}(.*)`
-	// ([#\w\d]+) captures the color code.
-	bgColorRegex := regexp.MustCompile(`(.*?)\{bg:([#\w\d]+)\}(.*)`)
+	// Regex: `(.*?)\{bg:([^{}]+)\}(.*)`
+	// ([^{}]+) captures the fill: a color (#RRGGBBAA, rgba(...), named) or a fill function such as linear(...).
+	bgColorRegex := regexp.MustCompile(`(.*?)\{bg:([^{}]+)\}(.*)`)
 	if matches := bgColorRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
 		bgColor = strings.TrimSpace(matches[2]) // The color code itself
 		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
 	}
 
-	// 5. Extract Table Reference (e.g., "::table=ref-id::")
-	// This should ideally be the only significant content if present.
-	isTableRef := false
-	tableRefID := ""
-	// Regex: `(.*?)::table=([\w\-]+)::(.*)`
-	tableRefRegex := regexp.MustCompile(`(.*?)::table=([\w\-]+)::(.*)`)
-	if matches := tableRefRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
-		// If a table reference is found, it takes precedence.
-		// Content before or after the directive will be trimmed.
-		isTableRef = true
-		tableRefID = strings.TrimSpace(matches[2])
-		// tempStr is reconstructed from parts not including the table directive.
-		// If other text was present (matches[1] or matches[3]), it's kept for now,
-		// but will be cleared if isTableRef is true.
+	// 4b. Extract Text Color (e.g., "Some Content {fg:#RRGGBB}"), same value forms as bg colors.
+	textColor := ""
+	fgColorRegex := regexp.MustCompile(`(.*?)\{fg:([^{}]+)\}(.*)`)
+	if matches := fgColorRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		textColor = strings.TrimSpace(matches[2])
 		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
 	}
 
@@ -346,18 +888,36 @@ func parseCell(cellInput string) (table.Cell, error) {
 	finalCell.Colspan = colspan
 	finalCell.Rowspan = rowspan
 	finalCell.BackgroundColor = bgColor
+	finalCell.TextColor = textColor
 	finalCell.IsTableRef = isTableRef
 	finalCell.TableRefID = tableRefID
 
-	// 6. Parse ::inner_align=VALUE::
+	// 5b. With several tables, ::inner_align:: and ::inner_scale:: written after a table (and before the
+	// next one) apply to that table alone. Those written before the first table apply to all of them.
 	innerAlignRegex := regexp.MustCompile(`(.*?)::inner_align=([\w\-]+)::(.*)`)
+	innerScaleRegex := regexp.MustCompile(`(.*?)::inner_scale=([\w\_]+)::(.*)`)
+	if len(tableBlocks) > 1 {
+		parts := strings.Split(tempStr, blockMarker("table"))
+		for i := 1; i < len(parts); i++ {
+			if matches := innerAlignRegex.FindStringSubmatch(parts[i]); len(matches) == 4 {
+				tableBlocks[i-1].InnerTableAlignment = strings.TrimSpace(matches[2])
+				parts[i] = " " + strings.TrimSpace(matches[1]+" "+matches[3]) + " "
+			}
+			if matches := innerScaleRegex.FindStringSubmatch(parts[i]); len(matches) == 4 {
+				tableBlocks[i-1].InnerTableScaleMode = strings.TrimSpace(matches[2])
+				parts[i] = " " + strings.TrimSpace(matches[1]+" "+matches[3]) + " "
+			}
+		}
+		tempStr = strings.TrimSpace(strings.Join(parts, blockMarker("table")))
+	}
+
+	// 6. Parse ::inner_align=VALUE::
 	if matches := innerAlignRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
 		finalCell.InnerTableAlignment = strings.TrimSpace(matches[2])
 		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
 	}
 
 	// 7. Parse ::inner_scale=MODE::
-	innerScaleRegex := regexp.MustCompile(`(.*?)::inner_scale=([\w\_]+)::(.*)`)
 	if matches := innerScaleRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
 		finalCell.InnerTableScaleMode = strings.TrimSpace(matches[2])
 		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
@@ -371,8 +931,7 @@ func parseCell(cellInput string) (table.Cell, error) {
 		if err == nil && parsedVal >= 0 {
 			finalCell.FixedWidth = parsedVal
 		} else {
-			// Consider adding import "log" and using log.Printf for warnings
-			fmt.Printf("Warning: Invalid value for fixed_width '%s' in cell input '%s'. Ignoring.\n", valStr, cellInput)
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for fixed_width '%s' in cell input '%s'. Ignoring.\n", valStr, cellInput)
 		}
 		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
 	}
@@ -385,21 +944,296 @@ func parseCell(cellInput string) (table.Cell, error) {
 		if err == nil && parsedVal >= 0 {
 			finalCell.FixedHeight = parsedVal
 		} else {
-			fmt.Printf("Warning: Invalid value for fixed_height '%s' in cell input '%s'. Ignoring.\n", valStr, cellInput)
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for fixed_height '%s' in cell input '%s'. Ignoring.\n", valStr, cellInput)
+		}
+		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+	}
+
+	// 9b. Parse ::min_width=VALUE:: and ::max_width=VALUE:: (pixels or percent of the table width)
+	widthBoundRegex := regexp.MustCompile(`(.*?)::(min|max)_width=([\d\.]+%?)::(.*)`)
+	for i := 0; i < 2; i++ {
+		matches := widthBoundRegex.FindStringSubmatch(tempStr)
+		if len(matches) != 5 {
+			break
+		}
+		length, err := parseLength(matches[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for %s_width '%s' in cell input '%s'. Ignoring.\n", matches[2], matches[3], cellInput)
+		} else if matches[2] == "min" {
+			finalCell.MinWidth = length
+		} else {
+			finalCell.MaxWidth = length
+		}
+		tempStr = strings.TrimSpace(matches[1] + " " + matches[4])
+	}
+
+	// 10. Parse ::corner_radius=VALUE_PX::
+	cornerRadiusRegex := regexp.MustCompile(`(.*?)::corner_radius=([\d\.]+)::(.*)`)
+	if matches := cornerRadiusRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		valStr := strings.TrimSpace(matches[2])
+		parsedVal, err := strconv.ParseFloat(valStr, 64)
+		if err == nil {
+			finalCell.CornerRadius = &parsedVal
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for corner_radius '%s' in cell input '%s'. Ignoring.\n", valStr, cellInput)
+		}
+	}
+
+	// 11. Parse ::padding=SPEC:: and ::padding_top|right|bottom|left=VALUE_PX::
+	// As with borders, the shorthand is applied first and per-side values override it.
+	paddingRegex := regexp.MustCompile(`::padding(?:_(top|right|bottom|left))?=([\d\. ]+)::`)
+	var sidePaddings [][]string
+	for _, matches := range paddingRegex.FindAllStringSubmatch(tempStr, -1) {
+		if matches[1] == "" {
+			padding, err := parsePaddingSpec(matches[2])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Invalid value for padding '%s' in cell input '%s': %v. Ignoring.\n", matches[2], cellInput, err)
+				continue
+			}
+			finalCell.Padding = padding.Over(finalCell.Padding)
+		} else {
+			sidePaddings = append(sidePaddings, matches)
+		}
+	}
+	for _, matches := range sidePaddings {
+		parsedVal, err := strconv.ParseFloat(strings.TrimSpace(matches[2]), 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for padding_%s '%s' in cell input '%s'. Ignoring.\n", matches[1], matches[2], cellInput)
+			continue
+		}
+		setPaddingSide(&finalCell.Padding, matches[1], parsedVal)
+	}
+	tempStr = strings.TrimSpace(paddingRegex.ReplaceAllString(tempStr, " "))
+
+	// 12. Parse ::border=SPEC:: and ::border_top|right|bottom|left=SPEC::
+	// The generic form is applied first so per-side directives override it regardless of order.
+	borderRegex := regexp.MustCompile(`::border(?:_(top|right|bottom|left))?=([^:]*)::`)
+	sideSpecs := make(map[string]table.BorderSpec)
+	for _, matches := range borderRegex.FindAllStringSubmatch(tempStr, -1) {
+		spec, err := parseBorderSpec(matches[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid border value '%s' in cell input '%s': %v. Ignoring.\n", matches[2], cellInput, err)
+			continue
+		}
+		sideSpecs[matches[1]] = spec
+	}
+	tempStr = strings.TrimSpace(borderRegex.ReplaceAllString(tempStr, " "))
+	if spec, ok := sideSpecs[""]; ok {
+		finalCell.BorderTop, finalCell.BorderRight, finalCell.BorderBottom, finalCell.BorderLeft = spec, spec, spec, spec
+	}
+	if spec, ok := sideSpecs["top"]; ok {
+		finalCell.BorderTop = spec
+	}
+	if spec, ok := sideSpecs["right"]; ok {
+		finalCell.BorderRight = spec
+	}
+	if spec, ok := sideSpecs["bottom"]; ok {
+		finalCell.BorderBottom = spec
+	}
+	if spec, ok := sideSpecs["left"]; ok {
+		finalCell.BorderLeft = spec
+	}
+
+	// 13. Parse ::class=NAME [NAME...]:: (resolved against the document styles by the table parser)
+	classRegex := regexp.MustCompile(`(.*?)::class=([\w\- ]+)::(.*)`)
+	if matches := classRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		finalCell.Class = strings.Join(strings.Fields(matches[2]), " ")
+		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+	}
+
+	// 14. Parse ::align=left|center|right::
+	alignRegex := regexp.MustCompile(`(.*?)::align=(\w+)::(.*)`)
+	if matches := alignRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		if isValidAlign(matches[2]) {
+			finalCell.Align = matches[2]
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for align '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+
+	// 15. Parse ::overflow=clip|ellipsis|shrink|expand::
+	overflowRegex := regexp.MustCompile(`(.*?)::overflow=(\w+)::(.*)`)
+	if matches := overflowRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		if isValidOverflow(matches[2]) {
+			finalCell.Overflow = matches[2]
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for overflow '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+
+	// 16. Parse ::rotate=0|90|180|270:: and its alias ::writing_mode=vertical|horizontal::
+	rotateRegex := regexp.MustCompile(`(.*?)::rotate=(-?\w+)::(.*)`)
+	if matches := rotateRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		if degrees, err := parseRotation(matches[2]); err == nil {
+			finalCell.Rotate, finalCell.RotateSet = degrees, true
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for rotate '%s' in cell input '%s': %v. Ignoring.\n", matches[2], cellInput, err)
+		}
+	}
+	writingModeRegex := regexp.MustCompile(`(.*?)::writing_mode=(\w+)::(.*)`)
+	if matches := writingModeRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		switch matches[2] {
+		case "vertical":
+			finalCell.Rotate, finalCell.RotateSet = 90, true
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		case "horizontal":
+			finalCell.Rotate, finalCell.RotateSet = 0, true
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for writing_mode '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+
+	// 17. Parse ::image=PATH::, ::icon=NAME:: and ::image_pos=left|top|right|bottom::
+	// Next to a nested table, images are blocks of their own and keep their place like the table.
+	imageRegex := regexp.MustCompile(`(.*?)::image=([^:]+)::(.*)`)
+	if matches := imageRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		finalCell.Image = strings.TrimSpace(matches[2])
+		tempStr = strings.TrimSpace(matches[1] + " " + blockMarkerIf(isTableRef, "image") + " " + matches[3])
+	}
+	iconRegex := regexp.MustCompile(`(.*?)::icon=([\w\-]+)::(.*)`)
+	if matches := iconRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		finalCell.Icon = matches[2]
+		tempStr = strings.TrimSpace(matches[1] + " " + blockMarkerIf(isTableRef, "icon") + " " + matches[3])
+	}
+	imagePosRegex := regexp.MustCompile(`(.*?)::image_pos=(\w+)::(.*)`)
+	if matches := imagePosRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		switch matches[2] {
+		case "left", "top", "right", "bottom":
+			finalCell.ImagePosition = matches[2]
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for image_pos '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+
+	// 18. Parse ::stack=vertical|horizontal:: and ::stack_gap=VALUE_PX:: (how the blocks of a cell with a
+	// nested table are laid out)
+	stackRegex := regexp.MustCompile(`(.*?)::stack=(\w+)::(.*)`)
+	if matches := stackRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		if matches[2] == "vertical" || matches[2] == "horizontal" {
+			finalCell.Stack = matches[2]
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for stack '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+	stackGapRegex := regexp.MustCompile(`(.*?)::stack_gap=([\d\.]+)::(.*)`)
+	if matches := stackGapRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		if parsedVal, err := strconv.ParseFloat(matches[2], 64); err == nil {
+			finalCell.StackGap = &parsedVal
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for stack_gap '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+
+	// 19. Parse ::format=PATTERN:: (the number format of a computed or numeric cell)
+	formatRegex := regexp.MustCompile(`(.*?)::format=([^:]+)::(.*)`)
+	if matches := formatRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		finalCell.NumberFormat = strings.TrimSpace(matches[2])
+		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+	}
+
+	// 20. Parse ::id=NAME:: (how other cells and tables refer to this cell, as "#NAME") and
+	// ::link=ADDRESS, ...:: (arrows to other cells of the table, given as "B3" or "#NAME")
+	idRegex := regexp.MustCompile(`(.*?)::id=([^:]+)::(.*)`)
+	if matches := idRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		if id := strings.TrimSpace(matches[2]); cellIDRegex.MatchString(id) {
+			finalCell.ID = id
+			tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Invalid value for id '%s' in cell input '%s'. Ignoring.\n", matches[2], cellInput)
+		}
+	}
+	linkRegex := regexp.MustCompile(`(.*?)::link=([^:]+)::(.*)`)
+	if matches := linkRegex.FindStringSubmatch(tempStr); len(matches) == 4 {
+		for _, link := range strings.Split(matches[2], ",") {
+			if _, err := grid.ParseCellAddress(link); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Invalid value for link '%s' in cell input '%s': %v. Ignoring.\n", strings.TrimSpace(link), cellInput, err)
+				continue
+			}
+			finalCell.Links = append(finalCell.Links, strings.TrimSpace(link))
 		}
 		tempStr = strings.TrimSpace(matches[1] + " " + matches[3])
 	}
 
-	// Process \n for multiline content
+	// Process \n for multiline content, and &shy; (an optional hyphenation point) in content and title
 	tempStr = strings.ReplaceAll(tempStr, "\\n", "\n")
+	tempStr = strings.ReplaceAll(tempStr, "&shy;", "\u00ad")
+	finalCell.Title = strings.ReplaceAll(finalCell.Title, "&shy;", "\u00ad")
 
 	// What's left in tempStr after removing all directives and processing newlines is the actual content.
 	finalCell.Content = strings.TrimSpace(tempStr) // Set the final content
 
-	// If the cell is a table reference, its direct content should be empty.
+	// A table reference alone has no content; with text or an image around it, the cell gets blocks.
 	if finalCell.IsTableRef {
-		finalCell.Content = ""
+		setCellBlocks(&finalCell, tableBlocks)
+	}
+
+	// Content starting with "=" and an expression is a formula, computed before layout; "'=" keeps a
+	// leading "=" as text.
+	if strings.HasPrefix(finalCell.Content, "=") {
+		finalCell.Formula = finalCell.Content
+	} else if strings.HasPrefix(finalCell.Content, "'=") {
+		finalCell.Content = finalCell.Content[1:]
+	}
+
+	// Text from data files is only unescaped now, so it cannot have started a directive or a formula.
+	finalCell.Title, finalCell.Content, finalCell.Formula = unescapeData(finalCell.Title), unescapeData(finalCell.Content), unescapeData(finalCell.Formula)
+	finalCell.Image = unescapeData(finalCell.Image)
+	for i := range finalCell.Blocks {
+		finalCell.Blocks[i].Text, finalCell.Blocks[i].Image = unescapeData(finalCell.Blocks[i].Text), unescapeData(finalCell.Blocks[i].Image)
 	}
 
 	return finalCell, nil
 }
+
+// parseBorderSpec parses a border value such as "2 solid #000", "dashed" or "none".
+// Tokens may appear in any order: a number is the width, solid/dashed/dotted/none is
+// the style and anything else is taken as the color.
+func parseBorderSpec(value string) (table.BorderSpec, error) {
+	var spec table.BorderSpec
+	fields := splitOutsideParens(value, unicode.IsSpace)
+	if len(fields) == 0 {
+		return spec, fmt.Errorf("empty border spec")
+	}
+	zeroWidth := false
+	for _, field := range fields {
+		if width, err := strconv.ParseFloat(field, 64); err == nil {
+			if width < 0 {
+				return table.BorderSpec{}, fmt.Errorf("border width must be non-negative, got %g", width)
+			}
+			zeroWidth = width == 0
+			spec.Width = width
+			continue
+		}
+		switch strings.ToLower(field) {
+		case "solid", "dashed", "dotted", "none":
+			spec.Style = strings.ToLower(field)
+		default:
+			spec.Color = field
+		}
+	}
+	// A width of 0 is no border, whatever style is given with it: an unset width would be the table's.
+	if zeroWidth {
+		spec.Style = "none"
+	}
+	return spec, nil
+}
+
+// splitOutsideParens splits s like table.SplitOutsideParens and drops empty parts, e.g.
+// "2 rgb(0, 0, 0)" on spaces yields "2" and "rgb(0, 0, 0)".
+func splitOutsideParens(s string, isSep func(rune) bool) []string {
+	var parts []string
+	for _, part := range table.SplitOutsideParens(s, isSep) {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
@@ -94,6 +94,242 @@ func TestParseSingleTableDefinition(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "Geometry Settings (corner_radius, margin, padding)",
+			input: "table: [geo] Geometry {corner_radius:0, margin:4, padding:2 6, padding_left:10}",
+			want: table.Table{
+				ID:    "geo",
+				Title: "Geometry",
+				Rows:  []table.Row{},
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.CornerRadius = floatPtr(0)
+					s.Margin = floatPtr(4)
+					s.Padding = table.PaddingSpec{Top: floatPtr(2), Right: floatPtr(6), Bottom: floatPtr(2), Left: floatPtr(10)}
+					return s
+				}(),
+			},
+		},
+		{
+			name:    "Invalid padding setting",
+			input:   "table: [geo] {padding:1 2 3}",
+			wantErr: true,
+		},
+		{
+			name:    "Negative corner_radius setting",
+			input:   "table: [geo] {corner_radius:-2}",
+			wantErr: true,
+		},
+		{
+			name:  "Border mode and cell spacing settings",
+			input: "table: [grid] {border_mode:collapse, cell_spacing:6}",
+			want: table.Table{
+				ID:   "grid",
+				Rows: []table.Row{},
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.BorderMode = "collapse"
+					s.CellSpacing = 6
+					return s
+				}(),
+			},
+		},
+		{
+			name:  "Fill settings containing commas",
+			input: "table: [fills] {bg_table:linear(#fff, #ddd, 90), bg_cell:rgba(255,255,255,0.5), edge_color:slategray}",
+			want: table.Table{
+				ID:   "fills",
+				Rows: []table.Row{},
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.TableBackgroundColor = "linear(#fff, #ddd, 90)"
+					s.DefaultCellBackgroundColor = "rgba(255,255,255,0.5)"
+					s.EdgeColor = "slategray"
+					return s
+				}(),
+			},
+		},
+		{
+			name:  "Row directive and columns line",
+			input: "table: [rc]\ncolumns: {width:120, align:right} | | {bg:#EEE, fg:navy, format:\"#,##0\"}\n@row {bg:#DDD, height:40, align:center, class:header} A | B\nC | D",
+			want: table.Table{
+				ID: "rc",
+				Columns: []table.ColumnSpec{
+					{Width: 120, Align: "right"}, {}, {BackgroundColor: "#EEE", TextColor: "navy", NumberFormat: "#,##0"},
+				},
+				Rows: []table.Row{
+					{Cells: []table.Cell{table.NewCell("", "A"), table.NewCell("", "B")},
+						BackgroundColor: "#DDD", Height: 40, Align: "center", Class: "header"},
+					{Cells: []table.Cell{table.NewCell("", "C"), table.NewCell("", "D")}},
+				},
+				Settings: table.DefaultGlobalSettings(),
+			},
+		},
+		{
+			name:  "Markdown header separator",
+			input: "table: [hdr] {header_cols:1, header_bg:#333, header_fg:#FFF}\n| Name | Qty |\n|:-----|--:|\n| a | 1 |",
+			want: table.Table{
+				ID:      "hdr",
+				Columns: []table.ColumnSpec{{Align: "left"}, {Align: "right"}},
+				Rows: []table.Row{
+					{Cells: []table.Cell{table.NewCell("", "Name"), table.NewCell("", "Qty")}},
+					{Cells: []table.Cell{table.NewCell("", "a"), table.NewCell("", "1")}},
+				},
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.HeaderRows, s.HeaderCols = 1, 1
+					s.HeaderBackgroundColor, s.HeaderTextColor = "#333", "#FFF"
+					return s
+				}(),
+			},
+		},
+		{
+			name:  "Dash-only cells are not a header separator",
+			input: "table: [dash]\n- | -",
+			want: table.Table{
+				ID:       "dash",
+				Rows:     []table.Row{{Cells: []table.Cell{table.NewCell("", "-"), table.NewCell("", "-")}}},
+				Settings: table.DefaultGlobalSettings(),
+			},
+		},
+		{
+			name:    "Negative header_rows",
+			input:   "table: [hdr] {header_rows:-1}",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown row setting",
+			input:   "table: [rc]\n@row {weight:2} A | B",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid column width",
+			input:   "table: [rc]\ncolumns: {width:-5}\nA",
+			wantErr: true,
+		},
+		{
+			name:  "Table width and column constraints",
+			input: "table: [w] {width:600}\ncolumns: {width:25%, min_width:80} | {max_width:40%, flex:2} | {width:100}\nA | B | C",
+			want: table.Table{
+				ID: "w",
+				Columns: []table.ColumnSpec{
+					{WidthPercent: 25, MinWidth: table.Length{Value: 80}},
+					{MaxWidth: table.Length{Value: 40, Percent: true}, Flex: 2},
+					{Width: 100},
+				},
+				Rows: []table.Row{
+					{Cells: []table.Cell{table.NewCell("", "A"), table.NewCell("", "B"), table.NewCell("", "C")}},
+				},
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.Width = 600
+					return s
+				}(),
+			},
+		},
+		{
+			name:    "Column width over 100 percent",
+			input:   "table: [w]\ncolumns: {width:120%}\nA",
+			wantErr: true,
+		},
+		{
+			name:    "Negative column flex",
+			input:   "table: [w]\ncolumns: {flex:-1}\nA",
+			wantErr: true,
+		},
+		{
+			name:  "Table max_width",
+			input: "table: [w] {max_width:880}",
+			want: table.Table{
+				ID: "w",
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.MaxWidth = 880
+					return s
+				}(),
+			},
+		},
+		{
+			name:    "Negative table max_width",
+			input:   "table: [w] {max_width:-5}",
+			wantErr: true,
+		},
+		{
+			name:  "Table overflow setting",
+			input: "table: [o] {overflow:ellipsis}",
+			want: table.Table{
+				ID: "o",
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.Overflow = "ellipsis"
+					return s
+				}(),
+			},
+		},
+		{
+			name:  "Hyphenation settings",
+			input: "table: [h] {hyphens:auto, hyphen_words:ku-ber-ne-tes da-ta-base}",
+			want: table.Table{
+				ID: "h",
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.Hyphens, s.HyphenWords = "auto", "ku-ber-ne-tes da-ta-base"
+					return s
+				}(),
+			},
+		},
+		{
+			name:    "Invalid hyphens setting",
+			input:   "table: [h] {hyphens:always}",
+			wantErr: true,
+		},
+		{
+			name:  "Right-to-left direction",
+			input: "table: [r] {direction:rtl}",
+			want: table.Table{
+				ID: "r",
+				Settings: func() table.GlobalSettings {
+					s := table.DefaultGlobalSettings()
+					s.Direction = "rtl"
+					return s
+				}(),
+			},
+		},
+		{
+			name:    "Invalid direction",
+			input:   "table: [r] {direction:up}",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid hyphen_words entry",
+			input:   "table: [h] {hyphen_words:kubernetes}",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid table overflow setting",
+			input:   "table: [o] {overflow:wrap}",
+			wantErr: true,
+		},
+		{
+			name:    "Zero table width",
+			input:   "table: [w] {width:0}",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate columns line",
+			input:   "table: [rc]\ncolumns: {align:left}\ncolumns: {align:right}\nA",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid border_mode setting",
+			input:   "table: [grid] {border_mode:double}",
+			wantErr: true,
+		},
+		{
+			name:    "Negative cell_spacing setting",
+			input:   "table: [grid] {cell_spacing:-1}",
+			wantErr: true,
+		},
 		// Adjusted tests: parseSingleTableDefinition requires "table:" prefix.
 		// Original tests for row/cell parsing are adapted by adding a dummy table prefix.
 		{
@@ -708,12 +944,48 @@ func TestParseCellDirectives(t *testing.T) {
 		{
 			name:  "Title, Content Before, Table Reference, Content After",
 			input: "[CellTitle] ContentBefore ::table=ref6:: MoreContentAfter",
-			want:  table.Cell{IsTableRef: true, TableRefID: "ref6", Content: "", Title: "CellTitle", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none", FixedWidth: 0.0, FixedHeight: 0.0},
+			want:  table.Cell{Blocks: []table.CellBlock{{Text: "ContentBefore"}, {TableRefID: "ref6"}, {Text: "MoreContentAfter"}}, Title: "CellTitle", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none", FixedWidth: 0.0, FixedHeight: 0.0},
 		},
 		{
 			name:  "Content Before Table Reference",
 			input: "Content Before ::table=ref7::",
-			want:  table.Cell{IsTableRef: true, TableRefID: "ref7", Content: "", Title: "", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none", FixedWidth: 0.0, FixedHeight: 0.0},
+			want:  table.Cell{Blocks: []table.CellBlock{{Text: "Content Before"}, {TableRefID: "ref7"}}, Title: "", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none", FixedWidth: 0.0, FixedHeight: 0.0},
+		},
+		{
+			name:  "Caption under a table, side by side with an icon",
+			input: `::icon=info:: ::table=ref8:: Caption\nsecond line ::stack=horizontal::`,
+			want:  table.Cell{Blocks: []table.CellBlock{{Icon: "info"}, {TableRefID: "ref8"}, {Text: "Caption\nsecond line"}}, Stack: "horizontal", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none"},
+		},
+		{
+			name:  "Two tables with their own alignment and scale",
+			input: "::inner_scale=fit_both:: ::table=in:: ::inner_align=center:: ::table=out:: ::inner_scale=none:: ::stack=horizontal:: ::stack_gap=12::",
+			want: table.Cell{Blocks: []table.CellBlock{{TableRefID: "in", InnerTableAlignment: "center"}, {TableRefID: "out", InnerTableScaleMode: "none"}},
+				Stack: "horizontal", StackGap: floatPtr(12), Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "fit_both"},
+		},
+		{
+			name:  "Table with an invalid stack",
+			input: "::table=ref9:: ::stack=diagonal::",
+			want:  table.Cell{Blocks: []table.CellBlock{{TableRefID: "ref9"}, {Text: "::stack=diagonal::"}}, Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none"},
+		},
+		{
+			name:  "Formula with a number format",
+			input: "=SUM(B2:B5) ::format=#,##0.00::",
+			want:  table.Cell{Content: "=SUM(B2:B5)", Formula: "=SUM(B2:B5)", NumberFormat: "#,##0.00", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none"},
+		},
+		{
+			name:  "Cell ID and links",
+			input: "API ::id=api-gw:: ::link=#db-primary, C3::",
+			want:  table.Cell{Content: "API", ID: "api-gw", Links: []string{"#db-primary", "C3"}, Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none"},
+		},
+		{
+			name:  "Invalid cell ID",
+			input: "API ::id=a b::",
+			want:  table.Cell{Content: "API ::id=a b::", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none"},
+		},
+		{
+			name:  "Escaped leading equals sign",
+			input: "'=> next",
+			want:  table.Cell{Content: "=> next", Colspan: 1, Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none"},
 		},
 		{
 			name:  "Normal Content Cell",
@@ -847,7 +1119,7 @@ func TestParseCellDirectives(t *testing.T) {
 		{
 			name:  "New and old directives combined",
 			input: "[Title] Data ::colspan=2:: {bg:#ABCDEF} ::fixed_width=100:: ::table=refX:: ::inner_align=bottom_left::",
-			want:  table.Cell{Title: "Title", Content: "", Colspan: 2, BackgroundColor: "#ABCDEF", FixedWidth: 100.0, IsTableRef: true, TableRefID: "refX", InnerTableAlignment: "bottom_left", InnerTableScaleMode: "none", FixedHeight: 0.0, Rowspan: 1},
+			want:  table.Cell{Title: "Title", Blocks: []table.CellBlock{{Text: "Data"}, {TableRefID: "refX"}}, Colspan: 2, BackgroundColor: "#ABCDEF", FixedWidth: 100.0, InnerTableAlignment: "bottom_left", InnerTableScaleMode: "none", FixedHeight: 0.0, Rowspan: 1},
 		},
 		{
 			name:  "Order independence check 1",
@@ -860,6 +1132,151 @@ func TestParseCellDirectives(t *testing.T) {
 			want:  table.Cell{Content: "Content", Colspan: 2, FixedWidth: 100.0, Title: "", Rowspan: 1, InnerTableAlignment: "top_left", InnerTableScaleMode: "none", FixedHeight: 0.0},
 		},
 		// --- End of Test Cases for New Cell Directives ---
+
+		// --- Test Cases for Geometry Directives ---
+		{
+			name:  "corner_radius directive",
+			input: "Square ::corner_radius=0::",
+			want:  table.Cell{Content: "Square", CornerRadius: floatPtr(0)},
+		},
+		{
+			name:  "padding shorthand with side override",
+			input: "::padding_top=12:: Roomy ::padding=4::",
+			want:  table.Cell{Content: "Roomy", Padding: table.PaddingSpec{Top: floatPtr(12), Right: floatPtr(4), Bottom: floatPtr(4), Left: floatPtr(4)}},
+		},
+		{
+			name:  "single padding side",
+			input: "Indented ::padding_left=20::",
+			want:  table.Cell{Content: "Indented", Padding: table.PaddingSpec{Left: floatPtr(20)}},
+		},
+		// --- End of Test Cases for Geometry Directives ---
+
+		// --- Test Cases for Border Directives ---
+		{
+			name:  "border_top full spec",
+			input: "Header ::border_top=2 solid #000::",
+			want:  table.Cell{Content: "Header", BorderTop: table.BorderSpec{Width: 2, Style: "solid", Color: "#000"}},
+		},
+		{
+			name:  "border none applies to all sides",
+			input: "::border=none:: Plain",
+			want: table.Cell{Content: "Plain",
+				BorderTop: table.BorderSpec{Style: "none"}, BorderRight: table.BorderSpec{Style: "none"},
+				BorderBottom: table.BorderSpec{Style: "none"}, BorderLeft: table.BorderSpec{Style: "none"}},
+		},
+		{
+			name:  "per-side border overrides generic border regardless of order",
+			input: "::border_bottom=3 dashed #F00:: Cell ::border=1 dotted::",
+			want: table.Cell{Content: "Cell",
+				BorderTop: table.BorderSpec{Width: 1, Style: "dotted"}, BorderRight: table.BorderSpec{Width: 1, Style: "dotted"},
+				BorderBottom: table.BorderSpec{Width: 3, Style: "dashed", Color: "#F00"}, BorderLeft: table.BorderSpec{Width: 1, Style: "dotted"}},
+		},
+		{
+			name:  "border width zero means none",
+			input: "::border_left=0::",
+			want:  table.Cell{BorderLeft: table.BorderSpec{Width: 0, Style: "none"}},
+		},
+		{
+			name:  "border width zero before a style means none",
+			input: "::border_top=0 solid #000::",
+			want:  table.Cell{BorderTop: table.BorderSpec{Width: 0, Style: "none", Color: "#000"}},
+		},
+		{
+			name:  "border width zero after a style means none",
+			input: "::border_top=solid 0::",
+			want:  table.Cell{BorderTop: table.BorderSpec{Width: 0, Style: "none"}},
+		},
+		{
+			name:  "border tokens in any order",
+			input: "::border_right=#336699 dashed 1.5::",
+			want:  table.Cell{BorderRight: table.BorderSpec{Width: 1.5, Style: "dashed", Color: "#336699"}},
+		},
+		{
+			name:  "border color function with spaces",
+			input: "::border=2 rgb(200, 0, 0)::",
+			want: table.Cell{
+				BorderTop: table.BorderSpec{Width: 2, Color: "rgb(200, 0, 0)"}, BorderRight: table.BorderSpec{Width: 2, Color: "rgb(200, 0, 0)"},
+				BorderBottom: table.BorderSpec{Width: 2, Color: "rgb(200, 0, 0)"}, BorderLeft: table.BorderSpec{Width: 2, Color: "rgb(200, 0, 0)"}},
+		},
+		// --- End of Test Cases for Border Directives ---
+
+		// --- Test Cases for Fills ---
+		{
+			name:  "gradient background",
+			input: "Title row {bg:linear(#fff,#ddd,90)}",
+			want:  table.Cell{Content: "Title row", BackgroundColor: "linear(#fff,#ddd,90)"},
+		},
+		{
+			name:  "rgba background",
+			input: "{bg:rgba(0, 0, 0, 0.25)} Shadowed",
+			want:  table.Cell{Content: "Shadowed", BackgroundColor: "rgba(0, 0, 0, 0.25)"},
+		},
+		{
+			name:  "text color and classes",
+			input: "Alert {fg:white} ::class=error  muted::",
+			want:  table.Cell{Content: "Alert", TextColor: "white", Class: "error muted"},
+		},
+		{
+			name:  "align directive",
+			input: "Total ::align=right::",
+			want:  table.Cell{Content: "Total", Align: "right"},
+		},
+		{
+			name:  "min and max width directives",
+			input: "Long text ::min_width=80:: ::max_width=30%::",
+			want:  table.Cell{Content: "Long text", MinWidth: table.Length{Value: 80}, MaxWidth: table.Length{Value: 30, Percent: true}},
+		},
+		{
+			name:  "soft hyphens",
+			input: "[Infra&shy;structure] Ku&shy;ber&shy;netes",
+			want:  table.Cell{Title: "Infra\u00adstructure", Content: "Ku\u00adber\u00adnetes"},
+		},
+		{
+			name:  "overflow directive",
+			input: "Long text ::overflow=shrink::",
+			want:  table.Cell{Content: "Long text", Overflow: "shrink"},
+		},
+		{
+			name:  "invalid overflow stays in content",
+			input: "Long text ::overflow=scroll::",
+			want:  table.Cell{Content: "Long text ::overflow=scroll::"},
+		},
+		{
+			name:  "rotate directive",
+			input: "CPU cores ::rotate=-90::",
+			want:  table.Cell{Content: "CPU cores", Rotate: 270, RotateSet: true},
+		},
+		{
+			name:  "vertical writing mode",
+			input: "::writing_mode=vertical:: Memory",
+			want:  table.Cell{Content: "Memory", Rotate: 90, RotateSet: true},
+		},
+		{
+			name:  "invalid rotate stays in content",
+			input: "Tilted ::rotate=45::",
+			want:  table.Cell{Content: "Tilted ::rotate=45::"},
+		},
+		{
+			name:  "invalid align stays in content",
+			input: "Total ::align=justify::",
+			want:  table.Cell{Content: "Total ::align=justify::"},
+		},
+		{
+			name:  "image with text below it",
+			input: "::image=img/logo v2.png:: ::image_pos=top:: ACME Corp",
+			want:  table.Cell{Content: "ACME Corp", Image: "img/logo v2.png", ImagePosition: "top"},
+		},
+		{
+			name:  "scaled icon",
+			input: "::icon=database:: ::inner_scale=fit_both:: ::inner_align=center::",
+			want:  table.Cell{Icon: "database", InnerTableAlignment: "center", InnerTableScaleMode: "fit_both"},
+		},
+		{
+			name:  "invalid image position stays in content",
+			input: "::icon=check:: Done ::image_pos=behind::",
+			want:  table.Cell{Content: "Done ::image_pos=behind::", Icon: "check"},
+		},
+		// --- End of Test Cases for Fills ---
 	}
 
 	for _, tt := range tests {
@@ -884,3 +1301,22 @@ func TestParseCellDirectives(t *testing.T) {
 		})
 	}
 }
+
+// floatPtr returns a pointer to v, for optional settings in expected values.
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// TestTextStartingWithEqualsStaysContent checks that a document with text starting with "=" that
+// is not a formula, written before formulas existed, still parses with its text.
+func TestTextStartingWithEqualsStaysContent(t *testing.T) {
+	all, err := ParseAllText("table: [t]\n== Overview == | b\n=> next | =3*2")
+	if err != nil {
+		t.Fatalf("ParseAllText failed: %v", err)
+	}
+	rows := all.Tables["t"].Rows
+	got := []string{rows[0].Cells[0].Content, rows[1].Cells[0].Content, rows[1].Cells[1].Content}
+	if want := []string{"== Overview ==", "=> next", "6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("contents = %q, want %q", got, want)
+	}
+}
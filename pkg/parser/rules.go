@@ -0,0 +1,249 @@
+package parser
+
+import (
+	"diagramgen/pkg/grid"
+	"diagramgen/pkg/table"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cellRule is a conditional formatting rule, `rule: content =~ /FAIL/ -> {bg:#FFCCCC}`: cells whose
+// content meets the condition take its style properties. A rule can be limited to some cells by
+// addressing them instead of "content": `rule: C2:C9 > 90 -> {...}`, `rule: #db-primary == down -> {...}`.
+type cellRule struct {
+	text    string // The rule as written, for messages.
+	origin  string // Where the rule is defined, for messages: "table 'x'", "template 'z'" or "style 'y'".
+	subject string // The cells the rule applies to (see grid.SelectCells); empty for all cells.
+	op      string
+	re      *regexp.Regexp // For =~ and !~.
+	operand string
+	number  float64 // The operand as a number, for the numeric comparisons.
+	props   []styleProp
+	matches int
+	// definition identifies the rule line of a table or template. Each instance of a template parses its
+	// rules anew; matches are counted per definition.
+	definition string
+}
+
+var (
+	// ruleLineRegex matches a rule line: an optional [style] it belongs to, an optional subject
+	// ("content", a cell address or a range), the comparison, and the style block.
+	ruleLineRegex  = regexp.MustCompile(`^rule:\s*(?:\[([\w\-]+)\]\s*)?(?:(content|#[\w\-]+|[A-Z]+\d+(?::[A-Z]+\d+)?|[A-Z]+:[A-Z]+|\d+:\d+)\s*)?(=~|!~|==|!=|>=|<=|>|<)\s*(.*?)\s*->\s*\{(.*)\}\s*$`)
+	ruleRegexRegex = regexp.MustCompile(`^/(.*)/([a-z]*)$`)
+	styleRuleRegex = regexp.MustCompile(`^rule:\s*\[`)
+)
+
+// parseRule parses a `rule:` line. It returns the name of the style the rule belongs to, empty for a
+// rule of its table.
+func parseRule(line string) (string, *cellRule, error) {
+	matches := ruleLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if len(matches) != 6 {
+		return "", nil, fmt.Errorf("invalid rule '%s': expected 'rule: [content|B3|B2:C5|#id] <op> <value> -> {key:value, ...}' with op =~, !~, ==, !=, >, >=, < or <=", line)
+	}
+	rule := &cellRule{text: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "rule:")), op: matches[3], operand: strings.Trim(matches[4], `"`)}
+	if matches[2] != "content" {
+		rule.subject = matches[2]
+	}
+	switch rule.op {
+	case "=~", "!~":
+		re := ruleRegexRegex.FindStringSubmatch(matches[4])
+		if re == nil {
+			return "", nil, fmt.Errorf("rule '%s': expected a /regular expression/ after %s", rule.text, rule.op)
+		}
+		flags := ""
+		for _, flag := range re[2] {
+			if !strings.ContainsRune("imsU", flag) {
+				return "", nil, fmt.Errorf("rule '%s': unknown regular expression flag '%c'", rule.text, flag)
+			}
+			flags += string(flag)
+		}
+		if flags != "" {
+			re[1] = "(?" + flags + ")" + re[1]
+		}
+		var err error
+		if rule.re, err = regexp.Compile(re[1]); err != nil {
+			return "", nil, fmt.Errorf("rule '%s': %w", rule.text, err)
+		}
+	case ">", ">=", "<", "<=":
+		number, ok := ruleNumber(rule.operand)
+		if !ok {
+			return "", nil, fmt.Errorf("rule '%s': %s needs a number, got '%s'", rule.text, rule.op, rule.operand)
+		}
+		rule.number = number
+	default:
+		rule.number, _ = ruleNumber(rule.operand)
+	}
+	props, err := parseStyleProps(matches[5])
+	if err != nil {
+		return "", nil, fmt.Errorf("rule '%s': %w", rule.text, err)
+	}
+	for _, prop := range props {
+		if !cellStyleKeys[prop.key] {
+			return "", nil, fmt.Errorf("rule '%s': '%s' is not a cell property", rule.text, prop.key)
+		}
+		if err := setCellStyleProp(&table.Cell{}, prop); err != nil {
+			return "", nil, fmt.Errorf("rule '%s': %w", rule.text, err)
+		}
+	}
+	rule.props = props
+	return matches[1], rule, nil
+}
+
+// ruleNumber reads a cell's content as a number for the numeric comparisons. Surrounding spaces,
+// thousands separators and a trailing percent sign are ignored, so "1,250" and "95 %" are numbers.
+func ruleNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	number, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	return number, err == nil
+}
+
+// match reports whether content meets the rule's condition. The ordering comparisons only match
+// numbers; == and != compare numbers by value and other text as is.
+func (r *cellRule) match(content string) bool {
+	if r.re != nil {
+		return r.re.MatchString(content) == (r.op == "=~")
+	}
+	number, isNumber := ruleNumber(content)
+	_, operandIsNumber := ruleNumber(r.operand)
+	switch r.op {
+	case "==", "!=":
+		equal := strings.TrimSpace(content) == r.operand
+		if isNumber && operandIsNumber {
+			equal = number == r.number
+		}
+		return equal == (r.op == "==")
+	case ">":
+		return isNumber && number > r.number
+	case ">=":
+		return isNumber && number >= r.number
+	case "<":
+		return isNumber && number < r.number
+	case "<=":
+		return isNumber && number <= r.number
+	}
+	return false
+}
+
+// ruleSubjects returns the cells each rule with a subject applies to, addressed on the logical grid of
+// the table.
+func ruleSubjects(t *table.Table, rules []*cellRule) (map[*cellRule]map[*table.Cell]bool, error) {
+	subjects := make(map[*cellRule]map[*table.Cell]bool)
+	for _, rule := range rules {
+		if rule.subject == "" {
+			continue
+		}
+		cells, err := grid.SelectCells(t, rule.subject)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s': %w", rule.text, err)
+		}
+		subjects[rule] = make(map[*table.Cell]bool, len(cells))
+		for _, cell := range cells {
+			subjects[rule][cell] = true
+		}
+	}
+	return subjects, nil
+}
+
+// applyRules gives a cell the style properties of the rules its content matches, later rules
+// winning. Like classes, they only fill in what the cell does not set itself. Rules with a subject
+// only apply to the cells in subjects.
+func applyRules(cell *table.Cell, rules []*cellRule, subjects map[*cellRule]map[*table.Cell]bool) {
+	var styled table.Cell
+	matched := false
+	for _, rule := range rules {
+		if rule.subject != "" && !subjects[rule][cell] {
+			continue
+		}
+		if !rule.match(cell.Content) {
+			continue
+		}
+		rule.matches++
+		matched = true
+		for _, prop := range rule.props {
+			setCellStyleProp(&styled, prop) // Validated by parseRule.
+		}
+	}
+	if matched {
+		fillCellStyle(cell, styled)
+	}
+}
+
+// pendingCell is a formula cell with what styling it needs, kept until formulas are computed.
+type pendingCell struct {
+	cell     *table.Cell
+	header   bool
+	rules    []*cellRule
+	subjects map[*cellRule]map[*table.Cell]bool
+}
+
+// styleFormulaCells applies rules, then classes, to the formula cells once their results are known,
+// so rules match the results rather than the formulas.
+func (s *styleSheet) styleFormulaCells() error {
+	for _, p := range s.formulaCells {
+		if !p.header {
+			applyRules(p.cell, p.rules, p.subjects)
+		}
+		if err := s.applyToCell(p.cell); err != nil {
+			return err
+		}
+	}
+	s.formulaCells = nil
+	return nil
+}
+
+// addRuleLine adds a `rule: [style] ...` line to the rules of a style. Tables take the rules of the
+// `default` style and of their classes before their own.
+func (s *styleSheet) addRuleLine(line string) error {
+	name, rule, err := parseRule(line)
+	if err != nil {
+		return err
+	}
+	rule.origin = fmt.Sprintf("style '%s'", name)
+	rule.text = strings.TrimSpace(strings.TrimPrefix(rule.text, "["+name+"]"))
+	if _, exists := s.styles[name]; !exists {
+		s.styles[name] = nil // A style with only rules can still be used as a class.
+	}
+	s.rules[name] = append(s.rules[name], rule)
+	s.allRules = append(s.allRules, rule)
+	return nil
+}
+
+// tableRules returns the rules a table inherits: those of the `default` style, then those of the
+// classes in its settings block.
+func (s *styleSheet) tableRules(settingsStr string) []*cellRule {
+	rules := append([]*cellRule(nil), s.rules[defaultStyleName]...)
+	for _, name := range tableClassNames(settingsStr) {
+		if name != defaultStyleName {
+			rules = append(rules, s.rules[name]...)
+		}
+	}
+	return rules
+}
+
+// unmatchedRules returns a lint message for each rule definition that matched no cell, in any of
+// the instances of its template.
+func (s *styleSheet) unmatchedRules() []string {
+	matches := make(map[string]int)
+	for _, rule := range s.allRules {
+		if rule.definition != "" {
+			matches[rule.definition] += rule.matches
+		}
+	}
+	var warnings []string
+	reported := make(map[string]bool)
+	for _, rule := range s.allRules {
+		count := rule.matches
+		if rule.definition != "" {
+			if reported[rule.definition] {
+				continue
+			}
+			count, reported[rule.definition] = matches[rule.definition], true
+		}
+		if count == 0 {
+			warnings = append(warnings, fmt.Sprintf("rule '%s' of %s never matches a cell", rule.text, rule.origin))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule    string
+		content string
+		want    bool
+	}{
+		{"rule: content =~ /FAIL/ -> {bg:red}", "Tests FAILED", true},
+		{"rule: content =~ /FAIL/ -> {bg:red}", "failed", false},
+		{"rule: =~ /fail/i -> {bg:red}", "FAILED", true},
+		{"rule: !~ /^OK$/ -> {bg:red}", "OK", false},
+		{"rule: > 90 -> {bg:red}", "95", true},
+		{"rule: > 90 -> {bg:red}", "95 %", true},
+		{"rule: > 90 -> {bg:red}", "1,250", true},
+		{"rule: > 90 -> {bg:red}", "90", false},
+		{"rule: > 90 -> {bg:red}", "n/a", false},
+		{"rule: >= 90 -> {bg:red}", "90.0", true},
+		{"rule: < 0 -> {bg:red}", "-3", true},
+		{"rule: <= 1e3 -> {bg:red}", "1000", true},
+		{"rule: == 5 -> {bg:red}", "5.0", true},
+		{`rule: == "down" -> {bg:red}`, "down", true},
+		{"rule: != down -> {bg:red}", "up", true},
+	}
+	for _, tt := range tests {
+		_, rule, err := parseRule(tt.rule)
+		if err != nil {
+			t.Errorf("parseRule(%q) error = %v", tt.rule, err)
+			continue
+		}
+		if got := rule.match(tt.content); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.rule, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	lines := []string{
+		"rule: [default] =~ /FAIL/ -> {bg:#FFCCCC, fg:#990000}",
+		"rule: [dashboard] > 90 -> {bg:#FDE7E9}",
+		"rule: [unused] > 0 -> {bg:#000000}",
+		"table: [main] {class:dashboard}",
+		"Check | Result",
+		"|---|---|",
+		"build | 50",
+		"tests | FAIL {bg:#FFF4CE}",
+		"cpu | 95 ::class=muted::",
+		"rule: == tests -> {align:center}",
+		"rule: == Check -> {align:center}",
+		"rule: == never -> {align:center}",
+	}
+	sheet, err := collectStyleSheet(lines, lines)
+	if err != nil {
+		t.Fatalf("collectStyleSheet() error = %v", err)
+	}
+	tbl, err := parseTableDefinition(strings.Join(lines, "\n"), sheet, "", "")
+	if err != nil {
+		t.Fatalf("parseTableDefinition() error = %v", err)
+	}
+	// The header row takes no rules.
+	if cell := tbl.Rows[0].Cells[0]; cell.Content != "Check" || cell.Align != "" {
+		t.Errorf("header cell = %+v", cell)
+	}
+	// The cell's own color wins over the inherited rule; other properties are filled in.
+	if cell := tbl.Rows[2].Cells[1]; cell.BackgroundColor != "#FFF4CE" || cell.TextColor != "#990000" {
+		t.Errorf("FAIL cell: bg %q, fg %q", cell.BackgroundColor, cell.TextColor)
+	}
+	if cell := tbl.Rows[2].Cells[0]; cell.Align != "center" {
+		t.Errorf("table rule not applied: %+v", cell)
+	}
+	// Rules win over classes.
+	if cell := tbl.Rows[3].Cells[1]; cell.BackgroundColor != "#FDE7E9" || cell.TextColor != "#777777" {
+		t.Errorf("class rule with class: bg %q, fg %q", cell.BackgroundColor, cell.TextColor)
+	}
+
+	unmatched := sheet.unmatchedRules()
+	want := []string{
+		"rule '> 0 -> {bg:#000000}' of style 'unused' never matches a cell",
+		"rule '== Check -> {align:center}' of table 'main' never matches a cell",
+		"rule '== never -> {align:center}' of table 'main' never matches a cell",
+	}
+	if strings.Join(unmatched, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unmatched rules = %q, want %q", unmatched, want)
+	}
+}
+
+func TestRuleSubjects(t *testing.T) {
+	input := strings.Join([]string{
+		"rule: [status] C:C == down -> {bg:#FFCCCC}",
+		"table: [main] {class:status}",
+		"Service | Load | Status",
+		"|---|---|---|",
+		"API | 42 | up",
+		"DB ::id=db:: | 87 | down",
+		"Total | =SUM(B2:B3) ::id=total:: | down",
+		"rule: #total > 100 -> {fg:#006600}",
+		"rule: B2:B3 > 50 -> {align:right}",
+		"rule: #db == down -> {bg:#000000}",
+	}, "\n")
+	all, err := ParseAllText(input)
+	if err != nil {
+		t.Fatalf("ParseAllText() error = %v", err)
+	}
+	rows := all.Tables["main"].Rows
+	// Only the cells of the subject take the rule: the third column, not the ID cell saying "DB".
+	if rows[2].Cells[2].BackgroundColor != "#FFCCCC" || rows[3].Cells[2].BackgroundColor != "#FFCCCC" || rows[2].Cells[0].BackgroundColor != "" {
+		t.Errorf("column subject: %+v", rows)
+	}
+	// A formula cell is matched against its result.
+	if cell := rows[3].Cells[1]; cell.Content != "129" || cell.TextColor != "#006600" {
+		t.Errorf("formula cell addressed by ID = %+v", cell)
+	}
+	if rows[2].Cells[1].Align != "right" || rows[1].Cells[1].Align != "" || rows[3].Cells[1].Align != "" {
+		t.Errorf("range subject: %+v", rows)
+	}
+	// The ID cell says "DB", so its rule is reported rather than printed.
+	want := []string{"rule '#db == down -> {bg:#000000}' of table 'main' never matches a cell"}
+	if strings.Join(all.Warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Warnings = %q, want %q", all.Warnings, want)
+	}
+}
+
+// TestTemplateRuleMatches checks that a rule written in a template counts the matches of all its
+// instances and is reported once, under the template's name.
+func TestTemplateRuleMatches(t *testing.T) {
+	input := strings.Join([]string{
+		"table: [main]",
+		`::table=check(result="OK"):: | ::table=check(result="FAIL")::`,
+		"template: [check](result)",
+		"{{result}}",
+		"rule: content =~ /FAIL/ -> {bg:#FFCCCC}",
+		"rule: content == SKIPPED -> {fg:#777777}",
+	}, "\n")
+	all, err := ParseAllText(input)
+	if err != nil {
+		t.Fatalf("ParseAllText() error = %v", err)
+	}
+	if cell := all.Tables[`check(result="FAIL")`].Rows[0].Cells[0]; cell.BackgroundColor != "#FFCCCC" {
+		t.Errorf("FAIL instance cell = %+v", cell)
+	}
+	want := []string{"rule 'content == SKIPPED -> {fg:#777777}' of template 'check' never matches a cell"}
+	if strings.Join(all.Warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Warnings = %q, want %q", all.Warnings, want)
+	}
+}
+
+func TestRuleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing style block", "table: [t]\nrule: > 90\na", "invalid rule"},
+		{"unknown operator", "table: [t]\nrule: ~= x -> {bg:red}\na", "invalid rule"},
+		{"not a regex", "table: [t]\nrule: =~ FAIL -> {bg:red}\na", "regular expression"},
+		{"bad regex", "table: [t]\nrule: =~ /(/ -> {bg:red}\na", "missing closing )"},
+		{"bad flag", "table: [t]\nrule: =~ /a/x -> {bg:red}\na", "flag 'x'"},
+		{"non-numeric comparison", "table: [t]\nrule: > high -> {bg:red}\na", "needs a number"},
+		{"table property", "table: [t]\nrule: > 1 -> {bg_table:red}\na", "not a cell property"},
+		{"invalid value", "table: [t]\nrule: > 1 -> {align:middle}\na", "invalid align"},
+		{"style rule error", "rule: [x] > high -> {bg:red}\ntable: [t]\na", "line 1"},
+		{"subject in another table", "table: [t]\nrule: u!B3 > 1 -> {bg:red}\na", "invalid rule"},
+		{"duplicate cell id", "table: [t]\na ::id=x:: | b ::id=x::", "duplicate cell id 'x'"},
+		{"row 0", "table: [t]\nrule: 0:0 > 1 -> {bg:red}\na", "row 0 is not between 1"},
+		{"row 0 in range", "table: [t]\nrule: A0:B2 > 1 -> {bg:red}\na", "row 0 is not between 1"},
+		{"long column", "table: [t]\nrule: AAAAAAAAAAAAAAAAAAAAAAAAAA1 > 1 -> {bg:red}\na", "more than 3 letters"},
+		{"long column range", "table: [t]\nrule: A:AAAAAAAAAAAAAAAAAAAAAAAAAA > 1 -> {bg:red}\na", "more than 3 letters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAllText(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseAllText() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
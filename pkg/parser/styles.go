@@ -0,0 +1,416 @@
+package parser
+
+import (
+	"diagramgen/pkg/table"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultStyleName is the style applied to every table before its classes and own settings.
+const defaultStyleName = "default"
+
+// styleProp is one "key:value" pair of a style block, kept in source order so later values win.
+type styleProp struct {
+	key, value string
+}
+
+// styleSheet holds the styles visible to a document: the styles of the selected built-in
+// theme, extended by the document's own `style:` blocks. Styles are applied in cascade order
+// theme -> document -> table -> row -> cell; a more specific level always wins.
+type styleSheet struct {
+	theme  string
+	styles map[string][]styleProp
+	// rules holds the conditional formatting rules of each style (see cellRule), and allRules every
+	// rule of the document, including those of tables, for unmatchedRules.
+	rules    map[string][]*cellRule
+	allRules []*cellRule
+	// formulaCells wait for their results to be styled, see styleFormulaCells.
+	formulaCells []pendingCell
+}
+
+// builtinThemes defines the built-in themes. Every theme provides the same class names so that a
+// document can switch themes without touching its tables. The "light" default style is empty
+// because it matches table.DefaultGlobalSettings.
+var builtinThemes = map[string]map[string]string{
+	"light": {
+		defaultStyleName: "",
+		"header":         "bg:#E8E8E8",
+		"muted":          "fg:#777777",
+		"info":           "bg:#E7F1FB, fg:#0B4F8A",
+		"success":        "bg:#E6F4EA, fg:#1E6B34",
+		"warning":        "bg:#FFF4CE, fg:#7A5B00",
+		"error":          "bg:#FDE7E9, fg:#A4262C",
+		"deprecated":     "bg:hatch(#D0D0D0), fg:#777777",
+	},
+	"dark": {
+		defaultStyleName: "bg_table:#1E1E1E, bg_cell:#2B2B2B, edge_color:#5A5A5A, text_color:#E6E6E6, header_bg:#3A3A3A",
+		"header":         "bg:#3A3A3A",
+		"muted":          "fg:#9A9A9A",
+		"info":           "bg:#1B3A57, fg:#CFE6FF",
+		"success":        "bg:#1E3D2A, fg:#C8F2D4",
+		"warning":        "bg:#4A3B00, fg:#FFE8A3",
+		"error":          "bg:#4D1F22, fg:#FFD1D4",
+		"deprecated":     "bg:hatch(#444444, #2B2B2B), fg:#9A9A9A",
+	},
+	"high-contrast": {
+		defaultStyleName: "bg_table:#FFFFFF, bg_cell:#FFFFFF, edge_color:#000000, edge_thickness:2, text_color:#000000, header_bg:#000000, header_fg:#FFFFFF",
+		"header":         "bg:#000000, fg:#FFFFFF",
+		"muted":          "fg:#333333",
+		"info":           "bg:#003A8C, fg:#FFFFFF",
+		"success":        "bg:#005A1E, fg:#FFFFFF",
+		"warning":        "bg:#FFD400, fg:#000000",
+		"error":          "bg:#B00000, fg:#FFFFFF",
+		"deprecated":     "bg:hatch(#000000, #FFFFFF, 6), fg:#000000",
+	},
+	"print": {
+		defaultStyleName: "bg_table:#FFFFFF, bg_cell:#FFFFFF, edge_color:#808080, text_color:#000000, corner_radius:0, header_bg:#F0F0F0",
+		"header":         "bg:#F0F0F0",
+		"muted":          "fg:#666666",
+		"info":           "bg:#F5F5F5",
+		"success":        "bg:#F5F5F5",
+		"warning":        "bg:hatch(#C0C0C0, #FFFFFF, 10)",
+		"error":          "bg:hatch(#808080, #FFFFFF, 5)",
+		"deprecated":     "bg:hatch(#D0D0D0, #FFFFFF, 8), fg:#666666",
+	},
+}
+
+// Style keys that only make sense for a whole table, and keys that apply to cells. A style may mix
+// both; when applied to a table, bg and fg set the table's default cell colors, and when applied
+// to a cell, bg_cell and text_color set the cell's own colors.
+var (
+	tableStyleKeys = map[string]bool{"bg_table": true, "bg_cell": true, "edge_color": true, "edge_thickness": true, "text_color": true,
+		"corner_radius": true, "margin": true, "padding": true, "padding_top": true, "padding_right": true, "padding_bottom": true,
+		"padding_left": true, "border_mode": true, "cell_spacing": true, "header_rows": true, "header_cols": true,
+		"header_bg": true, "header_fg": true, "width": true, "max_width": true, "overflow": true,
+		"hyphens": true, "hyphen_words": true, "direction": true}
+	cellStyleKeys = map[string]bool{"bg": true, "fg": true, "align": true, "border": true, "border_top": true, "border_right": true,
+		"border_bottom": true, "border_left": true, "overflow": true, "rotate": true, "icon": true, "image_pos": true}
+)
+
+var (
+	styleLineRegex = regexp.MustCompile(`^style:\s*\[([\w\-]+)\]\s*\{(.*)\}\s*$`)
+	// styleStartRegex recognizes style definitions, well-formed or not. Like style rules, they need the
+	// bracketed name, so that a table row that happens to start with "style:" stays a row.
+	styleStartRegex = regexp.MustCompile(`^style:\s*\[`)
+	themeLineRegex  = regexp.MustCompile(`^theme:\s*([\w\-]+)\s*$`)
+)
+
+// collectStyleSheet builds the document style sheet from its `theme:`, `style:` and `rule: [style]`
+// lines. Those lines in contentLines are replaced by empty lines. The theme is applied first wherever it appears,
+// so document styles always override it.
+func collectStyleSheet(allLines, contentLines []string) (*styleSheet, error) {
+	theme := ""
+	for i, line := range allLines {
+		matches := themeLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if len(matches) != 2 {
+			continue
+		}
+		if theme != "" && theme != matches[1] {
+			return nil, fmt.Errorf("line %d: theme already set to '%s'", i+1, theme)
+		}
+		theme = matches[1]
+	}
+	if theme == "" {
+		theme = "light"
+	}
+	sheet, err := newStyleSheet(theme)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range allLines {
+		trimmed := strings.TrimSpace(line)
+		var err error
+		if styleStartRegex.MatchString(trimmed) {
+			err = sheet.addStyleLine(trimmed)
+		} else if styleRuleRegex.MatchString(trimmed) {
+			err = sheet.addRuleLine(trimmed)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	for i, line := range contentLines {
+		if trimmed := strings.TrimSpace(line); styleStartRegex.MatchString(trimmed) || styleRuleRegex.MatchString(trimmed) || themeLineRegex.MatchString(trimmed) {
+			contentLines[i] = ""
+		}
+	}
+	return sheet, nil
+}
+
+// newStyleSheet returns the style sheet for a built-in theme.
+func newStyleSheet(theme string) (*styleSheet, error) {
+	definitions, ok := builtinThemes[theme]
+	if !ok {
+		return nil, fmt.Errorf("unknown theme '%s' (available: %s)", theme, strings.Join(themeNames(), ", "))
+	}
+	sheet := &styleSheet{theme: theme, styles: make(map[string][]styleProp), rules: make(map[string][]*cellRule)}
+	for name, body := range definitions {
+		props, err := parseStyleProps(body)
+		if err != nil {
+			return nil, fmt.Errorf("built-in theme '%s', style '%s': %w", theme, name, err)
+		}
+		sheet.styles[name] = props
+	}
+	return sheet, nil
+}
+
+// themeNames returns the built-in theme names in sorted order.
+func themeNames() []string {
+	names := make([]string, 0, len(builtinThemes))
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseStyleProps parses the body of a style block ("bg:#FFF, fg:#333") and validates its keys.
+func parseStyleProps(body string) ([]styleProp, error) {
+	var props []styleProp
+	for _, pair := range splitOutsideParens(body, func(r rune) bool { return r == ',' }) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected 'key:value', got '%s'", pair)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !tableStyleKeys[key] && !cellStyleKeys[key] {
+			return nil, fmt.Errorf("unknown style property '%s'", key)
+		}
+		props = append(props, styleProp{key: key, value: value})
+	}
+	return props, nil
+}
+
+// addStyleLine parses a `style: [name] {...}` line. Properties are appended to any existing
+// style of the same name (including a built-in one), so they override it property by property.
+func (s *styleSheet) addStyleLine(line string) error {
+	matches := styleLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if len(matches) != 3 {
+		return fmt.Errorf("invalid style definition '%s': expected 'style: [name] {key:value, ...}'", line)
+	}
+	props, err := parseStyleProps(matches[2])
+	if err != nil {
+		return fmt.Errorf("style '%s': %w", matches[1], err)
+	}
+	s.styles[matches[1]] = append(s.styles[matches[1]], props...)
+	return nil
+}
+
+// tableDefaults returns the settings every table of the document starts from.
+func (s *styleSheet) tableDefaults() (table.GlobalSettings, error) {
+	settings := table.DefaultGlobalSettings()
+	err := s.applyToTable(defaultStyleName, &settings)
+	return settings, err
+}
+
+// applyToTable applies the table-level properties of a style to settings.
+func (s *styleSheet) applyToTable(name string, settings *table.GlobalSettings) error {
+	props, ok := s.styles[name]
+	if !ok {
+		return fmt.Errorf("unknown style class '%s'", name)
+	}
+	for _, prop := range props {
+		key := prop.key
+		switch key {
+		case "bg":
+			key = "bg_cell"
+		case "fg":
+			key = "text_color"
+		}
+		if !tableStyleKeys[key] {
+			continue
+		}
+		if err := applyGlobalSetting(key, prop.value, settings); err != nil {
+			return fmt.Errorf("style '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applyTableClasses applies the classes listed in the `class:` entry of a table settings block.
+// It runs before the table's own settings are parsed, so those take precedence.
+func (s *styleSheet) applyTableClasses(settingsStr string, settings *table.GlobalSettings) error {
+	for _, name := range tableClassNames(settingsStr) {
+		if err := s.applyToTable(name, settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableClassNames returns the classes listed in the `class:` entries of a table settings block.
+func tableClassNames(settingsStr string) []string {
+	var names []string
+	for _, pair := range splitOutsideParens(settingsStr, func(r rune) bool { return r == ',' }) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "class" {
+			names = append(names, strings.Fields(parts[1])...)
+		}
+	}
+	return names
+}
+
+// applyToCell applies the cell's ::class=...:: styles. Only properties the cell does not set
+// itself are filled in; among several classes the later one wins.
+func (s *styleSheet) applyToCell(cell *table.Cell) error {
+	if cell.Class == "" {
+		return nil
+	}
+	styled, err := s.resolveCellClasses(cell.Class)
+	if err != nil {
+		return fmt.Errorf("cell '%s': %w", cell.Content, err)
+	}
+	fillCellStyle(cell, styled)
+	return nil
+}
+
+// fillCellStyle sets the style properties of cell that it does not set itself to those of styled.
+func fillCellStyle(cell *table.Cell, styled table.Cell) {
+	if cell.BackgroundColor == "" {
+		cell.BackgroundColor = styled.BackgroundColor
+	}
+	if cell.TextColor == "" {
+		cell.TextColor = styled.TextColor
+	}
+	if cell.Align == "" {
+		cell.Align = styled.Align
+	}
+	if cell.Overflow == "" {
+		cell.Overflow = styled.Overflow
+	}
+	if !cell.RotateSet && styled.RotateSet {
+		cell.Rotate, cell.RotateSet = styled.Rotate, true
+	}
+	if cell.Icon == "" && cell.Image == "" {
+		cell.Icon = styled.Icon
+	}
+	if cell.ImagePosition == "" {
+		cell.ImagePosition = styled.ImagePosition
+	}
+	if cell.CornerRadius == nil {
+		cell.CornerRadius = styled.CornerRadius
+	}
+	cell.Padding = cell.Padding.Over(styled.Padding)
+	for _, side := range []struct{ own, class *table.BorderSpec }{
+		{&cell.BorderTop, &styled.BorderTop}, {&cell.BorderRight, &styled.BorderRight},
+		{&cell.BorderBottom, &styled.BorderBottom}, {&cell.BorderLeft, &styled.BorderLeft},
+	} {
+		if !side.own.IsSet() {
+			*side.own = *side.class
+		}
+	}
+}
+
+// resolveCellClasses merges the cell-level properties of the given classes into a fresh cell,
+// later classes winning. Unknown classes and invalid values are errors, as for table classes.
+func (s *styleSheet) resolveCellClasses(classes string) (table.Cell, error) {
+	var styled table.Cell
+	for _, name := range strings.Fields(classes) {
+		props, ok := s.styles[name]
+		if !ok {
+			return table.Cell{}, fmt.Errorf("unknown style class '%s'", name)
+		}
+		for _, prop := range props {
+			if err := setCellStyleProp(&styled, prop); err != nil {
+				return table.Cell{}, fmt.Errorf("style '%s': %w", name, err)
+			}
+		}
+	}
+	return styled, nil
+}
+
+// applyToRow applies the row's classes (from "@row {class:...}") to the colors and alignment the
+// row does not set itself.
+func (s *styleSheet) applyToRow(row *table.Row) error {
+	if row.Class == "" {
+		return nil
+	}
+	styled, err := s.resolveCellClasses(row.Class)
+	if err != nil {
+		return err
+	}
+	if row.BackgroundColor == "" {
+		row.BackgroundColor = styled.BackgroundColor
+	}
+	if row.TextColor == "" {
+		row.TextColor = styled.TextColor
+	}
+	if row.Align == "" {
+		row.Align = styled.Align
+	}
+	return nil
+}
+
+// setCellStyleProp applies one style property to a cell. Table-only properties are ignored.
+func setCellStyleProp(cell *table.Cell, prop styleProp) error {
+	switch prop.key {
+	case "bg", "bg_cell":
+		cell.BackgroundColor = prop.value
+	case "fg", "text_color":
+		cell.TextColor = prop.value
+	case "align":
+		if !isValidAlign(prop.value) {
+			return fmt.Errorf("invalid align '%s'", prop.value)
+		}
+		cell.Align = prop.value
+	case "overflow":
+		if !isValidOverflow(prop.value) {
+			return fmt.Errorf("invalid overflow '%s'", prop.value)
+		}
+		cell.Overflow = prop.value
+	case "rotate":
+		degrees, err := parseRotation(prop.value)
+		if err != nil {
+			return fmt.Errorf("invalid rotate '%s': %w", prop.value, err)
+		}
+		cell.Rotate, cell.RotateSet = degrees, true
+	case "icon":
+		cell.Icon = prop.value
+	case "image_pos":
+		switch prop.value {
+		case "left", "top", "right", "bottom":
+			cell.ImagePosition = prop.value
+		default:
+			return fmt.Errorf("invalid image_pos '%s'", prop.value)
+		}
+	case "corner_radius":
+		radius, err := strconv.ParseFloat(prop.value, 64)
+		if err != nil || radius < 0 {
+			return fmt.Errorf("invalid corner_radius '%s'", prop.value)
+		}
+		cell.CornerRadius = &radius
+	case "padding":
+		padding, err := parsePaddingSpec(prop.value)
+		if err != nil {
+			return err
+		}
+		cell.Padding = padding.Over(cell.Padding)
+	case "padding_top", "padding_right", "padding_bottom", "padding_left":
+		value, err := strconv.ParseFloat(prop.value, 64)
+		if err != nil || value < 0 {
+			return fmt.Errorf("invalid %s '%s'", prop.key, prop.value)
+		}
+		setPaddingSide(&cell.Padding, strings.TrimPrefix(prop.key, "padding_"), value)
+	case "border", "border_top", "border_right", "border_bottom", "border_left":
+		spec, err := parseBorderSpec(prop.value)
+		if err != nil {
+			return err
+		}
+		switch prop.key {
+		case "border":
+			cell.BorderTop, cell.BorderRight, cell.BorderBottom, cell.BorderLeft = spec, spec, spec, spec
+		case "border_top":
+			cell.BorderTop = spec
+		case "border_right":
+			cell.BorderRight = spec
+		case "border_bottom":
+			cell.BorderBottom = spec
+		case "border_left":
+			cell.BorderLeft = spec
+		}
+	}
+	return nil
+}
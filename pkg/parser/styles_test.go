@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"diagramgen/pkg/table"
+	"strings"
+	"testing"
+)
+
+func TestThemesAndClasses(t *testing.T) {
+	input := strings.Join([]string{
+		"theme: dark",
+		"main_table: [main]",
+		"style: [warning] {fg:#FFFF00}",
+		"style: [done] {icon:check, image_pos:top}",
+		"style: [default] {edge_thickness:3}",
+		"",
+		"table: [main] {class:info, text_color:#ABCDEF}",
+		"Plain | Warn ::class=warning:: | Own {bg:#123456} ::class=warning:: | Shipped ::class=done:: | ::icon=cross:: ::class=done::",
+		"",
+		"style: [late] {bg:#010203, border_bottom:2 solid #000}",
+		"table: [other]",
+		"Late ::class=late warning::",
+	}, "\n")
+	all, err := ParseAllText(input)
+	if err != nil {
+		t.Fatalf("ParseAllText() error = %v", err)
+	}
+
+	main := all.Tables["main"]
+	// Theme default, then the document default style, then the table class, then the table's own settings.
+	if main.Settings.TableBackgroundColor != "#1E1E1E" || main.Settings.EdgeColor != "#5A5A5A" {
+		t.Errorf("dark theme defaults not applied: %+v", main.Settings)
+	}
+	if main.Settings.EdgeThickness != 3 {
+		t.Errorf("document default style: EdgeThickness = %d, want 3", main.Settings.EdgeThickness)
+	}
+	if main.Settings.DefaultCellBackgroundColor != "#1B3A57" {
+		t.Errorf("table class: DefaultCellBackgroundColor = %q, want the dark info bg", main.Settings.DefaultCellBackgroundColor)
+	}
+	if main.Settings.TextColor != "#ABCDEF" {
+		t.Errorf("table settings must override the class: TextColor = %q", main.Settings.TextColor)
+	}
+
+	cells := main.Rows[0].Cells
+	if cells[0].BackgroundColor != "" || cells[0].TextColor != "" {
+		t.Errorf("cell without class should inherit from the table, got %+v", cells[0])
+	}
+	// The document style extends the theme's warning class property by property.
+	if cells[1].BackgroundColor != "#4A3B00" || cells[1].TextColor != "#FFFF00" {
+		t.Errorf("warning class: got bg %q fg %q", cells[1].BackgroundColor, cells[1].TextColor)
+	}
+	if cells[2].BackgroundColor != "#123456" || cells[2].Content != "Own" {
+		t.Errorf("cell directives must override the class: got %+v", cells[2])
+	}
+
+	// Classes can give cells an icon; a cell's own icon wins.
+	if cells[3].Icon != "check" || cells[3].ImagePosition != "top" || cells[4].Icon != "cross" {
+		t.Errorf("icon class: got %+v and %+v", cells[3], cells[4])
+	}
+
+	// Styles defined after a table are still visible to it; among classes the later one wins.
+	late := all.Tables["other"].Rows[0].Cells[0]
+	if late.BackgroundColor != "#4A3B00" || late.BorderBottom != (table.BorderSpec{Width: 2, Style: "solid", Color: "#000"}) {
+		t.Errorf("late/multiple classes: got %+v", late)
+	}
+	if len(all.Tables) != 2 {
+		t.Errorf("style lines must not be parsed as table rows, got %d tables", len(all.Tables))
+	}
+}
+
+func TestThemeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown theme", "theme: neon\ntable: [t]\na"},
+		{"conflicting themes", "theme: dark\ntheme: print\ntable: [t]\na"},
+		{"unknown table class", "table: [t] {class:nope}\na"},
+		{"unknown cell class", "table: [t]\na ::class=nope::"},
+		{"unknown formula cell class", "table: [t]\n=1+1 ::class=nope::"},
+		{"unknown row class", "table: [t]\n@row {class:nope} a | b"},
+		{"invalid value in a cell class", "style: [x] {align:middle}\ntable: [t]\na ::class=x::"},
+		{"unknown style property", "style: [x] {font:bold}\ntable: [t]\na"},
+		{"malformed style line", "style: [x] bg:#fff\ntable: [t]\na"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseAllText(tt.input); err == nil {
+				t.Errorf("ParseAllText(%q): expected an error", tt.input)
+			}
+		})
+	}
+}
+
+func TestBuiltinThemesParse(t *testing.T) {
+	for _, name := range themeNames() {
+		sheet, err := newStyleSheet(name)
+		if err != nil {
+			t.Errorf("theme %s: %v", name, err)
+			continue
+		}
+		if _, err := sheet.tableDefaults(); err != nil {
+			t.Errorf("theme %s defaults: %v", name, err)
+		}
+	}
+	// The light theme is the implicit default and must not change existing output.
+	sheet, _ := newStyleSheet("light")
+	if settings, _ := sheet.tableDefaults(); settings != table.DefaultGlobalSettings() {
+		t.Errorf("light theme defaults = %+v, want DefaultGlobalSettings", settings)
+	}
+}
+
+// TestStyleLookalikeRowStaysContent checks that only `style: [name] {...}` lines are style
+// definitions: a table row whose first cell starts with "style:" keeps its cells.
+func TestStyleLookalikeRowStaysContent(t *testing.T) {
+	all, err := ParseAllText("table: [t]\nstyle: bold | weight: 700\na | b")
+	if err != nil {
+		t.Fatalf("ParseAllText failed: %v", err)
+	}
+	rows := all.Tables["t"].Rows
+	if len(rows) != 2 || len(rows[0].Cells) != 2 || rows[0].Cells[0].Content != "style: bold" {
+		t.Errorf("expected the 'style: bold' row to be kept, got %+v", rows)
+	}
+}
+
+// TestExplicitRotateZeroOverridesClass checks that ::rotate=0:: and ::writing_mode=horizontal:: keep
+// a cell upright although its class turns text.
+func TestExplicitRotateZeroOverridesClass(t *testing.T) {
+	all, err := ParseAllText("style: [turned] {rotate:90}\ntable: [t]\nA ::class=turned:: | B ::class=turned:: ::rotate=0:: | C ::class=turned:: ::writing_mode=horizontal::")
+	if err != nil {
+		t.Fatalf("ParseAllText failed: %v", err)
+	}
+	cells := all.Tables["t"].Rows[0].Cells
+	for i, want := range []int{90, 0, 0} {
+		if cells[i].Rotate != want {
+			t.Errorf("cell %s: Rotate = %d, want %d", cells[i].Content, cells[i].Rotate, want)
+		}
+	}
+}
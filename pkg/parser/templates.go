@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"diagramgen/pkg/table"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxTemplateDepth limits how deeply template instances may nest, which stops a template that
+// instantiates itself with ever new arguments.
+const maxTemplateDepth = 16
+
+// tableTemplate is a `template: [name](param, ...)` definition: a table whose {{param}} placeholders
+// are filled in by each `::table=name(param="value", ...)::` reference to it.
+type tableTemplate struct {
+	name   string
+	params []string
+	body   string // The definition with its first line turned into an ID-less "table:" line.
+}
+
+var (
+	templateLineRegex  = regexp.MustCompile(`^template:\s*\[([\w\-]+)\]\s*\(([^()]*)\)(.*)$`)
+	templateRefRegex   = regexp.MustCompile(`^([\w\-]+)\((.*)\)$`)
+	templateArgRegex   = regexp.MustCompile(`^\s*([\w\-]+)\s*=\s*(?:"([^"]*)"|([^",]*))\s*(?:,|$)`)
+	templateParamRegex = regexp.MustCompile(`^[\w\-]+$`)
+	placeholderRegex   = regexp.MustCompile(`\{\{\s*([\w\-]+)\s*\}\}`)
+)
+
+// templateKeywords are the text/template keywords that stand alone between braces, like the
+// "{{end}}" of a rows_from row template. They are left to the row template and cannot be parameters;
+// other actions, such as "{{.field}}" or "{{if eq .a 1}}", do not look like placeholders.
+var templateKeywords = map[string]bool{"end": true, "else": true, "break": true, "continue": true, "nil": true, "true": true, "false": true}
+
+// collectTemplates reads the `template:` blocks of a document. A template runs up to the next
+// `table:` or `template:` line; its lines are replaced by empty lines, like style lines, so they
+// neither end up in a table nor shift line numbers.
+func collectTemplates(contentLines []string) (map[string]*tableTemplate, error) {
+	templates := make(map[string]*tableTemplate)
+	var current *tableTemplate
+	var currentLines []string
+	finish := func() {
+		if current != nil {
+			current.body = strings.Join(currentLines, "\n")
+		}
+		current, currentLines = nil, nil
+	}
+	for i, line := range contentLines {
+		switch {
+		case strings.HasPrefix(line, "template:"):
+			finish()
+			matches := templateLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+			if len(matches) != 4 {
+				return nil, fmt.Errorf("line %d: invalid template definition '%s': expected 'template: [name](param, ...) Title {settings}'", i+1, line)
+			}
+			if _, exists := templates[matches[1]]; exists {
+				return nil, fmt.Errorf("line %d: duplicate template '%s'", i+1, matches[1])
+			}
+			current = &tableTemplate{name: matches[1]}
+			declared := make(map[string]bool)
+			for _, param := range strings.Split(matches[2], ",") {
+				if param = strings.TrimSpace(param); param == "" && len(strings.TrimSpace(matches[2])) == 0 {
+					break // `name()` declares no parameters.
+				}
+				if !templateParamRegex.MatchString(param) || declared[param] {
+					return nil, fmt.Errorf("line %d: template '%s' has an invalid or repeated parameter '%s'", i+1, matches[1], param)
+				}
+				if templateKeywords[param] {
+					return nil, fmt.Errorf("line %d: template '%s' cannot name a parameter '%s', a keyword of row templates", i+1, matches[1], param)
+				}
+				declared[param] = true
+				current.params = append(current.params, param)
+			}
+			templates[current.name] = current
+			currentLines = []string{"table: " + strings.TrimSpace(matches[3])}
+			contentLines[i] = ""
+		case strings.HasPrefix(line, "table:"):
+			finish()
+		case current != nil:
+			currentLines = append(currentLines, line)
+			contentLines[i] = ""
+		}
+	}
+	finish()
+	for _, tmpl := range templates {
+		for _, matches := range placeholderRegex.FindAllStringSubmatch(tmpl.body, -1) {
+			if !tmpl.hasParam(matches[1]) && !templateKeywords[matches[1]] {
+				return nil, fmt.Errorf("template '%s' uses undeclared parameter '%s'", tmpl.name, matches[1])
+			}
+		}
+	}
+	return templates, nil
+}
+
+// hasParam reports whether the template declares the parameter.
+func (tmpl *tableTemplate) hasParam(name string) bool {
+	for _, param := range tmpl.params {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTemplateArgs parses the `param="value", ...` arguments of a template reference. Values are
+// quoted, or bare up to the next comma.
+func (tmpl *tableTemplate) parseTemplateArgs(argsStr string) (map[string]string, error) {
+	args := make(map[string]string)
+	for rest := strings.TrimSpace(argsStr); rest != ""; {
+		matches := templateArgRegex.FindStringSubmatch(rest)
+		if matches == nil {
+			return nil, fmt.Errorf("invalid arguments '%s': expected 'param=\"value\", ...'", argsStr)
+		}
+		name := matches[1]
+		if !tmpl.hasParam(name) {
+			return nil, fmt.Errorf("template '%s' has no parameter '%s'", tmpl.name, name)
+		}
+		if _, exists := args[name]; exists {
+			return nil, fmt.Errorf("parameter '%s' given twice", name)
+		}
+		// A template passing its own placeholder on gets the escaped value; the instance ID shows it as written.
+		args[name] = unescapeData(matches[2] + strings.TrimSpace(matches[3]))
+		rest = strings.TrimSpace(rest[len(matches[0]):])
+	}
+	for _, param := range tmpl.params {
+		if _, ok := args[param]; !ok {
+			return nil, fmt.Errorf("template '%s' is missing parameter '%s'", tmpl.name, param)
+		}
+	}
+	return args, nil
+}
+
+// instanceID returns the table ID of the template instance with the given arguments, listed in the
+// order the template declares them, e.g. `contact_card(name="Alice", email="a@x")`. References with
+// the same arguments share one instance.
+func (tmpl *tableTemplate) instanceID(args map[string]string) string {
+	parts := make([]string, len(tmpl.params))
+	for i, param := range tmpl.params {
+		parts[i] = fmt.Sprintf("%s=%q", param, args[param])
+	}
+	return tmpl.name + "(" + strings.Join(parts, ", ") + ")"
+}
+
+// expandTemplates turns the template references of the document's cells, `name(param="value", ...)`
+// or just `name` for a template without parameters, into tables of their own: each distinct set of
+// arguments is parsed once from the template with its placeholders substituted, and the cells refer
+// to it by its instance ID. Instances may refer to templates in turn.
+func expandTemplates(allTables *table.AllTables, templates map[string]*tableTemplate, sheet *styleSheet, baseDir string) error {
+	for name := range templates {
+		if _, exists := allTables.Tables[name]; exists {
+			return fmt.Errorf("template '%s' has the same name as a table", name)
+		}
+	}
+	queue := make([]string, 0, len(allTables.Tables))
+	for id := range allTables.Tables {
+		queue = append(queue, id)
+	}
+	sort.Strings(queue) // Report errors deterministically.
+	depth := make(map[string]int)
+
+	instantiate := func(ref string, parentDepth int) (string, error) {
+		name, argsStr := ref, ""
+		matches := templateRefRegex.FindStringSubmatch(ref)
+		if len(matches) == 3 {
+			name, argsStr = matches[1], matches[2]
+		} else if _, isTable := allTables.Tables[ref]; isTable {
+			return ref, nil
+		}
+		tmpl, ok := templates[name]
+		if !ok {
+			if len(matches) == 3 {
+				return "", fmt.Errorf("unknown template '%s'", name)
+			}
+			return ref, nil // Left for the renderer to report as a missing table.
+		}
+		args, err := tmpl.parseTemplateArgs(argsStr)
+		if err != nil {
+			return "", err
+		}
+		id := tmpl.instanceID(args)
+		if _, exists := allTables.Tables[id]; exists {
+			return id, nil
+		}
+		if parentDepth+1 > maxTemplateDepth {
+			return "", fmt.Errorf("template instances nested more than %d deep; does template '%s' refer to itself?", maxTemplateDepth, name)
+		}
+		// Values are escaped like the data rows print (see dataEscaper), so an argument is always text
+		// and cannot add cells, directives or settings to the instance.
+		body := placeholderRegex.ReplaceAllStringFunc(tmpl.body, func(placeholder string) string {
+			name := placeholderRegex.FindStringSubmatch(placeholder)[1]
+			if templateKeywords[name] {
+				return placeholder
+			}
+			return escapeDataValue(args[name])
+		})
+		instance, err := parseTableDefinition(body, sheet, baseDir, tmpl.name)
+		if err != nil {
+			return "", fmt.Errorf("instance '%s': %w", id, err)
+		}
+		instance.ID = id
+		allTables.Tables[id] = instance
+		depth[id] = parentDepth + 1
+		queue = append(queue, id)
+		return id, nil
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		t := allTables.Tables[id]
+		for r := range t.Rows {
+			for c := range t.Rows[r].Cells {
+				cell := &t.Rows[r].Cells[c]
+				refs := []*string{&cell.TableRefID}
+				for i := range cell.Blocks {
+					refs = append(refs, &cell.Blocks[i].TableRefID)
+				}
+				for _, ref := range refs {
+					if *ref == "" {
+						continue
+					}
+					instanceID, err := instantiate(*ref, depth[id])
+					if err != nil {
+						return fmt.Errorf("table '%s', cell [%d,%d]: %w", id, r, c, err)
+					}
+					*ref = instanceID
+				}
+			}
+		}
+	}
+	return nil
+}
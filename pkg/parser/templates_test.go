@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTableTemplates(t *testing.T) {
+	input := strings.Join([]string{
+		"table: [users]",
+		`Alice | ::table=contact_card(name="Alice", email="a@x")::`,
+		`Bob | ::table=contact_card(email=b@x, name=Bob):: ::inner_align=center::`,
+		`Again | Same card: ::table=contact_card(name="Alice", email="a@x")::`,
+		"",
+		"template: [contact_card](name, email) Contact of {{name}} {bg_table:#F0F8FF}",
+		"Name | {{name}}",
+		"Email | {{ email }} ::table=badge::",
+		"",
+		"template: [badge]() {bg_cell:#FFF4CE}",
+		"★",
+	}, "\n")
+	all, err := ParseAllText(input)
+	if err != nil {
+		t.Fatalf("ParseAllText() error = %v", err)
+	}
+	if all.MainTableID != "users" {
+		t.Errorf("MainTableID = %q, templates must not become the main table", all.MainTableID)
+	}
+
+	alice, bob := `contact_card(name="Alice", email="a@x")`, `contact_card(name="Bob", email="b@x")`
+	rows := all.Tables["users"].Rows
+	if rows[0].Cells[1].TableRefID != alice || rows[1].Cells[1].TableRefID != bob || rows[1].Cells[1].InnerTableAlignment != "center" {
+		t.Errorf("references not resolved to instances: %+v, %+v", rows[0].Cells[1], rows[1].Cells[1])
+	}
+	if blocks := rows[2].Cells[1].Blocks; len(blocks) != 2 || blocks[1].TableRefID != alice {
+		t.Errorf("block reference not resolved: %+v", blocks)
+	}
+	// Identical arguments share one instance: users, two cards and the badge.
+	if len(all.Tables) != 4 {
+		t.Errorf("got %d tables, want 4", len(all.Tables))
+	}
+
+	card, ok := all.Tables[bob]
+	if !ok {
+		t.Fatalf("instance %s missing", bob)
+	}
+	if card.ID != bob || card.Title != "Contact of Bob" || card.Settings.TableBackgroundColor != "#F0F8FF" {
+		t.Errorf("instance header: ID %q, title %q, settings %+v", card.ID, card.Title, card.Settings)
+	}
+	if got := card.Rows[1].Cells[1]; len(got.Blocks) != 2 || got.Blocks[0].Text != "b@x" || got.Blocks[1].TableRefID != "badge()" {
+		t.Errorf("instance cell = %+v", got)
+	}
+	if badge := all.Tables["badge()"]; len(badge.Rows) != 1 || badge.Settings.DefaultCellBackgroundColor != "#FFF4CE" {
+		t.Errorf("parameterless instance = %+v", badge)
+	}
+}
+
+func TestTableTemplateErrors(t *testing.T) {
+	const card = "template: [card](name) {{name}}\n{{name}}\n"
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing argument", "table: [t]\n::table=card()::\n" + card, "missing parameter 'name'"},
+		{"unknown argument", "table: [t]\n::table=card(name=a, age=3)::\n" + card, "no parameter 'age'"},
+		{"repeated argument", "table: [t]\n::table=card(name=a, name=b)::\n" + card, "given twice"},
+		{"unknown template", "table: [t]\n::table=nope(name=a)::\n" + card, "unknown template 'nope'"},
+		{"undeclared placeholder", "table: [t]\na\ntemplate: [card](name)\n{{age}}", "undeclared parameter 'age'"},
+		{"duplicate template", "table: [t]\na\n" + card + card, "duplicate template 'card'"},
+		{"malformed template line", "table: [t]\na\ntemplate: [card] name\nx", "invalid template definition"},
+		{"template named like a table", "table: [card]\na\n" + card, "same name as a table"},
+		{"keyword parameter", "table: [t]\na\ntemplate: [card](end)\nx", "cannot name a parameter 'end'"},
+		{"recursive template", "table: [t]\n::table=r(n=x)::\ntemplate: [r](n)\n::table=r(n={{n}}x)::", "nested more than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAllText(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseAllText() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestTemplateArgumentsStayText checks that directives and settings inside argument values neither
+// style the outer cell nor the instance.
+func TestTemplateArgumentsStayText(t *testing.T) {
+	input := strings.Join([]string{
+		"table: [t]",
+		`::table=card(name="Bob {bg_table:#FF0000}")::`,
+		`::table=card(name="x ::colspan=3:: y")::`,
+		"template: [card](name) Card of {{name}}",
+		"{{name}}",
+	}, "\n")
+	all, err := ParseAllText(input)
+	if err != nil {
+		t.Fatalf("ParseAllText() error = %v", err)
+	}
+	rows := all.Tables["t"].Rows
+	for i, want := range []string{"Bob {bg_table:#FF0000}", "x ::colspan=3:: y"} {
+		outer := rows[i].Cells[0]
+		if outer.Colspan != 1 || !outer.IsTableRef {
+			t.Errorf("outer cell %d: colspan %d, table reference %t; want 1 and a reference", i, outer.Colspan, outer.IsTableRef)
+		}
+		instance := all.Tables[outer.TableRefID]
+		if instance.Settings.TableBackgroundColor != "" || instance.Title != "Card of "+want {
+			t.Errorf("instance %q: title %q, table background %q", outer.TableRefID, instance.Title, instance.Settings.TableBackgroundColor)
+		}
+		if got := instance.Rows[0].Cells[0]; got.Content != want || got.Colspan != 1 {
+			t.Errorf("instance %q cell = %+v, want text %q", outer.TableRefID, got, want)
+		}
+	}
+}
+
+// TestTemplateWithDataRows checks that a template can take its rows from a data file: the actions of
+// the row template, "{{end}}" included, are left to it, and parameters can be used in it.
+func TestTemplateWithDataRows(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "services.json"), []byte(`[{"name": "auth", "status": "up"}, {"name": "db", "status": "down"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"table: [t]",
+		"::table=card(team=Core)::",
+		"template: [card](team) Services of {{team}}",
+		"rows_from: services.json",
+		`{{.name}} ({{team}}) | {{.status}} {{if eq .status "down"}}::class=error::{{end}}`,
+	}, "\n")
+	all, err := ParseAllTextWithOptions(input, Options{BaseDir: dir})
+	if err != nil {
+		t.Fatalf("ParseAllTextWithOptions() error = %v", err)
+	}
+	instance := all.Tables[all.Tables["t"].Rows[0].Cells[0].TableRefID]
+	if len(instance.Rows) != 2 || instance.Title != "Services of Core" {
+		t.Fatalf("instance = %+v, want 2 data rows", instance)
+	}
+	if up, down := instance.Rows[0].Cells[1], instance.Rows[1].Cells[1]; up.BackgroundColor != "" || down.BackgroundColor != "#FDE7E9" || down.Content != "down" {
+		t.Errorf("status cells = %+v and %+v, want only the second styled as an error", up, down)
+	}
+	if got := instance.Rows[1].Cells[0].Content; got != "db (Core)" {
+		t.Errorf("name cell = %q, want %q", got, "db (Core)")
+	}
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	letLineRegex     = regexp.MustCompile(`^let\s+([A-Za-z_]\w*)\s*=\s*([^|]*)$`)
+	variableRefRegex = regexp.MustCompile(`\$\$|\$([A-Za-z_]\w*)`)
+	// variableSpanRegex matches the parts of a line where variables are substituted: settings, column
+	// and color blocks ("{bg_table:$brand}", "{bg:$brand}") and directives ("::fixed_width=$w::"). Both
+	// start with a key, so braces and colons in cell text ("{$price}", "::$x::") are left alone.
+	variableSpanRegex = regexp.MustCompile(`\{\s*[\w\-]+\s*:[^{}]*\}|::[\w\-]+=.*?::`)
+)
+
+// collectVariables reads the `let name = value` lines of a document, blanking them like style
+// lines, and substitutes the variables in the settings blocks and directives of all lines. A value
+// may use the variables defined above it. overrides (from the command line) replace the value of a
+// `let` of the same name, or define a variable of their own.
+func collectVariables(lines []string, overrides map[string]string) error {
+	variables := make(map[string]string, len(overrides))
+	for name, value := range overrides {
+		variables[name] = value
+	}
+	defined := make(map[string]bool)
+	for i, line := range lines {
+		matches := letLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if len(matches) != 3 {
+			continue
+		}
+		name := matches[1]
+		if defined[name] {
+			return fmt.Errorf("line %d: variable '%s' is already defined", i+1, name)
+		}
+		defined[name] = true
+		lines[i] = ""
+		if _, overridden := overrides[name]; overridden {
+			continue
+		}
+		value, err := substituteVariables(strings.TrimSpace(matches[2]), variables)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		variables[name] = value
+	}
+	for i, line := range lines {
+		var err error
+		lines[i] = variableSpanRegex.ReplaceAllStringFunc(line, func(span string) string {
+			substituted, spanErr := substituteVariables(span, variables)
+			if spanErr != nil && err == nil {
+				err = spanErr
+			}
+			return substituted
+		})
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// substituteVariables replaces the `$name` references in s by their values; `$$` stands for a
+// literal dollar sign.
+func substituteVariables(s string, variables map[string]string) (string, error) {
+	var err error
+	result := variableRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		value, ok := variables[ref[1:]]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable '%s'", ref)
+		}
+		return value
+	})
+	return result, err
+}
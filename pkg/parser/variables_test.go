@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVariables(t *testing.T) {
+	input := strings.Join([]string{
+		"let brand = #336699",
+		"let w = 120",
+		"let accent = $brand",
+		"style: [brand] {bg:$brand}",
+		"table: [main] Costs $5 {bg_table:$brand}",
+		"columns: {width:$w} |",
+		"Brand {bg:$accent} ::fixed_width=$w:: | Costs $5, $$ kept ::class=brand::",
+		"{$price} and ::$x:: | {{$item := .name}} {bg:$$1}",
+		"let late = 7",
+	}, "\n")
+	all, err := ParseAllTextWithOptions(input, Options{Variables: map[string]string{"w": "90", "extra": "1"}})
+	if err != nil {
+		t.Fatalf("ParseAllTextWithOptions() error = %v", err)
+	}
+	main := all.Tables["main"]
+	if main.Title != "Costs $5" || main.Settings.TableBackgroundColor != "#336699" {
+		t.Errorf("table header: title %q, bg_table %q", main.Title, main.Settings.TableBackgroundColor)
+	}
+	// The override wins over the document's let.
+	if main.Columns[0].Width != 90 {
+		t.Errorf("columns: width = %v, want the overridden 90", main.Columns[0].Width)
+	}
+	cells := main.Rows[0].Cells
+	if cells[0].BackgroundColor != "#336699" || cells[0].FixedWidth != 90 {
+		t.Errorf("cell directives: got %+v", cells[0])
+	}
+	// Text outside of directives and settings blocks is left alone.
+	if cells[1].Content != "Costs $5, $$ kept" || cells[1].BackgroundColor != "#336699" {
+		t.Errorf("plain text or style with a variable: got %+v", cells[1])
+	}
+	// Braces and colons in cell text are not settings or directives, so their variables stay as written.
+	cells = main.Rows[1].Cells
+	if cells[0].Content != "{$price} and ::$x::" || cells[1].Content != "{{$item := .name}}" || cells[1].BackgroundColor != "$1" {
+		t.Errorf("text with $ in braces: got %q, %q (bg %q)", cells[0].Content, cells[1].Content, cells[1].BackgroundColor)
+	}
+	if len(main.Rows) != 2 {
+		t.Errorf("let lines must not be parsed as rows, got %d rows", len(main.Rows))
+	}
+}
+
+func TestVariableErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"undefined in cell", "table: [t]\na {bg:$nope}", "line 2: undefined variable '$nope'"},
+		{"undefined in settings", "table: [t] {edge_color:$nope}\na", "line 1: undefined variable '$nope'"},
+		{"used before defined", "let a = $b\nlet b = 1\ntable: [t]\na", "line 1: undefined variable '$b'"},
+		{"defined twice", "let a = 1\nlet a = 2\ntable: [t]\na", "line 2: variable 'a' is already defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAllText(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseAllText() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
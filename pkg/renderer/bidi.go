@@ -0,0 +1,288 @@
+package renderer
+
+import "unicode"
+
+// Bidirectional character types of UAX #9 (Unicode Bidirectional Algorithm). Explicit embeddings,
+// overrides and isolates are not supported; their control characters are treated as BN and dropped.
+const (
+	bidiL   = iota // Left-to-right letters.
+	bidiR          // Hebrew and other right-to-left letters.
+	bidiAL         // Arabic letters.
+	bidiEN         // European digits.
+	bidiES         // European separators: "+" and "-".
+	bidiET         // European terminators: "#", "$", "%", currency signs, ...
+	bidiAN         // Arabic-Indic digits.
+	bidiCS         // Common separators: ",", ".", "/", ":" and the no-break space.
+	bidiNSM        // Non-spacing marks, e.g. Arabic vowel signs.
+	bidiBN         // Boundary neutrals: format and control characters.
+	bidiS          // Segment separators: tab.
+	bidiWS         // Whitespace.
+	bidiON         // Other neutrals: punctuation and symbols.
+)
+
+// bidiClass returns the bidirectional character type of r.
+func bidiClass(r rune) int {
+	switch {
+	case r == '\t':
+		return bidiS
+	case r == '\u200e':
+		return bidiL // Left-to-right mark.
+	case r == '\u200f':
+		return bidiR // Right-to-left mark.
+	case r == softHyphen, r >= '\u200b' && r <= '\u200d', r == '\ufeff', r >= '\u202a' && r <= '\u202e',
+		r >= '\u2066' && r <= '\u2069', r < ' ', r == 0x7f:
+		return bidiBN
+	case r >= '0' && r <= '9', r >= '\u06f0' && r <= '\u06f9':
+		return bidiEN
+	case r >= '\u0660' && r <= '\u0669', r == '\u066b', r == '\u066c', r >= '\u0600' && r <= '\u0605':
+		return bidiAN
+	case r == '+', r == '-', r == '\u2212':
+		return bidiES
+	case r == '#', r == '%', r == '\u00b0', r == '\u2030', r == '\u066a', unicode.Is(unicode.Sc, r):
+		return bidiET
+	case r == ',', r == '.', r == '/', r == ':', r == '\u00a0', r == '\u060c':
+		return bidiCS
+	case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r):
+		return bidiNSM
+	case unicode.IsSpace(r):
+		return bidiWS
+	case r >= '\u0590' && r <= '\u05ff', r >= '\u07c0' && r <= '\u085f', r >= '\ufb1d' && r <= '\ufb4f':
+		return bidiR
+	case r >= '\u0600' && r <= '\u07bf', r >= '\u0860' && r <= '\u08ff', r >= '\ufb50' && r <= '\ufdff', r >= '\ufe70' && r <= '\ufefe':
+		return bidiAL
+	case unicode.IsLetter(r), unicode.IsDigit(r), unicode.Is(unicode.Mc, r):
+		return bidiL
+	}
+	return bidiON
+}
+
+// isRTLClass reports whether a character type is strong right-to-left.
+func isRTLClass(class int) bool { return class == bidiR || class == bidiAL }
+
+// paragraphIsRTL reports the base direction of a paragraph following rules P2 and P3 of UAX #9: the
+// direction of its first strong character, or fallback when it has none.
+func paragraphIsRTL(paragraph string, fallback bool) bool {
+	for _, r := range paragraph {
+		switch class := bidiClass(r); {
+		case class == bidiL:
+			return false
+		case isRTLClass(class):
+			return true
+		}
+	}
+	return fallback
+}
+
+// bidiLevels resolves the embedding level of each character of a line (rules W1-W7, N1-N2 and
+// I1-I2 of UAX #9) in a paragraph of the given base direction. Even levels are left-to-right and odd
+// levels right-to-left. Characters of type BN get level -1.
+func bidiLevels(runes []rune, rtl bool) []int {
+	base, sos := 0, bidiL
+	if rtl {
+		base, sos = 1, bidiR
+	}
+	classes := make([]int, len(runes))
+	var idx []int // Indexes of the characters that take part in resolution (X9 removes BN).
+	for i, r := range runes {
+		classes[i] = bidiClass(r)
+		if classes[i] != bidiBN {
+			idx = append(idx, i)
+		}
+	}
+	at := func(k int) int { return classes[idx[k]] }
+	set := func(k, class int) { classes[idx[k]] = class }
+
+	// W1: a non-spacing mark takes the type of the character before it.
+	for k := range idx {
+		if at(k) == bidiNSM {
+			if k == 0 {
+				set(k, sos)
+			} else {
+				set(k, at(k-1))
+			}
+		}
+	}
+	// W2 and W3: European digits after Arabic letters become Arabic digits; Arabic letters become R.
+	lastStrong := sos
+	for k := range idx {
+		switch at(k) {
+		case bidiL, bidiR, bidiAL:
+			lastStrong = at(k)
+		case bidiEN:
+			if lastStrong == bidiAL {
+				set(k, bidiAN)
+			}
+		}
+	}
+	for k := range idx {
+		if at(k) == bidiAL {
+			set(k, bidiR)
+		}
+	}
+	// W4: a single separator between two numbers of the same type joins them.
+	for k := 1; k+1 < len(idx); k++ {
+		prev, next := at(k-1), at(k+1)
+		switch {
+		case at(k) == bidiES && prev == bidiEN && next == bidiEN:
+			set(k, bidiEN)
+		case at(k) == bidiCS && prev == next && (prev == bidiEN || prev == bidiAN):
+			set(k, prev)
+		}
+	}
+	// W5: terminators next to European digits become European digits.
+	for k := 0; k < len(idx); k++ {
+		if at(k) != bidiET {
+			continue
+		}
+		end := k
+		for end < len(idx) && at(end) == bidiET {
+			end++
+		}
+		if (k > 0 && at(k-1) == bidiEN) || (end < len(idx) && at(end) == bidiEN) {
+			for j := k; j < end; j++ {
+				set(j, bidiEN)
+			}
+		}
+		k = end - 1
+	}
+	// W6 and W7: remaining separators and terminators are neutral; European digits after L are L.
+	lastStrong = sos
+	for k := range idx {
+		switch at(k) {
+		case bidiES, bidiET, bidiCS:
+			set(k, bidiON)
+		case bidiL, bidiR:
+			lastStrong = at(k)
+		case bidiEN:
+			if lastStrong == bidiL {
+				set(k, bidiL)
+			}
+		}
+	}
+	// N1 and N2: neutrals between characters of the same direction take it, others the base direction.
+	strongDir := func(class int) int {
+		if class == bidiL {
+			return bidiL
+		}
+		return bidiR // R, EN and AN count as right-to-left here.
+	}
+	neutral := func(class int) bool { return class == bidiWS || class == bidiS || class == bidiON }
+	for k := 0; k < len(idx); k++ {
+		if !neutral(at(k)) {
+			continue
+		}
+		end := k
+		for end < len(idx) && neutral(at(end)) {
+			end++
+		}
+		before, after := sos, sos
+		if k > 0 {
+			before = strongDir(at(k - 1))
+		}
+		if end < len(idx) {
+			after = strongDir(at(end))
+		}
+		dir := sos
+		if before == after {
+			dir = before
+		}
+		for j := k; j < end; j++ {
+			set(j, dir)
+		}
+		k = end - 1
+	}
+	// I1 and I2: raise the levels of characters against the base direction.
+	levels := make([]int, len(runes))
+	for i := range levels {
+		levels[i] = -1
+	}
+	for _, i := range idx {
+		switch class := classes[i]; {
+		case base == 0 && class == bidiR:
+			levels[i] = 1
+		case base == 0 && (class == bidiAN || class == bidiEN):
+			levels[i] = 2
+		case base == 1 && (class == bidiL || class == bidiEN || class == bidiAN):
+			levels[i] = 2
+		default:
+			levels[i] = base
+		}
+	}
+	// L1: tabs and trailing whitespace go back to the base level.
+	trailing := true
+	for k := len(idx) - 1; k >= 0; k-- {
+		r := runes[idx[k]]
+		switch {
+		case r == '\t':
+			levels[idx[k]], trailing = base, true
+		case trailing && unicode.IsSpace(r):
+			levels[idx[k]] = base
+		default:
+			trailing = false
+		}
+	}
+	return levels
+}
+
+// bidiMirrors maps characters to their mirrored glyph, used for right-to-left runs (rule L4).
+var bidiMirrors = map[rune]rune{
+	'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<',
+	'«': '»', '»': '«', '‹': '›', '›': '‹', '≤': '≥', '≥': '≤',
+}
+
+// visualOrder returns a wrapped line in display order (rules L1-L4 of UAX #9): right-to-left runs
+// are reversed and their brackets mirrored, so the result can be drawn left to right. Boundary
+// neutrals such as zero-width joiners are dropped.
+func visualOrder(line string, rtl bool) string {
+	runes := []rune(line)
+	simple := !rtl
+	for _, r := range runes {
+		if class := bidiClass(r); isRTLClass(class) || class == bidiAN || class == bidiBN {
+			simple = false
+			break
+		}
+	}
+	if simple {
+		return line
+	}
+	levels := bidiLevels(runes, rtl)
+	var kept []rune
+	var keptLevels []int
+	maxLevel, minOdd := 0, 3
+	for i, r := range runes {
+		if levels[i] < 0 {
+			continue
+		}
+		if levels[i]%2 == 1 {
+			if m, ok := bidiMirrors[r]; ok {
+				r = m
+			}
+			if levels[i] < minOdd {
+				minOdd = levels[i]
+			}
+		}
+		if levels[i] > maxLevel {
+			maxLevel = levels[i]
+		}
+		kept = append(kept, r)
+		keptLevels = append(keptLevels, levels[i])
+	}
+	// L2: from the highest level down to the lowest odd one, reverse every run at that level or above.
+	for level := maxLevel; level >= minOdd; level-- {
+		for i := 0; i < len(kept); i++ {
+			if keptLevels[i] < level {
+				continue
+			}
+			j := i
+			for j < len(kept) && keptLevels[j] >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				kept[a], kept[b] = kept[b], kept[a]
+				keptLevels[a], keptLevels[b] = keptLevels[b], keptLevels[a]
+			}
+			i = j
+		}
+	}
+	return string(kept)
+}
@@ -0,0 +1,49 @@
+package renderer
+
+import "testing"
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		rtl  bool
+		want string
+	}{
+		{"latin is untouched", "abc def", false, "abc def"},
+		{"hebrew word", "שלום", true, "םולש"},
+		{"hebrew inside latin", "The word שלום means peace.", false, "The word םולש means peace."},
+		{"numbers keep their order", "שלום 123", true, "123 םולש"},
+		{"brackets are mirrored", "(גרסה 2)", true, "(2 הסרג)"},
+		{"digits after arabic letters", "عدد 12", true, "12 ددع"},
+		{"latin run in a right-to-left paragraph", "price: $5.00", true, "price: $5.00"},
+		{"trailing punctuation follows the paragraph", "Hello.", true, ".Hello"},
+		{"two runs in a left-to-right paragraph", "a אב b גד", false, "a בא b דג"},
+		{"zero-width joiners are dropped", "a\u200db", false, "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visualOrder(tt.line, tt.rtl); got != tt.want {
+				t.Errorf("visualOrder(%q, %t) = %q, want %q", tt.line, tt.rtl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParagraphIsRTL(t *testing.T) {
+	tests := []struct {
+		paragraph string
+		fallback  bool
+		want      bool
+	}{
+		{"hello שלום", false, false},
+		{"123 שלום", false, true},
+		{"مرحبا world", false, true},
+		{"123 + 4", true, true},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := paragraphIsRTL(tt.paragraph, tt.fallback); got != tt.want {
+			t.Errorf("paragraphIsRTL(%q, %t) = %t, want %t", tt.paragraph, tt.fallback, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"log"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// blockGapEm is the default space between the blocks of a cell, in multiples of the font size.
+const blockGapEm = 0.5
+
+// blockGap returns the space between the blocks of a cell: its stack_gap, else half a line.
+func blockGap(cell *table.Cell, fontSize float64) float64 {
+	if cell.StackGap != nil {
+		return *cell.StackGap
+	}
+	return fontSize * blockGapEm
+}
+
+// blockCells returns a cell for each block of a cell with blocks (see table.Cell.Blocks), to be
+// measured and drawn like a cell of its own with the cell's styling, and the alignment and scale mode
+// of its table if it has its own. The title goes with the first block when it is text, else before
+// it; an image set by a style class comes first.
+func blockCells(cell *table.Cell) []table.Cell {
+	base := *cell
+	base.Title, base.Content, base.Blocks = "", "", nil
+	base.IsTableRef, base.TableRefID, base.Image, base.Icon = false, "", "", ""
+	base.FixedWidth, base.FixedHeight = 0, 0
+	blocks := cell.Blocks
+	if cell.HasImage() {
+		blocks = append([]table.CellBlock{{Image: cell.Image, Icon: cell.Icon}}, blocks...)
+	}
+	cells := make([]table.Cell, 0, len(blocks)+1)
+	if cell.Title != "" && blocks[0].Text == "" {
+		title := base
+		title.Title = cell.Title
+		cells = append(cells, title)
+	}
+	for i, b := range blocks {
+		c := base
+		c.Content, c.Image, c.Icon = b.Text, b.Image, b.Icon
+		c.IsTableRef, c.TableRefID = b.TableRefID != "", b.TableRefID
+		if b.InnerTableAlignment != "" {
+			c.InnerTableAlignment = b.InnerTableAlignment
+		}
+		if b.InnerTableScaleMode != "" {
+			c.InnerTableScaleMode = b.InnerTableScaleMode
+		}
+		if i == 0 && b.Text != "" {
+			c.Title = cell.Title
+		}
+		cells = append(cells, c)
+	}
+	return cells
+}
+
+// isTextBlock reports whether a block cell from blockCells holds text rather than a table or image.
+func isTextBlock(c *table.Cell) bool { return !c.IsTableRef && !c.HasImage() }
+
+// layoutBlocks places the blocks of a cell in a content area width wide. Stacked vertically, each
+// block is measured against the whole width; side by side, nested tables and images take their own
+// width and the text blocks share what is left. It returns the block cells, their rectangles, and the
+// size of the whole stack.
+func layoutBlocks(dc *gg.Context, cell *table.Cell, width float64, allTables map[string]table.Table, consts LayoutConstants) (cells []table.Cell, rects []contentRect, w, h float64, err error) {
+	cells = blockCells(cell)
+	rects = make([]contentRect, len(cells))
+	gap := blockGap(cell, consts.FontSize)
+	measure := func(i int, available float64) {
+		bw, bh, errBlock := calculateCellContentSizeInternal(dc, &cells[i], consts.FontSize, consts.LineHeightMultiplier, 0, available, allTables, consts)
+		if errBlock != nil && err == nil {
+			err = errBlock
+		}
+		rects[i].W, rects[i].H = bw, bh
+	}
+	if cell.Stack != "horizontal" {
+		for i := range cells {
+			measure(i, width)
+			rects[i].Y = h
+			h += rects[i].H + gap
+			w = math.Max(w, rects[i].W)
+		}
+		return cells, rects, w, h - gap, err
+	}
+	textBlocks, rest := 0, width-gap*float64(len(cells)-1)
+	for i := range cells {
+		if isTextBlock(&cells[i]) {
+			textBlocks++
+			continue
+		}
+		measure(i, width)
+		rest -= rects[i].W
+	}
+	for i := range cells {
+		if isTextBlock(&cells[i]) {
+			measure(i, math.Max(rest, 0)/float64(textBlocks))
+		}
+		rects[i].X = w
+		w += rects[i].W + gap
+		h = math.Max(h, rects[i].H)
+	}
+	return cells, rects, w - gap, h, err
+}
+
+// minBlocksWidth returns the narrowest content width of a cell with blocks: that of its widest block
+// when they are stacked vertically, of all of them side by side otherwise. Nested tables that are not
+// re-laid out to the cell's width keep their natural width, as in cells of their own.
+func minBlocksWidth(dc *gg.Context, cell *table.Cell, allTables map[string]table.Table, consts LayoutConstants) float64 {
+	cells := blockCells(cell)
+	total, widest := blockGap(cell, consts.FontSize)*float64(len(cells)-1), 0.0
+	for i := range cells {
+		c := &cells[i]
+		var bw float64
+		switch {
+		case c.IsTableRef || c.IsSideways():
+			available := 10000.0
+			if c.IsTableRef && reflowsInnerTable(c) {
+				available = 0
+			}
+			bw, _, _ = calculateCellContentSizeInternal(dc, c, consts.FontSize, consts.LineHeightMultiplier, 0, available, allTables, consts)
+		case c.HasImage():
+			bw = minImageCellWidth(dc, c, consts.FontSize, consts.Wrap)
+		default:
+			bw = minContentWidth(dc, c, consts.Wrap)
+		}
+		total += bw
+		widest = math.Max(widest, bw)
+	}
+	if cell.Stack == "horizontal" {
+		return total
+	}
+	return widest
+}
+
+// drawBlocks draws the blocks of a cell into the w x h content area at dc's origin, each like a cell
+// of its own: stacked vertically a block spans the width of the area, side by side its height.
+func (cd cellDrawing) drawBlocks(dc *gg.Context, cell *table.Cell, w, h float64) {
+	cells, rects, _, _, err := layoutBlocks(dc, cell, w, cd.allTables, cd.consts)
+	if err != nil {
+		log.Printf("CELL [%d,%d]: Error measuring blocks: %v", cd.r, cd.c, err)
+	}
+	log.Printf("CELL [%d,%d]: Blocks: drawing %d, stacked '%s'.", cd.r, cd.c, len(cells), firstNonEmpty(cell.Stack, "vertical"))
+	for i := range cells {
+		area := rects[i]
+		if cell.Stack == "horizontal" {
+			area.H = h
+		} else {
+			area.W = w
+		}
+		if area.X >= w || area.Y >= h {
+			break // Blocks past the content area are clipped.
+		}
+		dc.Push()
+		dc.Translate(area.X, area.Y)
+		if cells[i].IsTableRef {
+			cd.drawNestedTable(dc, &cells[i], area.W, area.H)
+		} else {
+			cd.drawText(dc, &cells[i], area.W, area.H)
+		}
+		dc.Pop()
+	}
+}
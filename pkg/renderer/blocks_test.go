@@ -0,0 +1,91 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestBlockCells(t *testing.T) {
+	cell := table.NewCell("Title", "")
+	cell.Blocks = []table.CellBlock{{TableRefID: "inner"}, {Text: "caption"}}
+	cell.Icon = "info" // From a style class.
+	cell.FixedWidth = 300
+	cells := blockCells(&cell)
+	if len(cells) != 4 {
+		t.Fatalf("blockCells() returned %d cells, want a title, the icon, the table and the caption", len(cells))
+	}
+	if cells[0].Title != "Title" || cells[1].Icon != "info" || !cells[2].IsTableRef || cells[2].TableRefID != "inner" || cells[3].Content != "caption" || cells[3].Title != "" {
+		t.Errorf("blockCells() = %+v", cells)
+	}
+	for _, c := range cells {
+		if c.FixedWidth != 0 || c.HasBlocks() {
+			t.Errorf("block cell %+v keeps the cell's fixed width or blocks", c)
+		}
+	}
+
+	// Tables take their own alignment and scale mode over the cell's.
+	cell.Blocks = []table.CellBlock{{TableRefID: "a", InnerTableScaleMode: "fit_both"}, {TableRefID: "b", InnerTableAlignment: "center"}}
+	cell.InnerTableAlignment, cell.InnerTableScaleMode = "bottom_left", "none"
+	if cells := blockCells(&cell); cells[2].InnerTableScaleMode != "fit_both" || cells[2].InnerTableAlignment != "bottom_left" || cells[3].InnerTableScaleMode != "none" || cells[3].InnerTableAlignment != "center" {
+		t.Errorf("blockCells() with table options = %+v", cells[2:])
+	}
+
+	// A title goes with a leading text block.
+	cell.Icon = ""
+	cell.Blocks = []table.CellBlock{{Text: "intro"}, {TableRefID: "inner"}}
+	if cells := blockCells(&cell); len(cells) != 2 || cells[0].Title != "Title" || cells[0].Content != "intro" {
+		t.Errorf("blockCells() with leading text = %+v", cells)
+	}
+}
+
+func TestLayoutBlocks(t *testing.T) {
+	dc := gg.NewContext(1, 1)
+	if err := dc.LoadFontFace(testFontPath, 12); err != nil {
+		t.Fatalf("loading bundled font: %v", err)
+	}
+	consts := LayoutConstants{FontPath: testFontPath, FontSize: 12, LineHeightMultiplier: 1.4, Padding: 8, MinCellWidth: 10, MinCellHeight: 10}
+	inner := table.Table{ID: "inner", Settings: table.DefaultGlobalSettings(), Rows: []table.Row{{Cells: []table.Cell{newLayoutTestCell("", "x", 1, 1)}}}}
+	allTables := map[string]table.Table{"inner": inner}
+	innerLg, _, err := layoutInnerTable(&inner, consts, allTables, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableW, tableH := innerLg.CanvasWidth, innerLg.CanvasHeight
+	captionW, _ := dc.MeasureString("caption")
+	lineH, gap := 12*1.4, 12*blockGapEm
+
+	cell := newLayoutTestCell("", "", 1, 1)
+	cell.Blocks = []table.CellBlock{{TableRefID: "inner"}, {Text: "caption"}}
+	_, rects, w, h, err := layoutBlocks(dc, &cell, 1000, allTables, consts)
+	widest := math.Max(tableW, captionW)
+	if err != nil || !floatEquals(w, widest, 0.01) || !floatEquals(h, tableH+gap+lineH, 0.01) || !floatEquals(rects[1].Y, tableH+gap, 0.01) {
+		t.Errorf("vertical: got %.1fx%.1f, caption at %+v (err %v), want %.1fx%.1f", w, h, rects[1], err, widest, tableH+gap+lineH)
+	}
+	if got := minBlocksWidth(dc, &cell, allTables, consts); !floatEquals(got, widest, 0.01) {
+		t.Errorf("vertical minBlocksWidth() = %.1f, want %.1f", got, widest)
+	}
+
+	cell.Stack = "horizontal"
+	_, rects, w, h, err = layoutBlocks(dc, &cell, 1000, allTables, consts)
+	if err != nil || !floatEquals(w, tableW+gap+captionW, 0.01) || !floatEquals(h, tableH, 0.01) || !floatEquals(rects[1].X, tableW+gap, 0.01) {
+		t.Errorf("horizontal: got %.1fx%.1f, caption at %+v (err %v), want %.1fx%.1f", w, h, rects[1], err, tableW+gap+captionW, tableH)
+	}
+	if got := minBlocksWidth(dc, &cell, allTables, consts); !floatEquals(got, tableW+gap+captionW, 0.01) {
+		t.Errorf("horizontal minBlocksWidth() = %.1f, want %.1f", got, tableW+gap+captionW)
+	}
+
+	// stack_gap replaces the default gap.
+	cell.StackGap = new(float64)
+	if _, _, w, _, _ := layoutBlocks(dc, &cell, 1000, allTables, consts); !floatEquals(w, tableW+captionW, 0.01) {
+		t.Errorf("horizontal without a gap: got width %.1f, want %.1f", w, tableW+captionW)
+	}
+	cell.StackGap = nil
+
+	// The cell is measured through calculateCellContentSizeInternal like any other.
+	if cw, ch, err := calculateCellContentSizeInternal(dc, &cell, 12, 1.4, 8, 1016, allTables, consts); err != nil || cw != w || ch != h {
+		t.Errorf("calculateCellContentSizeInternal() = %.1fx%.1f (err %v), want %.1fx%.1f", cw, ch, err, w, h)
+	}
+}
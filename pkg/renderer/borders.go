@@ -0,0 +1,228 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"log"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// borderSegment is a straight run of a resolved border edge in canvas coordinates.
+type borderSegment struct {
+	X1, Y1, X2, Y2 float64
+	Spec           table.BorderSpec // Fully resolved: Width, Style and Color are all set.
+}
+
+// borderStylePriority ranks styles for the collapse rule; higher wins.
+var borderStylePriority = map[string]int{"none": 0, "dotted": 1, "dashed": 2, "solid": 3}
+
+// effectiveBorder fills the unset parts of a cell side spec from the table settings.
+func effectiveBorder(spec table.BorderSpec, settings table.GlobalSettings) table.BorderSpec {
+	if spec.Style == "" {
+		spec.Style = "solid"
+	}
+	if spec.Width <= 0 {
+		spec.Width = float64(settings.EdgeThickness)
+		if spec.Width <= 0 {
+			spec.Width = 1.0
+		}
+	}
+	if spec.Color == "" {
+		spec.Color = settings.EdgeColor
+		if spec.Color == "" {
+			spec.Color = "#000000"
+		}
+	}
+	return spec
+}
+
+// collapseBorders resolves the border for an edge shared by two cells whose sides are both set on
+// the cells or both inherited from the table (a set side wins over an inherited one, see
+// resolveBorderSegments). first is the side of the cell above/left of the edge, second the side of
+// the cell below/right. A visible border beats "none", then the wider border wins, then the
+// stronger style (solid > dashed > dotted). Remaining ties go to first, so the result is deterministic.
+func collapseBorders(first, second table.BorderSpec) table.BorderSpec {
+	firstVisible, secondVisible := first.Style != "none", second.Style != "none"
+	if firstVisible != secondVisible {
+		if firstVisible {
+			return first
+		}
+		return second
+	}
+	if math.Abs(first.Width-second.Width) > epsilon {
+		if first.Width > second.Width {
+			return first
+		}
+		return second
+	}
+	if borderStylePriority[second.Style] > borderStylePriority[first.Style] {
+		return second
+	}
+	return first
+}
+
+// squareCells returns the cells of a separate-mode table that are drawn square, with their edges
+// stroked by resolveBorderSegments: the cells with border overrides and, when cells touch, their
+// neighbours, whose own rounded boxes would otherwise stroke the shared edge again.
+func squareCells(lg *LayoutGrid) map[*table.Cell]bool {
+	square := make(map[*table.Cell]bool)
+	for r, row := range lg.OccupationMap {
+		for c, cell := range row {
+			if cell == nil || !cell.HasBorderOverride() {
+				continue
+			}
+			square[cell] = true
+			if lg.CellSpacing > 0 {
+				continue
+			}
+			for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				if nr, nc := r+d[0], c+d[1]; nr >= 0 && nc >= 0 && nr < len(lg.OccupationMap) && nc < len(lg.OccupationMap[nr]) && lg.OccupationMap[nr][nc] != nil {
+					square[lg.OccupationMap[nr][nc]] = true
+				}
+			}
+		}
+	}
+	return square
+}
+
+// resolveBorderSegments computes the border segments for every grid edge that touches at least one
+// cell accepted by include. Without cell spacing a shared edge is collapsed and emitted once, a
+// side set on a cell winning over the table default of its neighbour; with
+// spacing each cell strokes its own side along its own edge. Consecutive pieces with identical specs
+// are merged so dash patterns run continuously. When outerFrame is set, outer edges are skipped
+// unless the cell sets that side explicitly, since the caller draws the table frame itself. In
+// right-to-left tables the segments are mirrored like the cells. Requires CalculateFinalCellLayouts.
+func resolveBorderSegments(tbl *table.Table, lg *LayoutGrid, include func(*table.Cell) bool, outerFrame bool) []borderSegment {
+	if lg.NumLogicalRows == 0 || lg.NumLogicalCols == 0 || len(lg.ColumnX) != lg.NumLogicalCols+1 || len(lg.RowY) != lg.NumLogicalRows+1 {
+		return nil
+	}
+	cellAt := func(r, c int) *table.Cell {
+		if r < 0 || c < 0 || r >= lg.NumLogicalRows || c >= lg.NumLogicalCols {
+			return nil
+		}
+		return lg.OccupationMap[r][c]
+	}
+	included := func(c *table.Cell) bool { return c != nil && include(c) }
+	// trackEnd is where a cell ending before track i+1 stops: the gap after it is not part of the cell.
+	trackEnd := func(positions []float64, i int, continues bool) float64 {
+		if continues || i+1 == len(positions)-1 {
+			return positions[i+1]
+		}
+		return positions[i+1] - lg.CellSpacing
+	}
+
+	var segments []borderSegment
+	appendSegment := func(seg borderSegment) {
+		if seg.Spec.Style == "none" {
+			return
+		}
+		if lg.RTL {
+			seg.X1, seg.X2 = lg.mirrorX(seg.X1), lg.mirrorX(seg.X2)
+		}
+		if n := len(segments); n > 0 {
+			last := &segments[n-1]
+			sameLine := (last.Y1 == last.Y2 && seg.Y1 == seg.Y2) || (last.X1 == last.X2 && seg.X1 == seg.X2)
+			if sameLine && last.Spec == seg.Spec && last.X2 == seg.X1 && last.Y2 == seg.Y1 {
+				last.X2, last.Y2 = seg.X2, seg.Y2
+				return
+			}
+		}
+		segments = append(segments, seg)
+	}
+	// edge resolves one grid edge piece between first (above/left) and second (below/right) and
+	// hands each resolved spec to emit with its line offset and the track index of the owning cell.
+	edge := func(first, second *table.Cell, b, n int, positions []float64, firstSide, secondSide func(*table.Cell) table.BorderSpec, emit func(spec table.BorderSpec, pos float64, ownerIdx int)) {
+		if first == second || !(included(first) || included(second)) {
+			return
+		}
+		if outerFrame && (b == 0 || b == n) {
+			if b == 0 && second != nil && secondSide(second).IsSet() {
+				emit(effectiveBorder(secondSide(second), tbl.Settings), positions[b], b)
+			} else if b == n && first != nil && firstSide(first).IsSet() {
+				emit(effectiveBorder(firstSide(first), tbl.Settings), positions[b], b-1)
+			}
+			return
+		}
+		firstPos, secondPos := positions[b], positions[b]
+		if b > 0 && b < n {
+			firstPos -= lg.CellSpacing
+		}
+		switch {
+		case first == nil:
+			emit(effectiveBorder(secondSide(second), tbl.Settings), secondPos, b)
+		case second == nil:
+			emit(effectiveBorder(firstSide(first), tbl.Settings), firstPos, b-1)
+		case firstPos != secondPos:
+			if included(first) {
+				emit(effectiveBorder(firstSide(first), tbl.Settings), firstPos, b-1)
+			}
+			if included(second) {
+				emit(effectiveBorder(secondSide(second), tbl.Settings), secondPos, b)
+			}
+		case firstSide(first).IsSet() && !secondSide(second).IsSet():
+			emit(effectiveBorder(firstSide(first), tbl.Settings), firstPos, b-1)
+		case secondSide(second).IsSet() && !firstSide(first).IsSet():
+			emit(effectiveBorder(secondSide(second), tbl.Settings), secondPos, b)
+		default:
+			emit(collapseBorders(effectiveBorder(firstSide(first), tbl.Settings), effectiveBorder(secondSide(second), tbl.Settings)), firstPos, b-1)
+		}
+	}
+
+	bottom := func(c *table.Cell) table.BorderSpec { return c.BorderBottom }
+	top := func(c *table.Cell) table.BorderSpec { return c.BorderTop }
+	for b := 0; b <= lg.NumLogicalRows; b++ {
+		for c := 0; c < lg.NumLogicalCols; c++ {
+			edge(cellAt(b-1, c), cellAt(b, c), b, lg.NumLogicalRows, lg.RowY, bottom, top, func(spec table.BorderSpec, y float64, ownerRow int) {
+				x2 := trackEnd(lg.ColumnX, c, cellAt(ownerRow, c+1) == cellAt(ownerRow, c))
+				appendSegment(borderSegment{X1: lg.ColumnX[c], Y1: y, X2: x2, Y2: y, Spec: spec})
+			})
+		}
+	}
+	right := func(c *table.Cell) table.BorderSpec { return c.BorderRight }
+	left := func(c *table.Cell) table.BorderSpec { return c.BorderLeft }
+	if lg.RTL {
+		// Columns run right to left, so a cell's left border faces the next logical column.
+		right, left = left, right
+	}
+	for b := 0; b <= lg.NumLogicalCols; b++ {
+		for r := 0; r < lg.NumLogicalRows; r++ {
+			edge(cellAt(r, b-1), cellAt(r, b), b, lg.NumLogicalCols, lg.ColumnX, right, left, func(spec table.BorderSpec, x float64, ownerCol int) {
+				y2 := trackEnd(lg.RowY, r, cellAt(r+1, ownerCol) == cellAt(r, ownerCol))
+				appendSegment(borderSegment{X1: x, Y1: lg.RowY[r], X2: x, Y2: y2, Spec: spec})
+			})
+		}
+	}
+	return segments
+}
+
+// drawBorderSegments strokes resolved border segments, applying dash patterns for dashed/dotted styles.
+func drawBorderSegments(dc *gg.Context, segments []borderSegment) {
+	sx, sy := contextScale(dc) // Widths and dashes are in pixels, so they follow a scaled table by hand.
+	lineScale := math.Sqrt(sx * sy)
+	for _, seg := range segments {
+		col, err := parseColor(seg.Spec.Color)
+		if err != nil {
+			log.Printf("Error parsing border color '%s': %v. Using black.", seg.Spec.Color, err)
+			col, _ = parseColor("#000000")
+		}
+		dc.SetColor(col)
+		width := deviceLineWidth(seg.Spec.Width, lineScale)
+		dc.SetLineWidth(width)
+		switch seg.Spec.Style {
+		case "dashed":
+			dc.SetLineCapButt()
+			dc.SetDash(3*width+2, 2*width+2)
+		case "dotted":
+			dc.SetLineCapButt()
+			dc.SetDash(width, width+1)
+		default:
+			dc.SetLineCapSquare()
+			dc.SetDash()
+		}
+		dc.DrawLine(seg.X1, seg.Y1, seg.X2, seg.Y2)
+		dc.Stroke()
+	}
+	dc.SetDash()
+	dc.SetLineCapRound()
+}
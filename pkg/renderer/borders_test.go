@@ -0,0 +1,170 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"testing"
+)
+
+func TestCollapseBorders(t *testing.T) {
+	solid1 := table.BorderSpec{Width: 1, Style: "solid", Color: "#000000"}
+	solid2 := table.BorderSpec{Width: 2, Style: "solid", Color: "#FF0000"}
+	dashed1 := table.BorderSpec{Width: 1, Style: "dashed", Color: "#00FF00"}
+	none := table.BorderSpec{Width: 1, Style: "none", Color: "#000000"}
+
+	tests := []struct {
+		name          string
+		first, second table.BorderSpec
+		want          table.BorderSpec
+	}{
+		{"Visible beats none (first none)", none, dashed1, dashed1},
+		{"Visible beats none (second none)", dashed1, none, dashed1},
+		{"Wider wins", solid1, solid2, solid2},
+		{"Style priority solid over dashed", dashed1, solid1, solid1},
+		{"Exact tie goes to first", solid1, table.BorderSpec{Width: 1, Style: "solid", Color: "#0000FF"}, solid1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collapseBorders(tt.first, tt.second); got != tt.want {
+				t.Errorf("collapseBorders() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveBorderSegments(t *testing.T) {
+	header := newLayoutTestCell("", "Header", 2, 1)
+	header.BorderBottom = table.BorderSpec{Width: 2, Style: "solid", Color: "#000"}
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{
+			{Cells: []table.Cell{header}},
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+		},
+	}
+	lg, _ := PopulateOccupationMap(tbl)
+	lg.ColumnWidths = []float64{50, 70}
+	lg.RowHeights = []float64{30, 40}
+	lg.CalculateFinalCellLayouts(10)
+
+	segments := resolveBorderSegments(tbl, lg, func(c *table.Cell) bool { return c.HasBorderOverride() }, false)
+
+	// The header row's underline is shared with both lower cells; it must be emitted once, merged
+	// across the two columns, and win the collapse against the 1px default of the lower cells.
+	underlines := 0
+	for _, seg := range segments {
+		if seg.Y1 == 40 && seg.Y2 == 40 {
+			underlines++
+			if seg.X1 != 10 || seg.X2 != 130 {
+				t.Errorf("underline spans X %.1f-%.1f, want 10-130", seg.X1, seg.X2)
+			}
+			if seg.Spec.Width != 2 || seg.Spec.Color != "#000" {
+				t.Errorf("underline spec = %+v, want 2px #000", seg.Spec)
+			}
+		}
+		if seg.Y1 > 40 || seg.Y2 > 40 {
+			t.Errorf("unexpected segment %+v below the header; lower cells have no overrides", seg)
+		}
+	}
+	if underlines != 1 {
+		t.Errorf("expected exactly 1 underline segment, got %d (segments: %+v)", underlines, segments)
+	}
+	// Header top edge and its two sides are resolved from the table defaults.
+	if len(segments) != 4 {
+		t.Errorf("expected 4 segments (top, underline, left, right), got %d: %+v", len(segments), segments)
+	}
+}
+
+func TestResolveBorderSegments_SpacingAndFrame(t *testing.T) {
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+		},
+	}
+	all := func(c *table.Cell) bool { return true }
+
+	// With spacing, the inner edge is stroked twice: once on each cell's own side of the gap.
+	tbl.Settings.CellSpacing = 4
+	lg, _ := PopulateOccupationMap(tbl)
+	lg.ColumnWidths = []float64{50, 70}
+	lg.RowHeights = []float64{30}
+	lg.CalculateFinalCellLayouts(0)
+	var inner []float64
+	for _, seg := range resolveBorderSegments(tbl, lg, all, false) {
+		if seg.X1 == seg.X2 && seg.X1 > 0 && seg.X1 < lg.CanvasWidth {
+			inner = append(inner, seg.X1)
+		}
+	}
+	if len(inner) != 2 || inner[0] != 50 || inner[1] != 54 {
+		t.Errorf("expected inner vertical edges at 50 and 54, got %v", inner)
+	}
+
+	// With an outer frame only the shared edge is emitted, unless a cell sets an outer side itself.
+	tbl.Settings.CellSpacing = 0
+	tbl.Rows[0].Cells[1].BorderRight = table.BorderSpec{Style: "dashed"}
+	lg, _ = PopulateOccupationMap(tbl)
+	lg.ColumnWidths = []float64{50, 70}
+	lg.RowHeights = []float64{30}
+	lg.CalculateFinalCellLayouts(0)
+	segments := resolveBorderSegments(tbl, lg, all, true)
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments (shared edge, explicit right side), got %d: %+v", len(segments), segments)
+	}
+	if segments[0].X1 != 50 || segments[1].X1 != 120 || segments[1].Spec.Style != "dashed" {
+		t.Errorf("unexpected frame segments: %+v", segments)
+	}
+}
+
+func TestResolveBorderSegments_RTL(t *testing.T) {
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+		},
+	}
+	tbl.Settings.Direction = "rtl"
+	// Border sides are physical: b is drawn on the left, so its left border is the table's outer edge.
+	tbl.Rows[0].Cells[1].BorderLeft = table.BorderSpec{Style: "dashed"}
+	lg, _ := PopulateOccupationMap(tbl)
+	lg.ColumnWidths = []float64{50, 70}
+	lg.RowHeights = []float64{30}
+	lg.CalculateFinalCellLayouts(0)
+	segments := resolveBorderSegments(tbl, lg, func(c *table.Cell) bool { return true }, true)
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments (shared edge, explicit left side), got %d: %+v", len(segments), segments)
+	}
+	if segments[0].X1 != 70 || segments[0].Spec.Style != "solid" || segments[1].X1 != 0 || segments[1].Spec.Style != "dashed" {
+		t.Errorf("unexpected mirrored segments: %+v", segments)
+	}
+}
+
+// TestResolveBorderSegments_NoneOverridesDefaults checks that ::border=none:: removes the edges a
+// cell shares with neighbours using the table default, but not a border set on a neighbour.
+func TestResolveBorderSegments_NoneOverridesDefaults(t *testing.T) {
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+			{Cells: []table.Cell{newLayoutTestCell("", "c", 1, 1), newLayoutTestCell("", "plain", 1, 1)}},
+		},
+	}
+	tbl.Rows[0].Cells[1].BorderBottom = table.BorderSpec{Width: 1, Style: "dashed"}
+	plain := &tbl.Rows[1].Cells[1]
+	plain.BorderTop, plain.BorderRight = table.BorderSpec{Style: "none"}, table.BorderSpec{Style: "none"}
+	plain.BorderBottom, plain.BorderLeft = table.BorderSpec{Style: "none"}, table.BorderSpec{Style: "none"}
+	lg, _ := PopulateOccupationMap(tbl)
+	lg.ColumnWidths = []float64{50, 70}
+	lg.RowHeights = []float64{30, 40}
+	lg.CalculateFinalCellLayouts(0)
+
+	square := squareCells(lg)
+	if len(square) != 4 {
+		t.Errorf("expected the overriding cells and their neighbours to be square, got %d cells", len(square))
+	}
+	for _, seg := range resolveBorderSegments(tbl, lg, func(c *table.Cell) bool { return square[c] }, false) {
+		touchesPlain := seg.X1 >= 50 && seg.Y1 >= 30 && seg.X2 >= 50 && seg.Y2 >= 30
+		if touchesPlain && !(seg.Y1 == 30 && seg.Y2 == 30 && seg.Spec.Style == "dashed") {
+			t.Errorf("unexpected segment %+v around the borderless cell", seg)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// parseColor parses a single color value. Supported forms are hex (#RGB, #RGBA, #RRGGBB, #RRGGBBAA),
+// rgb()/rgba(), hsl()/hsla() and CSS named colors (including "transparent").
+func parseColor(s string) (color.Color, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return color.Transparent, fmt.Errorf("empty color string")
+	}
+	if strings.HasPrefix(s, "#") {
+		return parseHexColor(s)
+	}
+	lower := strings.ToLower(s)
+	if open := strings.Index(lower, "("); open > 0 && strings.HasSuffix(lower, ")") {
+		name, args := lower[:open], splitColorArgs(lower[open+1:len(lower)-1])
+		switch name {
+		case "rgb", "rgba":
+			return parseRGBFunc(args)
+		case "hsl", "hsla":
+			return parseHSLFunc(args)
+		}
+		return color.Transparent, fmt.Errorf("unknown color function '%s'", name)
+	}
+	if col, ok := namedColors[lower]; ok {
+		return col, nil
+	}
+	return color.Transparent, fmt.Errorf("unknown color '%s'", s)
+}
+
+// parseHexColor parses #RGB, #RGBA, #RRGGBB and #RRGGBBAA (the leading '#' is optional).
+func parseHexColor(s string) (color.Color, error) {
+	if s == "" {
+		return color.Transparent, fmt.Errorf("empty color string")
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 || len(s) == 4 { // Expand short forms: "f0a" -> "ff00aa".
+		var expanded strings.Builder
+		for _, ch := range s {
+			expanded.WriteRune(ch)
+			expanded.WriteRune(ch)
+		}
+		s = expanded.String()
+	}
+	if len(s) != 6 && len(s) != 8 {
+		return color.Transparent, fmt.Errorf("invalid hex format: %s", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.Transparent, fmt.Errorf("hex: %w", err)
+	}
+	if len(s) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// splitColorArgs splits function arguments separated by commas, whitespace or '/' (CSS level 4 syntax).
+func splitColorArgs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '/' || r == ' ' || r == '\t' })
+}
+
+// parseColorChannel parses an rgb() channel: 0-255 or a percentage.
+func parseColorChannel(s string) (uint8, error) {
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return uint8(math.Round(clampUnit(p/100) * 255)), nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint8(math.Round(math.Max(0, math.Min(255, v)))), nil
+}
+
+// parseUnitValue parses an alpha, saturation or lightness value: 0-1 or a percentage.
+func parseUnitValue(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return clampUnit(p / 100), err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return clampUnit(v), err
+}
+
+func clampUnit(v float64) float64 { return math.Max(0, math.Min(1, v)) }
+
+func parseRGBFunc(args []string) (color.Color, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return color.Transparent, fmt.Errorf("rgb() expects 3 or 4 arguments, got %d", len(args))
+	}
+	var ch [3]uint8
+	for i := 0; i < 3; i++ {
+		v, err := parseColorChannel(args[i])
+		if err != nil {
+			return color.Transparent, fmt.Errorf("rgb() channel '%s': %w", args[i], err)
+		}
+		ch[i] = v
+	}
+	alpha := 1.0
+	if len(args) == 4 {
+		a, err := parseUnitValue(args[3])
+		if err != nil {
+			return color.Transparent, fmt.Errorf("rgb() alpha '%s': %w", args[3], err)
+		}
+		alpha = a
+	}
+	return color.NRGBA{R: ch[0], G: ch[1], B: ch[2], A: uint8(math.Round(alpha * 255))}, nil
+}
+
+func parseHSLFunc(args []string) (color.Color, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return color.Transparent, fmt.Errorf("hsl() expects 3 or 4 arguments, got %d", len(args))
+	}
+	h, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "deg"), 64)
+	if err != nil {
+		return color.Transparent, fmt.Errorf("hsl() hue '%s': %w", args[0], err)
+	}
+	sat, errS := parseUnitValue(args[1])
+	light, errL := parseUnitValue(args[2])
+	if errS != nil || errL != nil {
+		return color.Transparent, fmt.Errorf("hsl() saturation/lightness '%s', '%s' must be numbers or percentages", args[1], args[2])
+	}
+	alpha := 1.0
+	if len(args) == 4 {
+		a, err := parseUnitValue(args[3])
+		if err != nil {
+			return color.Transparent, fmt.Errorf("hsl() alpha '%s': %w", args[3], err)
+		}
+		alpha = a
+	}
+	// Standard HSL -> RGB conversion (CSS Color Module Level 3).
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+	q := light * (1 + sat)
+	if light >= 0.5 {
+		q = light + sat - light*sat
+	}
+	p := 2*light - q
+	hueToRGB := func(t float64) uint8 {
+		if t < 0 {
+			t++
+		}
+		if t > 1 {
+			t--
+		}
+		v := p
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 0.5:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		}
+		return uint8(math.Round(v * 255))
+	}
+	return color.NRGBA{R: hueToRGB(h + 1.0/3), G: hueToRGB(h), B: hueToRGB(h - 1.0/3), A: uint8(math.Round(alpha * 255))}, nil
+}
+
+// fillSpec is a parsed background fill: a solid color, a gradient or a hatch pattern.
+type fillSpec struct {
+	Kind    string        // "solid", "linear", "radial" or "hatch".
+	Colors  []color.Color // Solid: one color. Gradients: the evenly spaced stops. Hatch: line color, background color.
+	Angle   float64       // Linear gradient direction in degrees, CSS convention (0 = towards the top, 90 = towards the right).
+	Spacing float64       // Hatch: distance in pixels between lines.
+}
+
+// parseFill parses a background value. Besides plain colors it accepts:
+//
+//	linear(c1, c2, ..., [angle])  linear gradient, angle in degrees (default 180: top to bottom)
+//	radial(c1, c2, ...)           radial gradient from the center outwards
+//	hatch(line, [bg], [spacing])  diagonal hatching (default bg white, spacing 8)
+func parseFill(s string) (fillSpec, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	open := strings.Index(lower, "(")
+	if open <= 0 || !strings.HasSuffix(lower, ")") {
+		col, err := parseColor(s)
+		return fillSpec{Kind: "solid", Colors: []color.Color{col}}, err
+	}
+	name := lower[:open]
+	if name != "linear" && name != "radial" && name != "hatch" {
+		col, err := parseColor(s)
+		return fillSpec{Kind: "solid", Colors: []color.Color{col}}, err
+	}
+	args := table.SplitOutsideParens(s[open+1:len(s)-1], func(r rune) bool { return r == ',' })
+	for i, arg := range args {
+		if arg == "" {
+			return fillSpec{}, fmt.Errorf("%s() argument %d is empty", name, i+1)
+		}
+	}
+	spec := fillSpec{Kind: name, Angle: 180, Spacing: 8}
+	if name == "linear" && len(args) > 2 {
+		if angle, err := strconv.ParseFloat(strings.TrimSuffix(args[len(args)-1], "deg"), 64); err == nil {
+			spec.Angle = angle
+			args = args[:len(args)-1]
+		}
+	}
+	if name == "hatch" {
+		if last := args[len(args)-1]; len(args) > 1 && last != "" && strings.IndexFunc(last[:1], func(r rune) bool { return r >= '0' && r <= '9' }) == 0 {
+			spacing, err := strconv.ParseFloat(last, 64)
+			if err != nil || spacing < 2 {
+				return fillSpec{}, fmt.Errorf("hatch() spacing '%s' must be a number >= 2", last)
+			}
+			spec.Spacing, args = spacing, args[:len(args)-1]
+		}
+		if len(args) == 1 {
+			args = append(args, "#FFFFFF")
+		}
+		if len(args) != 2 {
+			return fillSpec{}, fmt.Errorf("hatch() expects 1 to 3 arguments, got %d", len(args))
+		}
+	} else if len(args) < 2 {
+		return fillSpec{}, fmt.Errorf("%s() needs at least two colors", name)
+	}
+	for _, arg := range args {
+		col, err := parseColor(arg)
+		if err != nil {
+			return fillSpec{}, fmt.Errorf("%s(): %w", name, err)
+		}
+		spec.Colors = append(spec.Colors, col)
+	}
+	return spec, nil
+}
+
+// Pattern builds the gg pattern for a rectangle in canvas coordinates. Gradients are stretched
+// over the rectangle; hatching is anchored to the canvas so adjacent hatched cells line up.
+func (f fillSpec) Pattern(x, y, w, h float64) gg.Pattern {
+	switch f.Kind {
+	case "linear":
+		// CSS gradient line: through the center, long enough that the corners get the end colors.
+		rad := f.Angle * math.Pi / 180
+		dx, dy := math.Sin(rad), -math.Cos(rad)
+		half := (math.Abs(w*dx) + math.Abs(h*dy)) / 2
+		cx, cy := x+w/2, y+h/2
+		grad := gg.NewLinearGradient(cx-dx*half, cy-dy*half, cx+dx*half, cy+dy*half)
+		addColorStops(grad, f.Colors)
+		return grad
+	case "radial":
+		cx, cy := x+w/2, y+h/2
+		grad := gg.NewRadialGradient(cx, cy, 0, cx, cy, math.Hypot(w, h)/2)
+		addColorStops(grad, f.Colors)
+		return grad
+	case "hatch":
+		return hatchPattern{line: f.Colors[0], background: f.Colors[1], spacing: f.Spacing}
+	}
+	return gg.NewSolidPattern(f.Colors[0])
+}
+
+func addColorStops(grad gg.Gradient, colors []color.Color) {
+	for i, col := range colors {
+		grad.AddColorStop(float64(i)/float64(len(colors)-1), col)
+	}
+}
+
+// hatchPattern draws 45-degree lines ("/") every spacing pixels, measured along the x axis.
+type hatchPattern struct {
+	line, background color.Color
+	spacing          float64
+}
+
+func (p hatchPattern) ColorAt(x, y int) color.Color {
+	lineWidth := math.Max(1, math.Round(p.spacing/4))
+	if math.Mod(float64(x+y), p.spacing) < lineWidth {
+		return p.line
+	}
+	return p.background
+}
+
+// setFill parses a background value and installs it as the fill style of dc for the given rectangle.
+// Invalid values are logged and replaced by fallback (a plain color). gg evaluates patterns in device
+// pixels regardless of dc's transform, so the rectangle and the hatch spacing are mapped to device
+// space first; in a scaled nested table the fill then lines up with the shape it paints.
+func setFill(dc *gg.Context, value, fallback string, x, y, w, h float64) {
+	spec, err := parseFill(value)
+	if err != nil {
+		log.Printf("Error parsing fill '%s': %v. Using %s.", value, err, fallback)
+		spec, _ = parseFill(fallback)
+	}
+	x0, y0 := dc.TransformPoint(x, y)
+	x1, y1 := dc.TransformPoint(x+w, y+h)
+	sx, sy := contextScale(dc)
+	spec.Spacing *= math.Sqrt(sx * sy)
+	dc.SetFillStyle(spec.Pattern(math.Min(x0, x1), math.Min(y0, y1), math.Abs(x1-x0), math.Abs(y1-y0)))
+}
+
+// namedColors holds the CSS Color Module Level 4 named colors.
+var namedColors = map[string]color.Color{
+	"transparent": color.NRGBA{},
+	"aliceblue":   rgbHex(0xf0f8ff), "antiquewhite": rgbHex(0xfaebd7), "aqua": rgbHex(0x00ffff), "aquamarine": rgbHex(0x7fffd4),
+	"azure": rgbHex(0xf0ffff), "beige": rgbHex(0xf5f5dc), "bisque": rgbHex(0xffe4c4), "black": rgbHex(0x000000),
+	"blanchedalmond": rgbHex(0xffebcd), "blue": rgbHex(0x0000ff), "blueviolet": rgbHex(0x8a2be2), "brown": rgbHex(0xa52a2a),
+	"burlywood": rgbHex(0xdeb887), "cadetblue": rgbHex(0x5f9ea0), "chartreuse": rgbHex(0x7fff00), "chocolate": rgbHex(0xd2691e),
+	"coral": rgbHex(0xff7f50), "cornflowerblue": rgbHex(0x6495ed), "cornsilk": rgbHex(0xfff8dc), "crimson": rgbHex(0xdc143c),
+	"cyan": rgbHex(0x00ffff), "darkblue": rgbHex(0x00008b), "darkcyan": rgbHex(0x008b8b), "darkgoldenrod": rgbHex(0xb8860b),
+	"darkgray": rgbHex(0xa9a9a9), "darkgreen": rgbHex(0x006400), "darkgrey": rgbHex(0xa9a9a9), "darkkhaki": rgbHex(0xbdb76b),
+	"darkmagenta": rgbHex(0x8b008b), "darkolivegreen": rgbHex(0x556b2f), "darkorange": rgbHex(0xff8c00), "darkorchid": rgbHex(0x9932cc),
+	"darkred": rgbHex(0x8b0000), "darksalmon": rgbHex(0xe9967a), "darkseagreen": rgbHex(0x8fbc8f), "darkslateblue": rgbHex(0x483d8b),
+	"darkslategray": rgbHex(0x2f4f4f), "darkslategrey": rgbHex(0x2f4f4f), "darkturquoise": rgbHex(0x00ced1), "darkviolet": rgbHex(0x9400d3),
+	"deeppink": rgbHex(0xff1493), "deepskyblue": rgbHex(0x00bfff), "dimgray": rgbHex(0x696969), "dimgrey": rgbHex(0x696969),
+	"dodgerblue": rgbHex(0x1e90ff), "firebrick": rgbHex(0xb22222), "floralwhite": rgbHex(0xfffaf0), "forestgreen": rgbHex(0x228b22),
+	"fuchsia": rgbHex(0xff00ff), "gainsboro": rgbHex(0xdcdcdc), "ghostwhite": rgbHex(0xf8f8ff), "gold": rgbHex(0xffd700),
+	"goldenrod": rgbHex(0xdaa520), "gray": rgbHex(0x808080), "green": rgbHex(0x008000), "greenyellow": rgbHex(0xadff2f),
+	"grey": rgbHex(0x808080), "honeydew": rgbHex(0xf0fff0), "hotpink": rgbHex(0xff69b4), "indianred": rgbHex(0xcd5c5c),
+	"indigo": rgbHex(0x4b0082), "ivory": rgbHex(0xfffff0), "khaki": rgbHex(0xf0e68c), "lavender": rgbHex(0xe6e6fa),
+	"lavenderblush": rgbHex(0xfff0f5), "lawngreen": rgbHex(0x7cfc00), "lemonchiffon": rgbHex(0xfffacd), "lightblue": rgbHex(0xadd8e6),
+	"lightcoral": rgbHex(0xf08080), "lightcyan": rgbHex(0xe0ffff), "lightgoldenrodyellow": rgbHex(0xfafad2), "lightgray": rgbHex(0xd3d3d3),
+	"lightgreen": rgbHex(0x90ee90), "lightgrey": rgbHex(0xd3d3d3), "lightpink": rgbHex(0xffb6c1), "lightsalmon": rgbHex(0xffa07a),
+	"lightseagreen": rgbHex(0x20b2aa), "lightskyblue": rgbHex(0x87cefa), "lightslategray": rgbHex(0x778899), "lightslategrey": rgbHex(0x778899),
+	"lightsteelblue": rgbHex(0xb0c4de), "lightyellow": rgbHex(0xffffe0), "lime": rgbHex(0x00ff00), "limegreen": rgbHex(0x32cd32),
+	"linen": rgbHex(0xfaf0e6), "magenta": rgbHex(0xff00ff), "maroon": rgbHex(0x800000), "mediumaquamarine": rgbHex(0x66cdaa),
+	"mediumblue": rgbHex(0x0000cd), "mediumorchid": rgbHex(0xba55d3), "mediumpurple": rgbHex(0x9370db), "mediumseagreen": rgbHex(0x3cb371),
+	"mediumslateblue": rgbHex(0x7b68ee), "mediumspringgreen": rgbHex(0x00fa9a), "mediumturquoise": rgbHex(0x48d1cc), "mediumvioletred": rgbHex(0xc71585),
+	"midnightblue": rgbHex(0x191970), "mintcream": rgbHex(0xf5fffa), "mistyrose": rgbHex(0xffe4e1), "moccasin": rgbHex(0xffe4b5),
+	"navajowhite": rgbHex(0xffdead), "navy": rgbHex(0x000080), "oldlace": rgbHex(0xfdf5e6), "olive": rgbHex(0x808000),
+	"olivedrab": rgbHex(0x6b8e23), "orange": rgbHex(0xffa500), "orangered": rgbHex(0xff4500), "orchid": rgbHex(0xda70d6),
+	"palegoldenrod": rgbHex(0xeee8aa), "palegreen": rgbHex(0x98fb98), "paleturquoise": rgbHex(0xafeeee), "palevioletred": rgbHex(0xdb7093),
+	"papayawhip": rgbHex(0xffefd5), "peachpuff": rgbHex(0xffdab9), "peru": rgbHex(0xcd853f), "pink": rgbHex(0xffc0cb),
+	"plum": rgbHex(0xdda0dd), "powderblue": rgbHex(0xb0e0e6), "purple": rgbHex(0x800080), "rebeccapurple": rgbHex(0x663399),
+	"red": rgbHex(0xff0000), "rosybrown": rgbHex(0xbc8f8f), "royalblue": rgbHex(0x4169e1), "saddlebrown": rgbHex(0x8b4513),
+	"salmon": rgbHex(0xfa8072), "sandybrown": rgbHex(0xf4a460), "seagreen": rgbHex(0x2e8b57), "seashell": rgbHex(0xfff5ee),
+	"sienna": rgbHex(0xa0522d), "silver": rgbHex(0xc0c0c0), "skyblue": rgbHex(0x87ceeb), "slateblue": rgbHex(0x6a5acd),
+	"slategray": rgbHex(0x708090), "slategrey": rgbHex(0x708090), "snow": rgbHex(0xfffafa), "springgreen": rgbHex(0x00ff7f),
+	"steelblue": rgbHex(0x4682b4), "tan": rgbHex(0xd2b48c), "teal": rgbHex(0x008080), "thistle": rgbHex(0xd8bfd8),
+	"tomato": rgbHex(0xff6347), "turquoise": rgbHex(0x40e0d0), "violet": rgbHex(0xee82ee), "wheat": rgbHex(0xf5deb3),
+	"white": rgbHex(0xffffff), "whitesmoke": rgbHex(0xf5f5f5), "yellow": rgbHex(0xffff00), "yellowgreen": rgbHex(0x9acd32),
+}
+
+func rgbHex(v uint32) color.Color {
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}
+}
@@ -0,0 +1,74 @@
+package renderer
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{input: "#F00", want: color.NRGBA{255, 0, 0, 255}},
+		{input: "#336699", want: color.NRGBA{0x33, 0x66, 0x99, 255}},
+		{input: "#33669980", want: color.NRGBA{0x33, 0x66, 0x99, 0x80}},
+		{input: "#0008", want: color.NRGBA{0, 0, 0, 0x88}},
+		{input: "rgb(10, 20, 30)", want: color.NRGBA{10, 20, 30, 255}},
+		{input: "rgba(255,0,0,0.5)", want: color.NRGBA{255, 0, 0, 128}},
+		{input: "rgb(100% 0% 0% / 50%)", want: color.NRGBA{255, 0, 0, 128}},
+		{input: "hsl(120, 100%, 50%)", want: color.NRGBA{0, 255, 0, 255}},
+		{input: "hsla(0, 0%, 100%, 0)", want: color.NRGBA{255, 255, 255, 0}},
+		{input: "RebeccaPurple", want: color.NRGBA{0x66, 0x33, 0x99, 255}},
+		{input: "transparent", want: color.NRGBA{}},
+		{input: "#12345", wantErr: true},
+		{input: "notacolor", wantErr: true},
+		{input: "rgb(1,2)", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseColor(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseColor(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && color.NRGBAModel.Convert(got) != tt.want {
+				t.Errorf("parseColor(%q) = %+v, want %+v", tt.input, color.NRGBAModel.Convert(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFill(t *testing.T) {
+	spec, err := parseFill("linear(#fff, rgb(0,0,0), 90)")
+	if err != nil || spec.Kind != "linear" || len(spec.Colors) != 2 || spec.Angle != 90 {
+		t.Errorf("linear: got %+v, err %v", spec, err)
+	}
+	// Horizontal gradient over a 100px wide rect: left edge white, right edge black.
+	pattern := spec.Pattern(0, 0, 100, 20)
+	if r, _, _, _ := pattern.ColorAt(0, 10).RGBA(); r>>8 < 250 {
+		t.Errorf("linear: expected white at the left edge, got r=%d", r>>8)
+	}
+	if r, _, _, _ := pattern.ColorAt(99, 10).RGBA(); r>>8 > 5 {
+		t.Errorf("linear: expected black at the right edge, got r=%d", r>>8)
+	}
+
+	spec, err = parseFill("hatch(#000, 6)")
+	if err != nil || spec.Kind != "hatch" || spec.Spacing != 6 || len(spec.Colors) != 2 {
+		t.Fatalf("hatch: got %+v, err %v", spec, err)
+	}
+	pattern = spec.Pattern(0, 0, 10, 10)
+	if pattern.ColorAt(0, 0) != spec.Colors[0] || pattern.ColorAt(3, 0) != spec.Colors[1] || pattern.ColorAt(3, 3) != spec.Colors[0] {
+		t.Errorf("hatch: unexpected pattern colors")
+	}
+
+	if spec, err = parseFill("gold"); err != nil || spec.Kind != "solid" {
+		t.Errorf("solid: got %+v, err %v", spec, err)
+	}
+	for _, bad := range []string{"linear(#fff)", "radial(#fff, nope)", "hatch(#000, 1)", "hatch(#f00,)", "hatch()", "linear(#fff,,#000)"} {
+		if _, err := parseFill(bad); err == nil {
+			t.Errorf("parseFill(%q): expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// fontFile is a font file to load faces from. When syntheticBold is set, faces are emboldened by
+// syntheticBoldFace, for header text in fonts that ship without a bold variant.
+type fontFile struct {
+	path          string
+	syntheticBold bool
+}
+
+// face loads the font at size points.
+func (f fontFile) face(size float64) (font.Face, error) {
+	face, err := gg.LoadFontFace(f.path, size)
+	if err != nil || !f.syntheticBold {
+		return face, err
+	}
+	return newSyntheticBoldFace(face), nil
+}
+
+// syntheticBoldFace emboldens a regular face by smearing each glyph to the right by strength pixels
+// and widening its advance by the same amount, so measured and drawn text agree.
+type syntheticBoldFace struct {
+	font.Face
+	strength int
+}
+
+// newSyntheticBoldFace wraps face with a stroke of about 1/20 of the line height, at least one pixel.
+func newSyntheticBoldFace(face font.Face) *syntheticBoldFace {
+	strength := int(math.Round(float64(face.Metrics().Height) / 64 / 20))
+	if strength < 1 {
+		strength = 1
+	}
+	return &syntheticBoldFace{Face: face, strength: strength}
+}
+
+func (f *syntheticBoldFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	dr, mask, maskp, advance, ok := f.Face.Glyph(dot, r)
+	if !ok {
+		return dr, mask, maskp, advance, ok
+	}
+	bold := image.NewAlpha(image.Rect(dr.Min.X, dr.Min.Y, dr.Max.X+f.strength, dr.Max.Y))
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			a := color.AlphaModel.Convert(mask.At(maskp.X+x-dr.Min.X, maskp.Y+y-dr.Min.Y)).(color.Alpha).A
+			if a == 0 {
+				continue
+			}
+			for k := 0; k <= f.strength; k++ {
+				if a > bold.AlphaAt(x+k, y).A {
+					bold.SetAlpha(x+k, y, color.Alpha{A: a})
+				}
+			}
+		}
+	}
+	return bold.Bounds(), bold, bold.Bounds().Min, advance + fixed.I(f.strength), true
+}
+
+func (f *syntheticBoldFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	bounds, advance, ok := f.Face.GlyphBounds(r)
+	bounds.Max.X += fixed.I(f.strength)
+	return bounds, advance + fixed.I(f.strength), ok
+}
+
+func (f *syntheticBoldFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	advance, ok := f.Face.GlyphAdvance(r)
+	return advance + fixed.I(f.strength), ok
+}
@@ -0,0 +1,48 @@
+package renderer
+
+import (
+	"image"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+func TestSyntheticBoldFace(t *testing.T) {
+	regular, err := fontFile{path: testFontPath}.face(20)
+	if err != nil {
+		t.Fatalf("failed to load font: %v", err)
+	}
+	bold, _ := fontFile{path: testFontPath, syntheticBold: true}.face(20)
+
+	// Measuring and drawing agree: every glyph is one stroke wider.
+	measure := func(dc *gg.Context) float64 { w, _ := dc.MeasureString("Header"); return w }
+	dc := gg.NewContext(200, 40)
+	dc.SetFontFace(regular)
+	regularW := measure(dc)
+	dc.SetFontFace(bold)
+	strength := bold.(*syntheticBoldFace).strength
+	if got, want := measure(dc), regularW+float64(6*strength); got != want {
+		t.Errorf("bold width = %.1f, want %.1f", got, want)
+	}
+
+	inked := func(face font.Face) int {
+		dc := gg.NewContext(200, 40)
+		dc.SetRGB(1, 1, 1)
+		dc.Clear()
+		dc.SetRGB(0, 0, 0)
+		dc.SetFontFace(face)
+		dc.DrawString("Header", 5, 30)
+		img := dc.Image().(*image.RGBA)
+		n := 0
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i] < 128 {
+				n++
+			}
+		}
+		return n
+	}
+	if r, b := inked(regular), inked(bold); b <= r {
+		t.Errorf("bold text inks %d pixels, regular %d: expected more", b, r)
+	}
+}
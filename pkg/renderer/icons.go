@@ -0,0 +1,59 @@
+package renderer
+
+import (
+	"sort"
+	"strings"
+)
+
+// iconSVG wraps the body of a bundled icon: a 24x24 line drawing stroked in the cell's text color.
+func iconSVG(body string) string {
+	return `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24" width="24" height="24" fill="none" ` +
+		`stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">` + body + `</svg>`
+}
+
+// bundledIcons holds the icons available to ::icon=name::, drawn with the SVG renderer.
+var bundledIcons = map[string]string{
+	"check":       iconSVG(`<path d="M4 12.5l5 5L20 6.5"/>`),
+	"cross":       iconSVG(`<path d="M6 6l12 12M18 6L6 18"/>`),
+	"plus":        iconSVG(`<path d="M12 5v14M5 12h14"/>`),
+	"minus":       iconSVG(`<path d="M5 12h14"/>`),
+	"info":        iconSVG(`<circle cx="12" cy="12" r="10"/><path d="M12 11v6"/><circle cx="12" cy="7.5" r="1" fill="currentColor" stroke="none"/>`),
+	"warning":     iconSVG(`<path d="M12 3L2 20.5h20z"/><path d="M12 10v4.5"/><circle cx="12" cy="17.5" r="1" fill="currentColor" stroke="none"/>`),
+	"error":       iconSVG(`<circle cx="12" cy="12" r="10"/><path d="M8.5 8.5l7 7M15.5 8.5l-7 7"/>`),
+	"question":    iconSVG(`<circle cx="12" cy="12" r="10"/><path d="M9.5 9.5a2.5 2.5 0 1 1 3.5 2.3c-.6.3-1 .9-1 1.6v.6"/><circle cx="12" cy="17.5" r="1" fill="currentColor" stroke="none"/>`),
+	"star":        iconSVG(`<polygon points="12 2.5 14.9 8.6 21.5 9.4 16.6 14 17.9 20.6 12 17.3 6.1 20.6 7.4 14 2.5 9.4 9.1 8.6"/>`),
+	"heart":       iconSVG(`<path d="M12 20.5S3 15 3 8.8A4.8 4.8 0 0 1 12 6.5a4.8 4.8 0 0 1 9 2.3C21 15 12 20.5 12 20.5z"/>`),
+	"user":        iconSVG(`<circle cx="12" cy="8" r="4"/><path d="M4 21a8 8 0 0 1 16 0"/>`),
+	"users":       iconSVG(`<circle cx="9" cy="8" r="3.5"/><path d="M2 20a7 7 0 0 1 14 0M16 4.5a3.5 3.5 0 0 1 0 7M18.5 14.5A7 7 0 0 1 22 20"/>`),
+	"lock":        iconSVG(`<rect x="4.5" y="11" width="15" height="10" rx="2"/><path d="M8 11V7a4 4 0 0 1 8 0v4"/>`),
+	"key":         iconSVG(`<circle cx="7.5" cy="15.5" r="4.5"/><path d="M10.7 12.3L20 3M16 7l3 3M14 9l2 2"/>`),
+	"mail":        iconSVG(`<rect x="2.5" y="5" width="19" height="14" rx="2"/><path d="M3 6.5l9 6.5 9-6.5"/>`),
+	"phone":       iconSVG(`<rect x="6.5" y="2" width="11" height="20" rx="2"/><path d="M11 18h2"/>`),
+	"globe":       iconSVG(`<circle cx="12" cy="12" r="10"/><ellipse cx="12" cy="12" rx="4" ry="10"/><path d="M2 12h20"/>`),
+	"cloud":       iconSVG(`<path d="M7 19h10.5a4.5 4.5 0 0 0 .5-9A6 6 0 0 0 6.3 9.5 4.8 4.8 0 0 0 7 19z"/>`),
+	"database":    iconSVG(`<ellipse cx="12" cy="5.5" rx="8" ry="3"/><path d="M4 5.5v13c0 1.7 3.6 3 8 3s8-1.3 8-3v-13M4 12c0 1.7 3.6 3 8 3s8-1.3 8-3"/>`),
+	"server":      iconSVG(`<rect x="3" y="3" width="18" height="7.5" rx="1.5"/><rect x="3" y="13.5" width="18" height="7.5" rx="1.5"/><circle cx="7" cy="6.75" r="1" fill="currentColor" stroke="none"/><circle cx="7" cy="17.25" r="1" fill="currentColor" stroke="none"/>`),
+	"cpu":         iconSVG(`<rect x="5" y="5" width="14" height="14" rx="1.5"/><rect x="9" y="9" width="6" height="6"/><path d="M9 2v3M15 2v3M9 19v3M15 19v3M2 9h3M2 15h3M19 9h3M19 15h3"/>`),
+	"file":        iconSVG(`<path d="M14 2.5H6.5a1.5 1.5 0 0 0-1.5 1.5v16a1.5 1.5 0 0 0 1.5 1.5h11A1.5 1.5 0 0 0 19 20V7.5z"/><path d="M14 2.5v5h5"/>`),
+	"folder":      iconSVG(`<path d="M3 6a1.5 1.5 0 0 1 1.5-1.5h5l2 2.5h8A1.5 1.5 0 0 1 21 8.5v10a1.5 1.5 0 0 1-1.5 1.5h-15A1.5 1.5 0 0 1 3 18.5z"/>`),
+	"clock":       iconSVG(`<circle cx="12" cy="12" r="10"/><path d="M12 6.5V12l3.5 2"/>`),
+	"calendar":    iconSVG(`<rect x="3" y="4.5" width="18" height="17" rx="2"/><path d="M3 10h18M8 2.5v4M16 2.5v4"/>`),
+	"gear":        iconSVG(`<circle cx="12" cy="12" r="3"/><path d="M12 2v3M12 19v3M2 12h3M19 12h3M4.9 4.9l2.1 2.1M17 17l2.1 2.1M4.9 19.1L7 17M17 7l2.1-2.1"/><circle cx="12" cy="12" r="7"/>`),
+	"home":        iconSVG(`<path d="M3 11L12 3l9 8M5.5 9v11.5h13V9"/><path d="M10 20.5v-6h4v6"/>`),
+	"search":      iconSVG(`<circle cx="10.5" cy="10.5" r="7"/><path d="M15.5 15.5L21 21"/>`),
+	"link":        iconSVG(`<path d="M10 14a4.5 4.5 0 0 0 6.4 0l3.2-3.2a4.5 4.5 0 0 0-6.4-6.4L12 5.6"/><path d="M14 10a4.5 4.5 0 0 0-6.4 0l-3.2 3.2a4.5 4.5 0 0 0 6.4 6.4l1.2-1.2"/>`),
+	"arrow_right": iconSVG(`<path d="M4 12h16M14 6l6 6-6 6"/>`),
+	"arrow_left":  iconSVG(`<path d="M20 12H4M10 6l-6 6 6 6"/>`),
+	"arrow_up":    iconSVG(`<path d="M12 20V4M6 10l6-6 6 6"/>`),
+	"arrow_down":  iconSVG(`<path d="M12 4v16M6 14l6 6 6-6"/>`),
+}
+
+// iconNames returns the names of the bundled icons, for error messages and the documentation.
+func iconNames() string {
+	names := make([]string, 0, len(bundledIcons))
+	for name := range bundledIcons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,243 @@
+package renderer
+
+import (
+	"bytes"
+	"diagramgen/pkg/table"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Decoders for image.Decode.
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+)
+
+// imageTextGapEm is the space between a cell's image and its text, in multiples of the font size.
+const imageTextGapEm = 0.5
+
+// iconSizeEm is the side of a bundled icon, in multiples of the font size.
+const iconSizeEm = 2.0
+
+// cellImage is a decoded cell image: a raster image, or an SVG document (including the bundled
+// icons) that is drawn as vectors at its final size.
+type cellImage struct {
+	Raster image.Image
+	Vector *svgDoc
+	Icon   bool // Sized from the font size rather than the document.
+}
+
+// size returns the natural size of the image in a table with the given font size.
+func (im *cellImage) size(fontSize float64) (w, h float64) {
+	switch {
+	case im.Icon:
+		return iconSizeEm * fontSize, iconSizeEm * fontSize
+	case im.Vector != nil:
+		return im.Vector.Width, im.Vector.Height
+	}
+	b := im.Raster.Bounds()
+	return float64(b.Dx()), float64(b.Dy())
+}
+
+// draw draws the image into the rectangle (x, y, w, h) of dc; currentColor colors the icons.
+func (im *cellImage) draw(dc *gg.Context, x, y, w, h float64, currentColor color.Color) {
+	if im.Vector != nil {
+		drawSVG(dc, im.Vector, x, y, w, h, currentColor)
+		return
+	}
+	sx, sy := contextScale(dc)
+	rw, rh := int(math.Round(w*sx)), int(math.Round(h*sy))
+	if rw <= 0 || rh <= 0 {
+		return
+	}
+	// Resample once to the final size in pixels; gg's bilinear transform alone aliases when shrinking a lot.
+	scaled := image.NewRGBA(image.Rect(0, 0, rw, rh))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), im.Raster, im.Raster.Bounds(), xdraw.Over, nil)
+	drawDeviceImage(dc, scaled, x, y)
+}
+
+// imageCache holds the images decoded so far, and the errors loading them, by path (icons by "icon:"
+// and their name), as cells are measured several times before being drawn.
+var imageCache = struct {
+	sync.Mutex
+	images map[string]*cellImage
+	errors map[string]error
+}{images: map[string]*cellImage{}, errors: map[string]error{}}
+
+// loadCellImage returns the image of a cell: its Image file, else its bundled Icon. Files ending in
+// .svg (or starting with an XML tag) are parsed as SVG, others decoded as PNG, JPEG or GIF.
+func loadCellImage(cell *table.Cell) (*cellImage, error) {
+	key := cell.Image
+	if key == "" {
+		key = "icon:" + strings.ReplaceAll(cell.Icon, "-", "_")
+	}
+	imageCache.Lock()
+	defer imageCache.Unlock()
+	if im, ok := imageCache.images[key]; ok {
+		return im, nil
+	}
+	if err, ok := imageCache.errors[key]; ok {
+		return nil, err
+	}
+	im, err := decodeCellImage(cell, key)
+	if err != nil {
+		imageCache.errors[key] = err
+		return nil, err
+	}
+	imageCache.images[key] = im
+	return im, nil
+}
+
+// decodeCellImage loads the image of a cell for loadCellImage.
+func decodeCellImage(cell *table.Cell, key string) (*cellImage, error) {
+	var im *cellImage
+	if cell.Image == "" {
+		source, ok := bundledIcons[strings.TrimPrefix(key, "icon:")]
+		if !ok {
+			return nil, fmt.Errorf("unknown icon '%s' (available: %s)", cell.Icon, iconNames())
+		}
+		doc, err := parseSVG([]byte(source))
+		if err != nil {
+			return nil, fmt.Errorf("icon '%s': %w", cell.Icon, err)
+		}
+		im = &cellImage{Vector: doc, Icon: true}
+	} else {
+		data, err := os.ReadFile(cell.Image)
+		if err != nil {
+			return nil, fmt.Errorf("reading image: %w", err)
+		}
+		if strings.EqualFold(filepath.Ext(cell.Image), ".svg") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+			doc, err := parseSVG(data)
+			if err != nil {
+				return nil, fmt.Errorf("image '%s': %w", cell.Image, err)
+			}
+			im = &cellImage{Vector: doc}
+		} else {
+			raster, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("decoding image '%s': %w", cell.Image, err)
+			}
+			im = &cellImage{Raster: raster}
+		}
+	}
+	return im, nil
+}
+
+// cellImageSize returns the natural size of a cell's image, or zero when it cannot be loaded (the
+// error is reported when the cell is drawn).
+func cellImageSize(cell *table.Cell, fontSize float64) (w, h float64) {
+	im, err := loadCellImage(cell)
+	if err != nil {
+		return 0, 0
+	}
+	return im.size(fontSize)
+}
+
+// ResolveImagePaths makes the relative ::image=...:: paths of every table relative to baseDir (the
+// directory of the input file) instead of the working directory, including images among a cell's Blocks.
+func ResolveImagePaths(allTables map[string]table.Table, baseDir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(baseDir, *path)
+		}
+	}
+	for _, t := range allTables {
+		for r := range t.Rows {
+			for c := range t.Rows[r].Cells {
+				cell := &t.Rows[r].Cells[c]
+				resolve(&cell.Image)
+				for i := range cell.Blocks {
+					resolve(&cell.Blocks[i].Image)
+				}
+			}
+		}
+	}
+}
+
+// contentRect is a rectangle inside a cell's content area.
+type contentRect struct{ X, Y, W, H float64 }
+
+// splitImageArea divides a w x h content area between a cell's image, of natural size imgW x imgH,
+// and its text: the image takes a strip on the side given by position (see table.Cell.ImagePosition)
+// and the text the rest, gap away. Without text the image gets the whole area.
+func splitImageArea(position string, hasText bool, w, h, imgW, imgH, gap float64) (img, text contentRect) {
+	if !hasText {
+		return contentRect{0, 0, w, h}, contentRect{}
+	}
+	imgW, imgH = math.Min(imgW, w), math.Min(imgH, h)
+	switch position {
+	case "top":
+		return contentRect{0, 0, w, imgH}, contentRect{0, imgH + gap, w, math.Max(0, h-imgH-gap)}
+	case "bottom":
+		return contentRect{0, h - imgH, w, imgH}, contentRect{0, 0, w, math.Max(0, h-imgH-gap)}
+	case "right":
+		return contentRect{w - imgW, 0, imgW, h}, contentRect{0, 0, math.Max(0, w-imgW-gap), h}
+	}
+	return contentRect{0, 0, imgW, h}, contentRect{imgW + gap, 0, math.Max(0, w-imgW-gap), h}
+}
+
+// cellHasText reports whether a cell has a title or content to draw besides its image.
+func cellHasText(cell *table.Cell) bool { return cell.Title != "" || cell.Content != "" }
+
+// measureImageCell measures the content block of a cell with an image (see
+// calculateCellContentSizeInternal): the image at its natural size, and its text beside it wrapped to
+// the remaining width.
+func measureImageCell(dc *gg.Context, cell *table.Cell, fontSize, lineHeightMultiplier, padding, availableWidthForTextAndPadding float64, allTables map[string]table.Table, layoutConsts LayoutConstants) (float64, float64, error) {
+	imgW, imgH := cellImageSize(cell, fontSize)
+	if !cellHasText(cell) {
+		return imgW, imgH, nil
+	}
+	textCell := *cell
+	textCell.Image, textCell.Icon = "", ""
+	gap := fontSize * imageTextGapEm
+	if cell.ImagePosition == "top" || cell.ImagePosition == "bottom" {
+		textW, textH, err := calculateCellContentSizeInternal(dc, &textCell, fontSize, lineHeightMultiplier, padding, availableWidthForTextAndPadding, allTables, layoutConsts)
+		return math.Max(imgW, textW), imgH + gap + textH, err
+	}
+	textW, textH, err := calculateCellContentSizeInternal(dc, &textCell, fontSize, lineHeightMultiplier, padding, availableWidthForTextAndPadding-imgW-gap, allTables, layoutConsts)
+	return imgW + gap + textW, math.Max(imgH, textH), err
+}
+
+// minImageCellWidth returns the narrowest content width of a cell with an image: scaled images can
+// shrink, unscaled (and expanding) ones cannot, and the text needs room for its longest word.
+func minImageCellWidth(dc *gg.Context, cell *table.Cell, fontSize float64, opts wrapOptions) float64 {
+	imgW := 0.0
+	if cell.InnerTableScaleMode == "" || cell.InnerTableScaleMode == "none" || cell.InnerTableScaleMode == "expand" {
+		imgW, _ = cellImageSize(cell, fontSize)
+	}
+	if !cellHasText(cell) {
+		return imgW
+	}
+	textW := minContentWidth(dc, cell, opts)
+	if cell.ImagePosition == "top" || cell.ImagePosition == "bottom" {
+		return math.Max(imgW, textW)
+	}
+	return imgW + fontSize*imageTextGapEm + textW
+}
+
+// drawCellImage draws the image of a cell into the w x h content area of dc, scaled and aligned in
+// its part of the area like a nested table (see innerScaleSize and innerAlignOffset). It returns the
+// part of the area left for the cell's text.
+func drawCellImage(dc *gg.Context, cell *table.Cell, w, h, fontSize float64, currentColor color.Color) contentRect {
+	im, err := loadCellImage(cell)
+	if err != nil {
+		log.Printf("Warning: cell '%s': %v. Ignoring the image.", firstNonEmpty(cell.Title, cell.Content), err)
+		return contentRect{0, 0, w, h}
+	}
+	natW, natH := im.size(fontSize)
+	imgArea, textArea := splitImageArea(cell.ImagePosition, cellHasText(cell), w, h, natW, natH, fontSize*imageTextGapEm)
+	scaledW, scaledH := innerScaleSize(cell.InnerTableScaleMode, natW, natH, imgArea.W, imgArea.H)
+	offsetX, offsetY := innerAlignOffset(cell.InnerTableAlignment, imgArea.W, imgArea.H, scaledW, scaledH)
+	dc.DrawRectangle(imgArea.X, imgArea.Y, imgArea.W, imgArea.H)
+	dc.Clip()
+	im.draw(dc, imgArea.X+offsetX, imgArea.Y+offsetY, scaledW, scaledH, currentColor)
+	dc.ResetClip()
+	return textArea
+}
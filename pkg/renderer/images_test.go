@@ -0,0 +1,143 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestInnerScaleAndAlign(t *testing.T) {
+	tests := []struct {
+		mode, align  string
+		wantW, wantH float64
+		wantX, wantY float64
+	}{
+		{"none", "top_left", 40, 20, 0, 0},
+		{"fit_width", "bottom_right", 100, 50, 0, 30},
+		{"fit_height", "middle_center", 160, 80, -30, 0},
+		{"fit_both", "center", 100, 50, 0, 15},
+		{"fill_stretch", "bottom_center", 100, 80, 0, 0},
+		{"none", "middle_right", 40, 20, 60, 30},
+		{"reflow", "top_left", 40, 20, 0, 0}, // Only shrinks.
+	}
+	for _, tt := range tests {
+		w, h := innerScaleSize(tt.mode, 40, 20, 100, 80)
+		x, y := innerAlignOffset(tt.align, 100, 80, w, h)
+		if w != tt.wantW || h != tt.wantH || x != tt.wantX || y != tt.wantY {
+			t.Errorf("%s/%s: got %gx%g at (%g, %g), want %gx%g at (%g, %g)", tt.mode, tt.align, w, h, x, y, tt.wantW, tt.wantH, tt.wantX, tt.wantY)
+		}
+	}
+	if w, h := innerScaleSize("reflow", 200, 100, 100, 80); w != 100 || h != 50 {
+		t.Errorf("reflow of a table too large: got %gx%g, want 100x50", w, h)
+	}
+}
+
+func TestSplitImageArea(t *testing.T) {
+	tests := []struct {
+		position  string
+		hasText   bool
+		img, text contentRect
+	}{
+		{"", true, contentRect{0, 0, 24, 60}, contentRect{30, 0, 70, 60}},
+		{"right", true, contentRect{76, 0, 24, 60}, contentRect{0, 0, 70, 60}},
+		{"top", true, contentRect{0, 0, 100, 24}, contentRect{0, 30, 100, 30}},
+		{"bottom", true, contentRect{0, 36, 100, 24}, contentRect{0, 0, 100, 30}},
+		{"top", false, contentRect{0, 0, 100, 60}, contentRect{}},
+	}
+	for _, tt := range tests {
+		img, text := splitImageArea(tt.position, tt.hasText, 100, 60, 24, 24, 6)
+		if img != tt.img || text != tt.text {
+			t.Errorf("%q (text %t): got image %+v text %+v, want %+v and %+v", tt.position, tt.hasText, img, text, tt.img, tt.text)
+		}
+	}
+}
+
+func TestMeasureImageCell(t *testing.T) {
+	dc := gg.NewContext(1, 1)
+	if err := dc.LoadFontFace(testFontPath, 12); err != nil {
+		t.Fatalf("loading bundled font: %v", err)
+	}
+	consts := LayoutConstants{FontPath: testFontPath, FontSize: 12, LineHeightMultiplier: 1.4, Padding: 8}
+	textW, _ := dc.MeasureString("Ready")
+	icon := table.NewCell("", "")
+	icon.Icon = "check"
+	beside := table.NewCell("", "Ready")
+	beside.Icon = "check"
+	above := beside
+	above.ImagePosition = "bottom"
+	tests := []struct {
+		name         string
+		cell         table.Cell
+		wantW, wantH float64
+	}{
+		{"icon alone", icon, 24, 24},
+		{"text beside the icon", beside, 24 + 6 + textW, 24},
+		{"text above the icon", above, textW, 24 + 6 + 12*1.4},
+	}
+	for _, tt := range tests {
+		w, h, err := calculateCellContentSizeInternal(dc, &tt.cell, 12, 1.4, 8, 1000, nil, consts)
+		if err != nil || !floatEquals(w, tt.wantW, 0.01) || !floatEquals(h, tt.wantH, 0.01) {
+			t.Errorf("%s: got %.1fx%.1f (err %v), want %.1fx%.1f", tt.name, w, h, err, tt.wantW, tt.wantH)
+		}
+	}
+	// Scaled images can shrink to nothing; text beside an unscaled one needs its longest word.
+	if got := minImageCellWidth(dc, &beside, 12, wrapOptions{}); !floatEquals(got, 24+6+textW, 0.01) {
+		t.Errorf("minImageCellWidth() = %.1f, want %.1f", got, 24+6+textW)
+	}
+	icon.InnerTableScaleMode = "fit_both"
+	if got := minImageCellWidth(dc, &icon, 12, wrapOptions{}); got != 0 {
+		t.Errorf("minImageCellWidth() of a scaled icon = %.1f, want 0", got)
+	}
+}
+
+func TestLoadCellImage(t *testing.T) {
+	dir := t.TempDir()
+	raster := image.NewRGBA(image.Rect(0, 0, 30, 10))
+	raster.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	f, err := os.Create(filepath.Join(dir, "photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, raster); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.WriteFile(filepath.Join(dir, "logo.svg"), []byte(`<svg width="40" height="16"/>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cells := []table.Cell{table.NewCell("", ""), table.NewCell("", ""), table.NewCell("", ""), table.NewCell("", "")}
+	cells[0].Image, cells[1].Image, cells[2].Image, cells[3].Icon = "photo.png", "logo.svg", "missing.png", "no-such-icon"
+	tables := map[string]table.Table{"t": {ID: "t", Rows: []table.Row{{Cells: cells}}}}
+	blocks := table.NewCell("", "")
+	blocks.Blocks = []table.CellBlock{{Image: "photo.png"}, {TableRefID: "t"}}
+	tables["b"] = table.Table{ID: "b", Rows: []table.Row{{Cells: []table.Cell{blocks}}}}
+	ResolveImagePaths(tables, dir)
+	if cells[0].Image != filepath.Join(dir, "photo.png") {
+		t.Fatalf("ResolveImagePaths: got %q", cells[0].Image)
+	}
+	if got := tables["b"].Rows[0].Cells[0].Blocks[0].Image; got != filepath.Join(dir, "photo.png") {
+		t.Errorf("ResolveImagePaths: image block next to a nested table got %q", got)
+	}
+	for i, want := range [][2]float64{{30, 10}, {40, 16}} {
+		if w, h := cellImageSize(&cells[i], 12); w != want[0] || h != want[1] {
+			t.Errorf("%s: got %gx%g, want %gx%g", cells[i].Image, w, h, want[0], want[1])
+		}
+	}
+	for _, cell := range cells[2:] {
+		if _, err := loadCellImage(&cell); err == nil {
+			t.Errorf("loadCellImage(%q%q) succeeded, want an error", cell.Image, cell.Icon)
+		}
+	}
+	// Cells that cannot load their image are drawn with their text in the whole content area.
+	dc := gg.NewContext(50, 20)
+	if area := drawCellImage(dc, &cells[2], 50, 20, 12, color.Black); area != (contentRect{0, 0, 50, 20}) {
+		t.Errorf("drawCellImage() with a missing image = %+v", area)
+	}
+}
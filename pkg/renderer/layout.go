@@ -1,16 +1,41 @@
 package renderer
 
 import (
+	"diagramgen/pkg/grid"
 	"diagramgen/pkg/table"
 	"fmt"   // For errors
 	"log"   // For logging overlaps or calculation issues
 	"math"  // For Max - Not used in this exact PopulateOccupationMap, but often in other layout funcs
+	"sort"  // For ordering cells by span
 	"github.com/fogleman/gg" // For gg.Context in measurement - Not used here, but in other layout funcs
 )
 
 // GridCellInfo, LayoutGrid, NewLayoutGrid, ensureCapacity definitions from the prompt
 type GridCellInfo struct {OriginalCell *table.Cell; X, Y, Width, Height float64; GridR, GridC int}
-type LayoutGrid struct { GridCells []GridCellInfo; ColumnWidths, RowHeights []float64; CanvasWidth, CanvasHeight float64; OccupationMap [][]*table.Cell; NumLogicalRows, NumLogicalCols int }
+type LayoutGrid struct { GridCells []GridCellInfo; ColumnWidths, RowHeights []float64; CanvasWidth, CanvasHeight float64; OccupationMap [][]*table.Cell; NumLogicalRows, NumLogicalCols int
+	// ColumnX and RowY hold the start position of each column/row followed by the end of the last one
+	// (len = cols+1 / rows+1). Set by CalculateFinalCellLayouts.
+	ColumnX, RowY []float64
+	// CellSpacing is the gap between adjacent columns and rows. Set by PopulateOccupationMap from the table settings.
+	CellSpacing float64
+	// ColumnSpecs / FixedRowHeights hold the table's "columns:" line and the heights declared by "@row" markers
+	// (0 = sized by content). Set by PopulateOccupationMap; may be shorter than the grid.
+	ColumnSpecs []table.ColumnSpec; FixedRowHeights []float64
+	// TableWidth / TableMaxWidth are the table's "width" and "max_width" settings (0 = unset); columns are
+	// solved to fit them. See solveColumns.
+	TableWidth, TableMaxWidth float64
+	// Overflow is the table's default overflow mode (see overflowMode).
+	Overflow string
+	// containerWidth caps a nested table at the content width of the cell it is drawn in (0 = unset).
+	containerWidth float64
+	// HeaderRows / HeaderCols mirror the table settings; see IsHeaderCell.
+	HeaderRows, HeaderCols int
+	// RTL is set for tables with direction "rtl": logical column 0 is drawn on the right. ColumnX stays in
+	// logical order; CalculateFinalCellLayouts mirrors the cells' X and resolveBorderSegments the borders.
+	RTL bool }
+
+// IsHeaderCell reports whether a cell starting at logical position (r, c) is a header cell.
+func (lg *LayoutGrid) IsHeaderCell(r, c int) bool { return r < lg.HeaderRows || c < lg.HeaderCols }
 
 func NewLayoutGrid(initialEstimatedRows int, initialEstimatedCols int) *LayoutGrid {
 	lg := &LayoutGrid{ NumLogicalRows: initialEstimatedRows, NumLogicalCols: initialEstimatedCols, GridCells: make([]GridCellInfo, 0), }
@@ -86,7 +111,8 @@ func (lg *LayoutGrid) ensureCapacity(targetMaxRow, targetMaxCol int) {
 	}
 }
 
-// PopulateOccupationMap with "true skip/overwrite" logic.
+// PopulateOccupationMap places the table's cells on a grid of logical rows and columns. Each cell occupies
+// Rowspan x Colspan slots; see the placement notes below.
 func PopulateOccupationMap(inputTable *table.Table) (*LayoutGrid, error) {
 	if inputTable == nil || len(inputTable.Rows) == 0 {
 		return NewLayoutGrid(0, 0), nil
@@ -106,148 +132,248 @@ func PopulateOccupationMap(inputTable *table.Table) (*LayoutGrid, error) {
     if estCols == 0 && estRows > 0 { /* No cells, estCols remains 0 */ }
 
 	lg := NewLayoutGrid(estRows, estCols)
+	if inputTable.Settings.BorderMode != "collapse" { lg.CellSpacing = inputTable.Settings.CellSpacing }
+	lg.ColumnSpecs, lg.TableWidth, lg.TableMaxWidth = inputTable.Columns, inputTable.Settings.Width, inputTable.Settings.MaxWidth
+	for _, row := range inputTable.Rows { lg.FixedRowHeights = append(lg.FixedRowHeights, row.Height) }
+	lg.HeaderRows, lg.HeaderCols = inputTable.Settings.HeaderRows, inputTable.Settings.HeaderCols
+	lg.Overflow, lg.RTL = inputTable.Settings.Overflow, inputTable.Settings.Direction == "rtl"
+
+	// Placement follows the HTML table model, see grid.Place; cell addresses such as "B3" name the same slots.
+	slots := grid.Place(inputTable)
+	if len(slots) > 0 { lg.ensureCapacity(len(slots)-1, len(slots[0])-1) }
+	for r := range slots { copy(lg.OccupationMap[r], slots[r]) }
+	if lg.NumLogicalCols > estCols { log.Printf("Info: cells shifted past row/column spans widen the table from %d to %d columns.", estCols, lg.NumLogicalCols) }
 
-	for rIdx, inputRow := range inputTable.Rows {
-		gridColPlacementTarget := 0
-
-        initialTargetColForEnsure := estCols -1
-        if initialTargetColForEnsure < 0 { initialTargetColForEnsure = 0 }
-        if estCols == 0 && len(inputRow.Cells) > 0 {
-            lg.ensureCapacity(rIdx, -1)
-        } else {
-		    lg.ensureCapacity(rIdx, initialTargetColForEnsure)
-        }
-        // After this, lg.OccupationMap[rIdx] exists and has length lg.NumLogicalCols (which is estCols or 0)
-
-		for cInputIdx, _ := range inputRow.Cells { // Use _ if cellData not needed directly
-            cellToPlace := &inputTable.Rows[rIdx].Cells[cInputIdx] // Use pointer to original cell
-			targetGridR := rIdx
-
-			isBlockedByRowspan := false
-            // Check within current grid column bounds AND table's natural width (estCols)
-			if gridColPlacementTarget < estCols && gridColPlacementTarget < lg.NumLogicalCols &&
-			   lg.OccupationMap[targetGridR][gridColPlacementTarget] != nil {
-				isBlockedByRowspan = true
-			}
-
-			if isBlockedByRowspan {
-				// log.Printf("Cell '%s' (input r%d, targetC %d) is blocked by rowspan. Skipping.", cellToPlace.Title, rIdx, gridColPlacementTarget)
-				gridColPlacementTarget += cellToPlace.Colspan
-				continue
-			}
+	return lg, nil
+}
 
-            // Check if placing it (starting at gridColPlacementTarget) would exceed estCols.
-            // This check is only meaningful if estCols > 0.
-			if estCols > 0 && (gridColPlacementTarget + cellToPlace.Colspan > estCols) {
-				// log.Printf("Skipping cell '%s' (input r%d): targetC %d + colspan %d > estCols %d.",
-				// 	cellToPlace.Title, rIdx, gridColPlacementTarget, cellToPlace.Colspan, estCols)
-				gridColPlacementTarget += cellToPlace.Colspan
-				continue
-			}
+// --- Other layout functions (LayoutConstants, CalculateColumnWidthsAndRowHeights, etc.) follow ---
+// (Assuming they are present from previous steps and are correct)
+type LayoutConstants struct {FontPath string; FontSize, LineHeightMultiplier, Padding, MinCellWidth, MinCellHeight float64
+	// CornerRadius and Margin are the effective values for the table being laid out.
+	// TablePadding holds the table's per-side overrides of Padding. See forTable.
+	CornerRadius, Margin float64; TablePadding table.PaddingSpec
+	// BoldFontPath is used for header cells. Empty means header text uses FontPath, emboldened.
+	BoldFontPath string
+	// Wrap holds the table's hyphenation settings for wrapText. See forTable.
+	Wrap wrapOptions }
+
+// cellFont returns the font of a cell's text. Header cells use BoldFontPath when available, else a
+// synthetic bold of FontPath.
+func (lc LayoutConstants) cellFont(header bool) fontFile {
+	if !header { return fontFile{path: lc.FontPath} }
+	if lc.BoldFontPath != "" { return fontFile{path: lc.BoldFontPath} }
+	return fontFile{path: lc.FontPath, syntheticBold: true}
+}
 
-            // If we are here, the cell is to be placed at (targetGridR, gridColPlacementTarget)
-			lg.ensureCapacity(targetGridR+cellToPlace.Rowspan-1, gridColPlacementTarget+cellToPlace.Colspan-1)
-
-			for rOffset := 0; rOffset < cellToPlace.Rowspan; rOffset++ {
-				for cOffset := 0; cOffset < cellToPlace.Colspan; cOffset++ {
-					mapR := targetGridR + rOffset
-					mapC := gridColPlacementTarget + cOffset
-
-                    if mapR < lg.NumLogicalRows && mapC < lg.NumLogicalCols && lg.OccupationMap[mapR] != nil && mapC < len(lg.OccupationMap[mapR]) {
-					    if lg.OccupationMap[mapR][mapC] != nil && lg.OccupationMap[mapR][mapC] != cellToPlace {
-						    // log.Printf("Warning: Overlap! Cell '%s' (input r%d, c%d) at grid (%d,%d) overwriting cell '%s'.",
-                            // cellToPlace.Title, rIdx, cInputIdx, mapR, mapC, lg.OccupationMap[mapR][mapC].Title)
-					    }
-					    lg.OccupationMap[mapR][mapC] = cellToPlace
-                    } else {
-                        // This should not happen if ensureCapacity and estCols logic is correct.
-                        // log.Printf("Error: mapR %d or mapC %d is out of bounds. Grid: %dx%d. Cell: %s", mapR, mapC, lg.NumLogicalRows, lg.NumLogicalCols, cellToPlace.Title)
-                    }
-				}
-			}
-			gridColPlacementTarget += cellToPlace.Colspan
-		}
-	}
-    // The final NumLogicalCols should be estCols, unless estCols was 0.
-    // If estCols was 0, NumLogicalCols is determined by the cells placed.
-    // If estCols > 0, ensureCapacity calls might have expanded NumLogicalCols temporarily
-    // if a cell placement target was at the edge, but it should not exceed estCols overall
-    // due to the skip conditions. We can enforce NumLogicalCols = estCols here if needed.
-    if estCols > 0 && lg.NumLogicalCols != estCols {
-        // This might happen if the table is narrower than estCols (e.g. last rows are short)
-        // Or if ensureCapacity expanded it due to a scan, but skip conditions prevented use.
-        // Forcing it to estCols ensures consistency with the definition of table width.
-        // log.Printf("Adjusting NumLogicalCols from %d to estCols %d", lg.NumLogicalCols, estCols)
-        lg.NumLogicalCols = estCols
-        if len(lg.ColumnWidths) > estCols { lg.ColumnWidths = lg.ColumnWidths[:estCols] }
-        for i := range lg.OccupationMap {
-            if len(lg.OccupationMap[i]) > estCols {
-                lg.OccupationMap[i] = lg.OccupationMap[i][:estCols]
-            }
-        }
-    } else if estCols == 0 { // If table had no cells initially, NumLogicalCols is actual max col placed + 1
-        // No adjustment needed, NumLogicalCols reflects actual width.
-    }
+// forTable returns the constants for laying out and drawing t: the table's own corner radius,
+// margin and padding settings override the inherited values. Nested tables inherit corner radius
+// and padding from the enclosing table but default to a zero margin.
+func (lc LayoutConstants) forTable(t *table.Table, nested bool) LayoutConstants {
+	if nested { lc.Margin = 0 }
+	if t == nil { return lc }
+	if t.Settings.CornerRadius != nil { lc.CornerRadius = *t.Settings.CornerRadius }
+	if t.Settings.Margin != nil { lc.Margin = *t.Settings.Margin }
+	lc.Wrap = wrapOptionsFor(t.Settings)
+	lc.TablePadding = t.Settings.Padding.Over(lc.TablePadding)
+	return lc
+}
 
+// cellPadding resolves the padding of a cell: cell overrides, then table overrides, then Padding.
+func (lc LayoutConstants) cellPadding(cell *table.Cell) (top, right, bottom, left float64) {
+	p := cell.Padding.Over(lc.TablePadding)
+	side := func(v *float64) float64 { if v != nil { return *v }; return lc.Padding }
+	return side(p.Top), side(p.Right), side(p.Bottom), side(p.Left)
+}
 
-	return lg, nil
+// cellCornerRadius resolves the corner radius of a cell: cell override, then CornerRadius.
+func (lc LayoutConstants) cellCornerRadius(cell *table.Cell) float64 {
+	if cell.CornerRadius != nil { return *cell.CornerRadius }
+	return lc.CornerRadius
 }
 
-// --- Other layout functions (LayoutConstants, CalculateColumnWidthsAndRowHeights, etc.) follow ---
-// (Assuming they are present from previous steps and are correct)
-type LayoutConstants struct {FontPath string; FontSize, LineHeightMultiplier, Padding, MinCellWidth, MinCellHeight float64}
 func (lg *LayoutGrid) CalculateColumnWidthsAndRowHeights(constants LayoutConstants, allTables map[string]table.Table) error {
 	if lg.NumLogicalCols == 0 || lg.NumLogicalRows == 0 { return nil }
 	tempDc := gg.NewContext(1, 1)
-	if err := tempDc.LoadFontFace(constants.FontPath, constants.FontSize); err != nil { return fmt.Errorf("failed to load font '%s': %w", constants.FontPath, err) }
-	type cellGridPos struct{ r, c int }; uniqueCellPositions := make(map[*table.Cell]cellGridPos); processedForPos := make(map[*table.Cell]bool)
-	for r := 0; r < lg.NumLogicalRows; r++ { for c := 0; c < lg.NumLogicalCols; c++ { cell := lg.OccupationMap[r][c]; if cell != nil && !processedForPos[cell] {
-		firstR, firstC := -1, -1; scanBreak: for rr := 0; rr < lg.NumLogicalRows; rr++ { for cc := 0; cc < lg.NumLogicalCols; cc++ { if lg.OccupationMap[rr][cc] == cell { firstR, firstC = rr, cc; break scanBreak }}}
-		if firstR != -1 { uniqueCellPositions[cell] = cellGridPos{firstR, firstC} }; processedForPos[cell] = true }}}
+	regularFace, err := constants.cellFont(false).face(constants.FontSize); if err != nil { return fmt.Errorf("failed to load font '%s': %w", constants.FontPath, err) }
+	headerFace := regularFace
+	if lg.HeaderRows > 0 || lg.HeaderCols > 0 { if face, errBold := constants.cellFont(true).face(constants.FontSize); errBold == nil { headerFace = face } else { log.Printf("Warning: failed to load header font '%s': %v. Using regular font.", constants.cellFont(true).path, errBold) } }
+	// Header cells are measured with the (wider) header font.
+	useCellFont := func(r, c int) { if lg.IsHeaderCell(r, c) { tempDc.SetFontFace(headerFace) } else { tempDc.SetFontFace(regularFace) } }
+	// Two-pass span resolution: cells spanning a single track are measured first, then spanning cells in order
+	// of increasing span, each growing the tracks it covers in proportion to their current size.
+	placed := lg.placedCells()
+	fixedCol := func(c int) bool { return c < len(lg.ColumnSpecs) && (lg.ColumnSpecs[c].Width > 0 || lg.ColumnSpecs[c].WidthPercent > 0) }
+	fixedRow := func(r int) bool { return r < len(lg.FixedRowHeights) && lg.FixedRowHeights[r] > 0 }
 	for i := range lg.ColumnWidths { lg.ColumnWidths[i] = 0.0 }
-	for cell, pos := range uniqueCellPositions {
-		textIdealW, _, err := calculateCellContentSizeInternal(tempDc, cell, constants.FontSize, constants.LineHeightMultiplier, constants.Padding, 10000.0, allTables, constants)
+	// colFloors holds the narrowest each column can get before words must break (see solveColumnWidths).
+	colFloors := make([]float64, lg.NumLogicalCols)
+	for _, pos := range spanOrder(placed, func(cell *table.Cell) int { return cell.Colspan }) {
+		cell := pos.cell
+		useCellFont(pos.r, pos.c)
+		_, padR, _, padL := constants.cellPadding(cell)
+		textIdealW, _, err := calculateCellContentSizeInternal(tempDc, cell, constants.FontSize, constants.LineHeightMultiplier, (padL+padR)/2, 10000.0, allTables, constants)
 		if err != nil {
 			log.Printf("Warning (ideal width calc for cell '%s'): %v", cell.Title, err)
 			// Fallback to MinCellWidth if content calculation fails, ensuring textIdealW is for content area
-			textIdealW = constants.MinCellWidth - (2*constants.Padding)
+			textIdealW = constants.MinCellWidth - (padL+padR)
 			if textIdealW < 0 { textIdealW = 0 }
 		}
 
-		var cellFullIdealW float64
-		if cell.FixedWidth > 0.0 { // FixedWidth is set
-			cellFullIdealW = cell.FixedWidth
-		} else { // Not fixed, calculate from content
-			cellFullIdealW = math.Max(textIdealW + (2*constants.Padding), constants.MinCellWidth)
-		}
-
-		if cell.Colspan == 1 { if cellFullIdealW > lg.ColumnWidths[pos.c] { lg.ColumnWidths[pos.c] = cellFullIdealW }
-		} else { currentSpanWidth := 0.0; for i := 0; i < cell.Colspan; i++ { if pos.c+i < lg.NumLogicalCols { currentSpanWidth += lg.ColumnWidths[pos.c+i] } }
-			if cellFullIdealW > currentSpanWidth { shortfall := cellFullIdealW - currentSpanWidth; widthToAddPerCol := shortfall / float64(cell.Colspan); for i := 0; i < cell.Colspan; i++ { if pos.c+i < lg.NumLogicalCols { lg.ColumnWidths[pos.c+i] += widthToAddPerCol } }}}}
+		fixed := cell.FixedWidth > 0.0 && !expandsCell(cell, lg.Overflow) // Fixed sizes of expanding cells become minimums.
+		cellFullIdealW := cell.FixedWidth
+		if !fixed { cellFullIdealW = math.Max(textIdealW + padL + padR, constants.MinCellWidth) } // Not fixed, calculate from content
+		cellFloorW := lg.cellFloorWidth(tempDc, cell, cellFullIdealW, allTables, constants)
+		if maxW, ok := cell.MaxWidth.Pixels(lg.percentBase()); ok && !fixed { cellFullIdealW = math.Min(cellFullIdealW, maxW) }
+		if (cell.MinWidth.Percent || cell.MaxWidth.Percent) && lg.percentBase() <= 0 { log.Printf("Warning: cell '%s' uses a percentage width but the table has no 'width' or 'max_width' setting. Ignoring it.", cell.Title) }
+		cellFullIdealW = math.Max(cellFullIdealW, cellFloorW)
+
+		// The gaps between spanned columns count towards the cell's width.
+		gaps := lg.CellSpacing * float64(cell.Colspan-1)
+		growSpan(lg.ColumnWidths, pos.c, cell.Colspan, cellFullIdealW-gaps, fixedCol); growSpan(colFloors, pos.c, cell.Colspan, cellFloorW-gaps, fixedCol)}
+	// Declared widths, min/max bounds and the table width decide the final column widths; heights below
+	// are then measured against them, so text in narrowed columns wraps.
+	lg.ColumnWidths = lg.solveColumns(lg.columnConstraints(lg.ColumnWidths, colFloors))
 	for i := range lg.RowHeights { lg.RowHeights[i] = 0.0 }
-	for cell, pos := range uniqueCellPositions {
-		currentCellActualDrawingWidth := 0.0; for i := 0; i < cell.Colspan; i++ { if pos.c+i < lg.NumLogicalCols { currentCellActualDrawingWidth += lg.ColumnWidths[pos.c+i] } }
-		_, finalTextH, err := calculateCellContentSizeInternal(tempDc, cell, constants.FontSize, constants.LineHeightMultiplier, constants.Padding, currentCellActualDrawingWidth, allTables, constants)
+	expandRowMin := make([]float64, lg.NumLogicalRows)
+	for _, pos := range spanOrder(placed, func(cell *table.Cell) int { return cell.Rowspan }) {
+		cell := pos.cell
+		useCellFont(pos.r, pos.c)
+		currentCellActualDrawingWidth := lg.CellSpacing * float64(cell.Colspan-1); for i := 0; i < cell.Colspan; i++ { if pos.c+i < lg.NumLogicalCols { currentCellActualDrawingWidth += lg.ColumnWidths[pos.c+i] } }
+		padT, padR, padB, padL := constants.cellPadding(cell)
+		_, finalTextH, err := calculateCellContentSizeInternal(tempDc, cell, constants.FontSize, constants.LineHeightMultiplier, (padL+padR)/2, currentCellActualDrawingWidth, allTables, constants)
 		if err != nil {
 			log.Printf("Warning (final height calc for cell '%s'): %v", cell.Title, err)
 			// Fallback to MinCellHeight if content calculation fails, ensuring finalTextH is for content area
-			finalTextH = constants.MinCellHeight - (2*constants.Padding)
+			finalTextH = constants.MinCellHeight - (padT+padB)
 			if finalTextH < 0 { finalTextH = 0 }
 		}
 
 		var cellFullFinalH float64
-		if cell.FixedHeight > 0.0 { // FixedHeight is set
+		expand := expandsCell(cell, lg.Overflow)
+		if cell.FixedHeight > 0.0 && !expand { // FixedHeight is set
 			cellFullFinalH = cell.FixedHeight
 		} else { // Not fixed, calculate from content
-			cellFullFinalH = math.Max(finalTextH + (2*constants.Padding), constants.MinCellHeight)
+			cellFullFinalH = math.Max(math.Max(finalTextH + padT + padB, constants.MinCellHeight), cell.FixedHeight)
 		}
+		if expand && cell.Rowspan == 1 { expandRowMin[pos.r] = math.Max(expandRowMin[pos.r], cellFullFinalH) }
 
-		if cell.Rowspan == 1 { if cellFullFinalH > lg.RowHeights[pos.r] { lg.RowHeights[pos.r] = cellFullFinalH }
-		} else { currentSpanHeight := 0.0; for i := 0; i < cell.Rowspan; i++ { if pos.r+i < lg.NumLogicalRows { currentSpanHeight += lg.RowHeights[pos.r+i] } }
-            if cellFullFinalH > currentSpanHeight { shortfall := cellFullFinalH - currentSpanHeight; heightToAddPerRow := shortfall / float64(cell.Rowspan); for i := 0; i < cell.Rowspan; i++ { if pos.r+i < lg.NumLogicalRows { lg.RowHeights[pos.r+i] += heightToAddPerRow } }}}}
+		growSpan(lg.RowHeights, pos.r, cell.Rowspan, cellFullFinalH-lg.CellSpacing*float64(cell.Rowspan-1), fixedRow)}
+	// Declared row heights win, except over cells with overflow "expand" (or inner_scale "expand").
+	for r, h := range lg.FixedRowHeights { if h > 0 && r < len(lg.RowHeights) { lg.RowHeights[r] = math.Max(h, expandRowMin[r]) } }
 	return nil
 }
+// cellFloorWidth returns the narrowest a cell can get, padding included, before its content would have
+// to break mid-word or overflow; idealW is its width when nothing wraps.
+func (lg *LayoutGrid) cellFloorWidth(dc *gg.Context, cell *table.Cell, idealW float64, allTables map[string]table.Table, consts LayoutConstants) float64 {
+	_, padR, _, padL := consts.cellPadding(cell)
+	padded := func(contentW float64) float64 { return math.Max(contentW+padL+padR, consts.MinCellWidth) }
+	expand := expandsCell(cell, lg.Overflow)
+	var floorW float64
+	switch {
+	case cell.FixedWidth > 0.0 && !expand:
+		// A fixed width is kept whatever the content.
+		floorW = cell.FixedWidth
+	case cell.IsTableRef && reflowsInnerTable(cell):
+		// A nested table drawn at its own size narrows as far as its columns can wrap.
+		floorW = idealW
+		if minW, _, err := calculateCellContentSizeInternal(dc, cell, consts.FontSize, consts.LineHeightMultiplier, (padL+padR)/2, 0, allTables, consts); err == nil {
+			floorW = padded(minW)
+		}
+	case cell.IsTableRef:
+		// A nested table scaled into the cell is laid out once, at its ideal width.
+		floorW = idealW
+	case cell.IsSideways():
+		// Rotated text runs down the column, so wrapping it does not make the cell narrower.
+		floorW = idealW
+	case cell.HasBlocks():
+		// Mixed content is as narrow as its narrowest stack of blocks.
+		floorW = padded(minBlocksWidth(dc, cell, allTables, consts))
+	case cell.HasImage():
+		// An image or icon keeps its size unless scaled, and text beside it wraps at its longest word.
+		floorW = padded(minImageCellWidth(dc, cell, consts.FontSize, consts.Wrap))
+	default:
+		// Text wraps down to its longest word.
+		floorW = padded(minContentWidth(dc, cell, consts.Wrap))
+	}
+	if minW, ok := cell.MinWidth.Pixels(lg.percentBase()); ok {
+		floorW = math.Max(floorW, minW)
+	}
+	if expand {
+		// Overflow "expand" turns a fixed width into a minimum.
+		floorW = math.Max(floorW, cell.FixedWidth)
+	}
+	return floorW
+}
+
+// expandsCell reports whether a cell grows past its fixed width and height, and its row's declared
+// height, to fit its content: text with overflow "expand", or a nested table or image with inner_scale
+// "expand", which is drawn at its natural size.
+func expandsCell(cell *table.Cell, tableOverflow string) bool {
+	return overflowMode(cell, tableOverflow) == "expand" || ((cell.IsTableRef || cell.HasImage()) && cell.InnerTableScaleMode == "expand")
+}
+
+// reflowsInnerTable reports whether a cell's nested table is laid out again to the cell's content width
+// ("none" and "reflow") rather than at its natural width and then scaled.
+func reflowsInnerTable(cell *table.Cell) bool { return cell.InnerTableScaleMode == "none" || cell.InnerTableScaleMode == "reflow" }
+
+// layoutInnerTable lays out a nested table with its own corner radius, margin and padding settings.
+// A containerWidth > 0 caps the table's canvas width; its columns shrink and wrap to fit when they can.
+// Empty tables are returned without calculating sizes.
+func layoutInnerTable(refTable *table.Table, outerConsts LayoutConstants, allTables map[string]table.Table, containerWidth float64) (*LayoutGrid, LayoutConstants, error) {
+	innerConsts := outerConsts.forTable(refTable, true)
+	innerLg, err := PopulateOccupationMap(refTable)
+	if err != nil { return nil, innerConsts, fmt.Errorf("populate occupation map: %w", err) }
+	if innerLg.NumLogicalRows == 0 || innerLg.NumLogicalCols == 0 { return innerLg, innerConsts, nil }
+	if containerWidth > 0 { innerLg.containerWidth = math.Max(containerWidth-2*innerConsts.Margin, 1) }
+	if err := innerLg.CalculateColumnWidthsAndRowHeights(innerConsts, allTables); err != nil { return nil, innerConsts, err } // Recursive call
+	// Inner tables default to a 0 margin, as the parent cell's padding handles spacing.
+	innerLg.CalculateFinalCellLayouts(innerConsts.Margin)
+	return innerLg, innerConsts, nil
+}
+// placedCell is a cell together with the logical position of its top-left slot.
+type placedCell struct { cell *table.Cell; r, c int }
+
+// placedCells returns every cell on the grid once, at its top-left slot, in row-major order.
+func (lg *LayoutGrid) placedCells() []placedCell {
+	var placed []placedCell; seen := make(map[*table.Cell]bool)
+	for r := 0; r < lg.NumLogicalRows; r++ { for c := 0; c < lg.NumLogicalCols && c < len(lg.OccupationMap[r]); c++ { cell := lg.OccupationMap[r][c]; if cell != nil && !seen[cell] { seen[cell] = true; placed = append(placed, placedCell{cell, r, c}) } } }
+	return placed
+}
+
+// spanOrder returns the cells sorted by increasing span (row-major within the same span), so that
+// single-track cells size the tracks before the cells spanning them are considered.
+func spanOrder(placed []placedCell, span func(*table.Cell) int) []placedCell {
+	ordered := append([]placedCell(nil), placed...)
+	sort.SliceStable(ordered, func(i, j int) bool { return span(ordered[i].cell) < span(ordered[j].cell) })
+	return ordered
+}
+
+// growSpan makes the tracks sizes[start:start+span] add up to at least size. A shortfall is spread over the
+// tracks in proportion to their current size (evenly when they are all empty), skipping fixed tracks unless
+// every spanned track is fixed.
+func growSpan(sizes []float64, start, span int, size float64, fixed func(i int) bool) {
+	if span == 1 { if start < len(sizes) && size > sizes[start] { sizes[start] = size }; return }
+	var receivers []int; currentSpanSize := 0.0
+	for i := start; i < start+span && i < len(sizes); i++ { currentSpanSize += sizes[i]; if !fixed(i) { receivers = append(receivers, i) } }
+	if size <= currentSpanSize { return }
+	if len(receivers) == 0 { for i := start; i < start+span && i < len(sizes); i++ { receivers = append(receivers, i) } }
+	shortfall, receiversSize := size-currentSpanSize, 0.0
+	for _, i := range receivers { receiversSize += sizes[i] }
+	for _, i := range receivers { if receiversSize > 0 { sizes[i] += shortfall * sizes[i] / receiversSize } else { sizes[i] += shortfall / float64(len(receivers)) } }
+}
+// calculateCellContentSizeInternal measures the content block of a cell. padding is the average of the
+// cell's left and right padding, so availableWidthForTextAndPadding - 2*padding is the content width.
+// The text of sideways cells (see table.Cell.IsSideways) is measured turned: its width is the height of
+// its lines. Cells with blocks are measured by layoutBlocks, and cells with an image by measureImageCell.
 func calculateCellContentSizeInternal(dc *gg.Context, cell *table.Cell, fontSize, lineHeightMultiplier, padding, availableWidthForTextAndPadding float64, allTables map[string]table.Table, layoutConsts LayoutConstants) (textBlockWidth float64, textBlockHeight float64, err error) {
+	if cell.HasBlocks() {
+		_, _, w, h, err := layoutBlocks(dc, cell, availableWidthForTextAndPadding-(2*padding), allTables, layoutConsts)
+		return w, h, err
+	}
+	if cell.HasImage() && !cell.IsTableRef {
+		return measureImageCell(dc, cell, fontSize, lineHeightMultiplier, padding, availableWidthForTextAndPadding, allTables, layoutConsts)
+	}
 	if cell.IsTableRef {
 		minContentWidth := math.Max(0, layoutConsts.MinCellWidth-(2*layoutConsts.Padding))
 		minContentHeight := math.Max(0, layoutConsts.MinCellHeight-(2*layoutConsts.Padding))
@@ -270,12 +396,13 @@ func calculateCellContentSizeInternal(dc *gg.Context, cell *table.Cell, fontSize
 		// Basic self-reference check could be added here if parent table ID were available.
 		// For now, proceeding without it.
 
-		// Create and calculate layout for the inner table.
-		// Note: Using the same layout constants for the inner table.
-		// A different set of constants (e.g., smaller font) could be passed if desired.
-		innerLayoutGrid, mapErr := PopulateOccupationMap(&refTable)
-		if mapErr != nil {
-			return 0, 0, fmt.Errorf("error populating occupation map for inner table '%s' (cell '%s'): %w", refTable.ID, cell.Title, mapErr)
+		// Create and calculate layout for the inner table. Unscaled inner tables are re-laid out to fit the
+		// content width, wrapping their text like the outer table does; scaled ones keep their natural size.
+		containerWidth := 0.0
+		if reflowsInnerTable(cell) { containerWidth = math.Max(availableWidthForTextAndPadding-(2*padding), 1) }
+		innerLayoutGrid, _, layoutErr := layoutInnerTable(&refTable, layoutConsts, allTables, containerWidth)
+		if layoutErr != nil {
+			return 0, 0, fmt.Errorf("error laying out inner table '%s' (cell '%s'): %w", refTable.ID, cell.Title, layoutErr)
 		}
 
 		if innerLayoutGrid.NumLogicalRows == 0 || innerLayoutGrid.NumLogicalCols == 0 {
@@ -283,14 +410,6 @@ func calculateCellContentSizeInternal(dc *gg.Context, cell *table.Cell, fontSize
 			return 0, 0, nil // Represents empty content
 		}
 
-		calcErr := innerLayoutGrid.CalculateColumnWidthsAndRowHeights(layoutConsts, allTables) // Recursive call
-		if calcErr != nil {
-			return 0, 0, fmt.Errorf("error calculating layout for inner table '%s' (cell '%s'): %w", refTable.ID, cell.Title, calcErr)
-		}
-
-		// Use 0 margin for inner table calculation, as parent cell's padding handles spacing.
-		innerLayoutGrid.CalculateFinalCellLayouts(0)
-
 		calculatedWidth := innerLayoutGrid.CanvasWidth
 		calculatedHeight := innerLayoutGrid.CanvasHeight
 		log.Printf("Info: Calculated inner table '%s' for cell '%s' (Title): width=%.2f, height=%.2f", refTable.ID, cell.Title, calculatedWidth, calculatedHeight)
@@ -300,13 +419,21 @@ func calculateCellContentSizeInternal(dc *gg.Context, cell *table.Cell, fontSize
 		// Original text measurement logic for non-reference cells
 		currentTotalHeight, actualMaxWidthUsed, lineHeight := 0.0, 0.0, fontSize*lineHeightMultiplier
 		textAvailableWidth := availableWidthForTextAndPadding - (2 * padding)
+		if cell.IsSideways() {
+			// Sideways text runs along the cell's height: it only wraps to a fixed height, whatever the column width.
+			textAvailableWidth = 10000.0
+			if cell.FixedHeight > 0 {
+				padT, _, padB, _ := layoutConsts.cellPadding(cell)
+				textAvailableWidth = cell.FixedHeight - padT - padB
+			}
+		}
 		if textAvailableWidth < 0 {
 			textAvailableWidth = 0.0
 		}
 
 		if cell.Title != "" {
 			titleText := "[" + cell.Title + "]"
-			titleLines := dc.WordWrap(titleText, textAvailableWidth)
+			titleLines := wrapText(titleText, textAvailableWidth, textMeasurer(dc), layoutConsts.Wrap)
 			if len(titleLines) == 0 && titleText != "" {
 				// Handle case where WordWrap returns empty for non-empty string (e.g. very narrow width)
 				titleLines = []string{""} // Count as one line
@@ -324,7 +451,7 @@ func calculateCellContentSizeInternal(dc *gg.Context, cell *table.Cell, fontSize
 			if cell.Title != "" && currentTotalHeight > 0 {
 				currentTotalHeight += lineHeight * 0.25 // Space between title and content
 			}
-			contentLines := dc.WordWrap(cell.Content, textAvailableWidth)
+			contentLines := wrapText(cell.Content, textAvailableWidth, textMeasurer(dc), layoutConsts.Wrap)
 			if len(contentLines) == 0 && cell.Content != "" {
 				contentLines = []string{""} // Count as one line
 			}
@@ -336,19 +463,45 @@ func calculateCellContentSizeInternal(dc *gg.Context, cell *table.Cell, fontSize
 			}
 			currentTotalHeight += float64(len(contentLines)) * lineHeight
 		}
+		if cell.IsSideways() {
+			return currentTotalHeight, actualMaxWidthUsed, nil // The block is turned on its side.
+		}
 		return actualMaxWidthUsed, currentTotalHeight, nil
 	}
 }
 func (lg *LayoutGrid) CalculateFinalCellLayouts(margin float64) {
 	lg.GridCells = make([]GridCellInfo, 0) ; if lg.NumLogicalCols == 0 || lg.NumLogicalRows == 0 { lg.CanvasWidth = margin * 2; if lg.CanvasWidth < 1 { lg.CanvasWidth = 1 }; lg.CanvasHeight = margin * 2; if lg.CanvasHeight < 1 { lg.CanvasHeight = 1 }; return }
+	// Grid line positions include CellSpacing between (but not around) columns and rows.
+	lg.ColumnX = gridLinePositions(lg.ColumnWidths, margin, lg.CellSpacing); lg.RowY = gridLinePositions(lg.RowHeights, margin, lg.CellSpacing)
 	uniqueCellStartPositions := make(map[*table.Cell]struct{ r, c int }); for r := 0; r < lg.NumLogicalRows; r++ { for c := 0; c < lg.NumLogicalCols; c++ { cellPtr := lg.OccupationMap[r][c]; if cellPtr != nil { if _, exists := uniqueCellStartPositions[cellPtr]; !exists { uniqueCellStartPositions[cellPtr] = struct{ r, c int }{r, c} } } } }
 	for cell, startPos := range uniqueCellStartPositions {
 		currentX, currentY, cellDrawingWidth, cellDrawingHeight := margin, margin, 0.0, 0.0
-		for i := 0; i < startPos.c; i++ { if i < len(lg.ColumnWidths) { currentX += lg.ColumnWidths[i] } }; for i := 0; i < startPos.r; i++ { if i < len(lg.RowHeights) { currentY += lg.RowHeights[i] } }
-		for i := 0; i < cell.Colspan; i++ { colIdx := startPos.c + i; if colIdx < len(lg.ColumnWidths) { cellDrawingWidth += lg.ColumnWidths[colIdx] } else { log.Printf("Warning: Col index %d for cell '%s' out of bounds.", colIdx, cell.Title) } }
-		for i := 0; i < cell.Rowspan; i++ { rowIdx := startPos.r + i; if rowIdx < len(lg.RowHeights) { cellDrawingHeight += lg.RowHeights[rowIdx] } else { log.Printf("Warning: Row index %d for cell '%s' out of bounds.", rowIdx, cell.Title) } }
+		if startPos.c < len(lg.ColumnX) { currentX = lg.ColumnX[startPos.c] }; if startPos.r < len(lg.RowY) { currentY = lg.RowY[startPos.r] }
+		for i := 0; i < cell.Colspan; i++ { colIdx := startPos.c + i; if colIdx < len(lg.ColumnWidths) { cellDrawingWidth += lg.ColumnWidths[colIdx]; if i > 0 { cellDrawingWidth += lg.CellSpacing } } else { log.Printf("Warning: Col index %d for cell '%s' out of bounds.", colIdx, cell.Title) } }
+		for i := 0; i < cell.Rowspan; i++ { rowIdx := startPos.r + i; if rowIdx < len(lg.RowHeights) { cellDrawingHeight += lg.RowHeights[rowIdx]; if i > 0 { cellDrawingHeight += lg.CellSpacing } } else { log.Printf("Warning: Row index %d for cell '%s' out of bounds.", rowIdx, cell.Title) } }
 		lg.GridCells = append(lg.GridCells, GridCellInfo{ OriginalCell: cell, X: currentX, Y: currentY, Width: cellDrawingWidth, Height: cellDrawingHeight, GridR: startPos.r, GridC: startPos.c, })
 	}
-	totalColWidth := 0.0; for _, w := range lg.ColumnWidths { totalColWidth += w }; lg.CanvasWidth = totalColWidth + (margin * 2); if lg.CanvasWidth < 1 { lg.CanvasWidth = 1 }
-	totalRowHeight := 0.0; for _, h := range lg.RowHeights { totalRowHeight += h }; lg.CanvasHeight = totalRowHeight + (margin * 2); if lg.CanvasHeight < 1 { lg.CanvasHeight = 1 }
+	lg.CanvasWidth = lg.ColumnX[len(lg.ColumnX)-1] + margin; if lg.CanvasWidth < 1 { lg.CanvasWidth = 1 }
+	if lg.RTL { for i := range lg.GridCells { lg.GridCells[i].X = lg.mirrorX(lg.GridCells[i].X + lg.GridCells[i].Width) } }
+	lg.CanvasHeight = lg.RowY[len(lg.RowY)-1] + margin; if lg.CanvasHeight < 1 { lg.CanvasHeight = 1 }
+}
+
+// mirrorX maps an x position of the logical (left-to-right) layout to its position in a right-to-left
+// table, reflecting it about the center of the grid. Requires ColumnX.
+func (lg *LayoutGrid) mirrorX(x float64) float64 {
+	if len(lg.ColumnX) == 0 { return x }
+	return lg.ColumnX[0] + lg.ColumnX[len(lg.ColumnX)-1] - x
 }
+
+// gridLinePositions returns the start offset of each track (column or row) followed by the end of
+// the last track. Consecutive tracks are separated by spacing.
+func gridLinePositions(sizes []float64, start, spacing float64) []float64 {
+	positions := make([]float64, len(sizes)+1)
+	positions[0] = start
+	for i, size := range sizes {
+		positions[i+1] = positions[i] + size
+		if i+1 < len(sizes) { positions[i+1] += spacing }
+	}
+	return positions
+}
+
@@ -3,6 +3,7 @@ package renderer
 import (
 	"diagramgen/pkg/table"
 	"math"    // For float comparisons
+	"reflect"
 	"strings" // For TestCalculateColumnWidthsAndRowHeights font error check
 	"testing"
 
@@ -56,19 +57,82 @@ func TestPopulateOccupationMap(t *testing.T) {
 	// Test Case 3: Rowspan
 	table3Cell0 := newLayoutTestCell("R0C0_rs2", "span2row", 1, 2)
 	table3Cell1 := newLayoutTestCell("R0C1_adj", "adjacent", 1, 1)
-	table3Cell2 := newLayoutTestCell("R1C0_skip", "SKIPPED", 1, 1) // This cell should be skipped by placement logic
-	table3Cell3 := newLayoutTestCell("R1C1_next", "next in row", 1, 1)
+	table3Cell2 := newLayoutTestCell("R1C1_shift", "shifted", 1, 1) // Shifted right past the rowspan, not dropped
+	table3Cell3 := newLayoutTestCell("R1C2_next", "next in row", 1, 1)
 	table3 := &table.Table{ Rows: []table.Row{
 		{Cells: []table.Cell{table3Cell0, table3Cell1}},
 		{Cells: []table.Cell{table3Cell2, table3Cell3}},
 	}}
 	lg3, _ := PopulateOccupationMap(table3)
 	if lg3.NumLogicalRows != 2 {t.Errorf("table3: exp 2 rows, got %d", lg3.NumLogicalRows)}
-	if lg3.NumLogicalCols != 2 {t.Errorf("table3: exp 2 cols for true skip, got %d. Map: %v", lg3.NumLogicalCols, lg3.OccupationMap)}
+	if lg3.NumLogicalCols != 3 {t.Errorf("table3: exp 3 cols after shifting, got %d. Map: %v", lg3.NumLogicalCols, lg3.OccupationMap)}
 	if lg3.OccupationMap[0][0] != &table3.Rows[0].Cells[0] {t.Error("table3: (0,0)")}
 	if lg3.OccupationMap[1][0] != &table3.Rows[0].Cells[0] {t.Error("table3: (1,0) from rowspan")}
 	if lg3.OccupationMap[0][1] != &table3.Rows[0].Cells[1] {t.Error("table3: (0,1)")}
-	if lg3.OccupationMap[1][1] != &table3.Rows[1].Cells[1] {t.Errorf("table3: Expected R1C1_next at (1,1)")}
+	if lg3.OccupationMap[1][1] != &table3.Rows[1].Cells[0] {t.Errorf("table3: Expected R1C1_shift at (1,1)")}
+	if lg3.OccupationMap[1][2] != &table3.Rows[1].Cells[1] {t.Errorf("table3: Expected R1C2_next at (1,2)")}
+}
+
+// TestPopulateOccupationMap_Golden checks placement of tricky span combinations against golden grids.
+// Each golden row lists the content of the cell occupying every slot ("." for an empty slot).
+func TestPopulateOccupationMap_Golden(t *testing.T) {
+	c := func(content string, colspan, rowspan int) table.Cell { return newLayoutTestCell("", content, colspan, rowspan) }
+	tests := []struct {
+		name   string
+		rows   [][]table.Cell
+		golden []string
+	}{
+		{
+			name:   "rowspan shifts later rows",
+			rows:   [][]table.Cell{{c("A", 1, 2), c("B", 1, 1), c("C", 1, 1)}, {c("D", 1, 1), c("E", 1, 1)}},
+			golden: []string{"A B C", "A D E"},
+		},
+		{
+			name:   "rowspan in the middle column",
+			rows:   [][]table.Cell{{c("A", 1, 1), c("B", 1, 3), c("C", 1, 1)}, {c("D", 1, 1), c("E", 1, 1)}, {c("F", 1, 1), c("G", 1, 1)}},
+			golden: []string{"A B C", "D B E", "F B G"},
+		},
+		{
+			name:   "colspan fits before a rowspan",
+			rows:   [][]table.Cell{{c("A", 1, 1), c("B", 1, 1), c("C", 1, 2)}, {c("D", 2, 1)}},
+			golden: []string{"A B C", "D D C"},
+		},
+		{
+			name:   "colspan does not fit before a rowspan and moves past it",
+			rows:   [][]table.Cell{{c("A", 1, 1), c("B", 1, 2), c("C", 1, 1)}, {c("D", 2, 1), c("E", 1, 1)}},
+			golden: []string{"A B C . .", ". B D D E"},
+		},
+		{
+			name:   "block spans",
+			rows:   [][]table.Cell{{c("A", 2, 2), c("B", 1, 1)}, {c("C", 1, 1)}, {c("D", 1, 1), c("E", 1, 1), c("F", 1, 1)}},
+			golden: []string{"A A B", "A A C", "D E F"},
+		},
+		{
+			name:   "row wider than the others grows the grid",
+			rows:   [][]table.Cell{{c("A", 1, 2), c("B", 2, 1)}, {c("C", 1, 1), c("D", 1, 1), c("E", 1, 1)}},
+			golden: []string{"A B B .", "A C D E"},
+		},
+		{
+			name:   "short rows leave empty slots",
+			rows:   [][]table.Cell{{c("A", 1, 1), c("B", 1, 1), c("C", 1, 1)}, {c("D", 1, 1)}},
+			golden: []string{"A B C", "D . ."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := &table.Table{}
+			for _, cells := range tt.rows { tbl.Rows = append(tbl.Rows, table.Row{Cells: cells}) }
+			lg, err := PopulateOccupationMap(tbl)
+			if err != nil { t.Fatalf("PopulateOccupationMap failed: %v", err) }
+			got := make([]string, lg.NumLogicalRows)
+			for r := range got {
+				slots := make([]string, lg.NumLogicalCols)
+				for col := range slots { slots[col] = "."; if cell := lg.OccupationMap[r][col]; cell != nil { slots[col] = cell.Content } }
+				got[r] = strings.Join(slots, " ")
+			}
+			if !reflect.DeepEqual(got, tt.golden) { t.Errorf("grid mismatch\ngot:  %q\nwant: %q", got, tt.golden) }
+		})
+	}
 }
 
 
@@ -583,9 +647,9 @@ func TestCalculateCellContentSizeInternal_Multiline(t *testing.T) {
 		})
 	}
 }
-// TestCalculateColumnWidthsAndRowHeights_WithFixedSizes tests how fixed cell dimensions
-// influence overall column and row size calculations.
-func TestCalculateColumnWidthsAndRowHeights_WithFixedSizes(t *testing.T) {
+// TestCalculateColumnWidthsAndRowHeights_WithFixedSizesAndTableMap tests how fixed cell dimensions
+// influence overall column and row size calculations when a table map is supplied.
+func TestCalculateColumnWidthsAndRowHeights_WithFixedSizesAndTableMap(t *testing.T) {
 	testLayoutConsts := LayoutConstants{
 		FontPath:             defaultFontPath_layout_test,
 		FontSize:             12.0,
@@ -632,7 +696,8 @@ func TestCalculateColumnWidthsAndRowHeights_WithFixedSizes(t *testing.T) {
 		tbl := &table.Table{Rows: []table.Row{{Cells: []table.Cell{cellA}}, {Cells: []table.Cell{cellC}}}}
 		lg, _ := PopulateOccupationMap(tbl)
 		// Need column widths to be calculated first for accurate height calculation of cellA
-		tempColWidth := math.Max(testLayoutConsts.MinCellWidth, dc.MeasureString("text") + 2*testLayoutConsts.Padding)
+		textW, _ := dc.MeasureString("text")
+		tempColWidth := math.Max(testLayoutConsts.MinCellWidth, textW + 2*testLayoutConsts.Padding)
 		lg.ColumnWidths[0] = tempColWidth
 
 		err := lg.CalculateColumnWidthsAndRowHeights(testLayoutConsts, allTablesMap)
@@ -723,3 +788,330 @@ func TestCalculateColumnWidthsAndRowHeights_WithFixedSizes(t *testing.T) {
 		}
 	})
 }
+
+// TestCalculateFinalCellLayouts_CellSpacing checks that cell_spacing opens gaps between
+// columns and rows, that spanning cells cover the gaps they cross, and that collapse ignores it.
+func TestCalculateFinalCellLayouts_CellSpacing(t *testing.T) {
+	span := newLayoutTestCell("", "span", 2, 1)
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{
+			{Cells: []table.Cell{span}},
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+		},
+	}
+	tbl.Settings.CellSpacing = 5
+	lg, err := PopulateOccupationMap(tbl)
+	if err != nil { t.Fatalf("PopulateOccupationMap failed: %v", err) }
+	if lg.CellSpacing != 5 { t.Fatalf("expected CellSpacing 5 from settings, got %.1f", lg.CellSpacing) }
+	lg.ColumnWidths = []float64{50, 70}; lg.RowHeights = []float64{30, 40}
+	lg.CalculateFinalCellLayouts(10)
+
+	if !floatEquals(lg.CanvasWidth, 10+50+5+70+10, epsilon_layout_test) { t.Errorf("CanvasWidth: exp %.1f, got %.1f", 145.0, lg.CanvasWidth) }
+	if !floatEquals(lg.CanvasHeight, 10+30+5+40+10, epsilon_layout_test) { t.Errorf("CanvasHeight: exp %.1f, got %.1f", 95.0, lg.CanvasHeight) }
+	for _, gc := range lg.GridCells {
+		switch gc.OriginalCell.Content {
+		case "span":
+			if !floatEquals(gc.Width, 50+5+70, epsilon_layout_test) { t.Errorf("spanning cell must include the gap it covers: width %.1f, want 125", gc.Width) }
+		case "b":
+			if !floatEquals(gc.X, 10+50+5, epsilon_layout_test) || !floatEquals(gc.Y, 10+30+5, epsilon_layout_test) { t.Errorf("cell b at (%.1f, %.1f), want (65, 45)", gc.X, gc.Y) }
+		}
+	}
+
+	// Collapsed tables share grid lines, so the spacing setting is ignored.
+	tbl.Settings.BorderMode = "collapse"
+	lg, _ = PopulateOccupationMap(tbl)
+	if lg.CellSpacing != 0 { t.Errorf("collapse mode: expected CellSpacing 0, got %.1f", lg.CellSpacing) }
+}
+
+func TestCalculateFinalCellLayouts_RTL(t *testing.T) {
+	span := newLayoutTestCell("", "span", 2, 1)
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{
+			{Cells: []table.Cell{span}},
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+		},
+	}
+	tbl.Settings.Direction, tbl.Settings.CellSpacing = "rtl", 5
+	lg, _ := PopulateOccupationMap(tbl)
+	if !lg.RTL { t.Fatalf("expected RTL from the direction setting") }
+	lg.ColumnWidths = []float64{50, 70}; lg.RowHeights = []float64{30, 40}
+	lg.CalculateFinalCellLayouts(10)
+
+	// The first column is drawn on the right; the grid keeps its size and margins.
+	want := map[string]float64{"span": 10, "a": 10 + 70 + 5, "b": 10}
+	for _, gc := range lg.GridCells {
+		if x := want[gc.OriginalCell.Content]; !floatEquals(gc.X, x, epsilon_layout_test) { t.Errorf("cell %s at X %.1f, want %.1f", gc.OriginalCell.Content, gc.X, x) }
+	}
+	if !floatEquals(lg.CanvasWidth, 145, epsilon_layout_test) { t.Errorf("CanvasWidth: exp 145, got %.1f", lg.CanvasWidth) }
+}
+
+func TestCalculateColumnWidthsAndRowHeights_RowAndColumnSizes(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Columns:  []table.ColumnSpec{{Width: 40}},
+		Rows: []table.Row{
+			{Cells: []table.Cell{newLayoutTestCell("", "a fairly long text that has to wrap", 1, 1), newLayoutTestCell("", "b", 1, 1)}},
+			{Cells: []table.Cell{newLayoutTestCell("", "c", 1, 1), newLayoutTestCell("", "d", 1, 1)}, Height: 55},
+		},
+	}
+	lg, _ := PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if !floatEquals(lg.ColumnWidths[0], 40, epsilon_layout_test) { t.Errorf("declared column width: got %.1f, want 40", lg.ColumnWidths[0]) }
+	if !floatEquals(lg.RowHeights[1], 55, epsilon_layout_test) { t.Errorf("declared row height: got %.1f, want 55", lg.RowHeights[1]) }
+	// The first row's text is measured against the narrow declared column, so it wraps onto several lines.
+	if lg.RowHeights[0] < 3*12.0*1.4 { t.Errorf("expected text in the 40px column to wrap, row height only %.1f", lg.RowHeights[0]) }
+}
+
+func TestCalculateColumnWidthsAndRowHeights_SpanDistribution(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	sized := func(content string, colspan, rowspan int, w, h float64) table.Cell { c := newLayoutTestCell("", content, colspan, rowspan); c.FixedWidth, c.FixedHeight = w, h; return c }
+	tests := []struct {
+		name        string
+		rows        [][]table.Cell
+		spacing     float64
+		wantWidths  []float64
+		wantHeights []float64
+	}{
+		{
+			// The spanning cell comes first but is resolved after the single cells: 150px short, split 1:2.
+			name:        "colspan shortfall is proportional",
+			rows:        [][]table.Cell{{sized("A", 2, 1, 300, 20)}, {sized("B", 1, 1, 50, 20), sized("C", 1, 1, 100, 20)}},
+			wantWidths:  []float64{100, 200},
+			wantHeights: []float64{20, 20},
+		},
+		{
+			name:        "rowspan shortfall is proportional",
+			rows:        [][]table.Cell{{sized("A", 1, 2, 40, 180), sized("B", 1, 1, 40, 30)}, {sized("C", 1, 1, 40, 60)}},
+			wantWidths:  []float64{40, 40},
+			wantHeights: []float64{60, 120},
+		},
+		{
+			name:        "spanned gaps count towards the spanning cell",
+			rows:        [][]table.Cell{{sized("A", 2, 1, 110, 20)}, {sized("B", 1, 1, 50, 20), sized("C", 1, 1, 50, 20)}},
+			spacing:     10,
+			wantWidths:  []float64{50, 50},
+			wantHeights: []float64{20, 20},
+		},
+		{
+			name:        "covered columns already wide enough",
+			rows:        [][]table.Cell{{sized("A", 2, 1, 80, 20), sized("B", 1, 1, 30, 20)}, {sized("C", 1, 1, 60, 20), sized("D", 1, 1, 70, 20), sized("E", 1, 1, 30, 20)}},
+			wantWidths:  []float64{60, 70, 30},
+			wantHeights: []float64{20, 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := &table.Table{Settings: table.DefaultGlobalSettings()}
+			tbl.Settings.CellSpacing = tt.spacing
+			for _, cells := range tt.rows { tbl.Rows = append(tbl.Rows, table.Row{Cells: cells}) }
+			lg, _ := PopulateOccupationMap(tbl)
+			if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+			for i, want := range tt.wantWidths { if !floatEquals(lg.ColumnWidths[i], want, epsilon_layout_test) { t.Errorf("column %d: got %.1f, want %.1f", i, lg.ColumnWidths[i], want) } }
+			for i, want := range tt.wantHeights { if !floatEquals(lg.RowHeights[i], want, epsilon_layout_test) { t.Errorf("row %d: got %.1f, want %.1f", i, lg.RowHeights[i], want) } }
+		})
+	}
+}
+
+func TestCalculateColumnWidthsAndRowHeights_TableWidth(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	long := "one long cell that would otherwise blow up the width of the whole diagram"
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Columns:  []table.ColumnSpec{{WidthPercent: 25}, {}},
+		Rows:     []table.Row{{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", long, 1, 1)}}},
+	}
+	tbl.Settings.Width, tbl.Settings.CellSpacing = 300, 4
+	lg, _ := PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	// 300px minus one 4px gap: 25% of 296 for the first column, the rest for the second.
+	if !floatEquals(lg.ColumnWidths[0], 74, epsilon_layout_test) { t.Errorf("percentage column: got %.1f, want 74", lg.ColumnWidths[0]) }
+	if !floatEquals(lg.ColumnWidths[1], 222, epsilon_layout_test) { t.Errorf("auto column: got %.1f, want 222", lg.ColumnWidths[1]) }
+	if lg.RowHeights[0] < 2*12.0*1.4 { t.Errorf("expected the long text to wrap, row height only %.1f", lg.RowHeights[0]) }
+
+	// A cell max_width caps its column without a table width.
+	tbl.Settings.Width, tbl.Columns = 0, nil
+	tbl.Rows[0].Cells[1].MaxWidth = table.Length{Value: 120}
+	lg, _ = PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if !floatEquals(lg.ColumnWidths[1], 120, epsilon_layout_test) { t.Errorf("max_width column: got %.1f, want 120", lg.ColumnWidths[1]) }
+}
+
+func TestCalculateColumnWidthsAndRowHeights_MaxWidthAndNested(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	long := "a description long enough to need wrapping in a narrow docs column"
+	inner := table.Table{ID: "inner", Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{{Cells: []table.Cell{newLayoutTestCell("", "key", 1, 1), newLayoutTestCell("", long, 1, 1)}}}}
+	refCell := newLayoutTestCell("", "", 1, 1)
+	refCell.IsTableRef, refCell.TableRefID, refCell.InnerTableScaleMode = true, "inner", "none"
+	outer := &table.Table{ID: "outer", Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{{Cells: []table.Cell{newLayoutTestCell("", "short", 1, 1), refCell}}}}
+	allTables := map[string]table.Table{"inner": inner, "outer": *outer}
+
+	lg, _ := PopulateOccupationMap(outer)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, allTables); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	naturalW, naturalH := lg.ColumnWidths[0]+lg.ColumnWidths[1], lg.RowHeights[0]
+
+	// max_width above the natural width changes nothing: columns never grow to fill it.
+	outer.Settings.MaxWidth = naturalW + 100
+	lg, _ = PopulateOccupationMap(outer)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, allTables); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if got := lg.ColumnWidths[0] + lg.ColumnWidths[1]; !floatEquals(got, naturalW, epsilon_layout_test) { t.Errorf("generous max_width: got width %.1f, want natural %.1f", got, naturalW) }
+
+	// A tighter max_width shrinks the columns, and the nested table is re-laid out to wrap its text.
+	outer.Settings.MaxWidth = 300
+	lg, _ = PopulateOccupationMap(outer)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, allTables); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if got := lg.ColumnWidths[0] + lg.ColumnWidths[1]; !floatEquals(got, 300, epsilon_layout_test) { t.Errorf("max_width: got width %.1f, want 300", got) }
+	if lg.RowHeights[0] <= naturalH { t.Errorf("expected the nested table to wrap and grow taller than %.1f, got %.1f", naturalH, lg.RowHeights[0]) }
+
+	LimitCanvasWidth(outer, 200)
+	if !floatEquals(outer.Settings.MaxWidth, 200-2*defaultMargin, epsilon_layout_test) { t.Errorf("LimitCanvasWidth: max_width %.1f, want %.1f", outer.Settings.MaxWidth, 200-2*defaultMargin) }
+}
+
+func TestCalculateColumnWidthsAndRowHeights_Sideways(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	label := "Requests per second"
+	upright, sideways := newLayoutTestCell("", label, 1, 1), newLayoutTestCell("", label, 1, 1)
+	sideways.Rotate = 270
+	tbl := &table.Table{Settings: table.DefaultGlobalSettings(), Rows: []table.Row{{Cells: []table.Cell{upright}}, {Cells: []table.Cell{sideways}}}}
+	lg, _ := PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	// The sideways label is as tall as the upright one is wide, and does not wrap in the upright column.
+	if !floatEquals(lg.RowHeights[1], lg.ColumnWidths[0], epsilon_layout_test) { t.Errorf("sideways row height %.1f, want the upright width %.1f", lg.RowHeights[1], lg.ColumnWidths[0]) }
+
+	// Alone in its column, the sideways label keeps the column narrow: one line high.
+	tbl.Rows = tbl.Rows[1:]
+	lg, _ = PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if want := 12.0*1.4 + 16; !floatEquals(lg.ColumnWidths[0], want, epsilon_layout_test) { t.Errorf("sideways column width %.1f, want one line plus padding %.1f", lg.ColumnWidths[0], want) }
+
+	// With a fixed height the label wraps along it, and the column widens by a line per wrap.
+	tbl.Rows[0].Cells[0].FixedHeight = 80
+	lg, _ = PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if lines := (lg.ColumnWidths[0] - 16) / (12.0 * 1.4); lines < 2-epsilon_layout_test || lg.RowHeights[0] != 80 {
+		t.Errorf("fixed height: got %.1fx%.1f (%.1f lines), want at least 2 lines in an 80px high cell", lg.ColumnWidths[0], lg.RowHeights[0], lines)
+	}
+}
+
+func TestCalculateColumnWidthsAndRowHeights_OverflowExpand(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	cell := newLayoutTestCell("", "text that is much wider than the fixed width", 1, 1)
+	cell.FixedWidth, cell.FixedHeight = 40, 20
+	tbl := &table.Table{Settings: table.DefaultGlobalSettings(), Rows: []table.Row{{Cells: []table.Cell{cell}, Height: 15}}}
+	lg, _ := PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if !floatEquals(lg.ColumnWidths[0], 40, epsilon_layout_test) || !floatEquals(lg.RowHeights[0], 15, epsilon_layout_test) {
+		t.Errorf("clip: got %.1fx%.1f, want the fixed 40x15", lg.ColumnWidths[0], lg.RowHeights[0])
+	}
+
+	// With overflow "expand" the fixed sizes (and the row height) only act as minimums.
+	tbl.Settings.Overflow = "expand"
+	lg, _ = PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil { t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err) }
+	if lg.ColumnWidths[0] <= 40 || lg.RowHeights[0] < 12.0*1.4 {
+		t.Errorf("expand: got %.1fx%.1f, want the content size", lg.ColumnWidths[0], lg.RowHeights[0])
+	}
+}
+
+func TestCellFloorWidth(t *testing.T) {
+	dc := gg.NewContext(1, 1)
+	if err := dc.LoadFontFace(defaultFontPath_layout_test, 12); err != nil {
+		t.Fatalf("loading bundled font: %v", err)
+	}
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	word, _ := dc.MeasureString("unbreakable")
+	const ideal = 500.0
+	tests := []struct {
+		name     string
+		overflow string
+		edit     func(c *table.Cell)
+		want     float64
+	}{
+		{"text wraps to its longest word", "", func(c *table.Cell) {}, word + 16},
+		{"fixed width", "", func(c *table.Cell) { c.FixedWidth = 40 }, 40},
+		{"fixed width with overflow expand", "expand", func(c *table.Cell) { c.FixedWidth = 300 }, 300},
+		{"min width", "", func(c *table.Cell) { c.MinWidth = table.Length{Value: 200} }, 200},
+		{"rotated text", "", func(c *table.Cell) { c.Rotate = 90 }, ideal},
+		{"scaled nested table", "", func(c *table.Cell) { c.IsTableRef, c.TableRefID, c.InnerTableScaleMode = true, "inner", "fit_width" }, ideal},
+	}
+	for _, tt := range tests {
+		cell := newLayoutTestCell("", "an unbreakable word", 1, 1)
+		tt.edit(&cell)
+		lg := &LayoutGrid{Overflow: tt.overflow}
+		if got := lg.cellFloorWidth(dc, &cell, ideal, nil, consts); !floatEquals(got, tt.want, epsilon_layout_test) {
+			t.Errorf("%s: cellFloorWidth() = %.1f, want %.1f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateColumnWidthsAndRowHeights_InnerScaleModes(t *testing.T) {
+	consts := LayoutConstants{FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4, Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0}
+	inner := table.Table{ID: "inner", Settings: table.DefaultGlobalSettings(),
+		Rows: []table.Row{{Cells: []table.Cell{newLayoutTestCell("", "key", 1, 1), newLayoutTestCell("", "a description long enough to need wrapping", 1, 1)}}}}
+	allTables := map[string]table.Table{"inner": inner}
+	innerLg, _, err := layoutInnerTable(&inner, consts, allTables, 0)
+	if err != nil { t.Fatalf("layoutInnerTable failed: %v", err) }
+	naturalW, naturalH := innerLg.CanvasWidth+16, innerLg.CanvasHeight+16 // Plus the parent cell's padding.
+
+	tests := []struct {
+		name, mode     string
+		fixedW, fixedH float64
+		tableWidth     float64
+		check          func(w, h float64) bool
+	}{
+		{"fit_both keeps the fixed size", "fit_both", 80, 30, 0, func(w, h float64) bool { return floatEquals(w, 80, epsilon_layout_test) && floatEquals(h, 30, epsilon_layout_test) }},
+		{"expand grows past the fixed size", "expand", 80, 30, 0, func(w, h float64) bool { return floatEquals(w, naturalW, epsilon_layout_test) && floatEquals(h, naturalH, epsilon_layout_test) }},
+		{"expand keeps its natural width in a narrow table", "expand", 0, 0, 150, func(w, h float64) bool { return floatEquals(w, naturalW, epsilon_layout_test) }},
+		{"reflow wraps to a narrow table and grows taller", "reflow", 0, 0, 150, func(w, h float64) bool { return floatEquals(w, 150, epsilon_layout_test) && h > naturalH }},
+	}
+	for _, tt := range tests {
+		refCell := newLayoutTestCell("", "", 1, 1)
+		refCell.IsTableRef, refCell.TableRefID, refCell.InnerTableScaleMode = true, "inner", tt.mode
+		refCell.FixedWidth, refCell.FixedHeight = tt.fixedW, tt.fixedH
+		outer := &table.Table{ID: "outer", Settings: table.DefaultGlobalSettings(), Rows: []table.Row{{Cells: []table.Cell{refCell}}}}
+		outer.Settings.Width = tt.tableWidth
+		lg, _ := PopulateOccupationMap(outer)
+		if err := lg.CalculateColumnWidthsAndRowHeights(consts, allTables); err != nil { t.Fatalf("%s: CalculateColumnWidthsAndRowHeights failed: %v", tt.name, err) }
+		if !tt.check(lg.ColumnWidths[0], lg.RowHeights[0]) { t.Errorf("%s: got %.1fx%.1f (natural size %.1fx%.1f)", tt.name, lg.ColumnWidths[0], lg.RowHeights[0], naturalW, naturalH) }
+	}
+}
+
+// TestLayoutConstants_GeometryOverrides checks that padding and corner radius resolve
+// cell -> table -> renderer default, and that the layout honours per-side padding.
+func TestLayoutConstants_GeometryOverrides(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+	base := LayoutConstants{
+		FontPath: defaultFontPath_layout_test, FontSize: 12.0, LineHeightMultiplier: 1.4,
+		Padding: 8.0, MinCellWidth: 10.0, MinCellHeight: 10.0, CornerRadius: 6.0, Margin: 15.0,
+	}
+	tbl := &table.Table{Settings: table.DefaultGlobalSettings()}
+	tbl.Settings.CornerRadius = ptr(0)
+	tbl.Settings.Padding = table.PaddingSpec{Left: ptr(20)}
+
+	consts := base.forTable(tbl, true)
+	if consts.Margin != 0 { t.Errorf("nested table: expected margin 0, got %.1f", consts.Margin) }
+	if consts.CornerRadius != 0 { t.Errorf("expected table corner radius 0, got %.1f", consts.CornerRadius) }
+
+	cell := newLayoutTestCell("", "x", 1, 1)
+	cell.Padding = table.PaddingSpec{Top: ptr(2)}
+	cell.CornerRadius = ptr(3)
+	top, right, bottom, left := consts.cellPadding(&cell)
+	if top != 2 || right != 8 || bottom != 8 || left != 20 {
+		t.Errorf("cellPadding = (%.1f, %.1f, %.1f, %.1f), want (2, 8, 8, 20)", top, right, bottom, left)
+	}
+	if r := consts.cellCornerRadius(&cell); r != 3 { t.Errorf("cellCornerRadius = %.1f, want 3", r) }
+
+	fixed := newLayoutTestCell("", "", 1, 1)
+	fixed.Padding = table.PaddingSpec{Top: ptr(30), Bottom: ptr(5), Left: ptr(40), Right: ptr(1)}
+	tbl.Rows = []table.Row{{Cells: []table.Cell{fixed}}}
+	lg, _ := PopulateOccupationMap(tbl)
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, nil); err != nil && !strings.Contains(err.Error(), "failed to load font") {
+		t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err)
+	}
+	if !floatEquals(lg.ColumnWidths[0], 41, epsilon_layout_test) { t.Errorf("expected empty cell width = left+right padding 41, got %.2f", lg.ColumnWidths[0]) }
+	if !floatEquals(lg.RowHeights[0], 35, epsilon_layout_test) { t.Errorf("expected empty cell height = top+bottom padding 35, got %.2f", lg.RowHeights[0]) }
+}
@@ -0,0 +1,92 @@
+package renderer
+
+import (
+	"diagramgen/pkg/grid"
+	"diagramgen/pkg/table"
+	"image/color"
+	"log"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// drawCellLinks draws the links between the cells of a laid out table as arrows from the edge of a cell
+// to the edge of the cell it points to, in the table's edge color. Links that do not resolve are
+// skipped; grid.CheckLinks reports them.
+func drawCellLinks(dc *gg.Context, t *table.Table, lg *LayoutGrid, lineScale float64) {
+	rects := make(map[*table.Cell]GridCellInfo, len(lg.GridCells))
+	byID := make(map[string]*table.Cell)
+	for _, gridCell := range lg.GridCells {
+		rects[gridCell.OriginalCell] = gridCell
+		if id := gridCell.OriginalCell.ID; id != "" && byID[id] == nil {
+			byID[id] = gridCell.OriginalCell
+		}
+	}
+	edgeColor, err := parseColor(t.Settings.EdgeColor)
+	if err != nil || t.Settings.EdgeColor == "" {
+		edgeColor, _ = parseColor("#000000")
+	}
+	width := float64(t.Settings.EdgeThickness)
+	if width <= 0 {
+		width = 1
+	}
+	for _, from := range lg.GridCells {
+		for _, link := range from.OriginalCell.Links {
+			addr, err := grid.ParseCellAddress(link)
+			if err != nil || (addr.TableID != "" && addr.TableID != t.ID) {
+				continue
+			}
+			target := byID[addr.ID]
+			if addr.ID == "" && addr.Row < lg.NumLogicalRows && addr.Col < lg.NumLogicalCols {
+				target = lg.OccupationMap[addr.Row][addr.Col]
+			}
+			to, ok := rects[target]
+			if !ok || target == from.OriginalCell {
+				log.Printf("CELL [%d,%d]: Warning: link to '%s' does not lead to another cell of table '%s'. Skipping.", from.GridR, from.GridC, link, t.ID)
+				continue
+			}
+			drawArrow(dc, from, to, width, lineScale, edgeColor)
+		}
+	}
+}
+
+// drawArrow draws an arrow along the line joining the centers of two cells, from where it leaves the
+// first cell to where it enters the second.
+func drawArrow(dc *gg.Context, from, to GridCellInfo, width, lineScale float64, c color.Color) {
+	x1, y1 := from.X+from.Width/2, from.Y+from.Height/2
+	x2, y2 := to.X+to.Width/2, to.Y+to.Height/2
+	dx, dy := x2-x1, y2-y1
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return
+	}
+	// The fraction of the line inside a cell, from its center to its edge.
+	inside := func(cell GridCellInfo) float64 {
+		f := math.Inf(1)
+		if dx != 0 {
+			f = cell.Width / 2 / math.Abs(dx)
+		}
+		if dy != 0 {
+			f = math.Min(f, cell.Height/2/math.Abs(dy))
+		}
+		return f
+	}
+	start, end := inside(from), 1-inside(to)
+	if start >= end {
+		return // The cells overlap along the line.
+	}
+	sx, sy, ex, ey := x1+dx*start, y1+dy*start, x1+dx*end, y1+dy*end
+	head := 6 + 2*width
+	ux, uy := dx/length, dy/length
+	bx, by := ex-ux*head, ey-uy*head // Base of the arrowhead.
+	dc.SetColor(c)
+	dc.SetLineWidth(deviceLineWidth(width, lineScale))
+	dc.SetDash()
+	dc.DrawLine(sx, sy, bx, by)
+	dc.Stroke()
+	dc.MoveTo(ex, ey)
+	dc.LineTo(bx-uy*head/2, by+ux*head/2)
+	dc.LineTo(bx+uy*head/2, by-ux*head/2)
+	dc.ClosePath()
+	dc.Fill()
+}
@@ -0,0 +1,167 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"log"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+const (
+	// minShrinkFontSize is the smallest font size the "shrink" overflow mode goes down to.
+	minShrinkFontSize = 6.0
+	// shrinkFontStep is how much the "shrink" overflow mode reduces the font size per attempt.
+	shrinkFontStep = 0.5
+	ellipsis       = "…"
+)
+
+// textLine is one wrapped line of a cell's text, in logical order. Baseline is relative to the top of
+// the content area. RTL is set when the line's paragraph is right-to-left (see visualOrder).
+type textLine struct {
+	Text     string
+	Baseline float64
+	RTL      bool
+}
+
+// textBlock is a cell's title and content wrapped to a width, as drawn by drawTableItself.
+type textBlock struct {
+	Lines                []textLine
+	Width, Height        float64 // Width of the widest line and height of all lines.
+	FontSize, LineHeight float64
+	Descent              float64 // How far the font reaches below a baseline (set by fitCellText).
+}
+
+// inkHeight is the height the lines are drawn in: down to the last baseline and the font's descent
+// below it, without the leading under the last line that Height includes.
+func (b textBlock) inkHeight() float64 {
+	if len(b.Lines) == 0 {
+		return 0
+	}
+	return b.Lines[len(b.Lines)-1].Baseline + b.Descent
+}
+
+// fits reports whether the block fits a content area of the given size.
+func (b textBlock) fits(width, height float64) bool {
+	return b.Width <= width+epsilon && b.inkHeight() <= height+epsilon
+}
+
+// overflowMode returns the effective overflow mode of a cell: its own, else the table's, else "clip".
+func overflowMode(cell *table.Cell, tableMode string) string {
+	if cell.Overflow != "" {
+		return cell.Overflow
+	}
+	if tableMode != "" {
+		return tableMode
+	}
+	return "clip"
+}
+
+// layoutCellText wraps the cell's "[title]" and content to width with dc's current font. Lines are laid
+// out like calculateCellContentSizeInternal measures them: a quarter line separates title and content.
+// Each line records the direction of its paragraph.
+func layoutCellText(dc *gg.Context, cell *table.Cell, width, fontSize, lineHeightMultiplier float64, opts wrapOptions) textBlock {
+	block := textBlock{FontSize: fontSize, LineHeight: fontSize * lineHeightMultiplier}
+	addLines := func(text string) {
+		for _, paragraph := range strings.Split(text, "\n") {
+			rtl := opts.RTL || paragraphIsRTL(paragraph, false)
+			for _, line := range wrapText(paragraph, width, textMeasurer(dc), opts) {
+				if w, _ := dc.MeasureString(line); w > block.Width {
+					block.Width = w
+				}
+				block.Lines = append(block.Lines, textLine{Text: line, Baseline: block.Height + fontSize, RTL: rtl})
+				block.Height += block.LineHeight
+			}
+		}
+	}
+	if cell.Title != "" {
+		addLines("[" + cell.Title + "]")
+	}
+	if cell.Content != "" {
+		if cell.Title != "" && block.Height > 0 {
+			block.Height += block.LineHeight * 0.25
+		}
+		addLines(cell.Content)
+	}
+	return block
+}
+
+// fitCellText lays out the cell's text for a width x height content area according to mode and
+// reports whether it still overflows. "shrink" reloads the font at smaller sizes (down to
+// minShrinkFontSize) until the text fits, leaving dc's font at the size used; "ellipsis" drops the
+// lines that do not fit and ends the last visible line (and any line that is too wide) with "…".
+// "clip" and "expand" return the text as is; the caller clips it to the content area.
+func fitCellText(dc *gg.Context, cell *table.Cell, width, height float64, textFont fontFile, fontSize, lineHeightMultiplier float64, mode string, opts wrapOptions) (block textBlock, overflowed bool) {
+	// The descent grows with the font size, so it is measured once and scaled for smaller sizes.
+	// Without the face, the leading below a line stands in for it.
+	descentPerPoint := lineHeightMultiplier - 1
+	if face, err := textFont.face(fontSize); err == nil {
+		descentPerPoint = float64(face.Metrics().Descent) / 64 / fontSize
+	}
+	block = layoutCellText(dc, cell, width, fontSize, lineHeightMultiplier, opts)
+	block.Descent = descentPerPoint * fontSize
+	if block.fits(width, height) {
+		return block, false
+	}
+	switch mode {
+	case "shrink":
+		for size := fontSize - shrinkFontStep; size >= minShrinkFontSize-epsilon; size -= shrinkFontStep {
+			face, err := textFont.face(size)
+			if err != nil {
+				log.Printf("Warning: failed to load font '%s' at size %.1f for shrinking: %v", textFont.path, size, err)
+				break
+			}
+			dc.SetFontFace(face)
+			block = layoutCellText(dc, cell, width, size, lineHeightMultiplier, opts)
+			block.Descent = descentPerPoint * size
+			if block.fits(width, height) {
+				return block, false
+			}
+		}
+	case "ellipsis":
+		return ellipsizeBlock(dc, block, width, height), true
+	}
+	return block, true
+}
+
+// ellipsizeBlock keeps the lines that fit entirely within height (at least one) and truncates the last
+// kept line, and any line wider than width, with an ellipsis.
+func ellipsizeBlock(dc *gg.Context, block textBlock, width, height float64) textBlock {
+	visible := 0
+	for _, line := range block.Lines {
+		if visible > 0 && line.Baseline+block.Descent > height+epsilon {
+			break
+		}
+		visible++
+	}
+	hidden := visible < len(block.Lines)
+	block.Lines = append([]textLine(nil), block.Lines[:visible]...)
+	block.Width = 0
+	for i := range block.Lines {
+		w, _ := dc.MeasureString(block.Lines[i].Text)
+		if w > width+epsilon || (hidden && i == visible-1) {
+			block.Lines[i].Text = truncateWithEllipsis(dc, block.Lines[i].Text, width)
+			w, _ = dc.MeasureString(block.Lines[i].Text)
+		}
+		if w > block.Width {
+			block.Width = w
+		}
+	}
+	if visible > 0 {
+		last := block.Lines[visible-1]
+		block.Height = last.Baseline - block.FontSize + block.LineHeight
+	}
+	return block
+}
+
+// truncateWithEllipsis shortens text until it fits width with a trailing ellipsis.
+func truncateWithEllipsis(dc *gg.Context, text string, width float64) string {
+	runes := []rune(strings.TrimRight(text, " "))
+	for len(runes) > 0 {
+		if w, _ := dc.MeasureString(string(runes) + ellipsis); w <= width+epsilon {
+			break
+		}
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), " ") + ellipsis
+}
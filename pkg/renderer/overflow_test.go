@@ -0,0 +1,117 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestFitCellText(t *testing.T) {
+	const fontSize, lineHeightMultiplier = 12.0, 1.4
+	long := "This is a rather long text that will not fit the fixed cell size at all"
+	tests := []struct {
+		name           string
+		content        string
+		width, height  float64
+		mode           string
+		wantOverflowed bool
+		check          func(t *testing.T, block textBlock)
+	}{
+		{
+			name: "fitting text is untouched", content: "short", width: 100, height: 40, mode: "ellipsis",
+			check: func(t *testing.T, block textBlock) {
+				if len(block.Lines) != 1 || block.Lines[0].Text != "short" {
+					t.Errorf("got lines %+v", block.Lines)
+				}
+			},
+		},
+		{
+			// The line needs 12 points above its baseline and the descent below it, not its 16.8 point line height.
+			name: "a line fits down to its descent", content: "gypsy jig", width: 100, height: 16, mode: "shrink",
+			check: func(t *testing.T, block textBlock) {
+				if block.FontSize != fontSize || block.inkHeight() > 16 {
+					t.Errorf("font size %.1f, ink height %.1f; want the text untouched", block.FontSize, block.inkHeight())
+				}
+			},
+		},
+		{
+			name: "clip keeps every line", content: long, width: 94, height: 34, mode: "clip", wantOverflowed: true,
+			check: func(t *testing.T, block textBlock) {
+				if len(block.Lines) < 3 {
+					t.Errorf("expected all wrapped lines, got %d", len(block.Lines))
+				}
+			},
+		},
+		{
+			name: "ellipsis truncates the last visible line", content: long, width: 94, height: 34, mode: "ellipsis", wantOverflowed: true,
+			check: func(t *testing.T, block textBlock) {
+				if len(block.Lines) != 2 {
+					t.Fatalf("expected 2 visible lines, got %d: %+v", len(block.Lines), block.Lines)
+				}
+				if !strings.HasSuffix(block.Lines[1].Text, ellipsis) {
+					t.Errorf("last line %q should end with an ellipsis", block.Lines[1].Text)
+				}
+				if block.Width > 94+epsilon {
+					t.Errorf("ellipsized width %.1f exceeds 94", block.Width)
+				}
+			},
+		},
+		{
+			name: "ellipsis ends a hard-broken word", content: "Supercalifragilisticexpialidocious", width: 60, height: 40, mode: "ellipsis", wantOverflowed: true,
+			check: func(t *testing.T, block textBlock) {
+				if len(block.Lines) != 2 || !strings.HasSuffix(block.Lines[1].Text, ellipsis) || block.Width > 60+epsilon {
+					t.Errorf("got %+v (width %.1f)", block.Lines, block.Width)
+				}
+			},
+		},
+		{
+			name: "shrink reduces the font until the text fits", content: long, width: 94, height: 60, mode: "shrink",
+			check: func(t *testing.T, block textBlock) {
+				if block.FontSize >= fontSize || block.FontSize < minShrinkFontSize {
+					t.Errorf("font size %.1f not in [%.1f, %.1f)", block.FontSize, minShrinkFontSize, fontSize)
+				}
+				if !block.fits(94, 60) {
+					t.Errorf("shrunk block %.1fx%.1f does not fit", block.Width, block.Height)
+				}
+			},
+		},
+		{
+			name: "shrink stops at the minimum font size", content: long + " " + long + " " + long, width: 40, height: 20, mode: "shrink", wantOverflowed: true,
+			check: func(t *testing.T, block textBlock) {
+				if !floatEquals(block.FontSize, minShrinkFontSize, epsilon) {
+					t.Errorf("expected minimum font size, got %.1f", block.FontSize)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := gg.NewContext(1, 1)
+			if err := dc.LoadFontFace(defaultFontPath, fontSize); err != nil {
+				t.Skipf("font not available: %v", err)
+			}
+			cell := table.NewCell("", tt.content)
+			block, overflowed := fitCellText(dc, &cell, tt.width, tt.height, fontFile{path: defaultFontPath}, fontSize, lineHeightMultiplier, tt.mode, wrapOptions{})
+			if overflowed != tt.wantOverflowed {
+				t.Errorf("overflowed = %v, want %v", overflowed, tt.wantOverflowed)
+			}
+			tt.check(t, block)
+		})
+	}
+}
+
+func TestOverflowMode(t *testing.T) {
+	cell := table.NewCell("", "x")
+	if got := overflowMode(&cell, ""); got != "clip" {
+		t.Errorf("default: got %q, want clip", got)
+	}
+	if got := overflowMode(&cell, "shrink"); got != "shrink" {
+		t.Errorf("table setting: got %q, want shrink", got)
+	}
+	cell.Overflow = "expand"
+	if got := overflowMode(&cell, "shrink"); got != "expand" {
+		t.Errorf("cell setting: got %q, want expand", got)
+	}
+}
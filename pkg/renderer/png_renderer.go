@@ -1,15 +1,19 @@
 package renderer
 
 import (
+	"diagramgen/pkg/grid"
 	"diagramgen/pkg/table"
 	"fmt"
+	"image"
 	"image/color"
 	"log"
 	"math"    // For math.Min and math.Round
+	"os"
+	"path/filepath"
 	"runtime" // Added for OS-dependent font path
-	"strings" // Needed for parseHexColor if it uses strings.TrimPrefix
-	// "image" // No longer directly needed after subDc.Image() is image.Image
+	"strings"
 	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
 )
 
 // Constants for rendering.
@@ -22,34 +26,33 @@ const (
 	defaultCornerRadius         = 6.0
 	defaultMinCellWidth         = 30.0
 	defaultMinCellHeight        = 30.0
+	defaultHeaderBackground     = "#E8E8E8"
 	epsilon                     = 0.1
 )
 
-func parseHexColor(s string) (color.Color, error) {
-	if s == "" { return color.Transparent, fmt.Errorf("empty color string") }
-	s = strings.TrimPrefix(s, "#")
-	var r, g, b uint8
-	if len(s) == 3 {
-		_, err := fmt.Sscanf(s, "%1x%1x%1x", &r, &g, &b); if err != nil { return color.Transparent, fmt.Errorf("short hex: %w", err) }
-		r *= 17; g *= 17; b *= 17
-	} else if len(s) == 6 {
-		_, err := fmt.Sscanf(s, "%02x%02x%02x", &r, &g, &b); if err != nil { return color.Transparent, fmt.Errorf("hex: %w", err) }
-	} else { return color.Transparent, fmt.Errorf("invalid hex format: %s", s) }
-	return color.RGBA{R: r, G: g, B: b, A: 255}, nil
+// LimitCanvasWidth caps the width of t's rendered image at canvasWidth pixels by tightening its
+// max_width setting to the canvas width minus the margins.
+func LimitCanvasWidth(t *table.Table, canvasWidth float64) {
+	margin := defaultMargin; if t.Settings.Margin != nil { margin = *t.Settings.Margin }
+	limit := math.Max(canvasWidth-2*margin, 1)
+	if t.Settings.MaxWidth == 0 || limit < t.Settings.MaxWidth { t.Settings.MaxWidth = limit }
 }
 
-func RenderToPNG(mainTable *table.Table, allTables map[string]table.Table, outputPath string) error {
-	if mainTable == nil { return fmt.Errorf("input mainTable is nil") }
+// RenderToPNG draws mainTable, with the tables its cells refer to, into a PNG file. It returns the lint
+// messages found while drawing, such as cells whose text overflows, for the caller to show.
+func RenderToPNG(mainTable *table.Table, allTables map[string]table.Table, outputPath string) ([]string, error) {
+	if mainTable == nil { return nil, fmt.Errorf("input mainTable is nil") }
 	layoutGrid, err := PopulateOccupationMap(mainTable)
-	if err != nil { return fmt.Errorf("populate occupation map: %w", err) }
+	if err != nil { return nil, fmt.Errorf("populate occupation map: %w", err) }
 
+	margin := defaultMargin; if mainTable.Settings.Margin != nil { margin = *mainTable.Settings.Margin }
 	if layoutGrid.NumLogicalRows == 0 || layoutGrid.NumLogicalCols == 0 {
-		dcWidth := int(defaultMargin * 2); if dcWidth < 1 { dcWidth = 1 }
-		dcHeight := int(defaultMargin * 2); if dcHeight < 1 { dcHeight = 1 }
+		dcWidth := int(margin * 2); if dcWidth < 1 { dcWidth = 1 }
+		dcHeight := int(margin * 2); if dcHeight < 1 { dcHeight = 1 }
 		dc := gg.NewContext(dcWidth, dcHeight)
 		tableBG := mainTable.Settings.TableBackgroundColor; if tableBG == "" { tableBG = "#FFFFFF" }
-		if col, errBg := parseHexColor(tableBG); errBg == nil { dc.SetColor(col) } else { dc.SetColor(color.White) }
-		dc.Clear(); log.Println("RenderToPNG: Empty table. Saving minimal image."); return dc.SavePNG(outputPath)
+		dc.SetColor(color.White); dc.Clear(); setFill(dc, tableBG, "#FFFFFF", 0, 0, float64(dcWidth), float64(dcHeight)); dc.DrawRectangle(0, 0, float64(dcWidth), float64(dcHeight)); dc.Fill()
+		 log.Println("RenderToPNG: Empty table. Saving minimal image."); return nil, dc.SavePNG(outputPath)
 	}
 
 	var osSpecificFontPath string
@@ -62,206 +65,333 @@ func RenderToPNG(mainTable *table.Table, allTables map[string]table.Table, outpu
 	layoutConsts := LayoutConstants{
 		FontPath: osSpecificFontPath, FontSize: defaultFontSize, LineHeightMultiplier: defaultLineHeightMultiplier,
 		Padding: defaultPadding, MinCellWidth: defaultMinCellWidth, MinCellHeight: defaultMinCellHeight,
+		CornerRadius: defaultCornerRadius, Margin: defaultMargin, BoldFontPath: boldFontVariant(osSpecificFontPath),
 	}
-	if err = layoutGrid.CalculateColumnWidthsAndRowHeights(layoutConsts, allTables); err != nil { return fmt.Errorf("calc sizes: %w", err) }
-	layoutGrid.CalculateFinalCellLayouts(defaultMargin)
+	layoutConsts = layoutConsts.forTable(mainTable, false)
+	if err = layoutGrid.CalculateColumnWidthsAndRowHeights(layoutConsts, allTables); err != nil { return nil, fmt.Errorf("calc sizes: %w", err) }
+	layoutGrid.CalculateFinalCellLayouts(layoutConsts.Margin)
 
 	canvasW := int(layoutGrid.CanvasWidth); if canvasW <= 0 { canvasW = 1 }
 	canvasH := int(layoutGrid.CanvasHeight); if canvasH <= 0 { canvasH = 1 }
 	dc := gg.NewContext(canvasW, canvasH)
 
-	canvasBgColorHex := mainTable.Settings.TableBackgroundColor; if canvasBgColorHex == "" { canvasBgColorHex = "#FFFFFF" }
-	if col, errBg := parseHexColor(canvasBgColorHex); errBg == nil { dc.SetColor(col) } else { dc.SetColor(color.White) }
-	dc.Clear()
+	// The table background itself is painted by drawTableItself; start from white when it is unset or unusable.
+	if _, errBg := parseFill(mainTable.Settings.TableBackgroundColor); errBg != nil { dc.SetColor(color.White); dc.Clear() }
 
-	if err = drawTableItself(dc, mainTable, layoutGrid, allTables, layoutConsts); err != nil { return fmt.Errorf("draw main table: %w", err) }
-	return dc.SavePNG(outputPath)
+	lint := &renderLint{}
+	if err = drawTableItself(dc, mainTable, layoutGrid, allTables, layoutConsts, lint); err != nil { return nil, fmt.Errorf("draw main table: %w", err) }
+	return lint.messages, dc.SavePNG(outputPath)
 }
 
-func drawTableItself(dc *gg.Context, tableToDraw *table.Table, lg *LayoutGrid, allTables map[string]table.Table, lConsts LayoutConstants) error {
+// resolveCellPaint resolves the background, text color and horizontal alignment of a grid cell through the
+// cascade cell -> row -> column -> table. Header cells use the table's header colors and are centered
+// unless the cell, its row or its column says otherwise. Other cells default to "start": left for left-to-right
+// text and right for right-to-left text. An empty text color means black.
+func resolveCellPaint(tbl *table.Table, gridCell GridCellInfo, isHeader bool) (bg, fg, align string) {
+	cell := gridCell.OriginalCell
+	var row table.Row; if gridCell.GridR < len(tbl.Rows) { row = tbl.Rows[gridCell.GridR] }
+	var column table.ColumnSpec; if gridCell.GridC < len(tbl.Columns) { column = tbl.Columns[gridCell.GridC] }
+	firstSet := func(values ...string) string { for _, v := range values { if v != "" { return v } }; return "" }
+	if isHeader {
+		bg = firstSet(cell.BackgroundColor, row.BackgroundColor, tbl.Settings.HeaderBackgroundColor, defaultHeaderBackground)
+		fg = firstSet(cell.TextColor, row.TextColor, tbl.Settings.HeaderTextColor, tbl.Settings.TextColor)
+		align = firstSet(cell.Align, row.Align, column.Align, "center")
+		return bg, fg, align
+	}
+	bg = firstSet(cell.BackgroundColor, row.BackgroundColor, column.BackgroundColor, tbl.Settings.DefaultCellBackgroundColor, "#FFFFFF")
+	fg = firstSet(cell.TextColor, row.TextColor, column.TextColor, tbl.Settings.TextColor)
+	align = firstSet(cell.Align, row.Align, column.Align, "start")
+	return bg, fg, align
+}
+
+// firstNonEmpty returns the first non-empty string, shortened for log messages.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values { if v != "" { if r := []rune(v); len(r) > 30 { return string(r[:30]) + ellipsis }; return v } }
+	return ""
+}
+
+// rotateTextFrame turns dc's coordinate system clockwise by degrees (0, 90, 180 or 270) about a
+// width x height content area, so text laid out in the turned frame starting at (0, 0) fills the area.
+func rotateTextFrame(dc *gg.Context, degrees int, width, height float64) {
+	switch degrees {
+	case 90: dc.Translate(width, 0); dc.Rotate(gg.Radians(90))
+	case 180: dc.Translate(width, height); dc.Rotate(gg.Radians(180))
+	case 270: dc.Translate(0, height); dc.Rotate(gg.Radians(-90))
+	}
+}
+
+// contextScale returns how much dc's transform stretches the x and y axes: 1 for the main table,
+// the scale of a nested table drawn straight at its final size otherwise.
+func contextScale(dc *gg.Context) (sx, sy float64) {
+	x0, y0 := dc.TransformPoint(0, 0); x1, y1 := dc.TransformPoint(1, 0); x2, y2 := dc.TransformPoint(0, 1)
+	return math.Hypot(x1-x0, y1-y0), math.Hypot(x2-x0, y2-y0)
+}
+
+// deviceLineWidth returns the pixel width of a width-wide line in a table drawn at scale s: gg does not
+// transform line widths. Lines keep at least one pixel (or their own width if thinner) so they do not vanish.
+func deviceLineWidth(width, s float64) float64 { return math.Max(width*s, math.Min(width, 1)) }
+
+// drawDeviceImage draws an image that is already at dc's pixel resolution with its top-left corner at
+// (x, y), without resampling it through dc's transform.
+func drawDeviceImage(dc *gg.Context, im image.Image, x, y float64) {
+	px, py := dc.TransformPoint(x, y)
+	dc.Push(); dc.Identity(); dc.DrawImage(im, int(math.Round(px)), int(math.Round(py))); dc.Pop()
+}
+
+// drawDeviceString draws s at (x, y) with face, which is dc's font loaded at k times its size: gg
+// rasterizes glyphs at the face size before transforming them, so text in a context scaled by k is only
+// sharp when the glyphs are drawn from a face that is already k times larger.
+func drawDeviceString(dc *gg.Context, face font.Face, k float64, s string, x, y float64) {
+	if face == nil { dc.DrawString(s, x, y); return }
+	dc.Push(); dc.SetFontFace(face); dc.Scale(1/k, 1/k); dc.DrawString(s, x*k, y*k); dc.Pop()
+}
+
+// innerScaleSize returns the size of a natW x natH nested table or image scaled into an areaW x areaH
+// area by an inner_scale mode ("none", "reflow", "expand", "fit_width", "fit_height", "fit_both" or
+// "fill_stretch").
+func innerScaleSize(mode string, natW, natH, areaW, areaH float64) (w, h float64) {
+	switch mode {
+	case "fit_width": if natW > 0 && areaW > 0 { return areaW, natH * areaW / natW }
+	case "fit_height": if natH > 0 && areaH > 0 { return natW * areaH / natH, areaH }
+	case "fit_both": if natW > 0 && natH > 0 && areaW > 0 && areaH > 0 { f := math.Min(areaW/natW, areaH/natH); return natW * f, natH * f }
+	case "fill_stretch": if areaW > 0 && areaH > 0 { return areaW, areaH }
+	case "reflow": if natW > 0 && natH > 0 && areaW > 0 && areaH > 0 { f := math.Min(1, math.Min(areaW/natW, areaH/natH)); return natW * f, natH * f }
+	}
+	return natW, natH // "none" and "expand", whose cell is sized to fit
+}
+
+// innerAlignOffset returns the position of a w x h nested table or image in an areaW x areaH area for
+// an inner_align value such as "top_left" (the default) or "middle_center".
+func innerAlignOffset(align string, areaW, areaH, w, h float64) (x, y float64) {
+	switch align {
+	case "top_center": x = (areaW - w) / 2
+	case "top_right": x = areaW - w
+	case "middle_left": y = (areaH - h) / 2
+	case "center", "middle_center": x = (areaW - w) / 2; y = (areaH - h) / 2
+	case "middle_right": x = areaW - w; y = (areaH - h) / 2
+	case "bottom_left": y = areaH - h
+	case "bottom_center": x = (areaW - w) / 2; y = areaH - h
+	case "bottom_right": x = areaW - w; y = areaH - h
+	}
+	return x, y
+}
+
+// boldFontVariant returns the bold sibling of a font file following the common "<name>-Bold.ttf"
+// naming (e.g. DejaVuSans-Bold.ttf), or "" if there is none.
+func boldFontVariant(fontPath string) string {
+	ext := filepath.Ext(fontPath)
+	candidate := strings.TrimSuffix(fontPath, ext) + "-Bold" + ext
+	if _, err := os.Stat(candidate); err != nil { log.Printf("Info: no bold font found at '%s'; header text uses a synthetic bold of the regular font.", candidate); return "" }
+	return candidate
+}
+
+func drawTableItself(dc *gg.Context, tableToDraw *table.Table, lg *LayoutGrid, allTables map[string]table.Table, lConsts LayoutConstants, lint *renderLint) error {
 	log.Printf("drawTableItself START: Drawing table ID '%s' on dc (size %dx%d)", tableToDraw.ID, dc.Width(), dc.Height())
+	// Scaled nested tables are drawn through a transformed dc at their final size: cell contents are
+	// rendered at that resolution and line widths, which gg does not transform, are scaled by hand.
+	sx, sy := contextScale(dc); lineScale := math.Sqrt(sx * sy)
 	if tableToDraw.Settings.TableBackgroundColor != "" {
-		if col, err := parseHexColor(tableToDraw.Settings.TableBackgroundColor); err == nil { dc.SetColor(col); dc.Clear()
+		if _, err := parseFill(tableToDraw.Settings.TableBackgroundColor); err == nil {
+			w, h := float64(dc.Width())/sx, float64(dc.Height())/sy
+			setFill(dc, tableToDraw.Settings.TableBackgroundColor, "transparent", 0, 0, w, h); dc.DrawRectangle(0, 0, w, h); dc.Fill()
 		} else { log.Printf("Error parsing table BG color '%s' for table '%s': %v", tableToDraw.Settings.TableBackgroundColor, tableToDraw.ID, err) }
 	}
 	// Note: Font is loaded onto the main dc by RenderToPNG. For subDc in recursion, it's loaded there.
 	// If this drawTableItself is called for the main table, font is loaded by the caller.
 	// If it's for a sub-table, its subDc needs font loading. (This is handled in the IsTableRef block for subDc)
 
+	// In collapse mode cells are square and clipped to one rounded outer frame; grid lines are drawn after the cells.
+	collapsed := tableToDraw.Settings.BorderMode == "collapse" && len(lg.ColumnX) > 1 && len(lg.RowY) > 1
+	frameX, frameY, frameW, frameH := 0.0, 0.0, 0.0, 0.0
+	if collapsed {
+		frameX, frameY = lg.ColumnX[0], lg.RowY[0]; frameW, frameH = lg.ColumnX[len(lg.ColumnX)-1]-frameX, lg.RowY[len(lg.RowY)-1]-frameY
+		dc.DrawRoundedRectangle(frameX, frameY, frameW, frameH, lConsts.CornerRadius); dc.Clip()
+	}
+
+	square := squareCells(lg)
 	for _, gridCell := range lg.GridCells {
 		cell := gridCell.OriginalCell
 		log.Printf("CELL [%d,%d]: Start. Title:'%s', Content:'%.20s', IsRef:%t. Geom: X:%.1f, Y:%.1f, W:%.1f, H:%.1f", gridCell.GridR, gridCell.GridC, cell.Title, strings.ReplaceAll(cell.Content, "\n", "\\n"), cell.IsTableRef, gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height)
 
-		cellBgColorHex := cell.BackgroundColor; if cellBgColorHex == "" { cellBgColorHex = tableToDraw.Settings.DefaultCellBackgroundColor }
-		if cellBgColorHex == "" { cellBgColorHex = "#FFFFFF" }
-		cellBg, _ := parseHexColor(cellBgColorHex); dc.SetColor(cellBg)
-		dc.DrawRoundedRectangle(gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height, defaultCornerRadius); dc.Fill()
-
-		edgeColorHex := tableToDraw.Settings.EdgeColor; if edgeColorHex == "" { edgeColorHex = "#000000" }
-		edgeCol, _ := parseHexColor(edgeColorHex); dc.SetColor(edgeCol)
-		edgeThickness := float64(tableToDraw.Settings.EdgeThickness); if edgeThickness <= 0 { edgeThickness = 1.0 }
-		dc.SetLineWidth(edgeThickness)
-		dc.DrawRoundedRectangle(gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height, defaultCornerRadius); dc.Stroke()
+		isHeader := lg.IsHeaderCell(gridCell.GridR, gridCell.GridC)
+		cellBgColorHex, textColorValue, textAlign := resolveCellPaint(tableToDraw, gridCell, isHeader)
+		setFill(dc, cellBgColorHex, "#FFFFFF", gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height)
+		if collapsed || square[cell] {
+			// Cells with per-side borders and their neighbours (and all cells in collapse mode) are square; their edges are stroked by the border pass below.
+			dc.DrawRectangle(gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height); dc.Fill()
+		} else {
+			cornerRadius := lConsts.cellCornerRadius(cell)
+			dc.DrawRoundedRectangle(gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height, cornerRadius); dc.Fill()
+
+			edgeColorHex := tableToDraw.Settings.EdgeColor; if edgeColorHex == "" { edgeColorHex = "#000000" }
+			edgeCol, _ := parseColor(edgeColorHex); dc.SetColor(edgeCol)
+			edgeThickness := float64(tableToDraw.Settings.EdgeThickness); if edgeThickness <= 0 { edgeThickness = 1.0 }
+			dc.SetLineWidth(deviceLineWidth(edgeThickness, lineScale))
+			dc.DrawRoundedRectangle(gridCell.X, gridCell.Y, gridCell.Width, gridCell.Height, cornerRadius); dc.Stroke()
+		}
 
 		// --- New strategy: Draw content to a temporary context, then draw that context's image ---
-		contentAreaX_on_main_dc := gridCell.X + lConsts.Padding
-		contentAreaY_on_main_dc := gridCell.Y + lConsts.Padding
-		contentAreaW := gridCell.Width - (2 * lConsts.Padding)
-		contentAreaH := gridCell.Height - (2 * lConsts.Padding)
+		padT, padR, padB, padL := lConsts.cellPadding(cell)
+		contentAreaX_on_main_dc := gridCell.X + padL
+		contentAreaY_on_main_dc := gridCell.Y + padT
+		contentAreaW := gridCell.Width - (padL + padR)
+		contentAreaH := gridCell.Height - (padT + padB)
 
 		if contentAreaW <= 0 || contentAreaH <= 0 {
 			log.Printf("CELL [%d,%d]: Content area is zero or negative (W:%.1f, H:%.1f). Skipping content drawing.", gridCell.GridR, gridCell.GridC, contentAreaW, contentAreaH)
 			continue
 		}
-		roundedContentW, roundedContentH := int(math.Round(contentAreaW)), int(math.Round(contentAreaH))
+		roundedContentW, roundedContentH := int(math.Round(contentAreaW*sx)), int(math.Round(contentAreaH*sy))
 		if roundedContentW <= 0 || roundedContentH <= 0 {
 			log.Printf("CELL [%d,%d]: Rounded content area is zero or negative (W:%d, H:%d). Skipping content drawing.", gridCell.GridR, gridCell.GridC, roundedContentW, roundedContentH)
 			continue
 		}
 
-		contentDc := gg.NewContext(roundedContentW, roundedContentH)
-		if errFont := contentDc.LoadFontFace(lConsts.FontPath, lConsts.FontSize); errFont != nil {
+		contentDc := gg.NewContext(roundedContentW, roundedContentH); contentDc.Scale(sx, sy) // In pixels, drawn in the cell's own units.
+		cellFont := lConsts.cellFont(isHeader)
+		if face, errFont := cellFont.face(lConsts.FontSize); errFont != nil {
 			log.Printf("CELL [%d,%d]: Error loading font for contentDc: %v", gridCell.GridR, gridCell.GridC, errFont)
 			// Continue, default font might be used or text might be missing.
-		}
+		} else { contentDc.SetFontFace(face) }
 
-		// All drawing coordinates from here are relative to contentDc (origin 0,0)
-
-		if cell.IsTableRef {
-			log.Printf("CELL [%d,%d]: IsTableRef TRUE. RefID: '%s'", gridCell.GridR, gridCell.GridC, cell.TableRefID)
-			if cell.TableRefID == "" { log.Printf("CELL [%d,%d]: Warning: TableRefID is empty. Skipping.", gridCell.GridR, gridCell.GridC); continue }
-			refTable, ok := allTables[cell.TableRefID]
-			if !ok { log.Printf("CELL [%d,%d]: Warning: Referenced table ID '%s' not found. Skipping.", gridCell.GridR, gridCell.GridC, cell.TableRefID); continue }
-
-			// Parent content area for alignment/scaling is contentDc's size
-			parentEffContentW, parentEffContentH := float64(roundedContentW), float64(roundedContentH)
-			log.Printf("CELL [%d,%d]: InnerTable: ID '%s'. ParentEffectiveContentArea W:%.1f, H:%.1f", gridCell.GridR, gridCell.GridC, refTable.ID, parentEffContentW, parentEffContentH)
-
-			innerLg, mapErr := PopulateOccupationMap(&refTable)
-			if mapErr != nil { log.Printf("CELL [%d,%d]: Error populating inner map for '%s': %v. Skipping.", gridCell.GridR, gridCell.GridC, refTable.ID, mapErr); continue }
-			if innerLg.NumLogicalRows == 0 || innerLg.NumLogicalCols == 0 { log.Printf("CELL [%d,%d]: Info: Inner table '%s' is empty. Skipping.", gridCell.GridR, gridCell.GridC, refTable.ID); continue }
-
-			calcErr := innerLg.CalculateColumnWidthsAndRowHeights(lConsts, allTables)
-			if calcErr != nil { log.Printf("CELL [%d,%d]: Error calculating inner layout for '%s': %v. Skipping.", gridCell.GridR, gridCell.GridC, refTable.ID, calcErr); continue }
-			innerLg.CalculateFinalCellLayouts(0)
-
-			innerDcWidth := int(innerLg.CanvasWidth); innerDcHeight := int(innerLg.CanvasHeight)
-			log.Printf("CELL [%d,%d]: InnerTable: Natural canvas size W:%d, H:%d for subDc.", gridCell.GridR, gridCell.GridC, innerDcWidth, innerDcHeight)
-			if innerDcWidth <= 0 || innerDcHeight <= 0 { log.Printf("CELL [%d,%d]: Warning: Inner table '%s' zero/neg dims (W:%d, H:%d). Skipping.", gridCell.GridR, gridCell.GridC, refTable.ID, innerDcWidth, innerDcHeight); continue }
-
-			subDc := gg.NewContext(innerDcWidth, innerDcHeight) // This is for the inner table's natural size
-			drawErr := drawTableItself(subDc, &refTable, innerLg, allTables, lConsts)
-			if drawErr != nil { log.Printf("CELL [%d,%d]: Error drawing inner table '%s': %v. Skipping.", gridCell.GridR, gridCell.GridC, refTable.ID, drawErr); continue }
-
-			naturalInnerTableImage := subDc.Image()
-			naturalInnerWidth := float64(naturalInnerTableImage.Bounds().Dx())
-			naturalInnerHeight := float64(naturalInnerTableImage.Bounds().Dy())
-
-			if naturalInnerWidth > 0 && naturalInnerHeight > 0 {
-				scaledW, scaledH := naturalInnerWidth, naturalInnerHeight
-				imageToDraw := naturalInnerTableImage
-				performScale := false
-
-				switch cell.InnerTableScaleMode {
-				case "fit_width":
-					if naturalInnerWidth > 0 && parentEffContentW > 0 {
-						scaleFactor := parentEffContentW / naturalInnerWidth
-						scaledW = parentEffContentW; scaledH = naturalInnerHeight * scaleFactor; performScale = true
-					}
-				case "fit_height":
-					if naturalInnerHeight > 0 && parentEffContentH > 0 {
-						scaleFactor := parentEffContentH / naturalInnerHeight
-						scaledH = parentEffContentH; scaledW = naturalInnerWidth * scaleFactor; performScale = true
-					}
-				case "fit_both":
-					if naturalInnerWidth > 0 && naturalInnerHeight > 0 && parentEffContentW > 0 && parentEffContentH > 0 {
-						scaleFactorW := parentEffContentW / naturalInnerWidth
-						scaleFactorH := parentEffContentH / naturalInnerHeight
-						scaleFactor := math.Min(scaleFactorW, scaleFactorH)
-						if scaleFactor > 0 { scaledW = naturalInnerWidth * scaleFactor; scaledH = naturalInnerHeight * scaleFactor; performScale = true }
-					}
-				case "fill_stretch":
-					if parentEffContentW > 0 && parentEffContentH > 0 {
-						scaledW = parentEffContentW; scaledH = parentEffContentH
-						if math.Abs(scaledW-naturalInnerWidth) > epsilon || math.Abs(scaledH-naturalInnerHeight) > epsilon { performScale = true }
-					}
-				} // Default "none" means scaledW/H remain naturalInnerWidth/Height
-
-				if scaledW <= 0 || scaledH <= 0 { imageToDraw = nil }
-
-				if performScale && imageToDraw != nil && (math.Abs(scaledW-naturalInnerWidth) > epsilon || math.Abs(scaledH-naturalInnerHeight) > epsilon) {
-					rSw, rSh := int(math.Round(scaledW)), int(math.Round(scaledH))
-					if rSw > 0 && rSh > 0 {
-						scaledSubDc := gg.NewContext(rSw, rSh)
-						scaledSubDc.Scale(float64(rSw)/naturalInnerWidth, float64(rSh)/naturalInnerHeight)
-						scaledSubDc.DrawImage(naturalInnerTableImage, 0, 0)
-						imageToDraw = scaledSubDc.Image()
-						scaledW, scaledH = float64(imageToDraw.Bounds().Dx()), float64(imageToDraw.Bounds().Dy())
-					} else { imageToDraw = nil }
-				}
-				log.Printf("CELL [%d,%d]: InnerTable: ScaledDims W:%.1f, H:%.1f. AlignMode:'%s', ScaleMode:'%s'", gridCell.GridR, gridCell.GridC, scaledW, scaledH, cell.InnerTableAlignment, cell.InnerTableScaleMode)
-
-				offsetX, offsetY := 0.0, 0.0
-				if imageToDraw != nil && scaledW > 0 && scaledH > 0 {
-					switch cell.InnerTableAlignment {
-					case "top_center": offsetX = (parentEffContentW - scaledW) / 2
-					case "top_right": offsetX = parentEffContentW - scaledW
-					case "middle_left": offsetY = (parentEffContentH - scaledH) / 2
-					case "center", "middle_center": offsetX = (parentEffContentW - scaledW) / 2; offsetY = (parentEffContentH - scaledH) / 2
-					case "middle_right": offsetX = parentEffContentW - scaledW; offsetY = (parentEffContentH - scaledH) / 2
-					case "bottom_left": offsetY = parentEffContentH - scaledH
-					case "bottom_center": offsetX = (parentEffContentW - scaledW) / 2; offsetY = parentEffContentH - scaledH
-					case "bottom_right": offsetX = parentEffContentW - scaledW; offsetY = parentEffContentH - scaledH
-					}
-				}
-
-				if imageToDraw != nil && scaledW > 0 && scaledH > 0 {
-					log.Printf("CELL [%d,%d]: InnerTable: Drawing image on contentDc at X:%.1f, Y:%.1f", gridCell.GridR, gridCell.GridC, math.Round(offsetX), math.Round(offsetY))
-					contentDc.DrawImage(imageToDraw, int(math.Round(offsetX)), int(math.Round(offsetY)))
-
-					log.Printf("CELL [%d,%d]: InnerTable: Drawing special border on contentDc at X:%.1f, Y:%.1f, W:%.1f, H:%.1f", gridCell.GridR, gridCell.GridC, math.Round(offsetX), math.Round(offsetY), math.Round(scaledW), math.Round(scaledH))
-					borderColHex := tableToDraw.Settings.EdgeColor; if borderColHex == "" { borderColHex = "#000000" }
-					parsedBorderCol, errBr := parseHexColor(borderColHex); if errBr != nil { parsedBorderCol = color.Black }
-					contentDc.SetColor(parsedBorderCol); contentDc.SetLineWidth(1.0); contentDc.SetDash()
-					contentDc.DrawRectangle(math.Round(offsetX), math.Round(offsetY), math.Round(scaledW), math.Round(scaledH)); contentDc.Stroke()
-				}
-			} else if naturalInnerWidth == 0 || naturalInnerHeight == 0 {
-                 log.Printf("CELL [%d,%d]: Info: Inner table '%s' for cell '%s' has zero natural dimensions. Nothing to draw.", gridCell.GridR, gridCell.GridC, refTable.ID, cell.Title)
-            }
-		} else { // Not IsTableRef - draw text content
-			contentDc.SetColor(color.Black) // Assuming text is black
-			textStartX := 0.0 // Relative to contentDc
-			textAvailableWidth := float64(roundedContentW)
-
-			contentAreaTopY_on_contentDc := 0.0
-			contentAreaBottomY_on_contentDc := float64(roundedContentH)
-			lineHeight := lConsts.FontSize * lConsts.LineHeightMultiplier
-			firstLineBaselineOffsetY := lConsts.FontSize
-			currentBaselineY := contentAreaTopY_on_contentDc + firstLineBaselineOffsetY
-			titleProcessed := false
-
-			if cell.Title != "" {
-				log.Printf("CELL [%d,%d]: Text: Drawing title. Initial baselineY: %.1f", gridCell.GridR, gridCell.GridC, currentBaselineY)
-				titleLines := contentDc.WordWrap("["+cell.Title+"]", textAvailableWidth)
-				for _, line := range titleLines {
-					if currentBaselineY < contentAreaBottomY_on_contentDc+epsilon {
-						contentDc.DrawString(line, textStartX, currentBaselineY); currentBaselineY += lineHeight; titleProcessed = true
-					} else { break }
-				}
-			}
-			if cell.Content != "" {
-				if titleProcessed && (currentBaselineY <= contentAreaBottomY_on_contentDc+epsilon) { currentBaselineY += lineHeight * 0.25 }
-				log.Printf("CELL [%d,%d]: Text: Drawing content. Initial baselineY: %.1f", gridCell.GridR, gridCell.GridC, currentBaselineY)
-				contentLines := contentDc.WordWrap(cell.Content, textAvailableWidth)
-				for _, line := range contentLines {
-					if currentBaselineY < contentAreaBottomY_on_contentDc+epsilon {
-						contentDc.DrawString(line, textStartX, currentBaselineY); currentBaselineY += lineHeight
-					} else { break }
-				}
-			}
-		}
+		// All drawing coordinates from here are relative to contentDc (origin 0,0), in the table's units
+
+		textCol := color.Color(color.Black)
+		if textColorValue != "" { if col, errFg := parseColor(textColorValue); errFg == nil { textCol = col } else { log.Printf("CELL [%d,%d]: Error parsing text color '%s': %v. Using black.", gridCell.GridR, gridCell.GridC, textColorValue, errFg) } }
+		cd := cellDrawing{table: tableToDraw, allTables: allTables, consts: lConsts, lint: lint, r: gridCell.GridR, c: gridCell.GridC, font: cellFont, textColor: textCol, textAlign: textAlign}
+		if cell.HasBlocks() { cd.drawBlocks(contentDc, cell, contentAreaW, contentAreaH) } else if cell.IsTableRef { cd.drawNestedTable(contentDc, cell, contentAreaW, contentAreaH) } else { cd.drawText(contentDc, cell, contentAreaW, contentAreaH) }
 		// Draw the contentDc (with all its drawings) onto the main dc
-		dc.DrawImage(contentDc.Image(), int(math.Round(contentAreaX_on_main_dc)), int(math.Round(contentAreaY_on_main_dc)))
+		drawDeviceImage(dc, contentDc.Image(), contentAreaX_on_main_dc, contentAreaY_on_main_dc)
 	}
+
+	if collapsed {
+		dc.ResetClip()
+		drawBorderSegments(dc, resolveBorderSegments(tableToDraw, lg, func(c *table.Cell) bool { return true }, true))
+		edgeColorHex := tableToDraw.Settings.EdgeColor; if edgeColorHex == "" { edgeColorHex = "#000000" }
+		edgeCol, _ := parseColor(edgeColorHex); dc.SetColor(edgeCol)
+		edgeThickness := float64(tableToDraw.Settings.EdgeThickness); if edgeThickness <= 0 { edgeThickness = 1.0 }
+		dc.SetLineWidth(deviceLineWidth(edgeThickness, lineScale))
+		dc.DrawRoundedRectangle(frameX, frameY, frameW, frameH, lConsts.CornerRadius); dc.Stroke()
+		drawCellLinks(dc, tableToDraw, lg, lineScale) // Links go over the grid lines.
+		return nil
+	}
+	// Edges of square cells are resolved once per shared edge and drawn on top.
+	segments := resolveBorderSegments(tableToDraw, lg, func(c *table.Cell) bool { return square[c] }, false)
+	drawBorderSegments(dc, segments)
+	drawCellLinks(dc, tableToDraw, lg, lineScale)
 	return nil
 }
+
+// cellDrawing holds what drawing the content of a cell needs besides the cell: its table, the
+// resolved font and paint, and its grid position for log messages.
+type cellDrawing struct {
+	table     *table.Table
+	allTables map[string]table.Table
+	consts    LayoutConstants
+	lint      *renderLint
+	r, c      int
+	font      fontFile
+	textColor color.Color
+	textAlign string
+}
+
+// renderLint collects the lint messages of a rendering, each once: a nested table drawn in several
+// cells would otherwise repeat its findings.
+type renderLint struct {
+	messages []string
+	seen     map[string]bool
+}
+
+func (l *renderLint) add(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if l.seen[message] {
+		return
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	l.seen[message] = true
+	l.messages = append(l.messages, message)
+}
+
+// drawNestedTable draws the table a cell refers to into the w x h content area at dc's origin, scaled
+// and aligned by the cell's inner_scale and inner_align.
+func (cd cellDrawing) drawNestedTable(dc *gg.Context, cell *table.Cell, w, h float64) {
+	sx, sy := contextScale(dc); lineScale := math.Sqrt(sx * sy)
+	log.Printf("CELL [%d,%d]: IsTableRef TRUE. RefID: '%s'", cd.r, cd.c, cell.TableRefID)
+	if cell.TableRefID == "" { log.Printf("CELL [%d,%d]: Warning: TableRefID is empty. Skipping.", cd.r, cd.c); return }
+	refTable, ok := cd.allTables[cell.TableRefID]
+	if !ok { log.Printf("CELL [%d,%d]: Warning: Referenced table ID '%s' not found. Skipping.", cd.r, cd.c, cell.TableRefID); return }
+
+	// Parent content area for alignment/scaling
+	parentEffContentW, parentEffContentH := w, h
+	log.Printf("CELL [%d,%d]: InnerTable: ID '%s'. ParentEffectiveContentArea W:%.1f, H:%.1f", cd.r, cd.c, refTable.ID, parentEffContentW, parentEffContentH)
+
+	// Unscaled inner tables are laid out to fit the content area, as they were measured.
+	containerWidth := 0.0; if reflowsInnerTable(cell) { containerWidth = w }
+	innerLg, innerConsts, layoutErr := layoutInnerTable(&refTable, cd.consts, cd.allTables, containerWidth)
+	if layoutErr != nil { log.Printf("CELL [%d,%d]: Error laying out inner table '%s': %v. Skipping.", cd.r, cd.c, refTable.ID, layoutErr); return }
+	if innerLg.NumLogicalRows == 0 || innerLg.NumLogicalCols == 0 { log.Printf("CELL [%d,%d]: Info: Inner table '%s' is empty. Skipping.", cd.r, cd.c, refTable.ID); return }
+
+	naturalInnerWidth, naturalInnerHeight := innerLg.CanvasWidth, innerLg.CanvasHeight
+	log.Printf("CELL [%d,%d]: InnerTable: Natural canvas size W:%.1f, H:%.1f.", cd.r, cd.c, naturalInnerWidth, naturalInnerHeight)
+	if naturalInnerWidth <= 0 || naturalInnerHeight <= 0 { log.Printf("CELL [%d,%d]: Info: Inner table '%s' for cell '%s' has zero natural dimensions. Nothing to draw.", cd.r, cd.c, refTable.ID, cell.Title); return }
+
+	scaledW, scaledH := innerScaleSize(cell.InnerTableScaleMode, naturalInnerWidth, naturalInnerHeight, parentEffContentW, parentEffContentH)
+	// The inner table is drawn straight at its final size in pixels (this cell's scale included), through a
+	// transform from its natural layout, so its text and lines stay sharp instead of being resampled.
+	subW, subH := int(math.Round(scaledW*sx)), int(math.Round(scaledH*sy))
+	if subW <= 0 || subH <= 0 { log.Printf("CELL [%d,%d]: Warning: Inner table '%s' scales to nothing (W:%d, H:%d). Skipping.", cd.r, cd.c, refTable.ID, subW, subH); return }
+	subDc := gg.NewContext(subW, subH)
+	subDc.Scale(float64(subW)/naturalInnerWidth, float64(subH)/naturalInnerHeight)
+	drawErr := drawTableItself(subDc, &refTable, innerLg, cd.allTables, innerConsts, cd.lint)
+	if drawErr != nil { log.Printf("CELL [%d,%d]: Error drawing inner table '%s': %v. Skipping.", cd.r, cd.c, refTable.ID, drawErr); return }
+	scaledW, scaledH = float64(subW)/sx, float64(subH)/sy
+	log.Printf("CELL [%d,%d]: InnerTable: ScaledDims W:%.1f, H:%.1f (%dx%d pixels). AlignMode:'%s', ScaleMode:'%s'", cd.r, cd.c, scaledW, scaledH, subW, subH, cell.InnerTableAlignment, cell.InnerTableScaleMode)
+
+	offsetX, offsetY := innerAlignOffset(cell.InnerTableAlignment, parentEffContentW, parentEffContentH, scaledW, scaledH)
+	log.Printf("CELL [%d,%d]: InnerTable: Drawing image on dc at X:%.1f, Y:%.1f", cd.r, cd.c, math.Round(offsetX), math.Round(offsetY))
+	drawDeviceImage(dc, subDc.Image(), math.Round(offsetX), math.Round(offsetY))
+
+	log.Printf("CELL [%d,%d]: InnerTable: Drawing special border on dc at X:%.1f, Y:%.1f, W:%.1f, H:%.1f", cd.r, cd.c, math.Round(offsetX), math.Round(offsetY), math.Round(scaledW), math.Round(scaledH))
+	borderColHex := cd.table.Settings.EdgeColor; if borderColHex == "" { borderColHex = "#000000" }
+	parsedBorderCol, errBr := parseColor(borderColHex); if errBr != nil { parsedBorderCol = color.Black }
+	dc.SetColor(parsedBorderCol); dc.SetLineWidth(deviceLineWidth(1.0, lineScale)); dc.SetDash()
+	dc.DrawRectangle(math.Round(offsetX), math.Round(offsetY), math.Round(scaledW), math.Round(scaledH)); dc.Stroke()
+}
+
+// drawText draws the image of a cell, if any, and its text into the w x h content area at dc's origin.
+func (cd cellDrawing) drawText(dc *gg.Context, cell *table.Cell, w, h float64) {
+	sx, sy := contextScale(dc); lineScale := math.Sqrt(sx * sy)
+	// An image takes its strip of the content area (icons are drawn in the text color); the text gets the rest.
+	textArea := contentRect{0, 0, w, h}
+	if cell.HasImage() { log.Printf("CELL [%d,%d]: Image: '%s%s' at '%s'.", cd.r, cd.c, cell.Image, cell.Icon, firstNonEmpty(cell.ImagePosition, "left")); textArea = drawCellImage(dc, cell, w, h, cd.consts.FontSize, cd.textColor) }
+	dc.SetColor(cd.textColor)
+	// Wrap against the unrounded content area, as calculateCellContentSizeInternal measured it. Sideways
+	// text is laid out in a turned frame whose width is the content area's height.
+	textAvailableWidth, contentAreaBottomY := textArea.W, textArea.H
+	if cell.IsSideways() { textAvailableWidth, contentAreaBottomY = textArea.H, textArea.W }
+	// lineStartX positions a wrapped line inside the content area (relative to dc) according to cd.textAlign.
+	lineStartX := func(line textLine) float64 {
+		lineW, _ := dc.MeasureString(line.Text)
+		switch {
+		case cd.textAlign == "center": return math.Max(0, (textAvailableWidth-lineW)/2)
+		case cd.textAlign == "right", cd.textAlign == "start" && line.RTL: return math.Max(0, textAvailableWidth-lineW)
+		}
+		return 0
+	}
+
+	mode := overflowMode(cell, cd.table.Settings.Overflow)
+	block, overflowed := fitCellText(dc, cell, textAvailableWidth, contentAreaBottomY, cd.font, cd.consts.FontSize, cd.consts.LineHeightMultiplier, mode, cd.consts.Wrap)
+	if overflowed { cd.lint.add("cell %s of table '%s' ('%s') overflows its %.0fx%.0f content area (text needs %.0fx%.0f, overflow mode '%s')", grid.CellName(cd.r, cd.c), cd.table.ID, firstNonEmpty(cell.Title, cell.Content), textAvailableWidth, contentAreaBottomY, block.Width, block.inkHeight(), mode) }
+	log.Printf("CELL [%d,%d]: Text: Drawing %d lines at font size %.1f, rotated %d degrees.", cd.r, cd.c, len(block.Lines), block.FontSize, cell.Rotate)
+	// In a scaled table the glyphs come from a face loaded at the scaled size, so they are rasterized at their final size.
+	var deviceFace font.Face
+	if math.Abs(lineScale-1) > epsilon { if face, errFace := cd.font.face(block.FontSize*lineScale); errFace == nil { deviceFace = face } else { log.Printf("CELL [%d,%d]: Error loading font at scale %.2f: %v", cd.r, cd.c, lineScale, errFace) } }
+	dc.Push(); dc.Translate(textArea.X, textArea.Y); rotateTextFrame(dc, cell.Rotate, textArea.W, textArea.H)
+	for _, line := range block.Lines {
+		// Lines starting below the content area are clipped; a partly visible last line is cut by dc's bounds.
+		if line.Baseline < contentAreaBottomY+epsilon { drawDeviceString(dc, deviceFace, lineScale, visualOrder(line.Text, line.RTL), lineStartX(line), line.Baseline) } else { break }
+	}
+	dc.Pop()
+}
@@ -3,10 +3,14 @@ package renderer
 import (
 	"diagramgen/pkg/parser"
 	"diagramgen/pkg/table"
+	"image"
+	"image/color"
 	"os"
 	"path/filepath"
-	// "reflect" // No longer needed after changes to FullExampleFile test
+	"strings"
 	"testing"
+
+	"github.com/fogleman/gg"
 )
 
 func TestRenderToPNG_FileCreation(t *testing.T) {
@@ -25,7 +29,7 @@ func TestRenderToPNG_FileCreation(t *testing.T) {
 		}
 	}()
 
-	err := RenderToPNG(&testTable, make(map[string]table.Table), outputPath)
+	_, err := RenderToPNG(&testTable, make(map[string]table.Table), outputPath)
 	if err != nil {
 		t.Fatalf("RenderToPNG failed: %v", err)
 	}
@@ -35,6 +39,21 @@ func TestRenderToPNG_FileCreation(t *testing.T) {
 	}
 }
 
+// TestRenderToPNG_OverflowLint checks that a cell whose text overflows is returned as lint, once.
+func TestRenderToPNG_OverflowLint(t *testing.T) {
+	cell := table.NewCell("", "This is a rather long text that will not fit")
+	cell.FixedWidth, cell.FixedHeight = 60, 30
+	testTable := table.Table{ID: "t", Rows: []table.Row{{Cells: []table.Cell{cell, table.NewCell("", "fits")}}}, Settings: table.DefaultGlobalSettings()}
+	outputPath := filepath.Join(t.TempDir(), "overflow.png")
+	warnings, err := RenderToPNG(&testTable, make(map[string]table.Table), outputPath)
+	if err != nil {
+		t.Fatalf("RenderToPNG failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "cell A1 of table 't' ('This is a rather long text tha…') overflows its") {
+		t.Errorf("warnings = %q, want one overflow of A1", warnings)
+	}
+}
+
 func TestRenderToPNG_EmptyTable(t *testing.T) {
 	emptyTable := table.Table{
 		Title:    "Empty Table Test",
@@ -49,7 +68,7 @@ func TestRenderToPNG_EmptyTable(t *testing.T) {
 		}
 	}()
 
-	err := RenderToPNG(&emptyTable, make(map[string]table.Table), outputPath)
+	_, err := RenderToPNG(&emptyTable, make(map[string]table.Table), outputPath)
 	if err != nil {
 		t.Fatalf("RenderToPNG failed for empty table: %v", err)
 	}
@@ -83,7 +102,7 @@ func TestRenderToPNG_InvalidColorHandling(t *testing.T) {
 		}
 	}()
 
-	err := RenderToPNG(&tableWithInvalidColor, make(map[string]table.Table), outputPath)
+	_, err := RenderToPNG(&tableWithInvalidColor, make(map[string]table.Table), outputPath)
 	if err != nil {
 		t.Fatalf("RenderToPNG failed: %v. Expected graceful handling of invalid colors.", err)
 	}
@@ -129,7 +148,7 @@ func TestRenderToPNG_FullExampleFile(t *testing.T) {
 		tablesToRender = make(map[string]table.Table)
 	}
 
-	renderErr := RenderToPNG(&mainTable, tablesToRender, outputFilePath)
+	_, renderErr := RenderToPNG(&mainTable, tablesToRender, outputFilePath)
 	if renderErr != nil {
 		t.Errorf("RenderToPNG failed for example.txt: %v", renderErr)
 	}
@@ -148,3 +167,137 @@ func TestRenderToPNG_FullExampleFile(t *testing.T) {
 	}
 	// For debugging, print the path: t.Logf("Output PNG created at: %s", outputFilePath)
 }
+
+func TestResolveCellPaint(t *testing.T) {
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Columns:  []table.ColumnSpec{{BackgroundColor: "#C0C0C0", Align: "right"}, {TextColor: "navy"}},
+		Rows: []table.Row{
+			{Cells: []table.Cell{newLayoutTestCell("", "a", 1, 1), newLayoutTestCell("", "b", 1, 1)}, BackgroundColor: "#EEEEEE", Align: "center"},
+			{Cells: []table.Cell{newLayoutTestCell("", "c", 1, 1), newLayoutTestCell("", "d", 1, 1)}},
+		},
+	}
+	tbl.Rows[1].Cells[1].BackgroundColor = "#FF0000"
+	tbl.Rows[1].Cells[1].Align = "left"
+
+	tests := []struct {
+		r, c          int
+		bg, fg, align string
+	}{
+		{0, 0, "#EEEEEE", "", "center"},     // row beats column
+		{0, 1, "#EEEEEE", "navy", "center"}, // column fills what the row leaves unset
+		{1, 0, "#C0C0C0", "", "right"},      // column only
+		{1, 1, "#FF0000", "navy", "left"},   // cell beats everything
+	}
+	for _, tt := range tests {
+		gc := GridCellInfo{OriginalCell: &tbl.Rows[tt.r].Cells[tt.c], GridR: tt.r, GridC: tt.c}
+		bg, fg, align := resolveCellPaint(tbl, gc, false)
+		if bg != tt.bg || fg != tt.fg || align != tt.align {
+			t.Errorf("cell [%d,%d]: got (%q, %q, %q), want (%q, %q, %q)", tt.r, tt.c, bg, fg, align, tt.bg, tt.fg, tt.align)
+		}
+	}
+}
+
+func TestResolveCellPaint_Header(t *testing.T) {
+	tbl := &table.Table{
+		Settings: table.DefaultGlobalSettings(),
+		Columns:  []table.ColumnSpec{{BackgroundColor: "#C0C0C0", Align: "right"}},
+		Rows:     []table.Row{{Cells: []table.Cell{newLayoutTestCell("", "h", 1, 1)}}},
+	}
+	gc := GridCellInfo{OriginalCell: &tbl.Rows[0].Cells[0]}
+	// Header colors replace the column's, but its alignment is kept.
+	if bg, _, align := resolveCellPaint(tbl, gc, true); bg != defaultHeaderBackground || align != "right" {
+		t.Errorf("header with column alignment: got bg %q align %q, want %q and right", bg, align, defaultHeaderBackground)
+	}
+	tbl.Columns[0].Align = ""
+	if _, _, align := resolveCellPaint(tbl, gc, true); align != "center" {
+		t.Errorf("header defaults: got align %q, want center", align)
+	}
+	tbl.Settings.HeaderBackgroundColor, tbl.Settings.HeaderTextColor = "#000000", "#FFFFFF"
+	if bg, fg, _ := resolveCellPaint(tbl, gc, true); bg != "#000000" || fg != "#FFFFFF" {
+		t.Errorf("header settings: got bg %q fg %q", bg, fg)
+	}
+}
+
+func TestDeviceScaling(t *testing.T) {
+	dc := gg.NewContext(60, 30)
+	if sx, sy := contextScale(dc); sx != 1 || sy != 1 {
+		t.Errorf("contextScale() of a plain context = %g, %g", sx, sy)
+	}
+	dc.Translate(5, 5)
+	dc.Scale(2, 0.5)
+	if sx, sy := contextScale(dc); sx != 2 || sy != 0.5 {
+		t.Errorf("contextScale() = %g, %g, want 2, 0.5", sx, sy)
+	}
+	for _, tt := range []struct{ width, scale, want float64 }{{2, 1, 2}, {2, 3, 6}, {1, 0.25, 1}, {3, 0.5, 1.5}, {0.5, 0.5, 0.5}} {
+		if got := deviceLineWidth(tt.width, tt.scale); got != tt.want {
+			t.Errorf("deviceLineWidth(%g, %g) = %g, want %g", tt.width, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestDrawDeviceString(t *testing.T) {
+	// Text drawn in a context scaled by 2 from a face twice the size matches text drawn unscaled at that size.
+	want := gg.NewContext(80, 40)
+	if err := want.LoadFontFace(testFontPath, 24); err != nil {
+		t.Fatalf("loading bundled font: %v", err)
+	}
+	want.SetColor(color.Black)
+	want.DrawString("Hi", 10, 30)
+	face, err := gg.LoadFontFace(testFontPath, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gg.NewContext(80, 40)
+	got.Scale(2, 2)
+	got.SetColor(color.Black)
+	drawDeviceString(got, face, 2, "Hi", 5, 15)
+	diffs := 0
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			_, _, _, a1 := want.Image().At(x, y).RGBA()
+			_, _, _, a2 := got.Image().At(x, y).RGBA()
+			if d := int(a1>>8) - int(a2>>8); d > 2 || d < -2 {
+				diffs++
+			}
+		}
+	}
+	if diffs > 0 {
+		t.Errorf("%d pixels differ from text drawn at the final size", diffs)
+	}
+}
+
+// TestScaledNestedTableGradient checks that the gradient of a nested table drawn at half size spans the
+// scaled cell: with the fill evaluated at the natural size, it would end half way, at half red.
+func TestScaledNestedTableGradient(t *testing.T) {
+	input := "table: [outer]\n::table=inner:: ::inner_scale=fit_both:: ::fixed_width=200:: ::fixed_height=60::\n" +
+		"table: [inner]\n{bg:linear(#000000, #FF0000, 90)} ::fixed_width=400:: ::fixed_height=100::"
+	all, err := parser.ParseAllText(input)
+	if err != nil {
+		t.Fatalf("ParseAllText failed: %v", err)
+	}
+	outer := all.Tables["outer"]
+	lg, _ := PopulateOccupationMap(&outer)
+	consts := LayoutConstants{FontPath: testFontPath, FontSize: 12, LineHeightMultiplier: 1.4, Padding: 8, MinCellWidth: 10, MinCellHeight: 10}
+	if err := lg.CalculateColumnWidthsAndRowHeights(consts, all.Tables); err != nil {
+		t.Fatalf("CalculateColumnWidthsAndRowHeights failed: %v", err)
+	}
+	lg.CalculateFinalCellLayouts(0)
+	dc := gg.NewContext(int(lg.CanvasWidth), int(lg.CanvasHeight))
+	dc.SetColor(color.White)
+	dc.Clear()
+	if err := drawTableItself(dc, &outer, lg, all.Tables, consts, &renderLint{}); err != nil {
+		t.Fatalf("drawTableItself failed: %v", err)
+	}
+
+	maxRed := uint8(0)
+	img := dc.Image().(*image.RGBA)
+	for i := 0; i < len(img.Pix); i += 4 {
+		if r, g, b := img.Pix[i], img.Pix[i+1], img.Pix[i+2]; g < 30 && b < 30 && r > maxRed {
+			maxRed = r
+		}
+	}
+	if maxRed < 230 {
+		t.Errorf("reddest gradient pixel has red %d, want the full gradient (about 255) inside the scaled cell", maxRed)
+	}
+}
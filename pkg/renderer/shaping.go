@@ -0,0 +1,163 @@
+package renderer
+
+import "unicode"
+
+// arabicForm describes how an Arabic letter joins its neighbours. Isolated is the first of its
+// presentation forms, which follow in the order isolated, final, initial, medial; letters that only
+// join on their right (dual false) have no initial and medial forms.
+type arabicForm struct {
+	Isolated rune
+	Dual     bool
+}
+
+// arabicForms maps the Arabic and Persian letters to their contextual forms in the Arabic
+// Presentation Forms blocks, which fonts without OpenType shaping tables still draw.
+var arabicForms = map[rune]arabicForm{
+	'\u0621': {'\ufe80', false}, // hamza
+	'\u0622': {'\ufe81', false}, // alef with madda above
+	'\u0623': {'\ufe83', false}, // alef with hamza above
+	'\u0624': {'\ufe85', false}, // waw with hamza above
+	'\u0625': {'\ufe87', false}, // alef with hamza below
+	'\u0626': {'\ufe89', true},  // yeh with hamza above
+	'\u0627': {'\ufe8d', false}, // alef
+	'\u0628': {'\ufe8f', true},  // beh
+	'\u0629': {'\ufe93', false}, // teh marbuta
+	'\u062a': {'\ufe95', true},  // teh
+	'\u062b': {'\ufe99', true},  // theh
+	'\u062c': {'\ufe9d', true},  // jeem
+	'\u062d': {'\ufea1', true},  // hah
+	'\u062e': {'\ufea5', true},  // khah
+	'\u062f': {'\ufea9', false}, // dal
+	'\u0630': {'\ufeab', false}, // thal
+	'\u0631': {'\ufead', false}, // reh
+	'\u0632': {'\ufeaf', false}, // zain
+	'\u0633': {'\ufeb1', true},  // seen
+	'\u0634': {'\ufeb5', true},  // sheen
+	'\u0635': {'\ufeb9', true},  // sad
+	'\u0636': {'\ufebd', true},  // dad
+	'\u0637': {'\ufec1', true},  // tah
+	'\u0638': {'\ufec5', true},  // zah
+	'\u0639': {'\ufec9', true},  // ain
+	'\u063a': {'\ufecd', true},  // ghain
+	'\u0641': {'\ufed1', true},  // feh
+	'\u0642': {'\ufed5', true},  // qaf
+	'\u0643': {'\ufed9', true},  // kaf
+	'\u0644': {'\ufedd', true},  // lam
+	'\u0645': {'\ufee1', true},  // meem
+	'\u0646': {'\ufee5', true},  // noon
+	'\u0647': {'\ufee9', true},  // heh
+	'\u0648': {'\ufeed', false}, // waw
+	'\u0649': {'\ufeef', false}, // alef maksura
+	'\u064a': {'\ufef1', true},  // yeh
+	'\u067e': {'\ufb56', true},  // peh
+	'\u0686': {'\ufb7a', true},  // tcheh
+	'\u0698': {'\ufb8a', false}, // jeh
+	'\u06a9': {'\ufb8e', true},  // keheh
+	'\u06af': {'\ufb92', true},  // gaf
+	'\u06cc': {'\ufbfc', true},  // farsi yeh
+}
+
+// lamAlef maps the alef variants to the isolated form of their ligature with a preceding lam.
+var lamAlef = map[rune]rune{'\u0622': '\ufef5', '\u0623': '\ufef7', '\u0625': '\ufef9', '\u0627': '\ufefb'}
+
+const (
+	arabicLam      = '\u0644'
+	arabicTatweel  = '\u0640'
+	zeroWidthJoin  = '\u200d'
+	zeroWidthSplit = '\u200c' // Zero-width non-joiner.
+)
+
+// Joining types of the Arabic shaping model: letters join on both sides, on their right only, not at
+// all, or are transparent (vowel marks), which are skipped when looking for neighbours.
+const (
+	joinNone = iota
+	joinRight
+	joinDual
+	joinTransparent
+)
+
+// arabicJoins returns the joining type of r.
+func arabicJoins(r rune) int {
+	if form, ok := arabicForms[r]; ok {
+		switch {
+		case r == '\u0621': // Hamza does not join at all.
+			return joinNone
+		case form.Dual:
+			return joinDual
+		}
+		return joinRight
+	}
+	switch {
+	case r == arabicTatweel, r == zeroWidthJoin:
+		return joinDual
+	case r == softHyphen, unicode.Is(unicode.Mn, r):
+		return joinTransparent
+	}
+	return joinNone
+}
+
+// shapeArabic replaces the Arabic letters of text, in logical order, with the presentation form that
+// matches how they join their neighbours (isolated, final, initial or medial), and lam followed by
+// alef with their ligature. Zero-width joiners and non-joiners are removed once applied. Text
+// without Arabic letters is returned unchanged.
+func shapeArabic(text string) string {
+	runes := []rune(text)
+	hasArabic := false
+	for _, r := range runes {
+		if _, ok := arabicForms[r]; ok {
+			hasArabic = true
+			break
+		}
+	}
+	if !hasArabic {
+		return text
+	}
+	types := make([]int, len(runes))
+	for i, r := range runes {
+		types[i] = arabicJoins(r)
+	}
+	// neighbour returns the joining type of the closest non-transparent character in direction step.
+	neighbour := func(i, step int) int {
+		for j := i + step; j >= 0 && j < len(runes); j += step {
+			if types[j] != joinTransparent {
+				return types[j]
+			}
+		}
+		return joinNone
+	}
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == zeroWidthJoin || r == zeroWidthSplit {
+			continue
+		}
+		form, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		joinsPrev := types[i] != joinNone && neighbour(i, -1) == joinDual
+		if r == arabicLam && i+1 < len(runes) {
+			if ligature, ok := lamAlef[runes[i+1]]; ok {
+				if joinsPrev {
+					ligature++ // Final form.
+				}
+				out = append(out, ligature)
+				i++
+				continue
+			}
+		}
+		joinsNext := types[i] == joinDual && neighbour(i, 1) != joinNone
+		switch {
+		case joinsPrev && joinsNext:
+			out = append(out, form.Isolated+3)
+		case joinsNext:
+			out = append(out, form.Isolated+2)
+		case joinsPrev:
+			out = append(out, form.Isolated+1)
+		default:
+			out = append(out, form.Isolated)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,96 @@
+package renderer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// testFontPath is a copy of DejaVu Sans bundled with the tests, so shaping is checked against a
+// font with Arabic and Hebrew coverage regardless of the fonts installed.
+const testFontPath = "testdata/DejaVuSans.ttf"
+
+func TestShapeArabic(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"latin is untouched", "abc", "abc"},
+		{"initial, medial and final forms", "بيت", "\ufe91\ufef4\ufe96"},
+		{"right-joining letters break the word", "دار", "\ufea9\ufe8d\ufead"},
+		{"lam-alef ligature", "لا", "\ufefb"},
+		{"final lam-alef ligature", "سلام", "\ufeb3\ufefc\ufee1"},
+		{"vowel marks are transparent", "بَب", "\ufe91\u064e\ufe90"},
+		{"zero-width joiner forces a join", "ب\u200d", "\ufe91"},
+		{"zero-width non-joiner prevents a join", "ب\u200cب", "\ufe8f\ufe8f"},
+		{"words are shaped separately", "باب بيت", "\ufe91\ufe8e\ufe8f \ufe91\ufef4\ufe96"},
+		{"persian letters", "پدر", "\ufb58\ufeaa\ufead"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shapeArabic(tt.text); got != tt.want {
+				t.Errorf("shapeArabic(%q) = %+q, want %+q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShapeArabic_BundledFont(t *testing.T) {
+	data, err := os.ReadFile(testFontPath)
+	if err != nil {
+		t.Fatalf("reading bundled font: %v", err)
+	}
+	font, err := truetype.Parse(data)
+	if err != nil {
+		t.Fatalf("parsing bundled font: %v", err)
+	}
+	// Every presentation form shapeArabic can produce must be drawable.
+	for letter, form := range arabicForms {
+		forms := []rune{form.Isolated, form.Isolated + 1}
+		if letter == '\u0621' {
+			forms = forms[:1]
+		}
+		if form.Dual {
+			forms = append(forms, form.Isolated+2, form.Isolated+3)
+		}
+		for _, r := range forms {
+			if font.Index(r) == 0 {
+				t.Errorf("bundled font has no glyph for %U (form of %U)", r, letter)
+			}
+		}
+	}
+	for _, ligature := range lamAlef {
+		if font.Index(ligature) == 0 || font.Index(ligature+1) == 0 {
+			t.Errorf("bundled font has no glyph for ligature %U", ligature)
+		}
+	}
+
+	// Lines are wrapped on shaped text and keep their paragraph's direction.
+	dc := gg.NewContext(200, 100)
+	if err := dc.LoadFontFace(testFontPath, 12); err != nil {
+		t.Fatalf("loading bundled font: %v", err)
+	}
+	cell := newLayoutTestCell("", "مرحبا بالعالم\nשלום עולם\nhello", 1, 1)
+	block := layoutCellText(dc, &cell, 200, 12, 1.4, wrapOptions{})
+	if len(block.Lines) != 3 {
+		t.Fatalf("expected 3 lines, got %+v", block.Lines)
+	}
+	if block.Lines[0].Text != shapeArabic("مرحبا بالعالم") || !block.Lines[0].RTL || !block.Lines[1].RTL || block.Lines[2].RTL {
+		t.Errorf("unexpected lines %+v", block.Lines)
+	}
+	// In a right-to-left table every paragraph is right-to-left; words are measured as they are drawn.
+	word := shapeArabic("بالعالم")
+	width, _ := dc.MeasureString(word)
+	narrow := layoutCellText(dc, &cell, width+1, 12, 1.4, wrapOptions{RTL: true})
+	if len(narrow.Lines) < 3 || narrow.Lines[0].Text != shapeArabic("مرحبا") || narrow.Lines[1].Text != word {
+		t.Errorf("expected one Arabic word per line at width %.1f, got %+v", width+1, narrow.Lines)
+	}
+	for _, line := range narrow.Lines {
+		if !line.RTL {
+			t.Errorf("line %q of a right-to-left table is not right-to-left", line.Text)
+		}
+	}
+}
@@ -0,0 +1,655 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// svgNode is an element of a parsed SVG document.
+type svgNode struct {
+	Name     string
+	Attrs    map[string]string
+	Children []*svgNode
+}
+
+// svgDoc is a parsed SVG document. Only the static drawing subset is supported: the svg and g
+// elements, the basic shapes (rect, circle, ellipse, line, polyline, polygon) and path, with solid
+// fills and strokes, opacity and transforms. Gradients, text, use, clipping and CSS style sheets are
+// ignored, and the viewBox is stretched to the requested size (preserveAspectRatio is ignored).
+type svgDoc struct {
+	Width, Height float64    // Intrinsic size in pixels.
+	ViewBox       [4]float64 // min-x, min-y, width and height of the user coordinate system.
+	Root          *svgNode
+}
+
+// parseSVG parses an SVG document. Its intrinsic size comes from the width and height attributes of
+// the root element, else from its viewBox; documents with neither are 300x150 like in browsers.
+func parseSVG(data []byte) (*svgDoc, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	var stack []*svgNode
+	var root *svgNode
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				break
+			}
+			return nil, fmt.Errorf("parsing svg: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &svgNode{Name: t.Name.Local, Attrs: map[string]string{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "" || attr.Name.Space == "svg" {
+					node.Attrs[attr.Name.Local] = strings.TrimSpace(attr.Value)
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if root.Name != "svg" {
+		return nil, fmt.Errorf("parsing svg: root element is <%s>, not <svg>", root.Name)
+	}
+
+	doc := &svgDoc{Root: root}
+	if viewBox := svgNumbers(root.Attrs["viewBox"]); len(viewBox) == 4 && viewBox[2] > 0 && viewBox[3] > 0 {
+		copy(doc.ViewBox[:], viewBox)
+	}
+	doc.Width, doc.Height = svgLength(root.Attrs["width"]), svgLength(root.Attrs["height"])
+	switch vbW, vbH := doc.ViewBox[2], doc.ViewBox[3]; {
+	case doc.Width > 0 && doc.Height > 0:
+	case doc.Width > 0 && vbW > 0:
+		doc.Height = doc.Width * vbH / vbW
+	case doc.Height > 0 && vbH > 0:
+		doc.Width = doc.Height * vbW / vbH
+	case vbW > 0:
+		doc.Width, doc.Height = vbW, vbH
+	default:
+		doc.Width, doc.Height = 300, 150
+	}
+	if doc.ViewBox[2] == 0 {
+		doc.ViewBox = [4]float64{0, 0, doc.Width, doc.Height}
+	}
+	return doc, nil
+}
+
+// svgLength parses a coordinate or length in pixels ("24" or "-2.5px"). Other units and percentages
+// give 0; callers ignore shapes with sizes <= 0.
+func svgLength(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "px"), 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// svgNumbers parses a list of numbers separated by spaces and/or commas, as in viewBox and points.
+func svgNumbers(s string) []float64 {
+	scanner := pathScanner{s: s}
+	var values []float64
+	for {
+		v, ok := scanner.number()
+		if !ok {
+			return values
+		}
+		values = append(values, v)
+	}
+}
+
+// svgPaint holds the inherited presentation properties of an element.
+type svgPaint struct {
+	Fill, Stroke                string
+	StrokeWidth                 float64
+	LineCap, LineJoin, FillRule string
+	FillOpacity, StrokeOpacity  float64
+	Opacity                     float64 // Product of the opacity of the element and its ancestors.
+}
+
+// drawSVG draws doc into the rectangle (x, y, w, h) of dc. currentColor is used for the keyword of
+// the same name, which the bundled icons use so they take the cell's text color.
+func drawSVG(dc *gg.Context, doc *svgDoc, x, y, w, h float64, currentColor color.Color) {
+	if doc.ViewBox[2] <= 0 || doc.ViewBox[3] <= 0 || w <= 0 || h <= 0 {
+		return
+	}
+	sx, sy := w/doc.ViewBox[2], h/doc.ViewBox[3]
+	m := gg.Matrix{XX: sx, YY: sy, X0: x - doc.ViewBox[0]*sx, Y0: y - doc.ViewBox[1]*sy}
+	paint := svgPaint{Fill: "black", Stroke: "none", StrokeWidth: 1, FillOpacity: 1, StrokeOpacity: 1, Opacity: 1}
+	dsx, dsy := contextScale(dc)
+	r := svgRenderer{dc: dc, currentColor: currentColor, deviceScale: math.Sqrt(dsx * dsy)}
+	r.drawNode(doc.Root, m, paint)
+	dc.ClearPath()
+}
+
+type svgRenderer struct {
+	dc           *gg.Context
+	currentColor color.Color
+	deviceScale  float64 // dc's own scale, as gg does not transform line widths.
+}
+
+func (r svgRenderer) drawChildren(node *svgNode, m gg.Matrix, paint svgPaint) {
+	for _, child := range node.Children {
+		r.drawNode(child, m, paint)
+	}
+}
+
+// drawNode draws an element and its children with the transform and paint inherited from its parent.
+func (r svgRenderer) drawNode(node *svgNode, m gg.Matrix, paint svgPaint) {
+	attrs := svgAttributes(node)
+	if attrs["display"] == "none" || attrs["visibility"] == "hidden" {
+		return
+	}
+	if transform, ok := attrs["transform"]; ok {
+		local, err := parseSVGTransform(transform)
+		if err != nil {
+			log.Printf("Warning: svg <%s>: %v. Ignoring the transform.", node.Name, err)
+		}
+		m = composeMatrix(m, local)
+	}
+	paint = paint.with(attrs)
+	pen := svgPen{dc: r.dc, m: m}
+	num := func(name string) float64 { return svgLength(attrs[name]) }
+	switch node.Name {
+	case "g", "svg", "a":
+		r.drawChildren(node, m, paint)
+		return
+	case "path":
+		if err := drawPathData(pen, attrs["d"]); err != nil {
+			log.Printf("Warning: svg path: %v. Drawing the part before the error.", err)
+		}
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		rx, okX := attrs["rx"]
+		ry, okY := attrs["ry"]
+		if !okX {
+			rx = ry
+		}
+		if !okY {
+			ry = rx
+		}
+		pen.roundedRect(x, y, w, h, math.Min(svgLength(rx), w/2), math.Min(svgLength(ry), h/2))
+	case "circle":
+		pen.ellipse(num("cx"), num("cy"), num("r"), num("r"))
+	case "ellipse":
+		pen.ellipse(num("cx"), num("cy"), num("rx"), num("ry"))
+	case "line":
+		pen.moveTo(num("x1"), num("y1"))
+		pen.lineTo(num("x2"), num("y2"))
+	case "polyline", "polygon":
+		points := svgNumbers(attrs["points"])
+		for i := 0; i+1 < len(points); i += 2 {
+			if i == 0 {
+				pen.moveTo(points[i], points[i+1])
+			} else {
+				pen.lineTo(points[i], points[i+1])
+			}
+		}
+		if node.Name == "polygon" && len(points) >= 4 {
+			r.dc.ClosePath()
+		}
+	default:
+		return // defs, title, gradients, text and other unsupported elements.
+	}
+	r.paint(paint, matrixScale(m))
+}
+
+// paint fills and strokes the current path, then clears it.
+func (r svgRenderer) paint(paint svgPaint, scale float64) {
+	if fill, ok := r.color(paint.Fill, paint.FillOpacity*paint.Opacity); ok {
+		r.dc.SetColor(fill)
+		if paint.FillRule == "evenodd" {
+			r.dc.SetFillRuleEvenOdd()
+		} else {
+			r.dc.SetFillRuleWinding()
+		}
+		r.dc.FillPreserve()
+	}
+	if stroke, ok := r.color(paint.Stroke, paint.StrokeOpacity*paint.Opacity); ok && paint.StrokeWidth > 0 {
+		r.dc.SetColor(stroke)
+		r.dc.SetLineWidth(paint.StrokeWidth * scale * r.deviceScale)
+		switch paint.LineCap {
+		case "round":
+			r.dc.SetLineCapRound()
+		case "square":
+			r.dc.SetLineCapSquare()
+		default:
+			r.dc.SetLineCapButt()
+		}
+		switch paint.LineJoin {
+		case "round":
+			r.dc.SetLineJoinRound()
+		default:
+			r.dc.SetLineJoinBevel() // gg has no miter joins.
+		}
+		r.dc.StrokePreserve()
+	}
+	r.dc.ClearPath()
+}
+
+// color resolves a paint value with the given opacity; ok is false for "none" and unsupported paints.
+func (r svgRenderer) color(value string, opacity float64) (color.Color, bool) {
+	var c color.Color
+	switch value {
+	case "none", "transparent", "":
+		return nil, false
+	case "currentColor":
+		c = r.currentColor
+	default:
+		parsed, err := parseColor(value)
+		if err != nil {
+			return nil, false // url(#gradient) and other paint servers.
+		}
+		c = parsed
+	}
+	if opacity >= 1 {
+		return c, true
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(math.Round(float64(n.A) * clampUnit(opacity)))
+	return n, true
+}
+
+// with returns the paint of an element with the given attributes.
+func (p svgPaint) with(attrs map[string]string) svgPaint {
+	opacity := func(name string, v *float64) {
+		if s, ok := attrs[name]; ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				*v *= clampUnit(f)
+			}
+		}
+	}
+	if v, ok := attrs["fill"]; ok && v != "inherit" {
+		p.Fill = v
+	}
+	if v, ok := attrs["stroke"]; ok && v != "inherit" {
+		p.Stroke = v
+	}
+	if v, ok := attrs["stroke-width"]; ok {
+		if w := svgLength(v); w > 0 || v == "0" {
+			p.StrokeWidth = w
+		}
+	}
+	if v, ok := attrs["stroke-linecap"]; ok {
+		p.LineCap = v
+	}
+	if v, ok := attrs["stroke-linejoin"]; ok {
+		p.LineJoin = v
+	}
+	if v, ok := attrs["fill-rule"]; ok {
+		p.FillRule = v
+	}
+	if _, ok := attrs["fill-opacity"]; ok {
+		p.FillOpacity = 1
+		opacity("fill-opacity", &p.FillOpacity)
+	}
+	if _, ok := attrs["stroke-opacity"]; ok {
+		p.StrokeOpacity = 1
+		opacity("stroke-opacity", &p.StrokeOpacity)
+	}
+	opacity("opacity", &p.Opacity)
+	return p
+}
+
+// svgAttributes returns the attributes of a node with the declarations of its style attribute,
+// which take precedence over presentation attributes.
+func svgAttributes(node *svgNode) map[string]string {
+	style, ok := node.Attrs["style"]
+	if !ok {
+		return node.Attrs
+	}
+	attrs := make(map[string]string, len(node.Attrs))
+	for k, v := range node.Attrs {
+		attrs[k] = v
+	}
+	for _, declaration := range strings.Split(style, ";") {
+		if name, value, found := strings.Cut(declaration, ":"); found {
+			attrs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return attrs
+}
+
+// composeMatrix returns the transform that applies local and then parent.
+func composeMatrix(parent, local gg.Matrix) gg.Matrix {
+	return gg.Matrix{
+		XX: parent.XX*local.XX + parent.XY*local.YX,
+		YX: parent.YX*local.XX + parent.YY*local.YX,
+		XY: parent.XX*local.XY + parent.XY*local.YY,
+		YY: parent.YX*local.XY + parent.YY*local.YY,
+		X0: parent.XX*local.X0 + parent.XY*local.Y0 + parent.X0,
+		Y0: parent.YX*local.X0 + parent.YY*local.Y0 + parent.Y0,
+	}
+}
+
+// matrixScale returns the factor by which m scales lengths on average, used for stroke widths.
+func matrixScale(m gg.Matrix) float64 {
+	return math.Sqrt(math.Abs(m.XX*m.YY - m.XY*m.YX))
+}
+
+// parseSVGTransform parses a transform list such as "translate(10 5) rotate(45)".
+func parseSVGTransform(s string) (gg.Matrix, error) {
+	m := gg.Identity()
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		open := strings.IndexByte(rest, '(')
+		end := strings.IndexByte(rest, ')')
+		if open < 0 || end < open {
+			return m, fmt.Errorf("invalid transform '%s'", s)
+		}
+		name, item := strings.TrimSpace(rest[:open]), rest[:end+1]
+		args := svgNumbers(rest[open+1 : end])
+		rest = strings.TrimLeft(rest[end+1:], " ,\t\n")
+		arg := func(i int, fallback float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return fallback
+		}
+		var local gg.Matrix
+		switch {
+		case name == "matrix" && len(args) == 6:
+			local = gg.Matrix{XX: args[0], YX: args[1], XY: args[2], YY: args[3], X0: args[4], Y0: args[5]}
+		case name == "translate" && len(args) >= 1:
+			local = gg.Translate(args[0], arg(1, 0))
+		case name == "scale" && len(args) >= 1:
+			local = gg.Scale(args[0], arg(1, args[0]))
+		case name == "rotate" && len(args) >= 1:
+			cx, cy := arg(1, 0), arg(2, 0)
+			local = composeMatrix(gg.Translate(cx, cy), composeMatrix(gg.Rotate(gg.Radians(args[0])), gg.Translate(-cx, -cy)))
+		case name == "skewX" && len(args) == 1:
+			local = gg.Shear(math.Tan(gg.Radians(args[0])), 0)
+		case name == "skewY" && len(args) == 1:
+			local = gg.Shear(0, math.Tan(gg.Radians(args[0])))
+		default:
+			return m, fmt.Errorf("invalid transform '%s'", strings.TrimSpace(item))
+		}
+		m = composeMatrix(m, local)
+	}
+	return m, nil
+}
+
+// svgPen adds path segments in user coordinates to dc's path, mapped by m.
+type svgPen struct {
+	dc *gg.Context
+	m  gg.Matrix
+}
+
+func (p svgPen) moveTo(x, y float64) { p.dc.MoveTo(p.m.TransformPoint(x, y)) }
+func (p svgPen) lineTo(x, y float64) { p.dc.LineTo(p.m.TransformPoint(x, y)) }
+
+func (p svgPen) cubicTo(x1, y1, x2, y2, x, y float64) {
+	ax, ay := p.m.TransformPoint(x1, y1)
+	bx, by := p.m.TransformPoint(x2, y2)
+	cx, cy := p.m.TransformPoint(x, y)
+	p.dc.CubicTo(ax, ay, bx, by, cx, cy)
+}
+
+func (p svgPen) quadTo(x1, y1, x, y float64) {
+	ax, ay := p.m.TransformPoint(x1, y1)
+	bx, by := p.m.TransformPoint(x, y)
+	p.dc.QuadraticTo(ax, ay, bx, by)
+}
+
+// ellipseArc adds the arc of the ellipse centred on (cx, cy) with radii rx and ry rotated by phi,
+// from angle theta over sweep radians, as cubic Béziers of at most a quarter turn each.
+func (p svgPen) ellipseArc(cx, cy, rx, ry, phi, theta, sweep float64) {
+	cosPhi, sinPhi := math.Cos(phi), math.Sin(phi)
+	point := func(a float64) (float64, float64) {
+		return cx + rx*math.Cos(a)*cosPhi - ry*math.Sin(a)*sinPhi, cy + rx*math.Cos(a)*sinPhi + ry*math.Sin(a)*cosPhi
+	}
+	tangent := func(a float64) (float64, float64) {
+		return -rx*math.Sin(a)*cosPhi - ry*math.Cos(a)*sinPhi, -rx*math.Sin(a)*sinPhi + ry*math.Cos(a)*cosPhi
+	}
+	segments := int(math.Ceil(math.Abs(sweep) / (math.Pi / 2)))
+	step := sweep / float64(segments)
+	k := 4.0 / 3 * math.Tan(step/4)
+	for i := 0; i < segments; i++ {
+		a, b := theta+float64(i)*step, theta+float64(i+1)*step
+		ax, ay := point(a)
+		bx, by := point(b)
+		dax, day := tangent(a)
+		dbx, dby := tangent(b)
+		p.cubicTo(ax+k*dax, ay+k*day, bx-k*dbx, by-k*dby, bx, by)
+	}
+}
+
+func (p svgPen) ellipse(cx, cy, rx, ry float64) {
+	if rx <= 0 || ry <= 0 {
+		return
+	}
+	p.moveTo(cx+rx, cy)
+	p.ellipseArc(cx, cy, rx, ry, 0, 0, 2*math.Pi)
+	p.dc.ClosePath()
+}
+
+func (p svgPen) roundedRect(x, y, w, h, rx, ry float64) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	if rx <= 0 || ry <= 0 {
+		p.moveTo(x, y)
+		p.lineTo(x+w, y)
+		p.lineTo(x+w, y+h)
+		p.lineTo(x, y+h)
+		p.dc.ClosePath()
+		return
+	}
+	p.moveTo(x+rx, y)
+	p.lineTo(x+w-rx, y)
+	p.ellipseArc(x+w-rx, y+ry, rx, ry, 0, -math.Pi/2, math.Pi/2)
+	p.lineTo(x+w, y+h-ry)
+	p.ellipseArc(x+w-rx, y+h-ry, rx, ry, 0, 0, math.Pi/2)
+	p.lineTo(x+rx, y+h)
+	p.ellipseArc(x+rx, y+h-ry, rx, ry, 0, math.Pi/2, math.Pi/2)
+	p.lineTo(x, y+ry)
+	p.ellipseArc(x+rx, y+ry, rx, ry, 0, math.Pi, math.Pi/2)
+	p.dc.ClosePath()
+}
+
+// arcTo adds an SVG elliptical arc from (x1, y1) to (x2, y2), converting the endpoint
+// parameterization to a centre one (SVG 1.1 appendix F.6.5).
+func (p svgPen) arcTo(x1, y1, rx, ry, rotation float64, large, sweep bool, x2, y2 float64) {
+	if x1 == x2 && y1 == y2 {
+		return
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		p.lineTo(x2, y2)
+		return
+	}
+	phi := gg.Radians(rotation)
+	cosPhi, sinPhi := math.Cos(phi), math.Sin(phi)
+	dx, dy := (x1-x2)/2, (y1-y2)/2
+	x1p, y1p := cosPhi*dx+sinPhi*dy, -sinPhi*dx+cosPhi*dy
+	if lambda := x1p*x1p/(rx*rx) + y1p*y1p/(ry*ry); lambda > 1 {
+		rx, ry = rx*math.Sqrt(lambda), ry*math.Sqrt(lambda)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1p*y1p - ry*ry*x1p*x1p
+	den := rx*rx*y1p*y1p + ry*ry*x1p*x1p
+	coef := 0.0
+	if num > 0 && den > 0 {
+		coef = math.Sqrt(num / den)
+	}
+	if large == sweep {
+		coef = -coef
+	}
+	cxp, cyp := coef*rx*y1p/ry, -coef*ry*x1p/rx
+	cx, cy := cosPhi*cxp-sinPhi*cyp+(x1+x2)/2, sinPhi*cxp+cosPhi*cyp+(y1+y2)/2
+	angle := func(ux, uy, vx, vy float64) float64 { return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy) }
+	ux, uy := (x1p-cxp)/rx, (y1p-cyp)/ry
+	theta := angle(1, 0, ux, uy)
+	delta := angle(ux, uy, (-x1p-cxp)/rx, (-y1p-cyp)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+	p.ellipseArc(cx, cy, rx, ry, phi, theta, delta)
+}
+
+// drawPathData adds the segments of an SVG path's d attribute to the pen's path. On a syntax error
+// the segments before it are kept, as SVG renderers do.
+func drawPathData(pen svgPen, d string) error {
+	scanner := pathScanner{s: d}
+	var cmd byte
+	var x, y, startX, startY, ctrlX, ctrlY float64 // ctrl is the last control point, for S and T.
+	var prev byte
+	for {
+		scanner.skipSeparators()
+		if scanner.done() {
+			return nil
+		}
+		if c := scanner.s[scanner.i]; isPathCommand(c) {
+			cmd = c
+			scanner.i++
+		} else if cmd == 0 || cmd == 'z' || cmd == 'Z' {
+			return fmt.Errorf("expected a command at offset %d of '%s'", scanner.i, d)
+		}
+		ox, oy := 0.0, 0.0
+		if cmd >= 'a' {
+			ox, oy = x, y
+		}
+		upper := cmd &^ 0x20
+		var args []float64
+		count := map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'A': 7, 'Z': 0}[upper]
+		for i := 0; i < count; i++ {
+			var v float64
+			var ok bool
+			if upper == 'A' && (i == 3 || i == 4) {
+				v, ok = scanner.flag()
+			} else {
+				v, ok = scanner.number()
+			}
+			if !ok {
+				return fmt.Errorf("missing argument for '%c' at offset %d of '%s'", cmd, scanner.i, d)
+			}
+			args = append(args, v)
+		}
+		switch upper {
+		case 'M':
+			x, y = args[0]+ox, args[1]+oy
+			startX, startY = x, y
+			pen.moveTo(x, y)
+			cmd = 'L' | cmd&0x20 // Further coordinate pairs are line segments.
+		case 'L':
+			x, y = args[0]+ox, args[1]+oy
+			pen.lineTo(x, y)
+		case 'H':
+			x = args[0] + ox
+			pen.lineTo(x, y)
+		case 'V':
+			y = args[0] + oy
+			pen.lineTo(x, y)
+		case 'C', 'S':
+			x1, y1 := x, y
+			if upper == 'C' {
+				x1, y1 = args[0]+ox, args[1]+oy
+				args = args[2:]
+			} else if prev == 'C' || prev == 'S' {
+				x1, y1 = 2*x-ctrlX, 2*y-ctrlY
+			}
+			ctrlX, ctrlY = args[0]+ox, args[1]+oy
+			x, y = args[2]+ox, args[3]+oy
+			pen.cubicTo(x1, y1, ctrlX, ctrlY, x, y)
+		case 'Q', 'T':
+			if upper == 'Q' {
+				ctrlX, ctrlY = args[0]+ox, args[1]+oy
+				args = args[2:]
+			} else if prev == 'Q' || prev == 'T' {
+				ctrlX, ctrlY = 2*x-ctrlX, 2*y-ctrlY
+			} else {
+				ctrlX, ctrlY = x, y
+			}
+			x, y = args[0]+ox, args[1]+oy
+			pen.quadTo(ctrlX, ctrlY, x, y)
+		case 'A':
+			x2, y2 := args[5]+ox, args[6]+oy
+			pen.arcTo(x, y, args[0], args[1], args[2], args[3] != 0, args[4] != 0, x2, y2)
+			x, y = x2, y2
+		case 'Z':
+			pen.dc.ClosePath()
+			x, y = startX, startY
+		}
+		prev = upper
+	}
+}
+
+func isPathCommand(c byte) bool { return strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 }
+
+// pathScanner reads the numbers of SVG path data and attribute lists, where separators are optional
+// whenever a number cannot continue ("1-2", "0.5.5").
+type pathScanner struct {
+	s string
+	i int
+}
+
+func (p *pathScanner) done() bool { return p.i >= len(p.s) }
+
+func (p *pathScanner) skipSeparators() {
+	for !p.done() && strings.IndexByte(" \t\r\n,", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// number reads the next number, if any.
+func (p *pathScanner) number() (float64, bool) {
+	p.skipSeparators()
+	start, seenDot, seenDigit := p.i, false, false
+	if !p.done() && (p.s[p.i] == '+' || p.s[p.i] == '-') {
+		p.i++
+	}
+	for ; !p.done(); p.i++ {
+		c := p.s[p.i]
+		if c >= '0' && c <= '9' {
+			seenDigit = true
+		} else if c == '.' && !seenDot {
+			seenDot = true
+		} else {
+			break
+		}
+	}
+	if seenDigit && !p.done() && (p.s[p.i] == 'e' || p.s[p.i] == 'E') {
+		exp := p.i + 1
+		if exp < len(p.s) && (p.s[exp] == '+' || p.s[exp] == '-') {
+			exp++
+		}
+		if exp < len(p.s) && p.s[exp] >= '0' && p.s[exp] <= '9' {
+			for p.i = exp; !p.done() && p.s[p.i] >= '0' && p.s[p.i] <= '9'; p.i++ {
+			}
+		}
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.i], 64)
+	if !seenDigit || err != nil {
+		p.i = start
+		return 0, false
+	}
+	return v, true
+}
+
+// flag reads an arc flag, a single "0" or "1" that needs no separator after it.
+func (p *pathScanner) flag() (float64, bool) {
+	p.skipSeparators()
+	if p.done() || (p.s[p.i] != '0' && p.s[p.i] != '1') {
+		return 0, false
+	}
+	p.i++
+	return float64(p.s[p.i-1] - '0'), true
+}
@@ -0,0 +1,144 @@
+package renderer
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestParseSVG_Size(t *testing.T) {
+	tests := []struct {
+		name             string
+		doc              string
+		wantW, wantH     float64
+		wantVBW, wantVBH float64
+	}{
+		{"width and height", `<svg width="64px" height="40" viewBox="0 0 32 20"/>`, 64, 40, 32, 20},
+		{"width from the viewBox ratio", `<svg width="48" viewBox="0 0 24 12"></svg>`, 48, 24, 24, 12},
+		{"viewBox only", `<?xml version="1.0"?><svg viewBox="-5 -5 10,10"><g/></svg>`, 10, 10, 10, 10},
+		{"no size at all", `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, 300, 150, 300, 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseSVG([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("parseSVG() error = %v", err)
+			}
+			if doc.Width != tt.wantW || doc.Height != tt.wantH || doc.ViewBox[2] != tt.wantVBW || doc.ViewBox[3] != tt.wantVBH {
+				t.Errorf("got size %gx%g viewBox %v, want %gx%g viewBox size %gx%g", doc.Width, doc.Height, doc.ViewBox, tt.wantW, tt.wantH, tt.wantVBW, tt.wantVBH)
+			}
+		})
+	}
+	for _, bad := range []string{`<html></html>`, `<svg><g>`, ``} {
+		if _, err := parseSVG([]byte(bad)); err == nil {
+			t.Errorf("parseSVG(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestSVGNumbers(t *testing.T) {
+	got := svgNumbers("1-2.5.5,3e1 -.5E-1")
+	want := []float64{1, -2.5, 0.5, 30, -0.05}
+	if len(got) != len(want) {
+		t.Fatalf("svgNumbers() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("svgNumbers()[%d] = %g, want %g", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseSVGTransform(t *testing.T) {
+	tests := []struct {
+		transform    string
+		x, y         float64
+		wantX, wantY float64
+	}{
+		{"translate(10 5)", 1, 1, 11, 6},
+		{"scale(2)", 3, 4, 6, 8},
+		{"translate(10,0) scale(2,3)", 1, 1, 12, 3},
+		{"rotate(90)", 1, 0, 0, 1},
+		{"rotate(180 5 5)", 0, 0, 10, 10},
+		{"matrix(1 0 0 1 7 8)", 0, 0, 7, 8},
+	}
+	for _, tt := range tests {
+		m, err := parseSVGTransform(tt.transform)
+		if err != nil {
+			t.Errorf("parseSVGTransform(%q) error = %v", tt.transform, err)
+			continue
+		}
+		if x, y := m.TransformPoint(tt.x, tt.y); math.Abs(x-tt.wantX) > 1e-9 || math.Abs(y-tt.wantY) > 1e-9 {
+			t.Errorf("%q maps (%g, %g) to (%g, %g), want (%g, %g)", tt.transform, tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+		}
+	}
+	if _, err := parseSVGTransform("perspective(3)"); err == nil {
+		t.Error("parseSVGTransform accepted an unknown transform")
+	}
+}
+
+func TestDrawSVG(t *testing.T) {
+	doc, err := parseSVG([]byte(`<svg viewBox="0 0 10 10" fill="currentColor">
+		<rect width="5" height="10"/>
+		<path d="M5 0h5v5h-5z" style="fill:#00ff00"/>
+		<g transform="translate(5 5)"><circle cx="2.5" cy="2.5" r="2" fill="none" stroke="#0000ff"/></g>
+		<path d="M0 0 A 5 5 0 0 1 10 0" fill="none" stroke="none"/>
+	</svg>`))
+	if err != nil {
+		t.Fatalf("parseSVG() error = %v", err)
+	}
+	dc := gg.NewContext(20, 20)
+	drawSVG(dc, doc, 0, 0, 20, 20, color.RGBA{255, 0, 0, 255}) // Drawn at twice its size.
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{2, 10, color.RGBA{255, 0, 0, 255}},  // Left half in currentColor.
+		{15, 2, color.RGBA{0, 255, 0, 255}},  // Style attribute beats the inherited fill.
+		{15, 15, color.RGBA{0, 0, 0, 0}},     // Centre of an unfilled circle.
+		{15, 11, color.RGBA{0, 0, 255, 255}}, // Its stroke, scaled to 2px.
+	}
+	for _, tt := range tests {
+		r, g, b, a := dc.Image().At(tt.x, tt.y).RGBA()
+		if got := (color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}); got != tt.want {
+			t.Errorf("pixel (%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestDrawPathData_Errors(t *testing.T) {
+	dc := gg.NewContext(10, 10)
+	pen := svgPen{dc: dc, m: gg.Identity()}
+	for _, d := range []string{"", "M0 0L1 1 2 2zm3 3h1v1H3V3c1 1 2 2 3 3s1 1 2 2q1 1 2 2t1 1a1 1 0 011 1"} {
+		if err := drawPathData(pen, d); err != nil {
+			t.Errorf("drawPathData(%q) error = %v", d, err)
+		}
+	}
+	for _, d := range []string{"0 0", "M0", "M0 0 L1 1 z 2 2", "M0 0 A1 1 0 2 0 1 1"} {
+		if err := drawPathData(pen, d); err == nil {
+			t.Errorf("drawPathData(%q) succeeded, want an error", d)
+		}
+	}
+}
+
+func TestBundledIconsParse(t *testing.T) {
+	for name, source := range bundledIcons {
+		doc, err := parseSVG([]byte(source))
+		if err != nil {
+			t.Errorf("icon %s: %v", name, err)
+			continue
+		}
+		if doc.Width != 24 || doc.Height != 24 {
+			t.Errorf("icon %s is %gx%g, want 24x24", name, doc.Width, doc.Height)
+		}
+		for _, node := range doc.Root.Children {
+			if node.Name == "path" {
+				if err := drawPathData(svgPen{dc: gg.NewContext(1, 1), m: gg.Identity()}, node.Attrs["d"]); err != nil {
+					t.Errorf("icon %s: %v", name, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"log"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// columnConstraint describes how one column may be sized by solveColumnWidths.
+type columnConstraint struct {
+	ideal float64 // Width needed to lay out the content without wrapping.
+	floor float64 // Smallest usable width: declared minimums, MinCellWidth and the longest unbreakable word.
+	max   float64 // Largest allowed width; +Inf when unbounded.
+	fixed float64 // Declared width (pixels or resolved percentage); 0 means sized by the solver.
+	flex  float64 // Weight for growing into leftover space; 0 means the column does not grow.
+}
+
+// solveColumnWidths sizes columns to fit available (the table width minus cell spacing). Without a
+// table width (available <= 0) each column gets its ideal width clamped to [floor, max]. Otherwise
+// columns that are too wide shrink towards their floor in proportion to how much they can give up,
+// so their text wraps instead of widening the table, and leftover space goes to the flex columns
+// (every non-fixed column when none declares a flex weight) without exceeding their max.
+// The floor wins over both max and available: a table whose floors do not fit overflows (see solveColumns).
+func solveColumnWidths(cols []columnConstraint, available float64) []float64 {
+	widths := make([]float64, len(cols))
+	total := 0.0
+	for i, col := range cols {
+		if col.fixed > 0 {
+			widths[i] = col.fixed
+		} else {
+			widths[i] = math.Max(math.Min(col.ideal, col.max), col.floor)
+		}
+		total += widths[i]
+	}
+	if available <= 0 || len(cols) == 0 {
+		return widths
+	}
+
+	if total > available {
+		excess, room := total-available, 0.0
+		for i, col := range cols {
+			if col.fixed <= 0 {
+				room += widths[i] - col.floor
+			}
+		}
+		if room <= 0 {
+			return widths
+		}
+		shrink := math.Min(1, excess/room)
+		for i, col := range cols {
+			if col.fixed <= 0 {
+				widths[i] -= (widths[i] - col.floor) * shrink
+			}
+		}
+		return widths
+	}
+
+	// Grow: hand out leftover space by flex weight, re-distributing whatever columns capped at max could not take.
+	weights := make([]float64, len(cols))
+	hasFlex := false
+	for _, col := range cols {
+		if col.fixed <= 0 && col.flex > 0 {
+			hasFlex = true
+		}
+	}
+	for i, col := range cols {
+		switch {
+		case col.fixed > 0:
+		case hasFlex:
+			weights[i] = col.flex
+		default:
+			weights[i] = 1
+		}
+	}
+	leftover := available - total
+	for leftover > 1e-9 {
+		totalWeight := 0.0
+		for i, col := range cols {
+			if weights[i] > 0 && widths[i] < col.max {
+				totalWeight += weights[i]
+			}
+		}
+		if totalWeight == 0 {
+			break
+		}
+		given := 0.0
+		for i, col := range cols {
+			if weights[i] > 0 && widths[i] < col.max {
+				grow := math.Min(leftover*weights[i]/totalWeight, col.max-widths[i])
+				widths[i] += grow
+				given += grow
+			}
+		}
+		leftover -= given
+	}
+	return widths
+}
+
+// solveColumns runs solveColumnWidths against the table width, then shrinks the result again if it
+// exceeds the narrowest of the table's max_width and the width of the cell containing a nested table.
+// Overflowing the table's own width settings is logged; a nested table too wide for its cell is not,
+// as it is also measured at zero width to find its minimum.
+func (lg *LayoutGrid) solveColumns(cols []columnConstraint) []float64 {
+	widths := solveColumnWidths(cols, lg.availableWidth())
+	limit := 0.0
+	for _, w := range []float64{lg.tracksWidth(lg.TableMaxWidth), lg.tracksWidth(lg.containerWidth)} {
+		if w > 0 && (limit == 0 || w < limit) {
+			limit = w
+		}
+	}
+	if limit > 0 && sumWidths(widths) > limit+1e-9 {
+		widths = solveColumnWidths(cols, limit)
+	}
+	own := lg.availableWidth()
+	if maxW := lg.tracksWidth(lg.TableMaxWidth); maxW > 0 && (own == 0 || maxW < own) {
+		own = maxW
+	}
+	if total := sumWidths(widths); own > 0 && total > own+1e-9 {
+		log.Printf("Warning: columns need at least %.1fpx but the table width only allows %.1fpx; the table overflows.", total, own)
+	}
+	return widths
+}
+
+func sumWidths(widths []float64) float64 {
+	total := 0.0
+	for _, w := range widths {
+		total += w
+	}
+	return total
+}
+
+// columnConstraints builds the solver input from the measured ideal and floor widths and the
+// table's "columns:" line. Percentages need a table width; without one they are ignored.
+func (lg *LayoutGrid) columnConstraints(ideals, floors []float64) []columnConstraint {
+	cols := make([]columnConstraint, lg.NumLogicalCols)
+	for c := range cols {
+		cols[c] = columnConstraint{ideal: ideals[c], floor: floors[c], max: math.Inf(1)}
+		if c >= len(lg.ColumnSpecs) {
+			continue
+		}
+		spec := lg.ColumnSpecs[c]
+		cols[c].flex = spec.Flex
+		if spec.Width > 0 {
+			cols[c].fixed = spec.Width
+		} else if spec.WidthPercent > 0 {
+			cols[c].fixed = lg.percentOfTable(table.Length{Value: spec.WidthPercent, Percent: true}, c)
+		}
+		if px := lg.percentOfTable(spec.MinWidth, c); px > 0 {
+			cols[c].floor = math.Max(cols[c].floor, px)
+		}
+		if px := lg.percentOfTable(spec.MaxWidth, c); px > 0 {
+			cols[c].max = px
+		}
+	}
+	return cols
+}
+
+// percentOfTable resolves a length against the table width, logging a warning for percentages
+// used without a "width:" setting. Returns 0 for unset or unresolvable lengths.
+func (lg *LayoutGrid) percentOfTable(l table.Length, col int) float64 {
+	px, ok := l.Pixels(lg.percentBase())
+	if !ok && l.IsSet() {
+		log.Printf("Warning: column %d uses a percentage width but the table has no 'width' or 'max_width' setting. Ignoring it.", col+1)
+	}
+	return px
+}
+
+// availableWidth is the table width left for columns once cell spacing is taken out; 0 when the
+// table has no width setting.
+func (lg *LayoutGrid) availableWidth() float64 {
+	return lg.tracksWidth(lg.TableWidth)
+}
+
+// percentBase is the width percentages resolve against: the available table width, else the
+// max_width minus cell spacing. 0 when the table has neither setting.
+func (lg *LayoutGrid) percentBase() float64 {
+	if w := lg.availableWidth(); w > 0 {
+		return w
+	}
+	return lg.tracksWidth(lg.TableMaxWidth)
+}
+
+// tracksWidth returns what is left of tableWidth for the columns once cell spacing is taken out;
+// 0 when tableWidth is unset.
+func (lg *LayoutGrid) tracksWidth(tableWidth float64) float64 {
+	if tableWidth <= 0 || lg.NumLogicalCols == 0 {
+		return 0
+	}
+	return math.Max(tableWidth-lg.CellSpacing*float64(lg.NumLogicalCols-1), 0)
+}
+
+// minContentWidth returns the width of the widest unbreakable segment of a text cell's title and
+// content (see wrapText): the narrowest the text can wrap to without breaking between characters.
+func minContentWidth(dc *gg.Context, cell *table.Cell, opts wrapOptions) float64 {
+	text := cell.Content
+	if cell.Title != "" {
+		text = "[" + cell.Title + "]\n" + text
+	}
+	return minWrapWidth(text, textMeasurer(dc), opts)
+}
+
+// textMeasurer returns a function measuring the width of a string in dc's current font.
+func textMeasurer(dc *gg.Context) func(string) float64 {
+	return func(s string) float64 {
+		w, _ := dc.MeasureString(s)
+		return w
+	}
+}
@@ -0,0 +1,66 @@
+package renderer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolveColumnWidths(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		name      string
+		cols      []columnConstraint
+		available float64
+		want      []float64
+	}{
+		{
+			name: "no table width uses ideal clamped to bounds",
+			cols: []columnConstraint{{ideal: 100, floor: 20, max: inf}, {ideal: 300, floor: 20, max: 150}, {ideal: 10, floor: 40, max: inf}, {ideal: 80, floor: 20, max: inf, fixed: 50}},
+			want: []float64{100, 150, 40, 50},
+		},
+		{
+			name:      "too wide shrinks towards floors",
+			cols:      []columnConstraint{{ideal: 100, floor: 50, max: inf}, {ideal: 500, floor: 100, max: inf}, {ideal: 60, floor: 20, max: inf, fixed: 60}},
+			available: 360,
+			// 660 wide, 300 excess, 450 room: each auto column gives up 2/3 of its room.
+			want: []float64{100 - 50*2.0/3, 500 - 400*2.0/3, 60},
+		},
+		{
+			name:      "floors that do not fit overflow",
+			cols:      []columnConstraint{{ideal: 200, floor: 150, max: inf}, {ideal: 200, floor: 150, max: inf}},
+			available: 200,
+			want:      []float64{150, 150},
+		},
+		{
+			name:      "leftover space goes to flex columns by weight",
+			cols:      []columnConstraint{{ideal: 50, floor: 20, max: inf, flex: 1}, {ideal: 50, floor: 20, max: inf, flex: 3}, {ideal: 50, floor: 20, max: inf}},
+			available: 350,
+			want:      []float64{100, 200, 50},
+		},
+		{
+			name:      "capped flex column passes space on",
+			cols:      []columnConstraint{{ideal: 50, floor: 20, max: 60}, {ideal: 50, floor: 20, max: inf}},
+			available: 300,
+			want:      []float64{60, 240},
+		},
+		{
+			name:      "only fixed columns do not grow",
+			cols:      []columnConstraint{{ideal: 50, floor: 20, max: inf, fixed: 70}},
+			available: 300,
+			want:      []float64{70},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := solveColumnWidths(tt.cols, tt.available)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d widths, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !floatEquals(got[i], tt.want[i], epsilon_layout_test) {
+					t.Errorf("column %d: got %.2f, want %.2f (all: %v)", i, got[i], tt.want[i], got)
+				}
+			}
+		})
+	}
+}
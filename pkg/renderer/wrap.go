@@ -0,0 +1,280 @@
+package renderer
+
+import (
+	"diagramgen/pkg/table"
+	"strings"
+	"unicode"
+)
+
+// softHyphen marks an optional break point. It is invisible unless a line breaks there, in which case
+// the line ends with a hyphen. The parser turns "&shy;" into it.
+const softHyphen = '\u00ad'
+
+// wrapOptions controls hyphenation in wrapText and the direction of the wrapped text.
+type wrapOptions struct {
+	// Hyphens is "manual" (break at soft hyphens only), "auto" (also at the points given by the
+	// hyphenation dictionary) or "none" (ignore soft hyphens). Empty means "manual".
+	Hyphens string
+	// Dictionary maps lower-case words to their break points, written like "in-fra-struc-ture".
+	// It extends builtinHyphenation.
+	Dictionary map[string]string
+	// RTL is set for tables with direction "rtl", whose paragraphs are all right-to-left. Otherwise each
+	// paragraph takes the direction of its first strong character (see paragraphIsRTL).
+	RTL bool
+}
+
+// wrapOptionsFor returns the wrap options of a table's settings.
+func wrapOptionsFor(settings table.GlobalSettings) wrapOptions {
+	opts := wrapOptions{Hyphens: settings.Hyphens, RTL: settings.Direction == "rtl"}
+	for _, word := range strings.Fields(settings.HyphenWords) {
+		if opts.Dictionary == nil {
+			opts.Dictionary = make(map[string]string)
+		}
+		opts.Dictionary[strings.ToLower(strings.ReplaceAll(word, "-", ""))] = strings.ToLower(word)
+	}
+	return opts
+}
+
+// builtinHyphenation holds break points for long words common in architecture diagrams; it is used
+// when hyphens is "auto". Tables add their own words with the hyphen_words setting.
+var builtinHyphenation = map[string]string{}
+
+func init() {
+	for _, word := range strings.Fields(`ad-min-is-tra-tor ap-pli-ca-tion ar-chi-tec-ture au-then-ti-ca-tion au-tho-ri-za-tion
+		avail-abil-i-ty cer-tif-i-cate com-mu-ni-ca-tion con-fig-u-ra-tion con-tain-er-ized de-pend-en-cies de-ploy-ment
+		de-scrip-tion de-vel-op-ment doc-u-men-ta-tion en-vi-ron-ment in-for-ma-tion in-fra-struc-ture im-ple-men-ta-tion
+		in-te-gra-tion in-ter-na-tion-al man-age-ment mi-cro-ser-vice mi-cro-ser-vices mon-i-tor-ing no-ti-fi-ca-tion
+		ob-serv-abil-i-ty or-ches-tra-tion or-ga-ni-za-tion per-for-mance pro-cess-ing re-pos-i-to-ry re-quire-ments
+		re-spon-si-bil-i-ty syn-chro-ni-za-tion through-put trans-ac-tion`) {
+		builtinHyphenation[strings.ReplaceAll(word, "-", "")] = word
+	}
+}
+
+// wrapSegment is a piece of text that may not be broken inside (except as a last resort). A line may
+// break after it. SoftHyphen is set when the break point is a soft hyphen, which then shows as "-".
+type wrapSegment struct {
+	Text       string
+	SoftHyphen bool
+}
+
+// wrapText breaks text into lines no wider than width, measured with measure. Lines break at
+// newlines, after spaces, after "/", ".", "_" and "-" inside words, at soft hyphens and between
+// CJK characters following the line breaking rules of UAX #14. A segment that does not fit on a
+// line of its own is broken between characters. Lines are trimmed and empty lines are dropped, like
+// gg.WordWrap.
+func wrapText(text string, width float64, measure func(string) float64, opts wrapOptions) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line, lineHyphen := "", false
+		emit := func() {
+			out := strings.TrimSpace(line)
+			if lineHyphen && out != "" {
+				out += "-"
+			}
+			if out != "" {
+				lines = append(lines, out)
+			}
+			line, lineHyphen = "", false
+		}
+		for _, seg := range wrapSegments(paragraph, opts) {
+			for {
+				candidate := strings.TrimRight(line+seg.Text, " \t")
+				if seg.SoftHyphen {
+					candidate += "-"
+				}
+				if measure(candidate) <= width {
+					line, lineHyphen = line+seg.Text, seg.SoftHyphen
+					break
+				}
+				if strings.TrimSpace(line) != "" {
+					emit()
+					continue // Retry the segment on a fresh line.
+				}
+				// The segment alone is too wide: break it between characters.
+				chunks := breakChars(strings.TrimLeft(seg.Text, " \t"), width, measure)
+				for _, chunk := range chunks[:len(chunks)-1] {
+					line = chunk
+					emit()
+				}
+				line, lineHyphen = chunks[len(chunks)-1], seg.SoftHyphen
+				break
+			}
+		}
+		emit()
+	}
+	return lines
+}
+
+// breakChars splits text greedily into chunks no wider than width, at least one character each.
+func breakChars(text string, width float64, measure func(string) float64) []string {
+	var chunks []string
+	runes := []rune(text)
+	for len(runes) > 0 {
+		n := 1
+		for n < len(runes) && measure(string(runes[:n+1])) <= width {
+			n++
+		}
+		chunks = append(chunks, string(runes[:n]))
+		runes = runes[n:]
+	}
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+	return chunks
+}
+
+// minWrapWidth returns the width of the widest segment of text: the narrowest it can wrap to
+// without breaking between characters.
+func minWrapWidth(text string, measure func(string) float64, opts wrapOptions) float64 {
+	widest := 0.0
+	for _, paragraph := range strings.Split(text, "\n") {
+		for _, seg := range wrapSegments(paragraph, opts) {
+			segText := strings.TrimSpace(seg.Text)
+			if seg.SoftHyphen {
+				segText += "-"
+			}
+			if w := measure(segText); w > widest {
+				widest = w
+			}
+		}
+	}
+	return widest
+}
+
+// wrapSegments splits a paragraph at its break opportunities. Soft hyphens are removed from the
+// text; with hyphens "auto" dictionary words get soft hyphens first, with "none" they are dropped.
+// Arabic letters are shaped (see shapeArabic) so that the segments measure as they are drawn.
+func wrapSegments(paragraph string, opts wrapOptions) []wrapSegment {
+	switch opts.Hyphens {
+	case "auto":
+		paragraph = hyphenate(paragraph, opts.Dictionary)
+	case "none":
+		paragraph = strings.ReplaceAll(paragraph, string(softHyphen), "")
+	}
+	paragraph = shapeArabic(paragraph)
+	runes := []rune(paragraph)
+	var segments []wrapSegment
+	var current []rune
+	flush := func(hyphen bool) {
+		if len(current) > 0 || hyphen {
+			segments = append(segments, wrapSegment{Text: string(current), SoftHyphen: hyphen})
+		}
+		current = nil
+	}
+	for i, r := range runes {
+		prev, next := rune(0), rune(0)
+		if i > 0 {
+			prev = runes[i-1]
+		}
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		if r == softHyphen {
+			flush(len(current) > 0)
+			continue
+		}
+		current = append(current, r)
+		switch {
+		case next == 0:
+		case unicode.IsSpace(r):
+			if !unicode.IsSpace(next) {
+				flush(false)
+			}
+		case unicode.IsSpace(next) || next == softHyphen:
+		case breakAfterPunct(prev, r, next), cjkBreakBetween(r, next):
+			flush(false)
+		}
+	}
+	flush(false)
+	return segments
+}
+
+// breakAfterPunct reports whether a word may break after r, one of "/", ".", "_" or "-", as in URLs,
+// file paths and identifiers. Runs of punctuation, leading punctuation and decimal points stay whole.
+func breakAfterPunct(prev, r, next rune) bool {
+	if !strings.ContainsRune("/._-", r) || prev == 0 || unicode.IsSpace(prev) || strings.ContainsRune("/._-", next) {
+		return false
+	}
+	if r == '.' && unicode.IsDigit(prev) && unicode.IsDigit(next) {
+		return false
+	}
+	return true
+}
+
+// Line breaking classes of UAX #14 that matter for CJK text.
+const (
+	lbOther       = iota
+	lbIdeographic // ID: Han, kana, Hangul and fullwidth forms; breaks are allowed on either side.
+	lbOpen        // OP: opening brackets; no break after.
+	lbClose       // CL, CP, EX, IS: closing brackets and punctuation; no break before.
+	lbNonStarter  // NS: small kana, prolonged sound mark and iteration marks; no break before.
+)
+
+// lineBreakClass returns a simplified UAX #14 line breaking class of r.
+func lineBreakClass(r rune) int {
+	switch {
+	case strings.ContainsRune("「『（〔［｛〈《【〘〖〝｟", r):
+		return lbOpen
+	case strings.ContainsRune("」』）〕］｝〉》】〙〗〞〟｠、。，．：；！？･・", r):
+		return lbClose
+	case strings.ContainsRune("ぁぃぅぇぉっゃゅょゎゕゖァィゥェォッャュョヮヵヶㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇺㇻㇼㇽㇾㇿーヽヾゝゞ々〻‼⁇⁈⁉", r):
+		return lbNonStarter
+	case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r),
+		unicode.Is(unicode.Hangul, r), r >= 0xFF01 && r <= 0xFF60, r >= 0x3000 && r <= 0x303F:
+		return lbIdeographic
+	}
+	return lbOther
+}
+
+// cjkBreakBetween reports whether UAX #14 allows a line break between a and b when at least one of
+// them is a CJK character.
+func cjkBreakBetween(a, b rune) bool {
+	ca, cb := lineBreakClass(a), lineBreakClass(b)
+	if ca == lbOther && cb == lbOther {
+		return false
+	}
+	if ca == lbOpen || cb == lbClose || cb == lbNonStarter {
+		return false
+	}
+	return ca == lbIdeographic || ca == lbClose || ca == lbNonStarter || cb == lbIdeographic || cb == lbOpen
+}
+
+// hyphenate inserts soft hyphens into the words of text found in dict or builtinHyphenation.
+func hyphenate(text string, dict map[string]string) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && unicode.IsLetter(runes[j]) {
+			j++
+		}
+		word := runes[i:j]
+		pattern, ok := dict[strings.ToLower(string(word))]
+		if !ok {
+			pattern, ok = builtinHyphenation[strings.ToLower(string(word))]
+		}
+		if !ok {
+			b.WriteString(string(word))
+		} else {
+			k := 0
+			for _, p := range pattern {
+				if p == '-' {
+					b.WriteRune(softHyphen)
+					continue
+				}
+				if k < len(word) {
+					b.WriteRune(word[k])
+					k++
+				}
+			}
+			b.WriteString(string(word[k:]))
+		}
+		i = j
+	}
+	return b.String()
+}
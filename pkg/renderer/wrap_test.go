@@ -0,0 +1,67 @@
+package renderer
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// runeWidth measures every character as one unit, so widths in these tests count characters.
+func runeWidth(s string) float64 { return float64(utf8.RuneCountInString(s)) }
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		width float64
+		opts  wrapOptions
+		want  []string
+	}{
+		{name: "spaces", text: "the quick brown fox jumps", width: 10, want: []string{"the quick", "brown fox", "jumps"}},
+		{name: "newlines and empty lines", text: "one\n\ntwo three", width: 20, want: []string{"one", "two three"}},
+		{name: "url breaks after slashes and dots", text: "https://example.com/api/v1/users", width: 14, want: []string{"https://", "example.com/", "api/v1/users"}},
+		{name: "path breaks after slashes", text: "/usr/local/share/fonts", width: 12, want: []string{"/usr/local/", "share/fonts"}},
+		{name: "identifier breaks after underscores", text: "MAX_CONNECTIONS_PER_HOST", width: 16, want: []string{"MAX_CONNECTIONS_", "PER_HOST"}},
+		{name: "hyphenated word", text: "state-of-the-art", width: 9, want: []string{"state-of-", "the-art"}},
+		{name: "no break after a decimal point, hard break instead", text: "v3.14159", width: 5, want: []string{"v3.14", "159"}},
+		{name: "hard break as a last resort", text: "abcdefghij", width: 4, want: []string{"abcd", "efgh", "ij"}},
+		{name: "soft hyphen shows only at a break", text: "infra\u00adstructure team", width: 12, want: []string{"infra-", "structure", "team"}},
+		{name: "unused soft hyphen is invisible", text: "infra\u00adstructure", width: 20, want: []string{"infrastructure"}},
+		{name: "hyphens none ignores soft hyphens", text: "infra\u00adstructure", width: 12, opts: wrapOptions{Hyphens: "none"}, want: []string{"infrastructu", "re"}},
+		{name: "dictionary hyphenation", text: "Configuration", width: 8, opts: wrapOptions{Hyphens: "auto"}, want: []string{"Configu-", "ration"}},
+		{name: "table dictionary words", text: "Kubernetes", width: 7, opts: wrapOptions{Hyphens: "auto", Dictionary: map[string]string{"kubernetes": "ku-ber-ne-tes"}}, want: []string{"Kuber-", "netes"}},
+		{name: "dictionary unused without auto", text: "Configuration", width: 8, want: []string{"Configur", "ation"}},
+		{name: "cjk breaks between ideographs", text: "日本語のテキスト", width: 4, want: []string{"日本語の", "テキスト"}},
+		{name: "cjk closing punctuation does not start a line", text: "日本語のテキスト。", width: 4, want: []string{"日本語の", "テキス", "ト。"}},
+		{name: "cjk brackets stay with the enclosed characters", text: "これは「引用」です", width: 4, want: []string{"これは", "「引用」", "です"}},
+		{name: "cjk small kana does not start a line", text: "チェックする", width: 3, want: []string{"チェッ", "クする"}},
+		{name: "latin words inside cjk text", text: "使用Go语言", width: 3, want: []string{"使用", "Go语", "言"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapText(tt.text, tt.width, runeWidth, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapText(%q, %.0f) = %q, want %q", tt.text, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinWrapWidth(t *testing.T) {
+	tests := []struct {
+		text string
+		opts wrapOptions
+		want float64
+	}{
+		{text: "short words only", want: 5},
+		{text: "https://example.com/api", want: 8},
+		{text: "infra\u00adstructure", want: 9},
+		{text: "Configuration", opts: wrapOptions{Hyphens: "auto"}, want: 4},
+		{text: "日本語。", want: 2},
+	}
+	for _, tt := range tests {
+		if got := minWrapWidth(tt.text, runeWidth, tt.opts); got != tt.want {
+			t.Errorf("minWrapWidth(%q) = %.0f, want %.0f", tt.text, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,83 @@
 package table
 
+import "strings"
+
+// PaddingSpec holds optional per-side padding in pixels.
+// A nil side is not set and falls back to the enclosing default (table, then renderer).
+type PaddingSpec struct {
+	Top, Right, Bottom, Left *float64
+}
+
+// IsSet reports whether any side of the padding was specified.
+func (p PaddingSpec) IsSet() bool {
+	return p.Top != nil || p.Right != nil || p.Bottom != nil || p.Left != nil
+}
+
+// Over returns p with its unset sides taken from fallback.
+func (p PaddingSpec) Over(fallback PaddingSpec) PaddingSpec {
+	if p.Top == nil {
+		p.Top = fallback.Top
+	}
+	if p.Right == nil {
+		p.Right = fallback.Right
+	}
+	if p.Bottom == nil {
+		p.Bottom = fallback.Bottom
+	}
+	if p.Left == nil {
+		p.Left = fallback.Left
+	}
+	return p
+}
+
 // GlobalSettings holds default styling for the entire table.
 type GlobalSettings struct {
 	DefaultCellBackgroundColor string // e.g., "#FFFFFF"
 	TableBackgroundColor       string // e.g., "#ECECEC"
 	EdgeColor                  string // e.g., "#000000"
 	EdgeThickness              int    // e.g., 1
+	TextColor                  string // Default text color for cells. Empty means black.
+
+	// Geometry overrides. nil / unset values use the renderer defaults
+	// (or, for nested tables, the values of the enclosing table).
+	CornerRadius *float64    // Corner radius of cells in pixels. 0 gives square corners.
+	Margin       *float64    // Space around the table on its canvas, in pixels.
+	Padding      PaddingSpec // Default padding inside every cell of the table.
+
+	// BorderMode selects how cell borders are drawn: "separate" (the default, also used when empty)
+	// draws each cell as its own rounded box; "collapse" draws shared square grid lines inside a
+	// single rounded outer frame.
+	BorderMode  string
+	CellSpacing float64 // Gap in pixels between adjacent cells in separate mode. Ignored when collapsed.
+
+	// Width is the target width of the table's grid in pixels (excluding the margin). Columns shrink
+	// (wrapping their text) or grow to fit it, and percentage widths are relative to it. 0 means unset.
+	Width float64
+	// MaxWidth caps the width of the table's grid in pixels (excluding the margin). Columns only shrink to
+	// fit it, never grow. Percentage widths are relative to it when Width is unset. 0 means unset.
+	MaxWidth float64
+
+	// Header cells are the cells starting in the first HeaderRows rows or the first HeaderCols columns.
+	// They are drawn bold, centered and shaded with HeaderBackgroundColor unless styled otherwise.
+	HeaderRows            int
+	HeaderCols            int
+	HeaderBackgroundColor string // Empty means the renderer's default header shade.
+	HeaderTextColor       string // Empty means use TextColor.
+
+	// Hyphens controls hyphenation when wrapping text: "none" ignores soft hyphens (&shy;), "manual" breaks
+	// at soft hyphens only and "auto" also uses the hyphenation dictionary. Empty means "manual".
+	Hyphens string
+	// HyphenWords extends the hyphenation dictionary with space-separated words written with '-' at their
+	// break points, e.g. "da-ta-base mi-cro-front-end".
+	HyphenWords string
+
+	// Direction is "ltr" or "rtl". Right-to-left tables put the first column on the right, align text to
+	// the right by default and lay out all paragraphs right-to-left. Empty means "ltr", where each
+	// paragraph takes the direction of its first letter.
+	Direction string
+
+	// Overflow is the default overflow mode of the table's cells; see Cell.Overflow. Empty means "clip".
+	Overflow string
 }
 
 // DefaultGlobalSettings provides a default set of global table settings.
@@ -18,6 +90,19 @@ func DefaultGlobalSettings() GlobalSettings {
 	}
 }
 
+// BorderSpec describes the border on one side of a cell.
+// Zero values mean "inherit from the table settings" (EdgeThickness, solid, EdgeColor).
+type BorderSpec struct {
+	Width float64 // Line width in pixels. 0.0 means use the table EdgeThickness.
+	Style string  // "solid", "dashed", "dotted" or "none". Empty means solid.
+	Color string  // e.g., "#RRGGBB". Empty means use the table EdgeColor.
+}
+
+// IsSet reports whether any part of the border spec was specified.
+func (b BorderSpec) IsSet() bool {
+	return b.Width != 0 || b.Style != "" || b.Color != ""
+}
+
 // Cell represents a single cell in a table
 type Cell struct {
 	Title           string
@@ -25,6 +110,9 @@ type Cell struct {
 	Colspan         int    // For merged cells horizontally
 	Rowspan         int    // For merged cells vertically
 	BackgroundColor string // Specific background color for this cell, e.g., "#RRGGBB"
+	TextColor       string // Specific text color for this cell. Empty means use the table TextColor.
+	Class           string // Style classes applied to this cell, space-separated as written in ::class=...::
+	Align           string // Horizontal text alignment: "left", "center" or "right". Empty means inherit (row, column, then left).
 	IsTableRef      bool   // Flag to indicate this cell is a table reference
 	TableRefID      string // ID of the table to render in this cell
 
@@ -35,6 +123,81 @@ type Cell struct {
 	// New fields for fixed cell dimensions
 	FixedWidth  float64 // Specified fixed width in pixels. 0.0 means not set.
 	FixedHeight float64 // Specified fixed height in pixels. 0.0 means not set.
+	MinWidth    Length  // Lower bound for the width of the cell's column(s).
+	MaxWidth    Length  // Upper bound for the cell's width; longer text wraps.
+	// Overflow decides what happens to text that does not fit the cell: "clip", "ellipsis", "shrink" or
+	// "expand". Empty means inherit the table setting.
+	Overflow string
+	// Rotate turns the cell's text block clockwise by 0, 90, 180 or 270 degrees. At 90 (text reading top
+	// to bottom) and 270 (bottom to top) the block's width and height are swapped when measuring.
+	// RotateSet records that Rotate was given explicitly, so that a 0 overrides a class's rotation.
+	Rotate    int
+	RotateSet bool
+	// Image is the path of a PNG, JPEG, GIF or SVG file drawn in the cell, and Icon the name of a bundled
+	// icon. Both are placed with InnerTableAlignment and InnerTableScaleMode like nested tables. Text in
+	// the same cell goes beside the image, on the side opposite ImagePosition ("left", "top", "right" or
+	// "bottom"; empty means left).
+	Image, Icon   string
+	ImagePosition string
+	// Blocks holds the content of a cell that mixes nested tables with text, images or each other, in
+	// the order it is written; Content, IsTableRef, TableRefID, Image and Icon are then unset. The title
+	// comes first.
+	// Stack lays the blocks out "vertical" (top to bottom, the default) or "horizontal" (left to right),
+	// StackGap pixels apart (nil means half a line).
+	Blocks   []CellBlock
+	Stack    string
+	StackGap *float64
+	// Formula is the formula of a computed cell as written, e.g. "=SUM(B2:B5)"; its Content holds the
+	// formula until it is evaluated, then the result; text that does not start with an expression is
+	// not a formula and has its Formula cleared. NumberFormat is a spreadsheet-like pattern such as
+	// "#,##0.00" or "0%" for the result or numeric content. Empty means the column's format.
+	Formula      string
+	NumberFormat string
+	// ID names the cell for references from elsewhere ("#db-primary"), besides its grid position ("B3").
+	// IDs are unique within a table. Links are the addresses of the cells of the same table this cell
+	// points to; each is drawn as an arrow.
+	ID    string
+	Links []string
+
+	// Geometry overrides for this cell. Unset values use the table settings.
+	CornerRadius *float64
+	Padding      PaddingSpec
+
+	// Per-side border overrides. Unset sides inherit the table edge settings.
+	BorderTop    BorderSpec
+	BorderRight  BorderSpec
+	BorderBottom BorderSpec
+	BorderLeft   BorderSpec
+}
+
+// CellBlock is one item of a cell's Blocks: a paragraph of text, a nested table or an image. A table
+// can have its own alignment and scale mode; empty values use the cell's.
+type CellBlock struct {
+	Text                string
+	TableRefID          string
+	Image, Icon         string
+	InnerTableAlignment string
+	InnerTableScaleMode string
+}
+
+// HasBorderOverride reports whether any side of the cell has an explicit border spec.
+func (c *Cell) HasBorderOverride() bool {
+	return c.BorderTop.IsSet() || c.BorderRight.IsSet() || c.BorderBottom.IsSet() || c.BorderLeft.IsSet()
+}
+
+// IsSideways reports whether the cell's text runs vertically (rotated by 90 or 270 degrees).
+func (c *Cell) IsSideways() bool {
+	return c.Rotate == 90 || c.Rotate == 270
+}
+
+// HasImage reports whether the cell draws an image or an icon.
+func (c *Cell) HasImage() bool {
+	return c.Image != "" || c.Icon != ""
+}
+
+// HasBlocks reports whether the cell's content is a list of blocks (see Cell.Blocks).
+func (c *Cell) HasBlocks() bool {
+	return len(c.Blocks) > 0
 }
 
 // NewCell creates a new Cell with default values.
@@ -59,6 +222,55 @@ func NewCell(title string, content string) Cell {
 // Row represents a row in a table
 type Row struct {
 	Cells []Cell
+
+	// Row-level styling from an "@row {...}" marker. Unset values fall back to the column and table
+	// settings; the cells' own styling takes precedence.
+	BackgroundColor string
+	TextColor       string
+	Align           string  // "left", "center" or "right". Empty means inherit.
+	Height          float64 // Fixed row height in pixels. 0.0 means sized by content.
+	Class           string  // Style classes applied to the row, space-separated.
+}
+
+// Length is a size in pixels or, when Percent is set, a percentage of the table width.
+// The zero value means "not set".
+type Length struct {
+	Value   float64
+	Percent bool
+}
+
+// IsSet reports whether the length was specified.
+func (l Length) IsSet() bool {
+	return l.Value > 0
+}
+
+// Pixels resolves the length against the table width. Percentages cannot be resolved
+// without a table width; ok is false in that case and for unset lengths.
+func (l Length) Pixels(tableWidth float64) (px float64, ok bool) {
+	if !l.IsSet() {
+		return 0, false
+	}
+	if !l.Percent {
+		return l.Value, true
+	}
+	if tableWidth <= 0 {
+		return 0, false
+	}
+	return tableWidth * l.Value / 100, true
+}
+
+// ColumnSpec holds the settings of one column, declared on a table's "columns:" line.
+// Zero values mean "not set".
+type ColumnSpec struct {
+	Width           float64 // Fixed column width in pixels. 0.0 means sized by content.
+	WidthPercent    float64 // Fixed column width as a percentage of the table width. 0.0 means not set.
+	MinWidth        Length
+	MaxWidth        Length
+	Flex            float64 // Weight for distributing space left over within the table width. 0.0 means not set.
+	Align           string  // "left", "center" or "right".
+	BackgroundColor string
+	TextColor       string
+	NumberFormat    string // Number format of the column's computed and numeric cells, see Cell.NumberFormat.
 }
 
 // Table represents a table, including its data and global settings.
@@ -66,6 +278,7 @@ type Table struct {
 	ID       string // New field for the table identifier
 	Title    string
 	Rows     []Row
+	Columns  []ColumnSpec   // Per-column settings from the "columns:" line, indexed by logical column. May be shorter than the table.
 	Settings GlobalSettings // Holds global settings for the table
 }
 
@@ -85,4 +298,30 @@ func NewTableWithDefaults() Table {
 type AllTables struct {
 	Tables      map[string]Table // Stores all parsed tables, keyed by their ID.
 	MainTableID string           // ID of the table to be rendered as the primary one.
+	// Warnings are lint messages about the source that do not stop it from being rendered, such as
+	// rules that never match a cell. Callers decide where to show them.
+	Warnings []string
+}
+
+// SplitOutsideParens splits s at runes matching isSep that are not nested inside parentheses or
+// double quotes, and trims each part. Empty parts are kept so callers can reject them, e.g.
+// "rgb(1, 2, 3), #fff" on commas yields "rgb(1, 2, 3)" and "#fff".
+func SplitOutsideParens(s string, isSep func(rune) bool) []string {
+	var parts []string
+	depth, start, quoted := 0, 0, false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0 && isSep(r):
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + len(string(r))
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
 }